@@ -0,0 +1,752 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// This file implements the append-only file: with appendonly enabled,
+// every command that changes the dataset is appended to the file in RESP,
+// as clients send commands, and the dataset is rebuilt at startup by
+// replaying it. BGREWRITEAOF compacts it into the current dataset, as a
+// snapshot in the RDB format or as commands, which later writes follow.
+//
+// What is logged is the effect of a command rather than the command as
+// sent wherever the two differ: commands whose outcome depends on the
+// clock or on chance, such as SPOP or XADD with an automatic ID, are
+// rewritten by their handlers through propagateAs, and so are those
+// setting a relative TTL, as their absolute form, so replaying the file
+// later sets the same deadline. Keys stay live while the file is replayed
+// until they are deleted, so the DEL of those that expire is logged when
+// they are, or when a write finds them expired. Transactions and scripts
+// are logged as the commands they ran, between MULTI and EXEC.
+//
+// With aof-timestamp-enabled, writes made in a new second are preceded by
+// an annotation line, "#TS:" and the Unix time, as Redis writes them, so
+// recover-to-timestamp can replay the file up to a given moment.
+
+// appendFsync is the appendfsync policy: when writes to the append-only
+// file are flushed to disk, trading durability against write latency.
+type appendFsync int
+
+const (
+	// fsyncEverysec syncs once a second from a background goroutine, so
+	// at most a second of writes is lost on a crash.
+	fsyncEverysec appendFsync = iota
+	// fsyncAlways syncs after every write, before its reply is sent.
+	fsyncAlways
+	// fsyncNo leaves flushing to the operating system.
+	fsyncNo
+)
+
+var appendFsyncNames = map[string]appendFsync{
+	"everysec": fsyncEverysec,
+	"always":   fsyncAlways,
+	"no":       fsyncNo,
+}
+
+func parseAppendFsync(s string) (appendFsync, error) {
+	policy, ok := appendFsyncNames[strings.ToLower(s)]
+	if !ok {
+		return 0, errors.New("argument must be 'always', 'everysec' or 'no'")
+	}
+	return policy, nil
+}
+
+// appendOnly is the open append-only file.
+type appendOnly struct {
+	mu     sync.Mutex
+	f      *os.File
+	policy appendFsync
+	// buf is reused to encode the commands fed.
+	buf []byte
+	// unsynced is set by writes the everysec flusher has not synced yet.
+	unsynced bool
+	// rewriteBuf collects the writes made while BGREWRITEAOF runs, to be
+	// appended to the rewritten file; rewriting is set meanwhile.
+	rewriting  bool
+	rewriteBuf []byte
+	// size is the length of the file, and baseSize what it was at startup
+	// or after the last rewrite, for INFO. lastWriteErr is the outcome of
+	// the last write.
+	size, baseSize int64
+	lastWriteErr   error
+	// timestamps has writes annotated with the time, and lastTimestamp is
+	// the last annotation written, in Unix seconds, to write one a second.
+	timestamps    bool
+	lastTimestamp int64
+	// stop ends the everysec flusher, which closes done when it returns.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startAppendOnly opens the appendfilename for appending and starts
+// logging writes to it. It is called once the data has been loaded, so
+// replaying the file does not log it again. If there is no file yet, it
+// is created from the dataset, which may have been loaded from the dump
+// file, so it holds every key from the start.
+func (srv *Server) startAppendOnly() error {
+	path := srv.cfg.appendPath()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		srv.store.mu.RLock()
+		f, err := srv.writeRewrite(srv.store.snapshot(false))
+		srv.store.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := os.Rename(f.Name(), path); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a := &appendOnly{f: f, policy: srv.cfg.AppendFsync, timestamps: srv.cfg.AOFTimestampEnabled, stop: make(chan struct{}), done: make(chan struct{})}
+	a.size, a.baseSize = fi.Size(), fi.Size()
+	if a.policy == fsyncEverysec {
+		go a.flushEverySecond()
+	} else {
+		close(a.done)
+	}
+	srv.aof = a
+	return nil
+}
+
+// feed appends cmds to the file, syncing it first under the always policy.
+func (a *appendOnly) feed(cmds [][]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.buf = a.buf[:0]
+	if now := time.Now().Unix(); a.timestamps && now != a.lastTimestamp {
+		a.buf = fmt.Appendf(a.buf, "%s%d\r\n", timestampAnnotation, now)
+		a.lastTimestamp = now
+	}
+	for _, args := range cmds {
+		a.buf = resp.AppendCommand(a.buf, args...)
+	}
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, a.buf...)
+	}
+	n, err := a.f.Write(a.buf)
+	a.size += int64(n)
+	if a.lastWriteErr = err; err != nil {
+		log.Printf("Error writing to the AOF: %v", err)
+		return
+	}
+	switch a.policy {
+	case fsyncAlways:
+		if err := a.f.Sync(); err != nil {
+			log.Printf("Error syncing the AOF: %v", err)
+		}
+	case fsyncEverysec:
+		a.unsynced = true
+	}
+}
+
+// timestampAnnotation starts the annotation lines giving the time of the
+// writes after them. Replaying the file, they read as inline commands of
+// one argument.
+const timestampAnnotation = "#TS:"
+
+// parseTimestampAnnotation returns the Unix time of the annotation read
+// as args, and whether args is one. Other annotations, starting with "#"
+// too, are skipped by the loader.
+func parseTimestampAnnotation(args []string) (ts int64, ok bool) {
+	if len(args) != 1 || !strings.HasPrefix(args[0], timestampAnnotation) {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(args[0][len(timestampAnnotation):], 10, 64)
+	return ts, err == nil
+}
+
+// flushEverySecond syncs the file once a second if it was written to,
+// until stop is closed. The sync runs without mu, so writes do not wait
+// for the disk.
+func (a *appendOnly) flushEverySecond() {
+	defer close(a.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		a.mu.Lock()
+		f, unsynced := a.f, a.unsynced
+		a.unsynced = false
+		a.mu.Unlock()
+		if !unsynced {
+			continue
+		}
+		// A rewrite may have swapped and closed f since.
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Printf("Error syncing the AOF: %v", err)
+		}
+	}
+}
+
+// close stops the flusher, then syncs and closes the file.
+func (a *appendOnly) close() error {
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// propagateAs replaces, in the AOF and for replicas, the write command
+// the handler is running with cmds, which must have the same effect when
+// replayed. It is used by commands whose effect depends on when or where
+// they run.
+func (c *Client) propagateAs(cmds ...[]string) {
+	if c.srv.propagating() {
+		c.rewritten = append(c.rewritten, cmds...)
+	}
+}
+
+// propagate records the effect of cmd, just run by c with args, if it
+// changed the dataset since the store's dirty counter was at dirty. The
+// effects are fed to the AOF and replicas by flushEffects once the
+// transaction or script they are part of is over. The caller must hold
+// the store's mu for writing.
+func (c *Client) propagate(cmd *Command, args []string, dirty int64) {
+	rewritten := c.rewritten
+	c.rewritten = nil
+	if !c.srv.propagating() || c.store.dirty == dirty {
+		return
+	}
+	if rewritten != nil {
+		c.effects = append(c.effects, rewritten...)
+	} else {
+		c.effects = append(c.effects, args)
+	}
+}
+
+// propagateExpiry replaces, in the AOF and for replicas, the command just
+// run, which gave key a TTL, with cmd, setting the same absolute deadline;
+// or with a DEL if the deadline had passed and the key is gone, as
+// replaying keeps keys until they are deleted.
+func (c *Client) propagateExpiry(key string, cmd ...string) {
+	if _, ok := c.store.data[key]; !ok {
+		cmd = []string{"DEL", key}
+	}
+	c.propagateAs(cmd)
+}
+
+// expireKeys deletes the keys of cmd, about to be run by c with args, that
+// expired but are still stored, and records their deletion, so replaying
+// cmd, where they are live until deleted, finds the same keys. The caller
+// must hold the store's mu for writing.
+func (c *Client) expireKeys(cmd *Command, args []string) {
+	if !c.srv.propagating() || c.store.replaying() {
+		return
+	}
+	now := time.Now()
+	for _, key := range cmd.keys(args) {
+		if d, ok := c.store.data[key]; ok && d.expired(now) {
+			c.store.remove(key)
+			c.store.notify(notifyExpired, "expired", key)
+			c.effects = append(c.effects, []string{"DEL", key})
+		}
+	}
+}
+
+// flushEffects feeds the effects recorded by propagate to the AOF and
+// replicas, in a MULTI/EXEC block if there are several, so they are
+// replayed together. The caller must hold the store's mu for writing, so
+// effects are logged in the order they happened.
+func (c *Client) flushEffects() {
+	effects := c.effects
+	c.effects = nil
+	switch {
+	case len(effects) == 0:
+		return
+	case len(effects) > 1:
+		effects = slices.Concat([][]string{{"MULTI"}}, effects, [][]string{{"EXEC"}})
+	}
+	c.woff = c.srv.feed(effects)
+}
+
+// feed sends effects to the AOF and replicas, and returns the replication
+// offset after them. The caller must hold the store's mu for writing.
+func (srv *Server) feed(effects [][]string) int64 {
+	if srv.aof != nil {
+		srv.aof.feed(effects)
+	}
+	return srv.repl.feed(effects)
+}
+
+// propagating reports whether the effects of writes are recorded, for the
+// AOF or replicas.
+func (srv *Server) propagating() bool {
+	return srv.aof != nil || srv.repl.hasReplicas()
+}
+
+// loadAppendOnly replays the append-only file at path into the store,
+// taking its lock. Commands run as in a transaction, so blocking commands
+// do not wait, and their replies are dropped; an unknown command is an
+// error. A MULTI block runs once its EXEC is read, so a transaction cut
+// short is not half applied.
+//
+// The file may start with a snapshot, as written by a rewrite with
+// aof-use-rdb-preamble, which is loaded before the commands after it.
+// A file ending in the middle of a command or transaction, as after a
+// crash while it was written, is truncated to the last complete one with
+// aof-load-truncated, and an error otherwise.
+//
+// With recover-to-timestamp, the file is replayed up to the first
+// timestamp annotation later than it and truncated there, so the writes
+// made after that moment are dropped for good.
+func (srv *Server) loadAppendOnly(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c := newClient(nil, srv)
+	defer srv.removeClient(c)
+	c.execing = true
+	srv.store.mu.Lock()
+	defer srv.store.mu.Unlock()
+	srv.store.loading = true
+	defer func() { srv.store.loading = false }()
+	in := &countingReader{r: f}
+	br := bufio.NewReader(in)
+	// valid is the offset the last complete command or transaction ends
+	// at, and multi holds the commands of an open transaction, with the
+	// offsets they start at.
+	var valid int64
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		if err := srv.store.readSnapshot(br); err != nil {
+			return fmt.Errorf("loading the RDB preamble of the append only file: %w", err)
+		}
+		valid = in.n - int64(br.Buffered())
+	}
+	r := resp.NewReader(br)
+	type logged struct {
+		args   []string
+		offset int64
+	}
+	var multi []logged
+	inMulti := false
+	for {
+		offset := in.n - int64(r.Buffered())
+		args, err := r.ReadCommand()
+		if err == io.EOF && !inMulti {
+			return nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return srv.truncatedAppendOnly(path, valid)
+		}
+		if err != nil {
+			return fmt.Errorf("bad file format reading the append only file: %w", err)
+		}
+		if ts, ok := parseTimestampAnnotation(args); ok && !inMulti && srv.cfg.RecoverToTimestamp > 0 && ts > srv.cfg.RecoverToTimestamp {
+			return srv.recoveredAppendOnly(path, valid, ts)
+		}
+		switch {
+		case len(args) == 0:
+		case len(args) == 1 && strings.HasPrefix(args[0], "#"):
+			// An annotation.
+		case strings.EqualFold(args[0], "MULTI"):
+			inMulti, multi = true, nil
+		case strings.EqualFold(args[0], "EXEC"):
+			for _, cmd := range multi {
+				if err := replay(c, cmd.args, cmd.offset); err != nil {
+					return err
+				}
+			}
+			inMulti, multi = false, nil
+		case inMulti:
+			multi = append(multi, logged{args, offset})
+		default:
+			if err := replay(c, args, offset); err != nil {
+				return err
+			}
+		}
+		if !inMulti {
+			valid = in.n - int64(r.Buffered())
+		}
+	}
+}
+
+// replay runs a command read from the append-only file at offset. An
+// unknown command, or one with the wrong number of arguments, is an error.
+func replay(c *Client, args []string, offset int64) error {
+	cmd := lookupCommand(args[0])
+	if cmd == nil {
+		return fmt.Errorf("unknown command '%s' reading the append only file at offset %d", args[0], offset)
+	}
+	if !cmd.checkArity(len(args)) {
+		return fmt.Errorf("wrong number of arguments for '%s' reading the append only file at offset %d", args[0], offset)
+	}
+	cmd.handler(c, args)
+	return nil
+}
+
+// truncatedAppendOnly handles an append-only file whose end, from offset
+// valid, is an incomplete command or transaction: with aof-load-truncated
+// the end is cut off, so later writes are appended to a valid file, and
+// loading succeeds with what was before it.
+func (srv *Server) truncatedAppendOnly(path string, valid int64) error {
+	if !srv.cfg.AOFLoadTruncated {
+		return fmt.Errorf("unexpected end of file reading the append only file %s; set aof-load-truncated to yes to load it anyway, dropping the incomplete command at the end", path)
+	}
+	log.Printf("!!! Warning: short read while loading the AOF file %s!!!", path)
+	if err := os.Truncate(path, valid); err != nil {
+		return fmt.Errorf("truncating the append only file: %w", err)
+	}
+	log.Printf("AOF %s loaded anyway because aof-load-truncated is enabled, truncated to %d bytes", path, valid)
+	return nil
+}
+
+// recoveredAppendOnly truncates the append-only file at offset valid, where
+// the annotation of time ts past the recover-to-timestamp was read, so the
+// server goes on from the dataset as it was then.
+func (srv *Server) recoveredAppendOnly(path string, valid, ts int64) error {
+	if err := os.Truncate(path, valid); err != nil {
+		return fmt.Errorf("truncating the append only file: %w", err)
+	}
+	log.Printf("AOF %s recovered to timestamp %d: the writes from %s on were dropped, truncating it to %d bytes",
+		path, srv.cfg.RecoverToTimestamp, time.Unix(ts, 0).UTC().Format(time.RFC3339), valid)
+	return nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// rewriteItemsPerCmd caps the elements a rewritten command adds at once,
+// so a large collection does not become one huge command.
+const rewriteItemsPerCmd = 64
+
+// writeCommands encodes the snapshot to out as the shortest commands that
+// rebuild it: the function libraries, then each key with its TTL.
+func (snap *snapshot) writeCommands(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	var buf []byte
+	emit := func(args ...string) {
+		buf = resp.AppendCommand(buf[:0], args...)
+		w.Write(buf)
+	}
+	for _, code := range snap.libraries {
+		emit("FUNCTION", "LOAD", code)
+	}
+	err := snap.each(func(key string, d StoreData) error {
+		if err := rewriteValue(key, d.value, emit); err != nil {
+			return err
+		}
+		if !d.expiresAt.IsZero() {
+			emit("PEXPIREAT", key, strconv.FormatInt(d.expiresAt.UnixMilli(), 10))
+		}
+		snap.processed.Add(1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// rewriteValue emits the commands that store v at key.
+func rewriteValue(key string, v any, emit func(args ...string)) error {
+	// batches emits cmd, key and then items, rewriteItemsPerCmd at a time.
+	batches := func(cmd string, items []string, perItem int) {
+		for len(items) > 0 {
+			n := min(len(items), rewriteItemsPerCmd*perItem)
+			emit(append([]string{cmd, key}, items[:n]...)...)
+			items = items[n:]
+		}
+	}
+	switch v := v.(type) {
+	case []byte:
+		emit("SET", key, string(v))
+	case *listValue:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = v.At(i)
+		}
+		batches("RPUSH", items, 1)
+	case *setValue:
+		batches("SADD", v.list(), 1)
+	case *hashValue:
+		var items []string
+		for field, val := range v.fields {
+			items = append(items, field, val)
+		}
+		batches("HSET", items, 2)
+		for field, at := range v.expires {
+			emit("HPEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10), "FIELDS", "1", field)
+		}
+	case *zsetValue:
+		var items []string
+		for x := v.zsl.first(); x != nil; x = x.next() {
+			items = append(items, resp.FormatDouble(x.score), x.member)
+		}
+		batches("ZADD", items, 2)
+	case *streamValue:
+		rewriteStream(key, v, emit)
+	case *moduleValue:
+		if v.typ.Rewrite == nil {
+			return fmt.Errorf("value of type %s at key %q cannot be rewritten", v.typ.Name, key)
+		}
+		for _, args := range v.typ.Rewrite(key, v.v) {
+			emit(args...)
+		}
+	}
+	return nil
+}
+
+// rewriteStream emits the commands that rebuild st at key: its entries,
+// its consumer groups and their consumers, and each pending entry as an
+// XCLAIM keeping its delivery time and count. Pending entries whose
+// stream entry was trimmed cannot be claimed back and are left out, as
+// the next XCLAIM of them would drop them anyway.
+func rewriteStream(key string, st *streamValue, emit func(args ...string)) {
+	for _, e := range st.entries {
+		emit(append([]string{"XADD", key, e.id.String()}, e.fields...)...)
+	}
+	switch {
+	case len(st.entries) > 0:
+	case st.lastID != streamID{}:
+		// An empty stream keeps its last ID through an entry trimmed
+		// as soon as it is added.
+		emit("XADD", key, "MAXLEN", "0", st.lastID.String(), "x", "y")
+	case len(st.groups) == 0:
+		// Without a last ID either, a group created and destroyed leaves
+		// the empty stream.
+		emit("XGROUP", "CREATE", key, "x", "0", "MKSTREAM")
+		emit("XGROUP", "DESTROY", key, "x")
+	}
+	for name, g := range st.groups {
+		emit("XGROUP", "CREATE", key, name, g.lastID.String(), "MKSTREAM")
+		for cname := range g.consumers {
+			emit("XGROUP", "CREATECONSUMER", key, name, cname)
+		}
+		for _, p := range sortedPending(g.pel) {
+			if _, exists := st.entry(p.id); !exists {
+				continue
+			}
+			emit("XCLAIM", key, name, p.consumer.name, "0", p.id.String(),
+				"TIME", strconv.FormatInt(p.deliveredAt.UnixMilli(), 10),
+				"RETRYCOUNT", strconv.FormatInt(p.deliveries, 10), "FORCE", "JUSTID")
+		}
+	}
+}
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// aofRewriteState tracks the AOF rewrites the server runs, for INFO.
+type aofRewriteState struct {
+	mu sync.Mutex
+	// running is set while BGREWRITEAOF runs, since start.
+	running bool
+	start   time.Time
+	// lastErr and lastTime are the outcome and duration of the last
+	// rewrite, -1 before the first. rewrites counts them.
+	lastErr  error
+	lastTime time.Duration
+	rewrites int64
+	// done is released when the running rewrite finishes.
+	done sync.WaitGroup
+}
+
+func newAOFRewriteState() *aofRewriteState {
+	return &aofRewriteState{lastTime: -1}
+}
+
+// bgrewriteaof rewrites the appendfilename from a view of the store in
+// the background, while writes go on. Writes made meanwhile are both
+// logged to the current file and kept aside, to be appended to the new one
+// before it replaces it. It fails while another rewrite runs. The caller
+// must hold the store's mu for reading at least.
+func (srv *Server) bgrewriteaof() error {
+	st := srv.aofRewrite
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.running {
+		return errRewriteInProgress
+	}
+	snap := srv.store.snapshot(true)
+	if a := srv.aof; a != nil {
+		a.mu.Lock()
+		a.rewriting, a.rewriteBuf = true, nil
+		a.mu.Unlock()
+	}
+	st.running, st.start = true, time.Now()
+	st.done.Add(1)
+	go func() {
+		defer st.done.Done()
+		err := srv.rewriteAppendOnly(snap)
+		snap.close()
+		if err != nil {
+			log.Printf("Background AOF rewrite error: %v", err)
+		} else {
+			log.Print("Background AOF rewrite terminated with success")
+		}
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.running = false
+		st.lastErr = err
+		st.lastTime = time.Since(st.start)
+		st.rewrites++
+	}()
+	return nil
+}
+
+// aofInfo writes the append-only file fields of the persistence section
+// of INFO.
+func (srv *Server) aofInfo(w io.Writer) {
+	st := srv.aofRewrite
+	st.mu.Lock()
+	inProgress, current := 0, int64(-1)
+	if st.running {
+		inProgress, current = 1, int64(time.Since(st.start).Seconds())
+	}
+	last := int64(-1)
+	if st.lastTime >= 0 {
+		last = int64(st.lastTime.Seconds())
+	}
+	rewriteStatus := "ok"
+	if st.lastErr != nil {
+		rewriteStatus = "err"
+	}
+	rewrites := st.rewrites
+	st.mu.Unlock()
+
+	a := srv.aof
+	enabled, writeStatus := 0, "ok"
+	if a != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		enabled = 1
+		if a.lastWriteErr != nil {
+			writeStatus = "err"
+		}
+	}
+	fmt.Fprintf(w, "aof_enabled:%d\r\n", enabled)
+	fmt.Fprintf(w, "aof_rewrite_in_progress:%d\r\n", inProgress)
+	fmt.Fprint(w, "aof_rewrite_scheduled:0\r\n")
+	fmt.Fprintf(w, "aof_last_rewrite_time_sec:%d\r\n", last)
+	fmt.Fprintf(w, "aof_current_rewrite_time_sec:%d\r\n", current)
+	fmt.Fprintf(w, "aof_last_bgrewrite_status:%s\r\n", rewriteStatus)
+	fmt.Fprintf(w, "aof_rewrites:%d\r\n", rewrites)
+	fmt.Fprintf(w, "aof_last_write_status:%s\r\n", writeStatus)
+	if a != nil {
+		fmt.Fprintf(w, "aof_current_size:%d\r\n", a.size)
+		fmt.Fprintf(w, "aof_base_size:%d\r\n", a.baseSize)
+	}
+}
+
+// rewriteAppendOnly writes snap to a new file and, once it is on disk,
+// renames it over the appendfilename. If the AOF is on, the writes kept
+// aside since snap was taken are appended first and the new file becomes
+// the one logged to, all under the AOF's lock so no write is missed.
+func (srv *Server) rewriteAppendOnly(snap *snapshot) error {
+	f, err := srv.writeRewrite(snap)
+	if err != nil {
+		if a := srv.aof; a != nil {
+			a.mu.Lock()
+			a.rewriting, a.rewriteBuf = false, nil
+			a.mu.Unlock()
+		}
+		return err
+	}
+	a := srv.aof
+	if a == nil {
+		f.Close()
+		return os.Rename(f.Name(), srv.cfg.appendPath())
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	buf := a.rewriteBuf
+	a.rewriting, a.rewriteBuf = false, nil
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), srv.cfg.appendPath()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	a.f.Close()
+	a.f = f
+	a.baseSize, a.size = fi.Size(), fi.Size()+int64(len(buf))
+	// The writes kept aside carry their annotations, but the rewritten
+	// part has none, so the next write gets one.
+	a.lastTimestamp = 0
+	switch a.policy {
+	case fsyncAlways:
+		return f.Sync()
+	case fsyncEverysec:
+		a.unsynced = true
+	}
+	return nil
+}
+
+// writeRewrite writes snap to a temporary file next to the appendfilename,
+// as a snapshot with aof-use-rdb-preamble, which loads faster, and as
+// commands otherwise, and syncs it, returning it open for further appends.
+func (srv *Server) writeRewrite(snap *snapshot) (*os.File, error) {
+	f, err := os.CreateTemp(srv.cfg.Dir, "temp-rewriteaof-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	write := snap.writeCommands
+	if srv.cfg.AOFUseRDBPreamble {
+		snap.compress, snap.checksum = srv.cfg.RDBCompression, srv.cfg.RDBChecksum
+		write = snap.write
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
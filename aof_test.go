@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// newAppendOnlyServer returns a server logging writes to a file in a
+// temporary directory.
+func newAppendOnlyServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Dir = t.TempDir()
+	cfg.AppendOnly = true
+	srv := NewServer(cfg)
+	if err := srv.startAppendOnly(); err != nil {
+		t.Fatalf("startAppendOnly: %v", err)
+	}
+	t.Cleanup(func() {
+		// SHUTDOWN closes it.
+		if srv.aof != nil {
+			srv.aof.close()
+		}
+	})
+	return srv
+}
+
+// appendOnlyCommands reads back the commands logged to the AOF of srv,
+// after its RDB preamble if it has one.
+func appendOnlyCommands(t *testing.T, srv *Server) [][]string {
+	t.Helper()
+	data, err := os.ReadFile(srv.cfg.appendPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmds [][]string
+	br := bufio.NewReader(bytes.NewReader(data))
+	if bytes.HasPrefix(data, []byte("REDIS")) {
+		if err := NewStore().readSnapshot(br); err != nil {
+			t.Fatalf("reading the RDB preamble: %v", err)
+		}
+	}
+	r := resp.NewReader(br)
+	for {
+		args, err := r.ReadCommand()
+		if err != nil {
+			return cmds
+		}
+		cmds = append(cmds, args)
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	c := newClient(nil, newAppendOnlyServer(t))
+	for _, args := range [][]string{
+		{"SET", "str", "v"},
+		{"GET", "str"},
+		{"SET", "ttl", "v", "EX", "100"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"SADD", "set", "a", "b", "c"},
+		{"SPOP", "set"},
+		{"HSET", "hash", "f", "1", "g", "2"},
+		{"HEXPIRE", "hash", "100", "FIELDS", "1", "g"},
+		{"XADD", "stream", "*", "f", "v"},
+		{"XADD", "stream", "*", "f", "w"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "STREAMS", "stream", ">"},
+		{"MULTI"},
+		{"INCR", "n"},
+		{"INCR", "n"},
+		{"EXEC"},
+		{"EVAL", "redis.call('LPUSH', KEYS[1], 'x'); return redis.call('LPOP', KEYS[1])", "1", "list"},
+		{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('hi', function() return 'hi' end)"},
+		{"ZADD", "gone", "1", "a"},
+		{"ZREM", "gone", "a"},
+	} {
+		if reply := do(c, args...); reply.IsError() {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	id := do(c, "XRANGE", "stream", "-", "+", "COUNT", "1").Array[0].Array[0].Str
+	if got := do(c, "XCLAIM", "stream", "grp", "bob", "0", id, "JUSTID"); len(got.Array) != 1 {
+		t.Fatalf("XCLAIM %s = %+v, want it claimed", id, got)
+	}
+	// A blocked client served by a push logs its pop after the push.
+	served := doBlocking(newClient(nil, c.srv), "BLPOP", "queue", "0")
+	do(c, "RPUSH", "queue", "a", "b")
+	expectUnblocked(t, served, resp.Array(resp.BulkString("queue"), resp.BulkString("a")))
+
+	cmds := appendOnlyCommands(t, c.srv)
+	logged := make(map[string]int)
+	for _, args := range cmds {
+		logged[args[0]]++
+	}
+	// Reads and commands depending on chance or the clock are not logged
+	// as sent.
+	for _, name := range []string{"GET", "SPOP", "HEXPIRE", "EVAL"} {
+		if logged[name] > 0 {
+			t.Errorf("%s was logged: %q", name, cmds)
+		}
+	}
+	for _, args := range cmds {
+		if args[0] == "XADD" && args[2] == "*" {
+			t.Errorf("XADD was logged with an automatic ID: %q", args)
+		}
+	}
+	if logged["MULTI"] != logged["EXEC"] || logged["MULTI"] < 2 {
+		t.Errorf("logged %d MULTI and %d EXEC, want the same, 2 or more", logged["MULTI"], logged["EXEC"])
+	}
+
+	reads := [][]string{
+		{"GET", "str"},
+		{"PEXPIRETIME", "ttl"},
+		{"LRANGE", "list", "0", "-1"},
+		{"SCARD", "set"},
+		{"SMISMEMBER", "set", "a", "b", "c"},
+		{"HMGET", "hash", "f", "g"},
+		{"XRANGE", "stream", "-", "+"},
+		{"GET", "n"},
+		{"FCALL", "hi", "0"},
+		{"EXISTS", "gone"},
+		{"LRANGE", "queue", "0", "-1"},
+	}
+	loaded := newClient(nil, NewServer(c.srv.cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	for _, args := range reads {
+		if want, got := do(c, args...), do(loaded, args...); !reflect.DeepEqual(got, want) {
+			t.Errorf("after loading, %q = %+v, want %+v", args, got, want)
+		}
+	}
+	expectSameInternals(t, c.store, loaded.store, "hash", "stream")
+}
+
+func TestAppendOnlyTTLs(t *testing.T) {
+	c := newClient(nil, newAppendOnlyServer(t))
+	pxat := func(key string) string { return strconv.FormatInt(do(c, "PEXPIRETIME", key).Int, 10) }
+	do(c, "SET", "dumped", "v")
+	dump := do(c, "DUMP", "dumped")
+	var want [][]string
+	for _, step := range []struct {
+		args   []string
+		logged func() [][]string
+	}{
+		// Relative TTLs are logged as absolute deadlines, and commands
+		// keeping the TTL as sent.
+		{[]string{"SET", "k", "v", "PX", "100000", "GET"}, func() [][]string { return [][]string{{"SET", "k", "v", "PXAT", pxat("k"), "GET"}} }},
+		{[]string{"APPEND", "k", "w"}, nil},
+		{[]string{"SET", "k", "v", "KEEPTTL"}, nil},
+		{[]string{"EXPIRE", "k", "200", "GT"}, func() [][]string { return [][]string{{"PEXPIREAT", "k", pxat("k")}} }},
+		{[]string{"GETEX", "k", "EX", "300"}, func() [][]string { return [][]string{{"PEXPIREAT", "k", pxat("k")}} }},
+		{[]string{"RESTORE", "r", "100000", dump.Str}, func() [][]string { return [][]string{{"RESTORE", "r", pxat("r"), dump.Str, "ABSTTL"}} }},
+		{[]string{"SET", "at", "v", "EXAT", "4102444800"}, nil},
+		// A deadline in the past deletes the key.
+		{[]string{"PEXPIRE", "k", "-1"}, func() [][]string { return [][]string{{"DEL", "k"}} }},
+		{[]string{"SET", "r", "v", "PXAT", "1"}, func() [][]string { return [][]string{{"DEL", "r"}} }},
+		{[]string{"SET", "short", "v", "PX", "20"}, func() [][]string { return [][]string{{"SET", "short", "v", "PXAT", pxat("short")}} }},
+		{[]string{"APPEND", "short", "w"}, nil},
+		{[]string{"SET", "shorter", "v", "PX", "20"}, func() [][]string { return [][]string{{"SET", "shorter", "v", "PXAT", pxat("shorter")}} }},
+	} {
+		if reply := do(c, step.args...); reply.IsError() {
+			t.Fatalf("%q = %+v", step.args, reply)
+		}
+		if step.logged != nil {
+			want = append(want, step.logged()...)
+		} else {
+			want = append(want, step.args)
+		}
+	}
+	// A write to a key that expired but is still stored logs its
+	// deletion first.
+	time.Sleep(30 * time.Millisecond)
+	do(c, "APPEND", "short", "x")
+	want = append(want, []string{"MULTI"}, []string{"DEL", "short"}, []string{"APPEND", "short", "x"}, []string{"EXEC"})
+
+	cmds := appendOnlyCommands(t, c.srv)
+	if i := slices.IndexFunc(cmds, func(args []string) bool { return args[0] == "SET" && args[1] == "k" }); i < 0 || !reflect.DeepEqual(cmds[i:], want) {
+		t.Errorf("logged %q, want %q", cmds, want)
+	}
+
+	// The keys are live while the file is replayed, so shorter, which
+	// has expired since, is not brought back by the APPEND that followed.
+	loaded := newClient(nil, NewServer(c.srv.cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	for _, args := range [][]string{
+		{"EXISTS", "k", "r", "shorter"},
+		{"GET", "short"},
+		{"PTTL", "short"},
+		{"PEXPIRETIME", "at"},
+	} {
+		if want, got := do(c, args...), do(loaded, args...); !reflect.DeepEqual(got, want) {
+			t.Errorf("after loading, %q = %+v, want %+v", args, got, want)
+		}
+	}
+}
+
+func TestAppendOnlyLoadErrors(t *testing.T) {
+	srv := newAppendOnlyServer(t)
+	for _, data := range []string{
+		string(resp.AppendCommand(nil, "NOSUCHCOMMAND", "k")),
+		string(resp.AppendCommand(nil, "SET", "k")),
+		string(resp.AppendCommand(nil, "MULTI")) + string(resp.AppendCommand(nil, "INCR")) + string(resp.AppendCommand(nil, "EXEC")),
+		"*3\r\n$3\r\nSET\r\n:1\r\n" + string(resp.AppendCommand(nil, "SET", "k", "v")),
+	} {
+		os.WriteFile(srv.cfg.appendPath(), []byte(data), 0o644)
+		if err := NewServer(srv.cfg).loadDataFromDisk(); err == nil {
+			t.Errorf("loading %q succeeded", data)
+		}
+	}
+
+	// The error tells where the bad command is.
+	set := resp.AppendCommand(nil, "SET", "k", "v")
+	os.WriteFile(srv.cfg.appendPath(), append(set, resp.AppendCommand(nil, "SET", "k")...), 0o644)
+	err := NewServer(srv.cfg).loadDataFromDisk()
+	if want := fmt.Sprintf("wrong number of arguments for 'SET' reading the append only file at offset %d", len(set)); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("loading a SET with one argument: %v, want %q", err, want)
+	}
+}
+
+func TestAppendOnlyTruncated(t *testing.T) {
+	set := func(key string) string { return string(resp.AppendCommand(nil, "SET", key, "v")) }
+	multi := string(resp.AppendCommand(nil, "MULTI"))
+	exec := string(resp.AppendCommand(nil, "EXEC"))
+	for _, tc := range []struct {
+		data, valid string
+		keys        int
+	}{
+		{set("a") + set("b")[:10], set("a"), 1},
+		{set("a") + set("b")[:len(set("b"))-1], set("a"), 1},
+		{set("a") + multi + set("b") + exec + multi + set("c"), set("a") + multi + set("b") + exec, 2},
+		{multi + set("a") + set("b"), "", 0},
+	} {
+		srv := newAppendOnlyServer(t)
+		path := srv.cfg.appendPath()
+		os.WriteFile(path, []byte(tc.data), 0o644)
+
+		// Without aof-load-truncated the server refuses to start.
+		cfg := *srv.cfg
+		cfg.AOFLoadTruncated = false
+		if err := NewServer(&cfg).loadDataFromDisk(); err == nil {
+			t.Errorf("loading %q without aof-load-truncated succeeded", tc.data)
+		}
+
+		loaded := NewServer(srv.cfg)
+		if err := loaded.loadDataFromDisk(); err != nil {
+			t.Errorf("loading %q: %v", tc.data, err)
+			continue
+		}
+		if got := len(loaded.store.data); got != tc.keys {
+			t.Errorf("loading %q loaded %d keys, want %d", tc.data, got, tc.keys)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tc.valid {
+			t.Errorf("loading %q left %q, want %q", tc.data, got, tc.valid)
+		}
+	}
+}
+
+func TestAppendOnlyTimestamps(t *testing.T) {
+	c := newClient(nil, newAppendOnlyServer(t))
+	c.srv.aof.timestamps = true
+	before := time.Now().Unix()
+	do(c, "SET", "k", "v")
+	cmds := appendOnlyCommands(t, c.srv)
+	if len(cmds) != 2 {
+		t.Fatalf("logged %q", cmds)
+	}
+	if ts, ok := parseTimestampAnnotation(cmds[0]); !ok || ts < before || ts > time.Now().Unix() {
+		t.Errorf("logged %q before the write, want the time", cmds[0])
+	}
+	loaded := NewServer(c.srv.cfg)
+	if err := loaded.loadDataFromDisk(); err != nil {
+		t.Fatalf("loading an annotated file: %v", err)
+	}
+	if got := len(loaded.store.data); got != 1 {
+		t.Errorf("loaded %d keys, want 1", got)
+	}
+
+	// Recovering to a timestamp replays the writes up to it and drops
+	// the rest from the file.
+	set := func(key string) string { return string(resp.AppendCommand(nil, "SET", key, "v")) }
+	del := string(resp.AppendCommand(nil, "DEL", "a"))
+	data := "#TS:100\r\n" + set("a") + "#TS:200\r\n" + set("b") + "#TS:300\r\n" + del + set("c")
+	for _, tc := range []struct {
+		to    int64
+		keys  []string
+		valid string
+	}{
+		{0, []string{"b", "c"}, data},
+		{50, nil, ""},
+		{100, []string{"a"}, "#TS:100\r\n" + set("a")},
+		{299, []string{"a", "b"}, "#TS:100\r\n" + set("a") + "#TS:200\r\n" + set("b")},
+		{300, []string{"b", "c"}, data},
+	} {
+		srv := newAppendOnlyServer(t)
+		path := srv.cfg.appendPath()
+		os.WriteFile(path, []byte(data), 0o644)
+		srv.cfg.RecoverToTimestamp = tc.to
+		loaded := NewServer(srv.cfg)
+		if err := loaded.loadDataFromDisk(); err != nil {
+			t.Errorf("recovering to %d: %v", tc.to, err)
+			continue
+		}
+		if got := slices.Sorted(maps.Keys(loaded.store.data)); !slices.Equal(got, tc.keys) {
+			t.Errorf("recovering to %d loaded %q, want %q", tc.to, got, tc.keys)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tc.valid {
+			t.Errorf("recovering to %d left %q, want %q", tc.to, got, tc.valid)
+		}
+	}
+}
+
+func TestAppendOnlyFromDumpFile(t *testing.T) {
+	// Turning appendonly on, there is no AOF yet: the dump file is loaded
+	// and the AOF created from it.
+	c := newClient(nil, newSnapshotServer(t))
+	do(c, "SET", "k", "v")
+	do(c, "SAVE")
+	cfg := *c.srv.cfg
+	cfg.AppendOnly = true
+	srv := NewServer(&cfg)
+	if err := srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk without an AOF: %v", err)
+	}
+	if err := srv.startAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// SHUTDOWN closes it.
+		if srv.aof != nil {
+			srv.aof.close()
+		}
+	})
+	do(newClient(nil, srv), "SET", "later", "v")
+	loaded := newClient(nil, NewServer(&cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	expectReply(t, loaded, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.BulkString("v")},
+		{[]string{"GET", "later"}, resp.BulkString("v")},
+	})
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	RegisterType(blobType)
+	defer delete(typeTable, blobType.Name)
+	blobType.Rewrite = func(key string, v any) [][]string { return [][]string{{"SET", key, v.(string)}} }
+	defer func() { blobType.Rewrite = nil }()
+
+	c := newClient(nil, newAppendOnlyServer(t))
+	c.srv.cfg.AOFUseRDBPreamble = false
+	for _, args := range [][]string{
+		{"SET", "str", "v"},
+		{"SET", "str", "w"},
+		{"SET", "ttl", "v", "PX", "100000"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"LPOP", "list"},
+		{"SADD", "set", "a", "b"},
+		{"HSET", "hash", "f", "1", "g", "2"},
+		{"HPEXPIRE", "hash", "100000", "FIELDS", "1", "g"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XADD", "stream", "2-1", "f", "w"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "COUNT", "1", "STREAMS", "stream", ">"},
+		{"XGROUP", "CREATECONSUMER", "stream", "grp", "bob"},
+		{"XADD", "trimmed", "5-5", "f", "v"},
+		{"XTRIM", "trimmed", "MAXLEN", "0"},
+		{"XGROUP", "CREATE", "empty", "grp", "0", "MKSTREAM"},
+		{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('hi', function() return 'hi' end)"},
+	} {
+		if reply := do(c, args...); reply.IsError() {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	for i := range 200 {
+		do(c, "RPUSH", "long", strconv.Itoa(i))
+	}
+	c.SetValue("blob", blobType, "opaque")
+	before := len(appendOnlyCommands(t, c.srv))
+
+	if got := do(c, "BGREWRITEAOF"); !reflect.DeepEqual(got, resp.SimpleString("Background append only file rewriting started")) {
+		t.Fatalf("BGREWRITEAOF = %+v", got)
+	}
+	c.srv.aofRewrite.done.Wait()
+	cmds := appendOnlyCommands(t, c.srv)
+	if len(cmds) >= before {
+		t.Errorf("the rewrite has %d commands, the AOF had %d", len(cmds), before)
+	}
+	for _, args := range cmds {
+		if args[0] == "RPUSH" && len(args) > 2+rewriteItemsPerCmd {
+			t.Errorf("RPUSH of %d elements", len(args)-2)
+		}
+	}
+
+	// Writes made while the rewrite runs end up in the new file, and
+	// later ones go there too.
+	c.store.mu.RLock()
+	snap := c.store.snapshot(true)
+	c.srv.aof.rewriting = true
+	c.store.mu.RUnlock()
+	defer snap.close()
+	do(c, "SET", "during", "v")
+	if err := c.srv.rewriteAppendOnly(snap); err != nil {
+		t.Fatalf("rewriteAppendOnly: %v", err)
+	}
+	do(c, "SET", "after", "v")
+
+	reads := [][]string{
+		{"GET", "str"},
+		{"PEXPIRETIME", "ttl"},
+		{"LRANGE", "list", "0", "-1"},
+		{"LRANGE", "long", "0", "-1"},
+		{"SMISMEMBER", "set", "a", "b"},
+		{"HMGET", "hash", "f", "g"},
+		{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		{"XRANGE", "stream", "-", "+"},
+		{"XPENDING", "stream", "grp", "-", "+", "10"},
+		{"EXISTS", "trimmed", "empty"},
+		{"FCALL", "hi", "0"},
+		{"GET", "blob"},
+		{"GET", "during"},
+		{"GET", "after"},
+		{"DBSIZE"},
+	}
+	loaded := newClient(nil, NewServer(c.srv.cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	c.store.data["blob"] = StoreData{value: []byte("opaque")}
+	for _, args := range reads {
+		want, got := do(c, args...), do(loaded, args...)
+		if args[0] == "XPENDING" {
+			want, got = pendingIDs(want), pendingIDs(got)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("after rewriting, %q = %+v, want %+v", args, got, want)
+		}
+	}
+	expectSameInternals(t, c.store, loaded.store, "hash", "stream", "trimmed", "empty")
+
+	// A value the rewrite cannot encode makes it fail.
+	blobType.Rewrite = nil
+	c.SetValue("blob", blobType, "opaque")
+	do(c, "BGREWRITEAOF")
+	c.srv.aofRewrite.done.Wait()
+	if err := c.srv.aofRewrite.lastErr; err == nil {
+		t.Error("rewriting a value of a type without Rewrite succeeded")
+	}
+	before = len(appendOnlyCommands(t, c.srv))
+	do(c, "SET", "k", "v")
+	if got := len(appendOnlyCommands(t, c.srv)); got != before+1 {
+		t.Errorf("after a failed rewrite, a write took the AOF from %d to %d commands", before, got)
+	}
+
+	c.srv.aofRewrite.running = true
+	if got := do(c, "BGREWRITEAOF"); !reflect.DeepEqual(got, resp.Error("ERR Background append only file rewriting already in progress")) {
+		t.Errorf("BGREWRITEAOF during a rewrite = %+v", got)
+	}
+	c.srv.aofRewrite.running = false
+}
+
+func TestBgrewriteaofPreamble(t *testing.T) {
+	c := newClient(nil, newAppendOnlyServer(t))
+	do(c, "RPUSH", "list", "a", "b", "c")
+	do(c, "LPOP", "list")
+	do(c, "SET", "ttl", "v", "PX", "100000")
+	do(c, "BGREWRITEAOF")
+	c.srv.aofRewrite.done.Wait()
+	do(c, "SET", "after", "v")
+	info := do(c, "INFO", "persistence").Str
+	for _, line := range []string{"aof_enabled:1", "aof_rewrite_in_progress:0", "aof_last_bgrewrite_status:ok", "aof_rewrites:1", "aof_last_write_status:ok"} {
+		if !strings.Contains(info, "\r\n"+line+"\r\n") {
+			t.Errorf("INFO persistence lacks %q:\n%s", line, info)
+		}
+	}
+
+	data, _ := os.ReadFile(c.srv.cfg.appendPath())
+	if want := fmt.Sprintf("aof_current_size:%d\r\n", len(data)); !strings.Contains(info, want) {
+		t.Errorf("INFO persistence lacks %q:\n%s", want, info)
+	}
+	if !bytes.HasPrefix(data, []byte("REDIS")) {
+		t.Fatalf("the rewritten AOF starts with %q, want an RDB preamble", data[:min(len(data), 16)])
+	}
+	if got := appendOnlyCommands(t, c.srv); !reflect.DeepEqual(got, [][]string{{"SET", "after", "v"}}) {
+		t.Errorf("after the preamble, the AOF has %q", got)
+	}
+	loaded := newClient(nil, NewServer(c.srv.cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	for _, args := range [][]string{{"LRANGE", "list", "0", "-1"}, {"PEXPIRETIME", "ttl"}, {"GET", "after"}, {"DBSIZE"}} {
+		if want, got := do(c, args...), do(loaded, args...); !reflect.DeepEqual(got, want) {
+			t.Errorf("after loading, %q = %+v, want %+v", args, got, want)
+		}
+	}
+
+	// A tail cut short is truncated after the preamble.
+	os.WriteFile(c.srv.cfg.appendPath(), data[:len(data)-3], 0o644)
+	if err := NewServer(c.srv.cfg).loadDataFromDisk(); err != nil {
+		t.Fatalf("loading a truncated tail: %v", err)
+	}
+	if got, _ := os.ReadFile(c.srv.cfg.appendPath()); !bytes.Equal(got, data[:len(data)-len(resp.AppendCommand(nil, "SET", "after", "v"))]) {
+		t.Errorf("the truncated AOF kept %d bytes of %d", len(got), len(data))
+	}
+}
+
+func TestAppendFsync(t *testing.T) {
+	for _, policy := range []appendFsync{fsyncAlways, fsyncEverysec, fsyncNo} {
+		srv := newAppendOnlyServer(t)
+		srv.aof.close()
+		srv.cfg.AppendFsync = policy
+		if err := srv.startAppendOnly(); err != nil {
+			t.Fatal(err)
+		}
+		c := newClient(nil, srv)
+		do(c, "SET", "k", "v")
+		if got := appendOnlyCommands(t, srv); len(got) != 1 {
+			t.Errorf("policy %d: logged %q, want the SET", policy, got)
+		}
+		srv.aof.mu.Lock()
+		unsynced := srv.aof.unsynced
+		srv.aof.mu.Unlock()
+		if unsynced != (policy == fsyncEverysec) {
+			t.Errorf("policy %d: unsynced = %v after a write", policy, unsynced)
+		}
+	}
+
+	// The everysec flusher syncs the writes of the last second.
+	srv := newAppendOnlyServer(t)
+	do(newClient(nil, srv), "SET", "k", "v")
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		srv.aof.mu.Lock()
+		unsynced := srv.aof.unsynced
+		srv.aof.mu.Unlock()
+		if !unsynced {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the everysec flusher did not sync the file")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, s := range []string{"always", "EVERYSEC", "no"} {
+		if _, err := parseAppendFsync(s); err != nil {
+			t.Errorf("parseAppendFsync(%q) = %v", s, err)
+		}
+	}
+	if _, err := parseAppendFsync("sometimes"); err == nil {
+		t.Error("parseAppendFsync(sometimes) succeeded")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// Blocking commands such as BLPOP park the connection when there is
+// nothing to serve them yet. The handler calls Client.block, which records
+// the command in a per-key registry on the Store and makes the connection
+// wait for a reply instead of sending one.
+//
+// Writes mark keys with waiters as ready: put and remove do it for every
+// key, which covers lists and sorted sets, as clients only block on those
+// while the key is missing; commands that grow an existing value a client
+// may wait on, like XADD, call signalReady themselves. Once the write
+// command returns, and still under the store lock, serveBlocked re-runs
+// the commands of the clients waiting on each ready key in the order they
+// blocked. A command that finds nothing again stays blocked; otherwise its
+// reply is handed to its connection. Serving may make more keys ready,
+// for instance when BLMOVE pushes to another list, so this repeats until
+// no key is left ready.
+
+// waiter is a client parked by a blocking command.
+type waiter struct {
+	c       *Client
+	cmd     *Command
+	args    []string
+	keys    []string
+	timeout time.Duration // 0 to wait forever
+	// timeoutReply is sent if the timeout passes first; or, if onTimeout
+	// is set, what it returns then, under the store lock.
+	timeoutReply resp.Value
+	onTimeout    func() resp.Value
+	// reply receives the reply once the command is served.
+	reply chan resp.Value
+	// again is set by block when the command is re-run and still has
+	// nothing to do.
+	again bool
+}
+
+// block parks c on keys until one of them can serve the command in args,
+// or timeout passes, in which case timeoutReply is sent; with no keys only
+// the timeout ends the wait. Inside EXEC or a script, which cannot wait,
+// the timeout reply is returned straight away. The handler must return the value block
+// returns. The caller must hold the store lock for writing.
+func (c *Client) block(args, keys []string, timeout time.Duration, timeoutReply resp.Value) resp.Value {
+	if c.execing {
+		return timeoutReply
+	}
+	if w := c.blocked; w != nil {
+		w.again = true
+		return resp.Value{}
+	}
+	w := &waiter{
+		c:            c,
+		cmd:          lookupCommand(args[0]),
+		args:         args,
+		keys:         keys,
+		timeout:      timeout,
+		timeoutReply: timeoutReply,
+		reply:        make(chan resp.Value, 1),
+	}
+	c.blocked = w
+	c.store.addWaiter(w)
+	return resp.Value{}
+}
+
+// waitUnblocked waits for the command c blocked in to be served, returning
+// its reply. It gives up with the command's timeout reply once the timeout
+// passes or reader reports the connection closed; input that arrives in
+// the meantime stays buffered in reader. A nil reader is never watched.
+func (c *Client) waitUnblocked(reader *resp.Reader) resp.Value {
+	w := c.blocked
+	defer func() { c.blocked = nil }()
+
+	var expired <-chan time.Time
+	if w.timeout > 0 {
+		t := time.NewTimer(w.timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	var closed chan struct{}
+	if reader != nil && c.conn != nil {
+		closed = make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			if err := reader.Wait(); err != nil {
+				close(closed)
+			}
+		}()
+		defer func() {
+			// Interrupt the watcher if it is still waiting, so that
+			// reading can carry on from this goroutine.
+			c.conn.SetReadDeadline(time.Now())
+			<-stopped
+			c.conn.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	select {
+	case reply := <-w.reply:
+		return reply
+	case <-expired:
+	case <-closed:
+	}
+	c.store.mu.Lock()
+	c.store.removeWaiter(w)
+	timeoutReply := w.timeoutReply
+	if w.onTimeout != nil {
+		timeoutReply = w.onTimeout()
+	}
+	c.store.mu.Unlock()
+	// The command may have been served before the waiter was removed.
+	select {
+	case reply := <-w.reply:
+		return reply
+	default:
+		return timeoutReply
+	}
+}
+
+// addWaiter registers w on each of its keys. The caller must hold mu for
+// writing.
+func (s *Store) addWaiter(w *waiter) {
+	if s.waiters == nil {
+		s.waiters = make(map[string][]*waiter)
+	}
+	for _, key := range w.keys {
+		s.waiters[key] = append(s.waiters[key], w)
+	}
+}
+
+// removeWaiter drops w from the registry, if it is still there. The caller
+// must hold mu for writing.
+func (s *Store) removeWaiter(w *waiter) {
+	for _, key := range w.keys {
+		ws := s.waiters[key]
+		for i, other := range ws {
+			if other == w {
+				ws = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+		if len(ws) == 0 {
+			delete(s.waiters, key)
+		} else {
+			s.waiters[key] = ws
+		}
+	}
+}
+
+// signalReady marks key as worth retrying for the clients blocked on it.
+// The caller must hold mu for writing.
+func (s *Store) signalReady(key string) {
+	if _, ok := s.waiters[key]; !ok {
+		return
+	}
+	if s.ready == nil {
+		s.ready = make(map[string]struct{})
+	}
+	if _, ok := s.ready[key]; !ok {
+		s.ready[key] = struct{}{}
+		s.readyKeys = append(s.readyKeys, key)
+	}
+}
+
+// serveBlocked re-runs the commands of the clients blocked on ready keys,
+// in the order the keys became ready and the clients blocked, handing each
+// command that completes its reply. The caller must hold mu for writing.
+func (s *Store) serveBlocked() {
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0]
+		s.readyKeys = s.readyKeys[1:]
+		delete(s.ready, key)
+		// Serving a waiter removes it from the registry, so walk a copy.
+		for _, w := range append([]*waiter(nil), s.waiters[key]...) {
+			w.again = false
+			by := s.current
+			s.current = w.c
+			dirty := s.dirty
+			reply := w.cmd.Handler(w.c, w.args)
+			w.c.propagate(w.cmd, w.args, dirty)
+			w.c.flushEffects()
+			s.current = by
+			if w.again {
+				continue
+			}
+			s.removeWaiter(w)
+			w.reply <- reply
+		}
+	}
+	s.readyKeys = nil
+}
+
+// parseTimeoutMillis parses the BLOCK timeout of XREAD and XREADGROUP,
+// given in milliseconds. 0 means no timeout.
+func parseTimeoutMillis(arg string) (time.Duration, error) {
+	ms, ok := parseInt(arg)
+	if !ok {
+		return 0, errors.New("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	if ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, errors.New("ERR timeout is out of range")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// parseTimeout parses the timeout of a blocking command, given in seconds
+// with an optional fraction. 0 means no timeout.
+func parseTimeout(arg string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, errTimeoutNotFloat
+	}
+	if secs < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	if secs*1000 > math.MaxInt64/float64(time.Millisecond) {
+		return 0, errors.New("ERR timeout is out of range")
+	}
+	return time.Duration(secs*1000) * time.Millisecond, nil
+}
+
+var errTimeoutNotFloat = errors.New("ERR timeout is not a float or out of range")
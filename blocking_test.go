@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// doBlocking runs a command that may block on c, the way a connection
+// does, and returns a channel that receives its reply. The command has
+// blocked, if it does, by the time doBlocking returns.
+func doBlocking(c *Client, args ...string) <-chan resp.Value {
+	ch := make(chan resp.Value, 1)
+	reply := c.execute(args)
+	if c.blocked == nil {
+		ch <- reply
+		return ch
+	}
+	go func() { ch <- c.waitUnblocked(nil) }()
+	return ch
+}
+
+// expectBlocked fails if ch already has a reply.
+func expectBlocked(t *testing.T, ch <-chan resp.Value) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		t.Fatalf("got %v, want the command still blocked", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// expectUnblocked waits for the reply on ch and compares it with want.
+func expectUnblocked(t *testing.T, ch <-chan resp.Value, want resp.Value) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("still blocked, want %v", want)
+	}
+}
+
+func TestBlockedClientsServedInOrder(t *testing.T) {
+	srv := NewServer(defaultConfig())
+	c1, c2, c3 := newClient(nil, srv), newClient(nil, srv), newClient(nil, srv)
+	pusher := newClient(nil, srv)
+
+	r1 := doBlocking(c1, "BLPOP", "a", "b", "0")
+	r2 := doBlocking(c2, "BLPOP", "b", "0")
+	r3 := doBlocking(c3, "BRPOP", "b", "0")
+	expectBlocked(t, r1)
+
+	// A single push serves as many clients as it has elements for, first
+	// come first served.
+	do(pusher, "RPUSH", "b", "x", "y")
+	expectUnblocked(t, r1, resp.Array(resp.BulkString("b"), resp.BulkString("x")))
+	expectUnblocked(t, r2, resp.Array(resp.BulkString("b"), resp.BulkString("y")))
+	expectBlocked(t, r3)
+	if got := do(pusher, "EXISTS", "b"); got.Int != 0 {
+		t.Errorf("EXISTS b = %v, want the emptied list deleted", got)
+	}
+
+	do(pusher, "LPUSH", "b", "z")
+	expectUnblocked(t, r3, resp.Array(resp.BulkString("b"), resp.BulkString("z")))
+	if len(srv.store.waiters) != 0 {
+		t.Errorf("waiters = %v, want none left", srv.store.waiters)
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	c := newTestClient()
+	start := time.Now()
+	expectUnblocked(t, doBlocking(c, "BLPOP", "a", "0.05"), resp.NullArray)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("BLPOP returned after %v, want at least 50ms", elapsed)
+	}
+	if len(c.store.waiters) != 0 {
+		t.Errorf("waiters = %v, want none left after the timeout", c.store.waiters)
+	}
+}
+
+func TestBlockingOverConnection(t *testing.T) {
+	srv := NewServer(defaultConfig())
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go srv.handleConnection(server)
+
+	// The PING pipelined after BLPOP waits for it to be served.
+	go conn.Write([]byte("BLPOP q 0\r\nPING\r\n"))
+	waitFor(t, func() bool { return waiterCount(srv.store) == 1 })
+	do(newClient(nil, srv), "RPUSH", "q", "job")
+
+	reader := resp.NewReader(conn)
+	for _, want := range []resp.Value{
+		resp.Array(resp.BulkString("q"), resp.BulkString("job")),
+		resp.SimpleString("PONG"),
+	} {
+		got, err := reader.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// A client that disconnects while blocked stops waiting.
+	go conn.Write([]byte("BLPOP q 0\r\n"))
+	waitFor(t, func() bool { return waiterCount(srv.store) == 1 })
+	conn.Close()
+	waitFor(t, func() bool { return waiterCount(srv.store) == 0 })
+}
+
+func waiterCount(s *Store) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.waiters)
+}
+
+// waitFor polls cond until it holds, failing after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met after 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BLPOP", "a", "x"}, resp.Error("ERR timeout is not a float or out of range")},
+		{[]string{"BLPOP", "a", "-1"}, resp.Error("ERR timeout is negative")},
+		{[]string{"BLPOP", "a", "inf"}, resp.Error("ERR timeout is not a float or out of range")},
+		{[]string{"BLPOP", "a", "1e300"}, resp.Error("ERR timeout is out of range")},
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+
+	"go-http-practice/resp"
+)
+
+var nextClientID atomic.Int64
+
+// Client holds the per-connection state of a connected client.
+type Client struct {
+	id    int64
+	conn  net.Conn
+	srv   *Server
+	store *Store
+	proto int
+	name  string
+	// blocked is set while the client waits in a blocking command.
+	blocked *waiter
+	out     *output
+	// subscribed holds the client's pub/sub subscriptions of each kind.
+	// They are only changed by the client's own commands, under the pubsub
+	// lock.
+	subscribed [numSubKinds]map[string]struct{}
+	// multi holds the commands queued since MULTI, nil outside of a
+	// transaction. execing is set while EXEC or a script runs commands on
+	// the client's behalf.
+	multi   *transaction
+	execing bool
+	// watched holds the keys WATCHed by the client, each mapped to whether
+	// it held a live value at the time, and dirty is set once one of them
+	// is modified. Both are changed under the store lock, watched only by
+	// the client's own commands.
+	watched map[string]bool
+	dirty   bool
+	// tracking holds the CLIENT TRACKING options, nil while tracking is
+	// off. It is changed by the client's own commands, under the tracking
+	// lock. caching is set by CLIENT CACHING, 1 for yes and -1 for no, until
+	// the next command. See tracking.go.
+	tracking *trackingOptions
+	caching  int
+	// rewritten holds the commands a write handler logs to the AOF in
+	// place of the one it runs, and effects the commands logged by a
+	// command, transaction or script, until it is over. See aof.go.
+	rewritten [][]string
+	effects   [][]string
+	// master is set on the client running the writes a replica receives
+	// from its master, and replicaPort is the port a replica connected
+	// as c listens on, as it told with REPLCONF. woff is the replication
+	// offset after the client's last write, which WAIT waits for. See
+	// replication.go.
+	master      bool
+	replicaPort string
+	woff        int64
+}
+
+func newClient(conn net.Conn, srv *Server) *Client {
+	c := &Client{
+		id:    nextClientID.Add(1),
+		conn:  conn,
+		srv:   srv,
+		store: srv.store,
+		proto: 2,
+		out:   newOutput(srv.cfg.ClientOutputBufferLimitPubSub),
+	}
+	srv.addClient(c)
+	return c
+}
+
+// addr returns the address of the client's end of the connection, or the
+// empty string for clients without one.
+func (c *Client) addr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// noReply is returned by handlers that have written their replies to the
+// client's output themselves, like SUBSCRIBE, which confirms each channel
+// separately.
+var noReply resp.Value
+
+// errReadOnlyReplica rejects the writes of clients to a replica.
+var errReadOnlyReplica = resp.Error("READONLY You can't write against a read only replica.")
+
+// errBusyScript rejects the commands of clients while a script that ran
+// past lua-time-limit is being aborted.
+var errBusyScript = resp.Error("BUSY Redis is busy running a script. It ran past lua-time-limit and is being aborted.")
+
+// execute runs a single command on behalf of the client. The command is
+// looked up in the command table and its arity checked before its handler
+// is called. Commands are refused while a script past lua-time-limit is
+// being aborted. A RESP2 client with subscriptions can only run the
+// commands of subscriber mode, as its connection also carries messages,
+// and the clients of a replica cannot write. Inside a transaction
+// commands are queued instead, and one that cannot be queued makes EXEC
+// fail.
+func (c *Client) execute(args []string) resp.Value {
+	cmd := lookupCommand(args[0])
+	var rejected resp.Value
+	switch {
+	case cmd == nil:
+		rejected = unknownCommandReply(args)
+	case !cmd.checkArity(len(args)):
+		rejected = wrongArityReply(cmd.Name)
+	case c.srv.scriptBusy.Load():
+		rejected = errBusyScript
+	case c.multi != nil && cmd.Flags&flagNoMulti != 0:
+		rejected = resp.Error("ERR Command not allowed inside a transaction")
+	case cmd.Flags&flagWrite != 0 && c.srv.repl.readOnly(c):
+		rejected = errReadOnlyReplica
+	case c.proto < 3 && c.subscriptions() > 0 && !allowedWhileSubscribed[cmd.Name]:
+		rejected = resp.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", cmd.Name)
+	}
+	if rejected.Type != 0 {
+		if c.multi != nil {
+			c.multi.aborted = true
+		}
+		return rejected
+	}
+	if c.multi != nil && !runsInMulti[cmd.Name] {
+		c.multi.queued = append(c.multi.queued, args)
+		return resp.SimpleString("QUEUED")
+	}
+	return c.call(cmd, args)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func newTestClient() *Client {
+	return newClient(nil, NewServer(defaultConfig()))
+}
+
+// do runs a command on c, taking its arguments variadically. A command
+// that blocks is waited for.
+func do(c *Client, args ...string) resp.Value {
+	reply := c.execute(args)
+	if c.blocked != nil {
+		return c.waitUnblocked(nil)
+	}
+	return reply
+}
+
+// expectReply runs each command in order and compares the reply.
+func expectReply(t *testing.T, c *Client, tests []struct {
+	args []string
+	want resp.Value
+}) {
+	t.Helper()
+	for _, tc := range tests {
+		if got := do(c, tc.args...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	c := newTestClient()
+
+	reply := c.execute([]string{"HELLO", "3", "SETNAME", "worker"})
+	if reply.Type != resp.TypeMap {
+		t.Fatalf("expected map reply, got %+v", reply)
+	}
+	if c.proto != 3 || c.name != "worker" {
+		t.Errorf("expected proto 3 and name worker, got %d and %q", c.proto, c.name)
+	}
+
+	reply = c.execute([]string{"HELLO", "4"})
+	if reply.Type != resp.TypeError || reply.Str != "NOPROTO unsupported protocol version" {
+		t.Errorf("expected NOPROTO error, got %+v", reply)
+	}
+	if c.proto != 3 {
+		t.Errorf("failed HELLO must not change protocol, got %d", c.proto)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := newTestClient()
+	do(c, "HELLO", "3", "SETNAME", "worker")
+	do(c, "CLIENT", "TRACKING", "ON")
+	do(c, "SUBSCRIBE", "ch")
+	do(c, "WATCH", "k")
+	do(c, "MULTI")
+	do(c, "SET", "k", "v")
+
+	if got := do(c, "RESET"); !reflect.DeepEqual(got, resp.SimpleString("RESET")) {
+		t.Fatalf("RESET = %+v, want RESET", got)
+	}
+	if c.proto != 2 || c.name != "" || c.multi != nil || c.watched != nil || c.tracking != nil || c.subscriptions() != 0 {
+		t.Errorf("RESET left state behind: proto %d, name %q, multi %v, watched %v, tracking %v, %d subscriptions",
+			c.proto, c.name, c.multi, c.watched, c.tracking, c.subscriptions())
+	}
+	if n := c.srv.pubsub.numSub("ch", channelSub); n != 0 {
+		t.Errorf("ch has %d subscribers after RESET, want 0", n)
+	}
+	if got := do(c, "GET", "k"); !reflect.DeepEqual(got, resp.NullBulk) {
+		t.Errorf("GET k = %+v, want the discarded SET not to have run", got)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	server, conn := net.Pipe()
+	defer conn.Close()
+
+	go NewServer(defaultConfig()).handleConnection(server)
+
+	go conn.Write([]byte("PING\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\nGET k\r\n"))
+
+	reader := resp.NewReader(conn)
+	want := []resp.Value{
+		resp.SimpleString("PONG"),
+		resp.OK,
+		resp.BulkString("v"),
+	}
+	for _, w := range want {
+		got, err := reader.ReadValue()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("expected %+v, got %+v", w, got)
+		}
+	}
+}
+
+func TestCommandValidation(t *testing.T) {
+	c := newTestClient()
+
+	tests := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"get"}, resp.Error("ERR wrong number of arguments for 'get' command")},
+		{[]string{"SET", "k"}, resp.Error("ERR wrong number of arguments for 'set' command")},
+		{[]string{"ping", "a", "b"}, resp.Error("ERR wrong number of arguments for 'ping' command")},
+		{[]string{"nope", "a", "b"}, resp.Error("ERR unknown command 'nope', with args beginning with: 'a' 'b' ")},
+		{[]string{"GeT", "missing"}, resp.NullBulk},
+	}
+
+	for _, tc := range tests {
+		if got := c.execute(tc.args); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteReplies(t *testing.T) {
+	c := newTestClient()
+
+	tests := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "missing"}, resp.NullBulk},
+		{[]string{"SET", "foo", "bar"}, resp.OK},
+		{[]string{"GET", "foo"}, resp.BulkString("bar")},
+		{[]string{"EXISTS", "foo"}, resp.Integer(1)},
+		{[]string{"DEL", "foo"}, resp.Integer(1)},
+		{[]string{"DEL", "foo"}, resp.Integer(0)},
+		{[]string{"PING", "hi"}, resp.BulkString("hi")},
+	}
+
+	for _, tc := range tests {
+		if got := c.execute(tc.args); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	RegisterCommand(&Command{
+		Name:     "TESTECHO",
+		Arity:    2,
+		Flags:    flagReadonly,
+		FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: func(c *Client, args []string) resp.Value {
+			return resp.BulkString(args[1])
+		},
+	})
+	defer delete(commandTable, "testecho")
+
+	c := newTestClient()
+	if got := c.execute([]string{"testEcho", "hi"}); !reflect.DeepEqual(got, resp.BulkString("hi")) {
+		t.Errorf("expected custom command to reply hi, got %+v", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	defer func() {
+		middlewares = nil
+		for _, cmd := range commandTable {
+			cmd.wrap()
+		}
+	}()
+	Use(func(next CommandHandler) CommandHandler {
+		return func(c *Client, args []string) resp.Value {
+			calls = append(calls, args[0])
+			return next(c, args)
+		}
+	})
+	Use(func(next CommandHandler) CommandHandler {
+		return func(c *Client, args []string) resp.Value {
+			if strings.EqualFold(args[0], "del") {
+				return resp.Error("NOPERM this command is disabled")
+			}
+			return next(c, args)
+		}
+	})
+
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "k", "v"}, resp.OK},
+		{[]string{"DEL", "k"}, resp.Error("NOPERM this command is disabled")},
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"GET", "k"}, resp.SimpleString("QUEUED")},
+		{[]string{"EXEC"}, resp.Array(resp.BulkString("v"))},
+	})
+	want := []string{"SET", "DEL", "MULTI", "EXEC", "GET"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("middleware saw %q, want %q", calls, want)
+	}
+}
+
+func TestSafeExecuteRecoversPanics(t *testing.T) {
+	RegisterCommand(&Command{
+		Name:  "testpanic",
+		Arity: 1,
+		Flags: flagWrite,
+		Handler: func(c *Client, args []string) resp.Value {
+			panic("boom")
+		},
+	})
+	defer delete(commandTable, "testpanic")
+
+	c := newTestClient()
+	reply, ok := c.safeExecute([]string{"TESTPANIC"})
+	if ok || reply.Type != resp.TypeError {
+		t.Errorf("expected error reply and ok=false, got %+v, %v", reply, ok)
+	}
+
+	// The store lock must have been released while unwinding.
+	if got := c.execute([]string{"SET", "k", "v"}); !reflect.DeepEqual(got, resp.OK) {
+		t.Errorf("expected OK after recovered panic, got %+v", got)
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "setbit", Arity: 4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: setbitCommand})
+	RegisterCommand(&Command{Name: "getbit", Arity: 3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: getbitCommand})
+	RegisterCommand(&Command{Name: "bitcount", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: bitcountCommand})
+	RegisterCommand(&Command{Name: "bitpos", Arity: -3, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: bitposCommand})
+	RegisterCommand(&Command{Name: "bitfield", Arity: -2, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: bitfieldCommand})
+	RegisterCommand(&Command{Name: "bitfield_ro", Arity: -2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: bitfieldCommand})
+	RegisterCommand(&Command{Name: "bitop", Arity: -4, Flags: flagWrite | flagDenyOOM, FirstKey: 2, LastKey: -1, Step: 1, Handler: bitopCommand})
+}
+
+// Bitmaps are plain strings addressed bit by bit. Bit 0 is the most
+// significant bit of the first byte, as in Redis, so a bitmap set with
+// SETBIT reads back the same way with GETRANGE.
+
+var errBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// parseBitOffset parses a bit offset, which must address a byte within the
+// largest string allowed.
+func parseBitOffset(c *Client, arg string) (int64, error) {
+	offset, ok := parseInt(arg)
+	if !ok || offset < 0 || offset>>3 >= int64(c.srv.cfg.ProtoMaxBulkLen) {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+// getBit returns the bit at offset, 0 past the end of b.
+func getBit(b []byte, offset int64) int64 {
+	i := offset >> 3
+	if i >= int64(len(b)) {
+		return 0
+	}
+	return int64(b[i]>>(7-offset&7)) & 1
+}
+
+// growToBit zero-pads b so that it holds the bit at offset.
+func growToBit(b []byte, offset int64) []byte {
+	if need := int(offset>>3) + 1; need > len(b) {
+		b = append(b, make([]byte, need-len(b))...)
+	}
+	return b
+}
+
+// setBit sets or clears the bit at offset, which must lie within b.
+func setBit(b []byte, offset int64, on bool) {
+	mask := byte(1) << (7 - offset&7)
+	if on {
+		b[offset>>3] |= mask
+	} else {
+		b[offset>>3] &^= mask
+	}
+}
+
+// popcount returns the number of bits set in b, eight bytes at a time.
+func popcount(b []byte) int64 {
+	var n int
+	for len(b) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+	}
+	for _, v := range b {
+		n += bits.OnesCount8(v)
+	}
+	return int64(n)
+}
+
+// countBits returns the number of bits set from bit first to bit last
+// inclusive, both of which must lie within b.
+func countBits(b []byte, first, last int64) int64 {
+	lo, hi := first>>3, last>>3
+	n := popcount(b[lo : hi+1])
+	// Take off the bits of the end bytes that lie outside the range.
+	n -= int64(bits.OnesCount8(b[lo] >> (8 - first&7)))
+	n -= int64(bits.OnesCount8(b[hi] << (last&7 + 1)))
+	return n
+}
+
+// findBit returns the offset of the first bit equal to bit from bit first
+// to bit last inclusive, or -1 if there is none.
+func findBit(b []byte, bit int64, first, last int64) int64 {
+	lo, hi := first>>3, last>>3
+	for i := lo; i <= hi; i++ {
+		v := b[i]
+		if bit == 0 {
+			v = ^v
+		}
+		if i == lo {
+			v &= 0xff >> (first & 7)
+		}
+		if i == hi {
+			v &= 0xff << (7 - last&7)
+		}
+		if v != 0 {
+			return i<<3 + int64(bits.LeadingZeros8(v))
+		}
+	}
+	return -1
+}
+
+// parseBitRange parses the optional start, end and BYTE|BIT unit of
+// BITCOUNT and BITPOS and resolves them against a string of n bytes into
+// an inclusive range of bit offsets. ok is false if the range is empty.
+func parseBitRange(args []string, n int) (first, last int64, ok bool, err error) {
+	start, end := int64(0), int64(-1)
+	var unitBits bool
+	if len(args) > 0 {
+		var ok1, ok2 bool
+		start, ok1 = parseInt(args[0])
+		if len(args) > 1 {
+			end, ok2 = parseInt(args[1])
+		} else {
+			ok2 = true
+		}
+		if !ok1 || !ok2 {
+			return 0, 0, false, errNotInteger
+		}
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			unitBits = true
+		default:
+			return 0, 0, false, errSyntax
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, false, errSyntax
+	}
+
+	total := int64(n)
+	if unitBits {
+		total *= 8
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	start, end = max(start, 0), min(max(end, 0), total-1)
+	if start > end {
+		return 0, 0, false, nil
+	}
+	if unitBits {
+		return start, end, true, nil
+	}
+	return start * 8, end*8 + 7, true, nil
+}
+
+// setbitCommand implements SETBIT key offset value, growing the string with
+// zero bytes as needed and replying with the previous bit.
+func setbitCommand(c *Client, args []string) resp.Value {
+	offset, err := parseBitOffset(c, args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return resp.Error("ERR bit is not an integer or out of range")
+	}
+
+	key := args[1]
+	d, _, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	b := growToBit(d.bytes(), offset)
+	old := getBit(b, offset)
+	setBit(b, offset, args[3] == "1")
+	d.value = b
+	c.store.put(key, d)
+	return resp.Integer(old)
+}
+
+// getbitCommand implements GETBIT key offset.
+func getbitCommand(c *Client, args []string) resp.Value {
+	offset, err := parseBitOffset(c, args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	d, _, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(getBit(d.bytes(), offset))
+}
+
+// bitcountCommand implements BITCOUNT key [start end [BYTE|BIT]].
+func bitcountCommand(c *Client, args []string) resp.Value {
+	if len(args) == 3 {
+		return syntaxErrorReply
+	}
+	d, _, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	first, last, ok, err := parseBitRange(args[2:], len(b))
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.Integer(0)
+	}
+	return resp.Integer(countBits(b, first, last))
+}
+
+// bitposCommand implements BITPOS key bit [start [end [BYTE|BIT]]]. When
+// looking for a clear bit without an explicit end, the string is treated as
+// padded with zeros, so an all-ones string yields the bit just past its end.
+func bitposCommand(c *Client, args []string) resp.Value {
+	var bit int64
+	switch args[2] {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		if _, ok := parseInt(args[2]); !ok {
+			return notIntegerReply
+		}
+		return resp.Error("ERR The bit argument must be 1 or 0.")
+	}
+	d, ok, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	first, last, inRange, err := parseBitRange(args[3:], len(b))
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		// A missing key is an empty string: all of its bits are clear.
+		if bit == 1 {
+			return resp.Integer(-1)
+		}
+		return resp.Integer(0)
+	}
+	if !inRange {
+		return resp.Integer(-1)
+	}
+	pos := findBit(b, bit, first, last)
+	if pos < 0 && bit == 0 && len(args) < 5 {
+		return resp.Integer(last + 1)
+	}
+	return resp.Integer(pos)
+}
+
+// bitopCommand implements BITOP AND|OR|XOR|NOT destkey key [key ...]. The
+// sources are zero-padded to the longest of them; the destination is
+// overwritten, or deleted if the result is empty, and the reply is its
+// length.
+func bitopCommand(c *Client, args []string) resp.Value {
+	op := strings.ToUpper(args[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 4 {
+			return resp.Error("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return syntaxErrorReply
+	}
+
+	srcs := make([][]byte, len(args)-3)
+	var n int
+	for i, key := range args[3:] {
+		d, _, err := c.store.lookupString(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		srcs[i] = d.bytes()
+		n = max(n, len(srcs[i]))
+	}
+
+	dst := args[2]
+	if n == 0 {
+		c.store.remove(dst)
+		return resp.Integer(0)
+	}
+	res := make([]byte, n)
+	copy(res, srcs[0])
+	switch op {
+	case "NOT":
+		for i := range res {
+			res[i] = ^res[i]
+		}
+	case "AND":
+		for _, src := range srcs[1:] {
+			for i := range res {
+				if i < len(src) {
+					res[i] &= src[i]
+				} else {
+					res[i] = 0
+				}
+			}
+		}
+	case "OR":
+		for _, src := range srcs[1:] {
+			for i, v := range src {
+				res[i] |= v
+			}
+		}
+	case "XOR":
+		for _, src := range srcs[1:] {
+			for i, v := range src {
+				res[i] ^= v
+			}
+		}
+	}
+	c.store.put(dst, StoreData{value: res})
+	return resp.Integer(int64(n))
+}
+
+// bitfieldOverflow is the OVERFLOW policy of BITFIELD SET and INCRBY.
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp is one GET, SET or INCRBY subcommand of BITFIELD.
+type bitfieldOp struct {
+	op       string
+	signed   bool
+	width    int
+	offset   int64
+	value    int64 // the value to SET or the INCRBY increment
+	overflow bitfieldOverflow
+}
+
+var errBitfieldType = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+
+// parseBitfieldType parses an integer type such as i5 or u16: signed types
+// are up to 64 bits wide, unsigned ones up to 63 so they fit an int64 reply.
+func parseBitfieldType(arg string) (signed bool, width int, err error) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u' && arg[0] != 'I' && arg[0] != 'U') {
+		return false, 0, errBitfieldType
+	}
+	signed = arg[0] == 'i' || arg[0] == 'I'
+	width, convErr := strconv.Atoi(arg[1:])
+	if convErr != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, errBitfieldType
+	}
+	return signed, width, nil
+}
+
+// parseBitfieldOffset parses the offset of a BITFIELD subcommand. A "#"
+// prefix counts in units of the field width, so #2 of a u8 is offset 16.
+func parseBitfieldOffset(c *Client, arg string, width int) (int64, error) {
+	unit := int64(1)
+	if rest, ok := strings.CutPrefix(arg, "#"); ok {
+		arg, unit = rest, int64(width)
+	}
+	offset, ok := parseInt(arg)
+	if !ok || offset < 0 || offset > math.MaxInt64/unit {
+		return 0, errBitOffset
+	}
+	offset *= unit
+	if (offset+int64(width)-1)>>3 >= int64(c.srv.cfg.ProtoMaxBulkLen) {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+// getField reads the width-bit integer at offset, sign-extending it when
+// signed. Bits past the end of b read as 0.
+func getField(b []byte, offset int64, width int, signed bool) int64 {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		v = v<<1 | uint64(getBit(b, offset+i))
+	}
+	if signed && width < 64 && v&(1<<(width-1)) != 0 {
+		v |= ^uint64(0) << width
+	}
+	return int64(v)
+}
+
+// setField writes the low width bits of v at offset, which must lie within b.
+func setField(b []byte, offset int64, width int, v int64) {
+	for i := 0; i < width; i++ {
+		setBit(b, offset+int64(i), uint64(v)>>(width-1-i)&1 == 1)
+	}
+}
+
+// fieldBounds returns the smallest and largest width-bit integers.
+func fieldBounds(width int, signed bool) (lo, hi int64) {
+	if signed {
+		hi = int64(uint64(1)<<(width-1) - 1)
+		return -hi - 1, hi
+	}
+	return 0, int64(uint64(1)<<width - 1)
+}
+
+// addField adds incr to value, a width-bit integer, applying the overflow
+// policy. ok is false if the policy is FAIL and the result overflows.
+func addField(value, incr int64, width int, signed bool, overflow bitfieldOverflow) (res int64, ok bool) {
+	lo, hi := fieldBounds(width, signed)
+	// For 64-bit fields the sum itself can wrap, which shows as a result on
+	// the wrong side of value.
+	sum := value + incr
+	up := (incr > 0 && sum < value) || sum > hi
+	down := (incr < 0 && sum > value) || sum < lo
+	if !up && !down {
+		return sum, true
+	}
+	switch overflow {
+	case overflowSat:
+		if up {
+			return hi, true
+		}
+		return lo, true
+	case overflowFail:
+		return 0, false
+	}
+	// Wrap around by keeping the low width bits, sign-extended if needed.
+	u := uint64(sum)
+	if width < 64 {
+		u &= uint64(1)<<width - 1
+		if signed && u&(1<<(width-1)) != 0 {
+			u |= ^uint64(0) << width
+		}
+	}
+	return int64(u), true
+}
+
+// fitField applies the overflow policy to a value stored with SET. As in
+// Redis, an unsigned field sees a negative value as a huge unsigned one,
+// so SAT stores the maximum.
+func fitField(v int64, width int, signed bool, overflow bitfieldOverflow) (int64, bool) {
+	if !signed && v < 0 && overflow == overflowSat {
+		_, hi := fieldBounds(width, signed)
+		return hi, true
+	}
+	return addField(0, v, width, signed, overflow)
+}
+
+// bitfieldCommand implements BITFIELD key [GET type offset] [SET type offset
+// value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ... and
+// BITFIELD_RO key [GET type offset] .... Subcommands run in order and the
+// reply has one entry per GET, SET (the old value) or INCRBY (the new
+// value), nil where OVERFLOW FAIL prevented a write.
+func bitfieldCommand(c *Client, args []string) resp.Value {
+	readonly := strings.EqualFold(args[0], "bitfield_ro")
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	maxBit := int64(-1)
+	for i := 2; i < len(args); {
+		sub := strings.ToUpper(args[i])
+		left := len(args) - i - 1
+		switch {
+		case sub == "OVERFLOW" && left >= 1:
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return resp.Error("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		case sub == "GET" && left >= 2:
+		case (sub == "SET" || sub == "INCRBY") && left >= 3:
+			if readonly {
+				return resp.Error("ERR BITFIELD_RO only supports the GET subcommand")
+			}
+		default:
+			return syntaxErrorReply
+		}
+
+		op := bitfieldOp{op: sub, overflow: overflow}
+		var err error
+		if op.signed, op.width, err = parseBitfieldType(args[i+1]); err != nil {
+			return errorReply(err)
+		}
+		if op.offset, err = parseBitfieldOffset(c, args[i+2], op.width); err != nil {
+			return errorReply(err)
+		}
+		i += 3
+		if sub != "GET" {
+			var ok bool
+			if op.value, ok = parseInt(args[i]); !ok {
+				return notIntegerReply
+			}
+			i++
+			maxBit = max(maxBit, op.offset+int64(op.width)-1)
+		}
+		ops = append(ops, op)
+	}
+
+	key := args[1]
+	d, _, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	if maxBit >= 0 {
+		// Any write grows the string to fit, even if it then fails.
+		b = growToBit(b, maxBit)
+		d.value = b
+		c.store.put(key, d)
+	}
+
+	replies := make([]resp.Value, 0, len(ops))
+	for _, op := range ops {
+		cur := getField(b, op.offset, op.width, op.signed)
+		switch op.op {
+		case "GET":
+			replies = append(replies, resp.Integer(cur))
+		case "SET":
+			v, ok := fitField(op.value, op.width, op.signed, op.overflow)
+			if !ok {
+				replies = append(replies, resp.NullBulk)
+				continue
+			}
+			setField(b, op.offset, op.width, v)
+			replies = append(replies, resp.Integer(cur))
+		case "INCRBY":
+			v, ok := addField(cur, op.value, op.width, op.signed, op.overflow)
+			if !ok {
+				replies = append(replies, resp.NullBulk)
+				continue
+			}
+			setField(b, op.offset, op.width, v)
+			replies = append(replies, resp.Integer(v))
+		}
+	}
+	return resp.Array(replies...)
+}
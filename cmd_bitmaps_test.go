@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestSetbitGetbit(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SETBIT", "b", "7", "1"}, resp.Integer(0)},
+		{[]string{"SETBIT", "b", "7", "1"}, resp.Integer(1)},
+		{[]string{"GET", "b"}, resp.BulkString("\x01")},
+		{[]string{"SETBIT", "b", "9", "1"}, resp.Integer(0)},
+		{[]string{"GET", "b"}, resp.BulkString("\x01\x40")},
+		{[]string{"SETBIT", "b", "7", "0"}, resp.Integer(1)},
+		{[]string{"GETBIT", "b", "7"}, resp.Integer(0)},
+		{[]string{"GETBIT", "b", "9"}, resp.Integer(1)},
+		{[]string{"GETBIT", "b", "1000"}, resp.Integer(0)},
+		{[]string{"GETBIT", "missing", "0"}, resp.Integer(0)},
+		{[]string{"SETBIT", "b", "-1", "1"}, resp.Error("ERR bit offset is not an integer or out of range")},
+		{[]string{"SETBIT", "b", "4294967296", "1"}, resp.Error("ERR bit offset is not an integer or out of range")},
+		{[]string{"SETBIT", "b", "0", "2"}, resp.Error("ERR bit is not an integer or out of range")},
+		{[]string{"SET", "s", "a"}, resp.OK},
+		{[]string{"SETBIT", "s", "6", "1"}, resp.Integer(0)},
+		{[]string{"GET", "s"}, resp.BulkString("c")},
+		{[]string{"RPUSH", "l", "x"}, resp.Integer(1)},
+		{[]string{"SETBIT", "l", "0", "1"}, wrongTypeReply},
+	})
+}
+
+func TestBitcountBitpos(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "foobar")
+	do(c, "SET", "ones", "\xff\xff\xff")
+	do(c, "SET", "z", "\x00\xff\xf0\x00")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BITCOUNT", "k"}, resp.Integer(26)},
+		{[]string{"BITCOUNT", "k", "0", "0"}, resp.Integer(4)},
+		{[]string{"BITCOUNT", "k", "1", "1"}, resp.Integer(6)},
+		{[]string{"BITCOUNT", "k", "1", "1", "BYTE"}, resp.Integer(6)},
+		{[]string{"BITCOUNT", "k", "5", "30", "BIT"}, resp.Integer(17)},
+		{[]string{"BITCOUNT", "k", "-2", "-1"}, resp.Integer(7)},
+		{[]string{"BITCOUNT", "k", "4", "2"}, resp.Integer(0)},
+		{[]string{"BITCOUNT", "k", "0"}, syntaxErrorReply},
+		{[]string{"BITCOUNT", "k", "0", "1", "WORD"}, syntaxErrorReply},
+		{[]string{"BITCOUNT", "missing"}, resp.Integer(0)},
+		{[]string{"BITPOS", "z", "1"}, resp.Integer(8)},
+		{[]string{"BITPOS", "z", "0"}, resp.Integer(0)},
+		{[]string{"BITPOS", "z", "0", "1"}, resp.Integer(20)},
+		{[]string{"BITPOS", "z", "1", "2"}, resp.Integer(16)},
+		{[]string{"BITPOS", "z", "1", "3"}, resp.Integer(-1)},
+		{[]string{"BITPOS", "z", "1", "7", "15", "BIT"}, resp.Integer(8)},
+		{[]string{"BITPOS", "z", "0", "9", "15", "BIT"}, resp.Integer(-1)},
+		{[]string{"BITPOS", "ones", "0"}, resp.Integer(24)},
+		{[]string{"BITPOS", "ones", "0", "0", "-1"}, resp.Integer(-1)},
+		{[]string{"BITPOS", "missing", "0"}, resp.Integer(0)},
+		{[]string{"BITPOS", "missing", "1"}, resp.Integer(-1)},
+		{[]string{"BITPOS", "z", "2"}, resp.Error("ERR The bit argument must be 1 or 0.")},
+	})
+}
+
+func TestCountBitsMatchesGetbit(t *testing.T) {
+	b := []byte("the quick brown fox jumps over the lazy dog")
+	total := int64(len(b)) * 8
+	for first := int64(0); first < total; first += 7 {
+		for last := first; last < total; last += 13 {
+			var want int64
+			for i := first; i <= last; i++ {
+				want += getBit(b, i)
+			}
+			if got := countBits(b, first, last); got != want {
+				t.Fatalf("countBits(%d, %d) = %d, want %d", first, last, got, want)
+			}
+		}
+	}
+}
+
+func TestBitop(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "a", "\xf0\x0f")
+	do(c, "SET", "b", "\xff")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BITOP", "AND", "dst", "a", "b"}, resp.Integer(2)},
+		{[]string{"GET", "dst"}, resp.BulkString("\xf0\x00")},
+		{[]string{"BITOP", "OR", "dst", "a", "b"}, resp.Integer(2)},
+		{[]string{"GET", "dst"}, resp.BulkString("\xff\x0f")},
+		{[]string{"BITOP", "XOR", "dst", "a", "b", "missing"}, resp.Integer(2)},
+		{[]string{"GET", "dst"}, resp.BulkString("\x0f\x0f")},
+		{[]string{"BITOP", "NOT", "dst", "a"}, resp.Integer(2)},
+		{[]string{"GET", "dst"}, resp.BulkString("\x0f\xf0")},
+		{[]string{"BITOP", "NOT", "dst", "a", "b"}, resp.Error("ERR BITOP NOT must be called with a single source key.")},
+		{[]string{"BITOP", "NAND", "dst", "a"}, syntaxErrorReply},
+		{[]string{"BITOP", "AND", "dst", "missing"}, resp.Integer(0)},
+		{[]string{"EXISTS", "dst"}, resp.Integer(0)},
+	})
+}
+
+func TestBitfield(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BITFIELD", "k", "GET", "u8", "0"}, resp.Array(resp.Integer(0))},
+		{[]string{"EXISTS", "k"}, resp.Integer(0)},
+		{[]string{"BITFIELD", "k", "SET", "u8", "0", "255", "GET", "u4", "4", "GET", "i8", "0"}, resp.Array(resp.Integer(0), resp.Integer(15), resp.Integer(-1))},
+		{[]string{"BITFIELD", "k", "SET", "i16", "#1", "-300", "GET", "i16", "16"}, resp.Array(resp.Integer(0), resp.Integer(-300))},
+		{[]string{"STRLEN", "k"}, resp.Integer(4)},
+		{[]string{"BITFIELD", "c", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"}, resp.Array(resp.Integer(1), resp.Integer(1))},
+		{[]string{"BITFIELD", "c", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"}, resp.Array(resp.Integer(2), resp.Integer(2))},
+		{[]string{"BITFIELD", "c", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"}, resp.Array(resp.Integer(3), resp.Integer(3))},
+		{[]string{"BITFIELD", "c", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1"}, resp.Array(resp.Integer(0), resp.Integer(3))},
+		{[]string{"BITFIELD", "c", "OVERFLOW", "FAIL", "INCRBY", "u2", "102", "1", "GET", "u2", "102"}, resp.Array(resp.NullBulk, resp.Integer(3))},
+		{[]string{"BITFIELD", "s", "SET", "i8", "0", "127", "INCRBY", "i8", "0", "1"}, resp.Array(resp.Integer(0), resp.Integer(-128))},
+		{[]string{"BITFIELD", "s", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-10"}, resp.Array(resp.Integer(-128))},
+		{[]string{"BITFIELD", "s", "OVERFLOW", "SAT", "SET", "u8", "0", "-1", "OVERFLOW", "WRAP", "SET", "u8", "0", "257"}, resp.Array(resp.Integer(128), resp.Integer(255))},
+		{[]string{"BITFIELD", "s", "OVERFLOW", "FAIL", "SET", "u8", "0", "256", "GET", "u8", "0"}, resp.Array(resp.NullBulk, resp.Integer(1))},
+		{[]string{"BITFIELD", "w", "SET", "i64", "0", "9223372036854775807", "INCRBY", "i64", "0", "1"}, resp.Array(resp.Integer(0), resp.Integer(-9223372036854775808))},
+		{[]string{"BITFIELD", "w", "OVERFLOW", "SAT", "INCRBY", "i64", "0", "-1"}, resp.Array(resp.Integer(-9223372036854775808))},
+		{[]string{"BITFIELD", "k", "GET", "u64", "0"}, resp.Error("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")},
+		{[]string{"BITFIELD", "k", "GET", "x8", "0"}, resp.Error("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")},
+		{[]string{"BITFIELD", "k", "GET", "u8", "-1"}, resp.Error("ERR bit offset is not an integer or out of range")},
+		{[]string{"BITFIELD", "k", "OVERFLOW", "MAYBE"}, resp.Error("ERR Invalid OVERFLOW type specified")},
+		{[]string{"BITFIELD", "k", "SET", "u8", "0"}, syntaxErrorReply},
+		{[]string{"BITFIELD", "k", "INCRBY", "u8", "0", "x"}, notIntegerReply},
+		{[]string{"BITFIELD_RO", "k", "GET", "u8", "0"}, resp.Array(resp.Integer(255))},
+		{[]string{"BITFIELD_RO", "k", "SET", "u8", "0", "1"}, resp.Error("ERR BITFIELD_RO only supports the GET subcommand")},
+	})
+}
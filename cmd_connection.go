@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+// serverVersion is reported to clients by HELLO. Some client libraries gate
+// features on it, so it tracks the Redis release whose behaviour we mimic.
+const serverVersion = "7.2.0"
+
+func init() {
+	RegisterCommand(&Command{Name: "ping", Arity: -1, Flags: flagFast | flagStale, Handler: pingCommand})
+	RegisterCommand(&Command{Name: "hello", Arity: -1, Flags: flagNoScript | flagLoading | flagStale | flagFast, Handler: helloCommand})
+	RegisterCommand(&Command{Name: "reset", Arity: 1, Flags: flagNoScript | flagLoading | flagStale | flagFast, Handler: resetCommand})
+	RegisterCommand(&Command{Name: "client", Arity: -2, Flags: flagNoScript | flagLoading | flagStale, Handler: clientCommand})
+}
+
+// pingCommand implements PING [message]. In RESP2 subscriber mode it
+// replies with ["pong", message] like a pushed message.
+func pingCommand(c *Client, args []string) resp.Value {
+	if len(args) > 2 {
+		return wrongArityReply("ping")
+	}
+	if c.proto < 3 && c.subscriptions() > 0 {
+		var msg string
+		if len(args) == 2 {
+			msg = args[1]
+		}
+		return resp.Array(resp.BulkString("pong"), resp.BulkString(msg))
+	}
+	if len(args) == 2 {
+		return resp.BulkString(args[1])
+	}
+	return resp.SimpleString("PONG")
+}
+
+// helloCommand implements HELLO [protover [AUTH username password]
+// [SETNAME name]]. It switches the connection to the requested protocol
+// version and replies with a map describing the server.
+func helloCommand(c *Client, args []string) resp.Value {
+	args = args[1:]
+	proto := c.proto
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			return resp.Error("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return resp.Error("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+		args = args[1:]
+	}
+
+	name := c.name
+	for len(args) > 0 {
+		switch opt := strings.ToUpper(args[0]); {
+		case opt == "AUTH" && len(args) >= 3:
+			// There is no ACL support, so only the default user exists and
+			// it accepts any password.
+			if args[1] != "default" {
+				return resp.Error("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			args = args[3:]
+		case opt == "SETNAME" && len(args) >= 2:
+			if strings.ContainsAny(args[1], " \n") {
+				return resp.Error("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name = args[1]
+			args = args[2:]
+		default:
+			return resp.Error("ERR Syntax error in HELLO option '" + args[0] + "'")
+		}
+	}
+
+	c.proto = proto
+	c.name = name
+
+	return resp.Map(
+		resp.BulkString("server"), resp.BulkString("redis"),
+		resp.BulkString("version"), resp.BulkString(serverVersion),
+		resp.BulkString("proto"), resp.Integer(int64(c.proto)),
+		resp.BulkString("id"), resp.Integer(c.id),
+		resp.BulkString("mode"), resp.BulkString("standalone"),
+		resp.BulkString("role"), resp.BulkString("master"),
+		resp.BulkString("modules"), resp.Array(),
+	)
+}
+
+// resetCommand implements RESET, returning the connection to the state of
+// a new one: the transaction is discarded, keys are unwatched, every
+// subscription is dropped without confirmation, tracking is turned off,
+// MONITOR mode is left, the protocol is back to RESP2 and the name is
+// cleared. There is a single database, so there is none to select. It
+// replies RESET.
+func resetCommand(c *Client, args []string) resp.Value {
+	c.multi = nil
+	c.unwatchAll()
+	c.srv.pubsub.removeClient(c)
+	c.srv.tracking.disable(c)
+	c.srv.monitors.remove(c)
+	c.proto = 2
+	c.name = ""
+	return resp.SimpleString("RESET")
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CACHING (YES|NO)",
+	"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+	"GETREDIR",
+	"    Return the client ID we are redirecting to when tracking is enabled.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+	"         [OPTIN] [OPTOUT] [NOLOOP]",
+	"    Control server assisted client side caching.",
+	"TRACKINGINFO",
+	"    Report tracking status for the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+// clientCommand implements the CLIENT subcommands ID, TRACKING, CACHING,
+// GETREDIR, TRACKINGINFO and HELP, which act on the calling connection.
+func clientCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"id": 2, "tracking": -3, "caching": 3, "getredir": 2, "trackinginfo": 2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[1]))
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return wrongArityReply("client|" + sub)
+	}
+
+	switch sub {
+	case "id":
+		return resp.Integer(c.id)
+	case "tracking":
+		return clientTracking(c, args[2:])
+	case "caching":
+		opts := c.tracking
+		if opts == nil || !(opts.optin || opts.optout) {
+			return resp.Error("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+		}
+		switch strings.ToUpper(args[2]) {
+		case "YES":
+			if !opts.optin {
+				return resp.Error("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+			}
+			c.caching = 1
+		case "NO":
+			if !opts.optout {
+				return resp.Error("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+			}
+			c.caching = -1
+		default:
+			return syntaxErrorReply
+		}
+		return resp.OK
+	case "getredir":
+		if c.tracking == nil {
+			return resp.Integer(-1)
+		}
+		return resp.Integer(c.tracking.redirect)
+	case "trackinginfo":
+		return clientTrackingInfo(c)
+	default:
+		return resp.BulkStrings(clientHelp)
+	}
+}
+
+// clientTracking implements CLIENT TRACKING ON|OFF [REDIRECT id]
+// [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]. Turning tracking
+// on again changes the options, keeping the prefixes already given, but
+// cannot switch between the default, BCAST, OPTIN and OPTOUT modes.
+func clientTracking(c *Client, args []string) resp.Value {
+	opts := &trackingOptions{}
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "REDIRECT" && i+1 < len(args):
+			id, ok := parseInt(args[i+1])
+			if !ok {
+				return notIntegerReply
+			}
+			opts.redirect = id
+			i++
+		case opt == "PREFIX" && i+1 < len(args):
+			opts.prefixes = append(opts.prefixes, args[i+1])
+			i++
+		case opt == "BCAST":
+			opts.bcast = true
+		case opt == "OPTIN":
+			opts.optin = true
+		case opt == "OPTOUT":
+			opts.optout = true
+		case opt == "NOLOOP":
+			opts.noloop = true
+		default:
+			return syntaxErrorReply
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "OFF":
+		c.srv.tracking.disable(c)
+		return resp.OK
+	case "ON":
+	default:
+		return syntaxErrorReply
+	}
+	old := c.tracking
+	if len(opts.prefixes) > 0 && !opts.bcast {
+		return resp.Error("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if old != nil && old.bcast != opts.bcast {
+		return resp.Error("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	}
+	if opts.optin && opts.optout {
+		return resp.Error("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if (opts.optin || opts.optout) && opts.bcast {
+		return resp.Error("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if old != nil && (old.optin != opts.optin || old.optout != opts.optout) {
+		return resp.Error("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+	}
+	if opts.redirect != 0 && c.srv.clientByID(opts.redirect) == nil {
+		return resp.Error("ERR The client ID you want redirect to does not exist")
+	}
+	if opts.bcast {
+		if len(opts.prefixes) == 0 {
+			opts.prefixes = []string{""}
+		}
+		var existing []string
+		if old != nil {
+			existing = old.prefixes
+		}
+		for i, prefix := range opts.prefixes {
+			for _, other := range slices.Concat(existing, opts.prefixes[:i]) {
+				if prefix != other && (strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix)) {
+					return resp.Errorf("ERR Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)
+				}
+			}
+		}
+		for _, prefix := range existing {
+			if !slices.Contains(opts.prefixes, prefix) {
+				opts.prefixes = append(opts.prefixes, prefix)
+			}
+		}
+	}
+	c.srv.tracking.enable(c, opts)
+	return resp.OK
+}
+
+// clientTrackingInfo implements CLIENT TRACKINGINFO, describing the
+// tracking mode of the client, where it redirects to and its prefixes.
+func clientTrackingInfo(c *Client) resp.Value {
+	opts := c.tracking
+	if opts == nil {
+		return resp.Map(
+			resp.BulkString("flags"), resp.Set(resp.BulkString("off")),
+			resp.BulkString("redirect"), resp.Integer(-1),
+			resp.BulkString("prefixes"), resp.Array(),
+		)
+	}
+	flags := []string{"on"}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{opts.bcast, "bcast"},
+		{opts.optin, "optin"},
+		{opts.optout, "optout"},
+		{c.caching > 0, "caching-yes"},
+		{c.caching < 0, "caching-no"},
+		{opts.noloop, "noloop"},
+		{opts.redirect != 0 && c.srv.clientByID(opts.redirect) == nil, "broken_redirect"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	prefixes := opts.prefixes
+	if !opts.bcast {
+		prefixes = nil
+	}
+	return resp.Map(
+		resp.BulkString("flags"), resp.Set(resp.BulkStrings(flags).Array...),
+		resp.BulkString("redirect"), resp.Integer(opts.redirect),
+		resp.BulkString("prefixes"), resp.BulkStrings(prefixes),
+	)
+}
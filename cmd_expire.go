@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	for _, name := range []string{"expire", "pexpire", "expireat", "pexpireat"} {
+		RegisterCommand(&Command{Name: name, Arity: -3, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: expireCommand})
+	}
+	RegisterCommand(&Command{Name: "persist", Arity: 2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: persistCommand})
+	RegisterCommand(&Command{Name: "ttl", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: ttlCommand})
+	RegisterCommand(&Command{Name: "pttl", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: pttlCommand})
+	RegisterCommand(&Command{Name: "expiretime", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: expiretimeCommand})
+	RegisterCommand(&Command{Name: "pexpiretime", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: pexpiretimeCommand})
+}
+
+// expireCommand implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT with the
+// NX, XX, GT and LT modifiers. The "P" variants take milliseconds and the
+// "AT" variants an absolute Unix time instead of a relative one.
+func expireCommand(c *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	n, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+
+	var nx, xx, gt, lt bool
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(opt) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return resp.Errorf("ERR Unsupported option %s", opt)
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return resp.Error("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return resp.Error("ERR GT and LT options at the same time are not compatible")
+	}
+
+	// Work out the deadline in Unix milliseconds, guarding against overflow
+	// the same way Redis does.
+	unit := int64(1)
+	if !strings.HasPrefix(name, "p") {
+		unit = 1000
+	}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return resp.Errorf("ERR invalid expire time in '%s' command", name)
+	}
+	ms := n * unit
+	if !strings.HasSuffix(name, "at") {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return resp.Errorf("ERR invalid expire time in '%s' command", name)
+		}
+		ms += now
+	}
+	at := time.UnixMilli(ms)
+
+	d, ok := c.store.lookup(args[1])
+	if !ok {
+		return resp.Integer(0)
+	}
+
+	if !expireAllowed(nx, xx, gt, lt, d.expiresAt, at) {
+		return resp.Integer(0)
+	}
+
+	c.store.setExpire(args[1], at)
+	c.propagateExpiry(args[1], "PEXPIREAT", args[1], strconv.FormatInt(ms, 10))
+	return resp.Integer(1)
+}
+
+// expireAllowed applies the NX, XX, GT and LT conditions to replacing the
+// deadline cur, which is zero when there is no TTL, with at. No TTL counts
+// as expiring infinitely far in the future.
+func expireAllowed(nx, xx, gt, lt bool, cur, at time.Time) bool {
+	hasTTL := !cur.IsZero()
+	switch {
+	case nx && hasTTL,
+		xx && !hasTTL,
+		gt && (!hasTTL || !at.After(cur)),
+		lt && hasTTL && !at.Before(cur):
+		return false
+	}
+	return true
+}
+
+// ttlCommand implements TTL key.
+func ttlCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(msToSeconds(c.store.pttl(args[1])))
+}
+
+// pttlCommand implements PTTL key.
+func pttlCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(c.store.pttl(args[1]))
+}
+
+// expiretimeCommand implements EXPIRETIME key, the absolute Unix time in
+// seconds at which key expires.
+func expiretimeCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(msToSeconds(c.store.pexpireTime(args[1])))
+}
+
+// pexpiretimeCommand implements PEXPIRETIME key.
+func pexpiretimeCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(c.store.pexpireTime(args[1]))
+}
+
+// persistCommand implements PERSIST key.
+func persistCommand(c *Client, args []string) resp.Value {
+	if c.store.persist(args[1]) {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
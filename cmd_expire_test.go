@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestExpireFamily(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXPIRE", "missing", "10"}, resp.Integer(0)},
+		{[]string{"EXPIRE", "k", "100"}, resp.Integer(1)},
+		{[]string{"EXPIRE", "k", "50", "NX"}, resp.Integer(0)},
+		{[]string{"EXPIRE", "k", "50", "GT"}, resp.Integer(0)},
+		{[]string{"EXPIRE", "k", "200", "GT"}, resp.Integer(1)},
+		{[]string{"PEXPIRE", "k", "300000", "LT"}, resp.Integer(0)},
+		{[]string{"PEXPIRE", "k", "150000", "LT", "XX"}, resp.Integer(1)},
+		{[]string{"EXPIRE", "k", "abc"}, notIntegerReply},
+		{[]string{"EXPIRE", "k", "10", "NX", "XX"}, resp.Error("ERR NX and XX, GT or LT options at the same time are not compatible")},
+		{[]string{"EXPIRE", "k", "10", "GT", "LT"}, resp.Error("ERR GT and LT options at the same time are not compatible")},
+		{[]string{"EXPIRE", "k", "10", "FOO"}, resp.Error("ERR Unsupported option FOO")},
+		{[]string{"EXPIRE", "k", "9223372036854775807"}, resp.Error("ERR invalid expire time in 'expire' command")},
+	})
+
+	remaining := time.Until(c.store.data["k"].expiresAt)
+	if remaining < 149*time.Second || remaining > 150*time.Second {
+		t.Errorf("expected about 150s left, got %v", remaining)
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "v")
+
+	at := time.Now().Add(time.Hour).Unix()
+	if got := do(c, "EXPIREAT", "k", strconv.FormatInt(at, 10)); !reflect.DeepEqual(got, resp.Integer(1)) {
+		t.Fatalf("expected 1, got %+v", got)
+	}
+	if got := c.store.data["k"].expiresAt.Unix(); got != at {
+		t.Errorf("expected deadline %d, got %d", at, got)
+	}
+
+	// A deadline in the past deletes the key.
+	past := time.Now().Add(-time.Second).UnixMilli()
+	if got := do(c, "PEXPIREAT", "k", strconv.FormatInt(past, 10)); !reflect.DeepEqual(got, resp.Integer(1)) {
+		t.Fatalf("expected 1, got %+v", got)
+	}
+	if got := do(c, "EXISTS", "k"); !reflect.DeepEqual(got, resp.Integer(0)) {
+		t.Errorf("expected key to be deleted, got %+v", got)
+	}
+}
+
+func TestTTLCommands(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "v")
+	c.store.data["forever"] = StoreData{value: []byte("v")}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"TTL", "missing"}, resp.Integer(-2)},
+		{[]string{"PTTL", "missing"}, resp.Integer(-2)},
+		{[]string{"TTL", "forever"}, resp.Integer(-1)},
+		{[]string{"PTTL", "forever"}, resp.Integer(-1)},
+		{[]string{"EXPIRE", "k", "100"}, resp.Integer(1)},
+		{[]string{"TTL", "k"}, resp.Integer(100)},
+	})
+
+	if ms := do(c, "PTTL", "k").Int; ms <= 99000 || ms > 100000 {
+		t.Errorf("expected PTTL just under 100000, got %d", ms)
+	}
+}
+
+func TestPersist(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PERSIST", "missing"}, resp.Integer(0)},
+		{[]string{"EXPIRE", "k", "100"}, resp.Integer(1)},
+		{[]string{"PERSIST", "k"}, resp.Integer(1)},
+		{[]string{"PERSIST", "k"}, resp.Integer(0)},
+		{[]string{"TTL", "k"}, resp.Integer(-1)},
+	})
+
+	if _, ok := c.store.expires["k"]; ok {
+		t.Error("persisted key is still tracked for expiry")
+	}
+}
+
+func TestExpireTime(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "forever", "v")
+	do(c, "SET", "k", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXPIRETIME", "missing"}, resp.Integer(-2)},
+		{[]string{"PEXPIRETIME", "missing"}, resp.Integer(-2)},
+		{[]string{"EXPIRETIME", "forever"}, resp.Integer(-1)},
+		{[]string{"PEXPIRETIME", "forever"}, resp.Integer(-1)},
+		{[]string{"PEXPIREAT", "k", "33177117420123"}, resp.Integer(1)},
+		{[]string{"PEXPIRETIME", "k"}, resp.Integer(33177117420123)},
+		{[]string{"EXPIRETIME", "k"}, resp.Integer(33177117420)},
+		{[]string{"EXPIREAT", "k", "33177117421"}, resp.Integer(1)},
+		{[]string{"PEXPIRETIME", "k"}, resp.Integer(33177117421000)},
+	})
+}
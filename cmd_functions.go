@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "function", Arity: -2, Flags: flagWrite | flagDenyOOM | flagNoScript, Handler: functionCommand})
+	RegisterCommand(&Command{Name: "fcall", Arity: -3, Flags: flagNoScript | flagStale, Handler: fcallCommand})
+	RegisterCommand(&Command{Name: "fcall_ro", Arity: -3, Flags: flagNoScript | flagStale, Handler: fcallCommand})
+}
+
+// fcallCommand implements FCALL function numkeys [key ...] [arg ...] and
+// FCALL_RO, which only calls functions flagged no-writes. The function's
+// callback gets the keys and the other arguments as two tables.
+func fcallCommand(c *Client, args []string) resp.Value {
+	keys, argv, err := parseScriptArgs(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	ro := strings.EqualFold(args[0], "fcall_ro")
+	return runAtomically(c, func() resp.Value {
+		f := c.store.functions.functions[args[1]]
+		if f == nil {
+			return resp.Error("ERR Function not found")
+		}
+		if ro && !f.noWrites() {
+			return resp.Error("ERR Can not execute a script with write flag using *_ro command.")
+		}
+		env, L := f.lib.env, f.lib.state
+		env.c, env.readOnly = c, ro || f.noWrites()
+		defer func() { env.c, env.readOnly = nil, false }()
+		return callLua(c, L, f.callback, "function (call to "+f.name+")", stringsTable(L, keys), stringsTable(L, argv))
+	})
+}
+
+var functionHelp = []string{
+	"FUNCTION <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"LOAD [REPLACE] <FUNCTION CODE>",
+	"    Create a new library with the given library name and code.",
+	"DELETE <LIBRARY NAME>",
+	"    Delete the given library.",
+	"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+	"    Return general information on all the libraries:",
+	"    * Library name",
+	"    * The engine used to run the Library",
+	"    * Functions list",
+	"    * Library code (if WITHCODE is given)",
+	"    It also possible to get only function that matches a pattern using LIBRARYNAME argument.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Delete all the libraries.",
+	"HELP",
+	"    Print this help.",
+}
+
+// functionCommand implements FUNCTION LOAD, DELETE, LIST, FLUSH and HELP,
+// which manage the libraries FCALL calls functions from.
+func functionCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"load": -3, "delete": 3, "list": -2, "flush": -2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try FUNCTION HELP.", args[1]))
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) || (sub == "flush" && len(args) > 3) {
+		return wrongArityReply("function|" + sub)
+	}
+
+	registry := &c.store.functions
+	switch sub {
+	case "load":
+		replace := false
+		if len(args) == 4 {
+			if !strings.EqualFold(args[2], "REPLACE") {
+				return resp.Errorf("ERR Unknown option given: %s", args[2])
+			}
+			replace = true
+		} else if len(args) > 4 {
+			return syntaxErrorReply
+		}
+		lib, err := registry.load(args[len(args)-1], replace)
+		if err != nil {
+			return errorReply(err)
+		}
+		c.store.dirty++
+		return resp.BulkString(lib.name)
+	case "delete":
+		if !registry.delete(args[2]) {
+			return resp.Error("ERR Library not found")
+		}
+		c.store.dirty++
+		return resp.OK
+	case "list":
+		return functionList(c, args[2:])
+	case "flush":
+		if len(args) == 3 && !strings.EqualFold(args[2], "ASYNC") && !strings.EqualFold(args[2], "SYNC") {
+			return resp.Error("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		}
+		registry.flush()
+		c.store.dirty++
+		return resp.OK
+	default:
+		return resp.BulkStrings(functionHelp)
+	}
+}
+
+// functionList implements FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE],
+// describing each library, in name order, with its functions.
+func functionList(c *Client, opts []string) resp.Value {
+	pattern, hasPattern, withCode := "", false, false
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i]); {
+		case opt == "WITHCODE" && !withCode:
+			withCode = true
+		case opt == "LIBRARYNAME" && !hasPattern && i+1 < len(opts):
+			pattern, hasPattern = opts[i+1], true
+			i++
+		default:
+			return resp.Errorf("ERR Unknown argument %s", opts[i])
+		}
+	}
+
+	registry := &c.store.functions
+	var names []string
+	for name := range registry.libraries {
+		if !hasPattern || matchPattern(pattern, name, false) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	libs := make([]resp.Value, len(names))
+	for i, name := range names {
+		lib := registry.libraries[name]
+		fnames := make([]string, 0, len(lib.functions))
+		for fname := range lib.functions {
+			fnames = append(fnames, fname)
+		}
+		slices.Sort(fnames)
+		funcs := make([]resp.Value, len(fnames))
+		for j, fname := range fnames {
+			f := lib.functions[fname]
+			desc := resp.NullBulk
+			if f.description != "" {
+				desc = resp.BulkString(f.description)
+			}
+			flags := resp.BulkStrings(f.flags)
+			funcs[j] = resp.Map(
+				resp.BulkString("name"), resp.BulkString(f.name),
+				resp.BulkString("description"), desc,
+				resp.BulkString("flags"), resp.Set(flags.Array...),
+			)
+		}
+		kvs := []resp.Value{
+			resp.BulkString("library_name"), resp.BulkString(lib.name),
+			resp.BulkString("engine"), resp.BulkString("LUA"),
+			resp.BulkString("functions"), resp.Array(funcs...),
+		}
+		if withCode {
+			kvs = append(kvs, resp.BulkString("library_code"), resp.BulkString(lib.code))
+		}
+		libs[i] = resp.Map(kvs...)
+	}
+	return resp.Array(libs...)
+}
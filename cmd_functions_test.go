@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+const testLibrary = `#!lua name=mylib
+local function set(keys, args)
+  return redis.call('SET', keys[1], args[1])
+end
+redis.register_function('myset', set)
+redis.register_function{
+  function_name = 'myget',
+  callback = function(keys) return redis.call('GET', keys[1]) end,
+  description = 'reads a key',
+  flags = {'no-writes'},
+}
+redis.register_function{
+  function_name = 'sneaky',
+  callback = function(keys) return redis.call('DEL', keys[1]) end,
+  flags = {'no-writes'},
+}`
+
+func TestFunctionLoadAndCall(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"FCALL", "myset", "1", "k", "v"}, resp.Error("ERR Function not found")},
+		{[]string{"FUNCTION", "LOAD", testLibrary}, resp.BulkString("mylib")},
+		{[]string{"FUNCTION", "LOAD", testLibrary}, resp.Error("ERR Library 'mylib' already exists")},
+		{[]string{"FUNCTION", "LOAD", "REPLACE", testLibrary}, resp.BulkString("mylib")},
+		{[]string{"FCALL", "myset", "1", "k", "v"}, resp.OK},
+		{[]string{"FCALL", "myget", "1", "k"}, resp.BulkString("v")},
+		{[]string{"FCALL_RO", "myget", "1", "k"}, resp.BulkString("v")},
+		{[]string{"FCALL_RO", "myset", "1", "k", "w"}, resp.Error("ERR Can not execute a script with write flag using *_ro command.")},
+		// A no-writes function cannot write, even through FCALL.
+		{[]string{"FCALL", "sneaky", "1", "k"}, resp.Error("ERR Write commands are not allowed from read-only scripts.")},
+		{[]string{"GET", "k"}, resp.BulkString("v")},
+		{[]string{"FCALL", "myget", "2", "k"}, resp.Error("ERR Number of keys can't be greater than number of args")},
+	})
+
+	// Functions keep running from the library's state across calls and
+	// inside transactions.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"FCALL", "myset", "1", "k", "w"}, queued},
+		{[]string{"FUNCTION", "DELETE", "mylib"}, queued},
+		{[]string{"EXEC"}, resp.Array(resp.OK, resp.OK)},
+		{[]string{"GET", "k"}, resp.BulkString("w")},
+		{[]string{"FCALL", "myget", "1", "k"}, resp.Error("ERR Function not found")},
+		{[]string{"FUNCTION", "DELETE", "mylib"}, resp.Error("ERR Library not found")},
+	})
+}
+
+func TestFunctionLoadErrors(t *testing.T) {
+	c := newTestClient()
+	do(c, "FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('f', function() return 1 end)")
+	for _, tc := range []struct {
+		code, want string
+	}{
+		{"return 1", "ERR Missing library metadata"},
+		{"#!python name=lib\n", "ERR Engine 'python' not found"},
+		{"#!lua\n", "ERR Library name was not given"},
+		{"#!lua name=lib foo=bar\n", "ERR Invalid metadata value given: foo=bar"},
+		{"#!lua name=bad-name\n", "ERR Library names can only contain"},
+		{"#!lua name=lib\nlocal x = 1", "ERR No functions registered"},
+		{"#!lua name=lib\nredis.register_function('f', function() return 2 end)", "ERR Function f already exists"},
+		{"#!lua name=lib\nredis.register_function('bad name', function() end)", "Function names can only contain"},
+		{"#!lua name=lib\nredis.register_function{function_name='g', callback=function() end, flags={'bogus'}}", "unknown flag given"},
+		{"#!lua name=lib\nredis.call('SET', 'k', 'v')", "Commands can not be called while loading a library"},
+		{"#!lua name=lib\nreturn +", "ERR Error compiling function"},
+	} {
+		got := do(c, "FUNCTION", "LOAD", tc.code)
+		if !got.IsError() || !strings.Contains(got.Str, tc.want) {
+			t.Errorf("FUNCTION LOAD %q = %+v, want an error containing %q", tc.code, got, tc.want)
+		}
+	}
+	if got := do(c, "FCALL", "f", "0"); got.Int != 1 {
+		t.Errorf("FCALL f = %+v after failed loads, want 1", got)
+	}
+	// register_function is only available while loading.
+	do(c, "FUNCTION", "LOAD", "#!lua name=late\nredis.register_function('late', function() redis.register_function('x', function() end) end)")
+	if got := do(c, "FCALL", "late", "0"); !strings.Contains(got.Str, "can only be called on FUNCTION LOAD") {
+		t.Errorf("register_function outside FUNCTION LOAD = %+v", got)
+	}
+}
+
+func TestFunctionList(t *testing.T) {
+	c := newTestClient()
+	do(c, "FUNCTION", "LOAD", testLibrary)
+	do(c, "FUNCTION", "LOAD", "#!lua name=zlib\nredis.register_function('z', function() return 1 end)")
+
+	fn := func(name string, desc resp.Value, flags ...string) resp.Value {
+		return resp.Map(
+			resp.BulkString("name"), resp.BulkString(name),
+			resp.BulkString("description"), desc,
+			resp.BulkString("flags"), resp.Set(resp.BulkStrings(flags).Array...),
+		)
+	}
+	lib := func(name string, funcs ...resp.Value) []resp.Value {
+		return []resp.Value{
+			resp.BulkString("library_name"), resp.BulkString(name),
+			resp.BulkString("engine"), resp.BulkString("LUA"),
+			resp.BulkString("functions"), resp.Array(funcs...),
+		}
+	}
+	mylib := lib("mylib",
+		fn("myget", resp.BulkString("reads a key"), "no-writes"),
+		fn("myset", resp.NullBulk),
+		fn("sneaky", resp.NullBulk, "no-writes"),
+	)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"FUNCTION", "LIST"}, resp.Array(resp.Map(mylib...), resp.Map(lib("zlib", fn("z", resp.NullBulk))...))},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "my*", "WITHCODE"}, resp.Array(resp.Map(append(mylib, resp.BulkString("library_code"), resp.BulkString(testLibrary))...))},
+		{[]string{"FUNCTION", "LIST", "BOGUS"}, resp.Error("ERR Unknown argument BOGUS")},
+		{[]string{"FUNCTION", "FLUSH"}, resp.OK},
+		{[]string{"FUNCTION", "LIST"}, resp.Array()},
+		{[]string{"FUNCTION", "NOPE"}, resp.Error("ERR unknown subcommand 'NOPE'. Try FUNCTION HELP.")},
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "geoadd", Arity: -5, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: geoaddCommand})
+	RegisterCommand(&Command{Name: "geopos", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: geoposCommand})
+	RegisterCommand(&Command{Name: "geodist", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: geodistCommand})
+	RegisterCommand(&Command{Name: "geosearch", Arity: -7, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: geosearchCommand})
+}
+
+var errGeoUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+// parseGeoUnit returns the number of metres in a distance unit.
+func parseGeoUnit(arg string) (float64, error) {
+	switch strings.ToLower(arg) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errGeoUnit
+}
+
+// parseLonLat parses a longitude and latitude pair that can be indexed.
+func parseLonLat(lonArg, latArg string) (lon, lat float64, err error) {
+	lon, ok1 := parseFloat(lonArg)
+	lat, ok2 := parseFloat(latArg)
+	if !ok1 || !ok2 {
+		return 0, 0, errors.New("ERR value is not a valid float")
+	}
+	if !validLonLat(lon, lat) {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+// distanceReply formats a distance the way Redis does, with four decimals
+// as a bulk string under both protocols.
+func distanceReply(meters, unit float64) resp.Value {
+	return resp.BulkString(strconv.FormatFloat(meters/unit, 'f', 4, 64))
+}
+
+// geoaddCommand implements GEOADD key [NX|XX] [CH] longitude latitude member
+// [longitude latitude member ...]. It replies like ZADD.
+func geoaddCommand(c *Client, args []string) resp.Value {
+	var flags zaddFlags
+	var ch bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return syntaxErrorReply
+	}
+	if flags.nx && flags.xx {
+		return resp.Error("ERR XX and NX options at the same time are not compatible")
+	}
+	scores := make([]float64, len(triples)/3)
+	for i := range scores {
+		lon, lat, err := parseLonLat(triples[3*i], triples[3*i+1])
+		if err != nil {
+			return errorReply(err)
+		}
+		scores[i] = float64(geoEncode(lon, lat))
+	}
+
+	z, err := c.store.zsetForWrite(args[1], flags.xx)
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Integer(0)
+	}
+	var n int64
+	changed := false
+	for i, score := range scores {
+		_, res, _ := z.upsert(triples[3*i+2], score, flags)
+		if res == zaddAdded || (ch && res == zaddUpdated) {
+			n++
+		}
+		changed = changed || res == zaddAdded || res == zaddUpdated
+	}
+	c.store.doneWithZset(args[1], z)
+	if changed {
+		c.store.signalModified(args[1])
+	}
+	return resp.Integer(n)
+}
+
+// geoposCommand implements GEOPOS key [member ...], replying with the
+// [longitude, latitude] of each member, or nil for a missing one.
+func geoposCommand(c *Client, args []string) resp.Value {
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	replies := make([]resp.Value, 0, len(args)-2)
+	for _, m := range args[2:] {
+		score, ok := z.score(m)
+		if !ok {
+			replies = append(replies, resp.NullArray)
+			continue
+		}
+		lon, lat := geoDecode(uint64(score))
+		replies = append(replies, resp.Array(resp.Double(lon), resp.Double(lat)))
+	}
+	return resp.Array(replies...)
+}
+
+// geodistCommand implements GEODIST key member1 member2 [M|KM|FT|MI].
+func geodistCommand(c *Client, args []string) resp.Value {
+	if len(args) > 5 {
+		return syntaxErrorReply
+	}
+	unit := 1.0
+	if len(args) == 5 {
+		var err error
+		if unit, err = parseGeoUnit(args[4]); err != nil {
+			return errorReply(err)
+		}
+	}
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	s1, ok1 := z.score(args[2])
+	s2, ok2 := z.score(args[3])
+	if !ok1 || !ok2 {
+		return resp.NullBulk
+	}
+	lon1, lat1 := geoDecode(uint64(s1))
+	lon2, lat2 := geoDecode(uint64(s2))
+	return distanceReply(geoDistance(lon1, lat1, lon2, lat2), unit)
+}
+
+// geoResult is one member found by GEOSEARCH.
+type geoResult struct {
+	member   string
+	hash     uint64
+	lon, lat float64
+	dist     float64
+}
+
+// geosearchCommand implements GEOSEARCH key FROMMEMBER member|FROMLONLAT
+// longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH].
+//
+// Only the sorted set ranges of the geohash cells covering the shape are
+// scanned, then each candidate is checked against the exact shape. With
+// ANY the search stops as soon as count members are found, which makes it
+// cheaper but means they are not necessarily the nearest ones.
+func geosearchCommand(c *Client, args []string) resp.Value {
+	var (
+		shape                         geoShape
+		fromMember                    string
+		hasMember, hasLonLat          bool
+		hasRadius, hasBox             bool
+		unit                          = 1.0
+		desc, sorted, useAny          bool
+		count                         int64
+		withCoord, withDist, withHash bool
+		err                           error
+	)
+	for i := 2; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "FROMMEMBER" && left >= 1 && !hasMember && !hasLonLat:
+			fromMember, hasMember = args[i+1], true
+			i++
+		case opt == "FROMLONLAT" && left >= 2 && !hasMember && !hasLonLat:
+			if shape.lon, shape.lat, err = parseLonLat(args[i+1], args[i+2]); err != nil {
+				return errorReply(err)
+			}
+			hasLonLat = true
+			i += 2
+		case opt == "BYRADIUS" && left >= 2 && !hasRadius && !hasBox:
+			var ok bool
+			if shape.radius, ok = parseFloat(args[i+1]); !ok {
+				return resp.Error("ERR need numeric radius")
+			}
+			if shape.radius < 0 {
+				return resp.Error("ERR radius cannot be negative")
+			}
+			if unit, err = parseGeoUnit(args[i+2]); err != nil {
+				return errorReply(err)
+			}
+			hasRadius = true
+			i += 2
+		case opt == "BYBOX" && left >= 3 && !hasRadius && !hasBox:
+			var ok1, ok2 bool
+			shape.width, ok1 = parseFloat(args[i+1])
+			shape.height, ok2 = parseFloat(args[i+2])
+			if !ok1 || !ok2 {
+				return resp.Error("ERR need numeric width and height")
+			}
+			if shape.width < 0 || shape.height < 0 {
+				return resp.Error("ERR height or width cannot be negative")
+			}
+			if unit, err = parseGeoUnit(args[i+3]); err != nil {
+				return errorReply(err)
+			}
+			shape.box, hasBox = true, true
+			i += 3
+		case opt == "ASC" || opt == "DESC":
+			desc, sorted = opt == "DESC", true
+		case opt == "COUNT" && left >= 1:
+			var ok bool
+			if count, ok = parseInt(args[i+1]); !ok {
+				return notIntegerReply
+			}
+			if count <= 0 {
+				return resp.Error("ERR COUNT must be > 0")
+			}
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "ANY") {
+				useAny = true
+				i++
+			}
+		case opt == "WITHCOORD":
+			withCoord = true
+		case opt == "WITHDIST":
+			withDist = true
+		case opt == "WITHHASH":
+			withHash = true
+		case opt == "FROMMEMBER" || opt == "FROMLONLAT":
+			return resp.Error("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+		case opt == "BYRADIUS" || opt == "BYBOX":
+			return resp.Error("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+		default:
+			return syntaxErrorReply
+		}
+	}
+	if !hasMember && !hasLonLat {
+		return resp.Error("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	}
+	if !hasRadius && !hasBox {
+		return resp.Error("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	}
+	shape.radius *= unit
+	shape.width *= unit
+	shape.height *= unit
+	// Without ANY, COUNT keeps the nearest members.
+	if count > 0 && !useAny && !sorted {
+		sorted = true
+	}
+
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Array()
+	}
+	if hasMember {
+		score, ok := z.score(fromMember)
+		if !ok {
+			return resp.Error("ERR could not decode requested zset member")
+		}
+		shape.lon, shape.lat = geoDecode(uint64(score))
+	}
+
+	var results []geoResult
+search:
+	for _, r := range shape.scoreRanges() {
+		sr := scoreRange{min: r[0], max: r[1], maxex: true}
+		for x := z.zsl.firstInScoreRange(sr); x != nil && sr.lteMax(x.score); x = x.next() {
+			hash := uint64(x.score)
+			lon, lat := geoDecode(hash)
+			dist, ok := shape.contains(lon, lat)
+			if !ok {
+				continue
+			}
+			results = append(results, geoResult{member: x.member, hash: hash, lon: lon, lat: lat, dist: dist})
+			if useAny && int64(len(results)) == count {
+				break search
+			}
+		}
+	}
+	if sorted {
+		slices.SortStableFunc(results, func(a, b geoResult) int {
+			if desc {
+				return cmp.Compare(b.dist, a.dist)
+			}
+			return cmp.Compare(a.dist, b.dist)
+		})
+	}
+	if count > 0 && int64(len(results)) > count {
+		results = results[:count]
+	}
+
+	replies := make([]resp.Value, 0, len(results))
+	for _, r := range results {
+		if !withCoord && !withDist && !withHash {
+			replies = append(replies, resp.BulkString(r.member))
+			continue
+		}
+		item := []resp.Value{resp.BulkString(r.member)}
+		if withDist {
+			item = append(item, distanceReply(r.dist, unit))
+		}
+		if withHash {
+			item = append(item, resp.Integer(int64(r.hash)))
+		}
+		if withCoord {
+			item = append(item, resp.Array(resp.Double(r.lon), resp.Double(r.lat)))
+		}
+		replies = append(replies, resp.Array(item...))
+	}
+	return resp.Array(replies...)
+}
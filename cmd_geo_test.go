@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestGeoaddGeoposGeodist(t *testing.T) {
+	c := newTestClient()
+
+	// The expected values are the ones Redis gives for the same input.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, resp.Integer(2)},
+		{[]string{"ZSCORE", "Sicily", "Palermo"}, resp.Double(3479099956230698)},
+		{[]string{"ZSCORE", "Sicily", "Catania"}, resp.Double(3479447370796909)},
+		{[]string{"TYPE", "Sicily"}, resp.SimpleString("zset")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania"}, resp.BulkString("166274.1516")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "km"}, resp.BulkString("166.2742")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "MI"}, resp.BulkString("103.3182")},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Nowhere"}, resp.NullBulk},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "yd"}, resp.Error("ERR unsupported unit provided. please use M, KM, FT, MI")},
+		{[]string{"GEOPOS", "Sicily", "Palermo", "Nowhere"}, resp.Array(
+			resp.Array(resp.Double(13.361389338970184), resp.Double(38.1155563954963)),
+			resp.NullArray,
+		)},
+		{[]string{"GEOADD", "Sicily", "NX", "0", "0", "Palermo"}, resp.Integer(0)},
+		{[]string{"GEOADD", "Sicily", "XX", "CH", "13.361389", "38.115556", "Palermo", "1", "1", "Nowhere"}, resp.Integer(0)},
+		{[]string{"ZCARD", "Sicily"}, resp.Integer(2)},
+		{[]string{"GEOADD", "Sicily", "181", "0", "x"}, resp.Error("ERR invalid longitude,latitude pair 181.000000,0.000000")},
+		{[]string{"GEOADD", "Sicily", "0", "86", "x"}, resp.Error("ERR invalid longitude,latitude pair 0.000000,86.000000")},
+		{[]string{"GEOADD", "Sicily", "0", "0", "x", "y"}, syntaxErrorReply},
+		{[]string{"GEOADD", "Sicily", "NX", "XX", "0", "0", "x"}, resp.Error("ERR XX and NX options at the same time are not compatible")},
+	})
+}
+
+func TestGeosearch(t *testing.T) {
+	c := newTestClient()
+	do(c, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	do(c, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	withDist := func(member, dist string) resp.Value {
+		return resp.Array(resp.BulkString(member), resp.BulkString(dist))
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}, resp.BulkStrings([]string{"Catania", "Palermo"})},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHDIST"}, resp.Array(
+			withDist("Catania", "56.4413"), withDist("Palermo", "190.4424"), withDist("edge2", "279.7403"), withDist("edge1", "279.7405"),
+		)},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "DESC", "COUNT", "1", "WITHHASH"}, resp.Array(
+			resp.Array(resp.BulkString("Catania"), resp.Integer(3479447370796909)),
+		)},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "0", "m"}, resp.BulkStrings([]string{"Palermo"})},
+		{[]string{"GEOSEARCH", "missing", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"}, resp.Array()},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Nowhere", "BYRADIUS", "1", "km"}, resp.Error("ERR could not decode requested zset member")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "FROMMEMBER", "Palermo"}, resp.Error("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "ASC", "COUNT", "1"}, resp.Error("ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km"}, resp.Error("ERR radius cannot be negative")},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "0"}, resp.Error("ERR COUNT must be > 0")},
+	})
+}
+
+// TestGeosearchMatchesBruteForce checks searches against a scan of every
+// member, including shapes that cross the antimeridian or reach the poles.
+func TestGeosearchMatchesBruteForce(t *testing.T) {
+	c := newTestClient()
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 2000; i++ {
+		lon := r.Float64()*360 - 180
+		lat := r.Float64()*170 - 85
+		do(c, "GEOADD", "points", fmt.Sprint(lon), fmt.Sprint(lat), fmt.Sprint("p", i))
+	}
+	z, _ := c.store.lookupZset("points")
+
+	centres := [][2]float64{{0, 0}, {179.5, 10}, {-179.9, -40}, {30, 84}, {-60, -84.9}}
+	for _, centre := range centres {
+		for _, radius := range []float64{50, 500, 3000} {
+			shapes := []geoShape{
+				{lon: centre[0], lat: centre[1], radius: radius * 1000},
+				{lon: centre[0], lat: centre[1], box: true, width: radius * 2000, height: radius * 1000},
+			}
+			for _, shape := range shapes {
+				var want []string
+				for x := z.zsl.first(); x != nil; x = x.next() {
+					lon, lat := geoDecode(uint64(x.score))
+					if _, ok := shape.contains(lon, lat); ok {
+						want = append(want, x.member)
+					}
+				}
+				args := []string{"GEOSEARCH", "points", "FROMLONLAT", fmt.Sprint(centre[0]), fmt.Sprint(centre[1])}
+				if shape.box {
+					args = append(args, "BYBOX", fmt.Sprint(radius*2), fmt.Sprint(radius), "km")
+				} else {
+					args = append(args, "BYRADIUS", fmt.Sprint(radius), "km")
+				}
+				got := sortedStrs(c.execute(args))
+				slices.Sort(want)
+				if !slices.Equal(got, want) {
+					t.Errorf("%v: got %d members, want %d", args, len(got), len(want))
+				}
+			}
+		}
+	}
+}
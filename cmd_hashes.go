@@ -0,0 +1,519 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "hset", Arity: -4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hsetCommand})
+	RegisterCommand(&Command{Name: "hget", Arity: 3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hgetCommand})
+	RegisterCommand(&Command{Name: "hmget", Arity: -3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hmgetCommand})
+	RegisterCommand(&Command{Name: "hdel", Arity: -3, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hdelCommand})
+	RegisterCommand(&Command{Name: "hexists", Arity: 3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hexistsCommand})
+	RegisterCommand(&Command{Name: "hlen", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hlenCommand})
+	RegisterCommand(&Command{Name: "hgetall", Arity: 2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: hgetallCommand})
+	RegisterCommand(&Command{Name: "hkeys", Arity: 2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: hkeysCommand})
+	RegisterCommand(&Command{Name: "hvals", Arity: 2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: hvalsCommand})
+	RegisterCommand(&Command{Name: "hsetnx", Arity: 4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hsetnxCommand})
+	RegisterCommand(&Command{Name: "hincrby", Arity: 4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hincrbyCommand})
+	RegisterCommand(&Command{Name: "hincrbyfloat", Arity: 4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hincrbyfloatCommand})
+	RegisterCommand(&Command{Name: "hrandfield", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: hrandfieldCommand})
+	for _, name := range []string{"hexpire", "hpexpire", "hexpireat", "hpexpireat"} {
+		RegisterCommand(&Command{Name: name, Arity: -6, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hexpireCommand})
+	}
+	RegisterCommand(&Command{Name: "httl", Arity: -5, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: httlCommand})
+	RegisterCommand(&Command{Name: "hpttl", Arity: -5, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: httlCommand})
+	RegisterCommand(&Command{Name: "hpersist", Arity: -5, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: hpersistCommand})
+}
+
+// hsetCommand implements HSET key field value [field value ...], replying
+// with the number of fields that were added rather than updated.
+func hsetCommand(c *Client, args []string) resp.Value {
+	if len(args)%2 != 0 {
+		return wrongArityReply("hset")
+	}
+	key := args[1]
+	h, err := c.store.hashForWrite(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		if _, ok := h.fields[args[i]]; !ok {
+			added++
+		}
+		h.set(args[i], args[i+1])
+	}
+	c.store.doneWithHash(key, h)
+	c.store.signalModified(key)
+	return resp.Integer(added)
+}
+
+// hgetCommand implements HGET key field.
+func hgetCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	v, ok := h.get(args[2])
+	if !ok {
+		return resp.NullBulk
+	}
+	return resp.BulkString(v)
+}
+
+// hmgetCommand implements HMGET key field [field ...]. Missing fields yield
+// nil.
+func hmgetCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	values := make([]resp.Value, 0, len(args)-2)
+	for _, field := range args[2:] {
+		if v, ok := h.get(field); ok {
+			values = append(values, resp.BulkString(v))
+		} else {
+			values = append(values, resp.NullBulk)
+		}
+	}
+	return resp.Array(values...)
+}
+
+// hdelCommand implements HDEL key field [field ...]. A hash left empty is
+// deleted.
+func hdelCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	h, err := c.store.lookupHash(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if h == nil {
+		return resp.Integer(0)
+	}
+	var removed int64
+	for _, field := range args[2:] {
+		if h.del(field) {
+			removed++
+		}
+	}
+	c.store.doneWithHash(key, h)
+	if removed > 0 {
+		c.store.signalModified(key)
+	}
+	return resp.Integer(removed)
+}
+
+// hexistsCommand implements HEXISTS key field.
+func hexistsCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if _, ok := h.get(args[2]); ok {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
+
+// hlenCommand implements HLEN key.
+func hlenCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(int64(h.length()))
+}
+
+// hgetallCommand implements HGETALL key. RESP3 clients get a map; RESP2
+// clients see it flattened to field, value, field, value...
+func hgetallCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	pairs := []resp.Value{}
+	h.each(func(field, v string) {
+		pairs = append(pairs, resp.BulkString(field), resp.BulkString(v))
+	})
+	return resp.Map(pairs...)
+}
+
+// hkeysCommand implements HKEYS key.
+func hkeysCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	keys := []string{}
+	h.each(func(field, _ string) {
+		keys = append(keys, field)
+	})
+	return resp.BulkStrings(keys)
+}
+
+// hvalsCommand implements HVALS key.
+func hvalsCommand(c *Client, args []string) resp.Value {
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	vals := []string{}
+	h.each(func(_, v string) {
+		vals = append(vals, v)
+	})
+	return resp.BulkStrings(vals)
+}
+
+// hsetnxCommand implements HSETNX key field value, which only sets the
+// field if it does not exist yet.
+func hsetnxCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	h, err := c.store.lookupHash(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if _, ok := h.get(args[2]); ok {
+		return resp.Integer(0)
+	}
+	h, _ = c.store.hashForWrite(key)
+	h.set(args[2], args[3])
+	c.store.doneWithHash(key, h)
+	c.store.signalModified(key)
+	return resp.Integer(1)
+}
+
+// hincrbyCommand implements HINCRBY key field increment, treating a missing
+// field as 0.
+func hincrbyCommand(c *Client, args []string) resp.Value {
+	delta, ok := parseInt(args[3])
+	if !ok {
+		return notIntegerReply
+	}
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	var n int64
+	if v, exists := h.get(args[2]); exists {
+		if n, ok = parseInt(v); !ok {
+			return resp.Error("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return resp.Error("ERR increment or decrement would overflow")
+	}
+	n += delta
+
+	h, _ = c.store.hashForWrite(args[1])
+	h.update(args[2], strconv.FormatInt(n, 10))
+	c.store.doneWithHash(args[1], h)
+	c.store.signalModified(args[1])
+	return resp.Integer(n)
+}
+
+// hincrbyfloatCommand implements HINCRBYFLOAT key field increment, treating
+// a missing field as 0.
+func hincrbyfloatCommand(c *Client, args []string) resp.Value {
+	delta, ok := parseFloat(args[3])
+	if !ok {
+		return notFloatReply
+	}
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	var f float64
+	if v, exists := h.get(args[2]); exists {
+		if f, ok = parseFloat(v); !ok {
+			return resp.Error("ERR hash value is not a float")
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return resp.Error("ERR increment would produce NaN or Infinity")
+	}
+
+	h, _ = c.store.hashForWrite(args[1])
+	s := formatFloat(f)
+	h.update(args[2], s)
+	c.store.doneWithHash(args[1], h)
+	c.store.signalModified(args[1])
+	return resp.BulkString(s)
+}
+
+// hrandfieldCommand implements HRANDFIELD key [count [WITHVALUES]]. A
+// positive count returns up to count distinct fields; a negative one
+// returns exactly -count fields, possibly repeated.
+func hrandfieldCommand(c *Client, args []string) resp.Value {
+	withCount := len(args) >= 3
+	var count int64
+	var withValues bool
+	if withCount {
+		var ok bool
+		if count, ok = parseInt(args[2]); !ok {
+			return notIntegerReply
+		}
+		if count < -math.MaxInt64/2 || count > math.MaxInt64/2 {
+			return resp.Error("ERR value is out of range")
+		}
+		switch {
+		case len(args) == 4 && strings.EqualFold(args[3], "WITHVALUES"):
+			withValues = true
+		case len(args) > 3:
+			return syntaxErrorReply
+		}
+	}
+
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	fields := make([]string, 0, h.length())
+	h.each(func(field, _ string) {
+		fields = append(fields, field)
+	})
+	if len(fields) == 0 {
+		if withCount {
+			return resp.Array()
+		}
+		return resp.NullBulk
+	}
+	if !withCount {
+		return resp.BulkString(fields[rand.IntN(len(fields))])
+	}
+
+	var picked []string
+	if count >= 0 {
+		rand.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+		picked = fields[:min(count, int64(len(fields)))]
+	} else {
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = fields[rand.IntN(len(fields))]
+		}
+	}
+
+	if !withValues {
+		return resp.BulkStrings(picked)
+	}
+	reply := make([]resp.Value, 0, 2*len(picked))
+	for _, field := range picked {
+		pair := []resp.Value{resp.BulkString(field), resp.BulkString(h.fields[field])}
+		if c.proto >= 3 {
+			reply = append(reply, resp.Array(pair...))
+		} else {
+			reply = append(reply, pair...)
+		}
+	}
+	return resp.Array(reply...)
+}
+
+// parseFieldsArg parses the FIELDS numfields field [field ...] tail of the
+// hash field expiration commands.
+func parseFieldsArg(args []string) ([]string, error) {
+	if len(args) < 3 || !strings.EqualFold(args[0], "FIELDS") {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, ok := parseInt(args[1])
+	if !ok {
+		return nil, errNotInteger
+	}
+	if n <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != int64(len(args)-2) {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// Per-field results of the hash field expiration commands.
+const (
+	fieldMissing    = -2 // no such field, or no such key
+	fieldNoTTL      = -1 // HTTL and HPERSIST: the field has no TTL
+	fieldNotSet     = 0  // HEXPIRE: the NX/XX/GT/LT condition was not met
+	fieldSet        = 1  // HEXPIRE: the TTL was set; HPERSIST: it was removed
+	fieldDeletedNow = 2  // HEXPIRE: the deadline had passed, so the field was deleted
+)
+
+// fieldReplies builds the array reply of the field expiration commands.
+func fieldReplies(results []int64) resp.Value {
+	vs := make([]resp.Value, len(results))
+	for i, r := range results {
+		vs[i] = resp.Integer(r)
+	}
+	return resp.Array(vs...)
+}
+
+// hexpireCommand implements HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT:
+//
+//	HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+//
+// The variants differ in units the same way EXPIRE and friends do. The
+// reply has one result per field.
+func hexpireCommand(c *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	n, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	if n < 0 {
+		return resp.Error("ERR invalid expire time, must be >= 0")
+	}
+
+	rest := args[3:]
+	var nx, xx, gt, lt bool
+	switch strings.ToUpper(rest[0]) {
+	case "NX":
+		nx = true
+	case "XX":
+		xx = true
+	case "GT":
+		gt = true
+	case "LT":
+		lt = true
+	}
+	if nx || xx || gt || lt {
+		rest = rest[1:]
+	}
+	fields, err := parseFieldsArg(rest)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	unit := int64(1)
+	if !strings.HasPrefix(name, "hp") {
+		unit = 1000
+	}
+	if n > math.MaxInt64/unit {
+		return resp.Errorf("ERR invalid expire time in '%s' command", name)
+	}
+	ms := n * unit
+	if !strings.HasSuffix(name, "at") {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return resp.Errorf("ERR invalid expire time in '%s' command", name)
+		}
+		ms += now
+	}
+	at := time.UnixMilli(ms)
+
+	key := args[1]
+	h, err := c.store.lookupHash(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	results := make([]int64, len(fields))
+	if h == nil {
+		for i := range results {
+			results[i] = fieldMissing
+		}
+		return fieldReplies(results)
+	}
+
+	now := time.Now()
+	changed := false
+	for i, field := range fields {
+		if _, ok := h.get(field); !ok {
+			results[i] = fieldMissing
+			continue
+		}
+		if !expireAllowed(nx, xx, gt, lt, h.expires[field], at) {
+			results[i] = fieldNotSet
+			continue
+		}
+		if !now.Before(at) {
+			h.del(field)
+			results[i] = fieldDeletedNow
+			changed = true
+			continue
+		}
+		h.setFieldExpire(field, at)
+		results[i] = fieldSet
+		changed = true
+	}
+	c.store.doneWithHash(key, h)
+	if changed {
+		c.store.signalModified(key)
+	}
+	if name != "hpexpireat" {
+		// Log the absolute deadline, so replaying the AOF sets the same.
+		logged := slices.Clone(args)
+		logged[0], logged[2] = "HPEXPIREAT", strconv.FormatInt(ms, 10)
+		c.propagateAs(logged)
+	}
+	return fieldReplies(results)
+}
+
+// httlCommand implements HTTL key FIELDS numfields field [field ...] and
+// HPTTL, which replies in milliseconds.
+func httlCommand(c *Client, args []string) resp.Value {
+	fields, err := parseFieldsArg(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	h, err := c.store.lookupHash(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	ms := strings.EqualFold(args[0], "hpttl")
+	results := make([]int64, len(fields))
+	for i, field := range fields {
+		if _, ok := h.get(field); !ok {
+			results[i] = fieldMissing
+			continue
+		}
+		at, ok := h.expires[field]
+		if !ok {
+			results[i] = fieldNoTTL
+			continue
+		}
+		left := max(time.Until(at).Milliseconds(), 0)
+		if !ms {
+			left = msToSeconds(left)
+		}
+		results[i] = left
+	}
+	return fieldReplies(results)
+}
+
+// hpersistCommand implements HPERSIST key FIELDS numfields field
+// [field ...], removing the TTL of each field.
+func hpersistCommand(c *Client, args []string) resp.Value {
+	fields, err := parseFieldsArg(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	key := args[1]
+	h, err := c.store.lookupHash(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	results := make([]int64, len(fields))
+	for i, field := range fields {
+		if _, ok := h.get(field); !ok {
+			results[i] = fieldMissing
+			continue
+		}
+		if _, ok := h.expires[field]; !ok {
+			results[i] = fieldNoTTL
+			continue
+		}
+		delete(h.expires, field)
+		results[i] = fieldSet
+		c.store.signalModified(key)
+	}
+	if h != nil {
+		c.store.doneWithHash(key, h)
+	}
+	return fieldReplies(results)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// sortedStrs returns the string elements of an array reply, sorted, for
+// replies whose order is unspecified.
+func sortedStrs(v resp.Value) []string {
+	strs := []string{}
+	for _, e := range v.Array {
+		strs = append(strs, e.Str)
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+func TestHashBasics(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"HSET", "user", "name", "ada", "lang", "go"}, resp.Integer(2)},
+		{[]string{"HSET", "user", "lang", "lisp", "year", "1843"}, resp.Integer(1)},
+		{[]string{"HSET", "user", "odd"}, wrongArityReply("hset")},
+		{[]string{"HGET", "user", "lang"}, resp.BulkString("lisp")},
+		{[]string{"HGET", "user", "nope"}, resp.NullBulk},
+		{[]string{"HGET", "missing", "name"}, resp.NullBulk},
+		{[]string{"HMGET", "user", "name", "nope", "year"}, resp.Array(resp.BulkString("ada"), resp.NullBulk, resp.BulkString("1843"))},
+		{[]string{"HMGET", "missing", "a"}, resp.Array(resp.NullBulk)},
+		{[]string{"HEXISTS", "user", "name"}, resp.Integer(1)},
+		{[]string{"HEXISTS", "user", "nope"}, resp.Integer(0)},
+		{[]string{"HEXISTS", "missing", "name"}, resp.Integer(0)},
+		{[]string{"HLEN", "user"}, resp.Integer(3)},
+		{[]string{"HLEN", "missing"}, resp.Integer(0)},
+		{[]string{"TYPE", "user"}, resp.SimpleString("hash")},
+		{[]string{"HDEL", "user", "year", "nope"}, resp.Integer(1)},
+		{[]string{"HDEL", "user", "name", "lang"}, resp.Integer(2)},
+		{[]string{"EXISTS", "user"}, resp.Integer(0)},
+		{[]string{"HDEL", "user", "name"}, resp.Integer(0)},
+		{[]string{"HGETALL", "missing"}, resp.Map()},
+		{[]string{"HKEYS", "missing"}, resp.Array()},
+	})
+
+	do(c, "HSET", "h", "a", "1", "b", "2")
+	if got := sortedStrs(do(c, "HKEYS", "h")); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("HKEYS = %q", got)
+	}
+	if got := sortedStrs(do(c, "HVALS", "h")); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("HVALS = %q", got)
+	}
+	all := do(c, "HGETALL", "h")
+	if all.Type != resp.TypeMap || len(all.Array) != 4 {
+		t.Fatalf("HGETALL = %v", all)
+	}
+	got := map[string]string{}
+	for i := 0; i < len(all.Array); i += 2 {
+		got[all.Array[i].Str] = all.Array[i+1].Str
+	}
+	if !reflect.DeepEqual(got, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("HGETALL = %v", got)
+	}
+}
+
+func TestHashTypeChecks(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "s", "v")
+	do(c, "HSET", "h", "f", "v")
+
+	for _, args := range [][]string{
+		{"HSET", "s", "f", "v"},
+		{"HGET", "s", "f"},
+		{"HMGET", "s", "f"},
+		{"HDEL", "s", "f"},
+		{"HEXISTS", "s", "f"},
+		{"HLEN", "s"},
+		{"HGETALL", "s"},
+		{"HKEYS", "s"},
+		{"HVALS", "s"},
+		{"GET", "h"},
+		{"LPUSH", "h", "x"},
+	} {
+		expectReply(t, c, []struct {
+			args []string
+			want resp.Value
+		}{{args, wrongTypeReply}})
+	}
+}
+
+func TestHashCounters(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"HSETNX", "h", "f", "1"}, resp.Integer(1)},
+		{[]string{"HSETNX", "h", "f", "2"}, resp.Integer(0)},
+		{[]string{"HGET", "h", "f"}, resp.BulkString("1")},
+		{[]string{"HINCRBY", "h", "f", "41"}, resp.Integer(42)},
+		{[]string{"HINCRBY", "h", "new", "-5"}, resp.Integer(-5)},
+		{[]string{"HINCRBY", "fresh", "n", "1"}, resp.Integer(1)},
+		{[]string{"HINCRBY", "h", "f", "x"}, notIntegerReply},
+		{[]string{"HSET", "h", "name", "ada", "big", "9223372036854775807"}, resp.Integer(2)},
+		{[]string{"HINCRBY", "h", "name", "1"}, resp.Error("ERR hash value is not an integer")},
+		{[]string{"HINCRBY", "h", "big", "1"}, resp.Error("ERR increment or decrement would overflow")},
+		{[]string{"HINCRBYFLOAT", "h", "f", "0.5"}, resp.BulkString("42.5")},
+		{[]string{"HINCRBYFLOAT", "h", "pi", "3.14"}, resp.BulkString("3.14")},
+		{[]string{"HINCRBYFLOAT", "h", "f", "1e2"}, resp.BulkString("142.5")},
+		{[]string{"HINCRBYFLOAT", "h", "name", "1"}, resp.Error("ERR hash value is not a float")},
+		{[]string{"HINCRBYFLOAT", "h", "f", "abc"}, notFloatReply},
+		{[]string{"HINCRBYFLOAT", "h", "f", "inf"}, resp.Error("ERR increment would produce NaN or Infinity")},
+		{[]string{"HINCRBYFLOAT", "missing", "f", "x"}, notFloatReply},
+		{[]string{"EXISTS", "missing"}, resp.Integer(0)},
+		{[]string{"SET", "s", "v"}, resp.OK},
+		{[]string{"HSETNX", "s", "f", "v"}, wrongTypeReply},
+		{[]string{"HINCRBY", "s", "f", "1"}, wrongTypeReply},
+		{[]string{"HINCRBYFLOAT", "s", "f", "1"}, wrongTypeReply},
+		{[]string{"HRANDFIELD", "s"}, wrongTypeReply},
+	})
+}
+
+func TestHRandField(t *testing.T) {
+	c := newTestClient()
+	do(c, "HSET", "h", "a", "1", "b", "2", "c", "3")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"HRANDFIELD", "missing"}, resp.NullBulk},
+		{[]string{"HRANDFIELD", "missing", "3"}, resp.Array()},
+		{[]string{"HRANDFIELD", "h", "0"}, resp.Array()},
+		{[]string{"HRANDFIELD", "h", "x"}, notIntegerReply},
+		{[]string{"HRANDFIELD", "h", "1", "WITHSCORES"}, syntaxErrorReply},
+		{[]string{"HRANDFIELD", "h", "-9223372036854775807"}, resp.Error("ERR value is out of range")},
+	})
+
+	if f := do(c, "HRANDFIELD", "h").Str; f != "a" && f != "b" && f != "c" {
+		t.Errorf("HRANDFIELD = %q", f)
+	}
+	if got := sortedStrs(do(c, "HRANDFIELD", "h", "10")); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("HRANDFIELD 10 = %q, want every field once", got)
+	}
+	if got := do(c, "HRANDFIELD", "h", "2"); len(got.Array) != 2 || got.Array[0].Str == got.Array[1].Str {
+		t.Errorf("HRANDFIELD 2 = %v, want two distinct fields", got)
+	}
+	if got := do(c, "HRANDFIELD", "h", "-10"); len(got.Array) != 10 {
+		t.Errorf("HRANDFIELD -10 returned %d fields", len(got.Array))
+	}
+
+	flat := do(c, "HRANDFIELD", "h", "-4", "WITHVALUES")
+	if len(flat.Array) != 8 {
+		t.Fatalf("HRANDFIELD WITHVALUES over RESP2 = %v", flat)
+	}
+	for i := 0; i < 8; i += 2 {
+		if want := map[string]string{"a": "1", "b": "2", "c": "3"}[flat.Array[i].Str]; flat.Array[i+1].Str != want {
+			t.Errorf("field %q paired with %q", flat.Array[i].Str, flat.Array[i+1].Str)
+		}
+	}
+
+	c.proto = 3
+	nested := do(c, "HRANDFIELD", "h", "2", "WITHVALUES")
+	if len(nested.Array) != 2 || len(nested.Array[0].Array) != 2 {
+		t.Fatalf("HRANDFIELD WITHVALUES over RESP3 = %v", nested)
+	}
+}
+
+// ints builds an array reply of integers.
+func ints(ns ...int64) resp.Value {
+	vs := make([]resp.Value, len(ns))
+	for i, n := range ns {
+		vs[i] = resp.Integer(n)
+	}
+	return resp.Array(vs...)
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	c := newTestClient()
+	do(c, "HSET", "session", "user", "ada", "token", "t1", "csrf", "c1")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"HEXPIRE", "session", "100", "FIELDS", "2", "token", "nope"}, ints(1, -2)},
+		{[]string{"HTTL", "session", "FIELDS", "3", "token", "user", "nope"}, ints(100, -1, -2)},
+		{[]string{"HEXPIRE", "session", "50", "NX", "FIELDS", "2", "token", "csrf"}, ints(0, 1)},
+		{[]string{"HEXPIRE", "session", "200", "GT", "FIELDS", "2", "token", "user"}, ints(1, 0)},
+		{[]string{"HEXPIRE", "session", "10", "LT", "FIELDS", "2", "token", "user"}, ints(1, 1)},
+		{[]string{"HEXPIRE", "session", "10", "XX", "FIELDS", "1", "user"}, ints(1)},
+		{[]string{"HPERSIST", "session", "FIELDS", "3", "user", "user", "nope"}, ints(1, -1, -2)},
+		{[]string{"HPEXPIRE", "session", "5000", "FIELDS", "1", "csrf"}, ints(1)},
+		{[]string{"HEXPIRE", "session", "0", "FIELDS", "1", "csrf"}, ints(2)},
+		{[]string{"HEXISTS", "session", "csrf"}, resp.Integer(0)},
+		{[]string{"HEXPIREAT", "session", "1", "FIELDS", "1", "token"}, ints(2)},
+		{[]string{"HGETALL", "session"}, resp.Map(resp.BulkString("user"), resp.BulkString("ada"))},
+		{[]string{"HEXPIRE", "missing", "10", "FIELDS", "2", "a", "b"}, ints(-2, -2)},
+		{[]string{"HTTL", "missing", "FIELDS", "1", "a"}, ints(-2)},
+		{[]string{"HPERSIST", "missing", "FIELDS", "1", "a"}, ints(-2)},
+		{[]string{"HEXPIRE", "session", "-1", "FIELDS", "1", "user"}, resp.Error("ERR invalid expire time, must be >= 0")},
+		{[]string{"HEXPIRE", "session", "10", "FIELDS", "0", "user"}, resp.Error("ERR Parameter `numFields` should be greater than 0")},
+		{[]string{"HEXPIRE", "session", "10", "FIELDS", "2", "user"}, resp.Error("ERR The `numfields` parameter must match the number of arguments")},
+		{[]string{"HEXPIRE", "session", "10", "NX", "XX", "FIELDS", "1", "user"}, resp.Error("ERR Mandatory argument FIELDS is missing or not at the right position")},
+		{[]string{"HEXPIRE", "session", "9223372036854775807", "FIELDS", "1", "user"}, resp.Error("ERR invalid expire time in 'hexpire' command")},
+		{[]string{"HSET", "s2", "f", "v"}, resp.Integer(1)},
+		{[]string{"HEXPIRE", "s2", "0", "FIELDS", "1", "f"}, ints(2)},
+		{[]string{"EXISTS", "s2"}, resp.Integer(0)},
+	})
+
+	if ms := do(c, "HPTTL", "session", "FIELDS", "1", "user").Array; len(ms) != 1 || ms[0].Int != -1 {
+		t.Errorf("HPTTL = %v", ms)
+	}
+}
+
+func TestHashFieldExpiryIsLazyAndActive(t *testing.T) {
+	c := newTestClient()
+	do(c, "HSET", "h", "a", "1", "b", "2")
+	do(c, "HSET", "gone", "x", "1")
+	do(c, "HPEXPIRE", "h", "1000", "FIELDS", "1", "a")
+	do(c, "HPEXPIRE", "gone", "1000", "FIELDS", "1", "x")
+
+	// Move the deadlines into the past without going through a command.
+	c.store.mu.Lock()
+	past := time.Now().Add(-time.Second)
+	c.store.data["h"].value.(*hashValue).expires["a"] = past
+	c.store.data["gone"].value.(*hashValue).expires["x"] = past
+	c.store.mu.Unlock()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"HGET", "h", "a"}, resp.NullBulk},
+		{[]string{"HLEN", "h"}, resp.Integer(1)},
+		{[]string{"HKEYS", "h"}, resp.BulkStrings([]string{"b"})},
+		{[]string{"HTTL", "h", "FIELDS", "1", "a"}, ints(-2)},
+		{[]string{"HINCRBY", "h", "a", "5"}, resp.Integer(5)},
+		{[]string{"HTTL", "h", "FIELDS", "1", "a"}, ints(-1)},
+	})
+
+	c.store.cleanup()
+	if _, ok := c.store.data["gone"]; ok {
+		t.Error("janitor left a hash whose only field expired")
+	}
+	if _, ok := c.store.fieldExpires["gone"]; ok {
+		t.Error("janitor left the deleted hash indexed")
+	}
+	if len(c.store.fieldExpires) != 0 {
+		t.Errorf("fieldExpires = %v, want empty", c.store.fieldExpires)
+	}
+}
+
+func TestHashFieldTTLSurvivesRenameAndHSetClearsIt(t *testing.T) {
+	c := newTestClient()
+	do(c, "HSET", "h", "f", "v")
+	do(c, "HEXPIRE", "h", "100", "FIELDS", "1", "f")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"RENAME", "h", "h2"}, resp.OK},
+		{[]string{"HTTL", "h2", "FIELDS", "1", "f"}, ints(100)},
+		{[]string{"COPY", "h2", "h3"}, resp.Integer(1)},
+		{[]string{"HTTL", "h3", "FIELDS", "1", "f"}, ints(100)},
+		{[]string{"HSET", "h2", "f", "new"}, resp.Integer(0)},
+		{[]string{"HTTL", "h2", "FIELDS", "1", "f"}, ints(-1)},
+	})
+	if _, ok := c.store.fieldExpires["h"]; ok {
+		t.Error("renamed-away key is still indexed")
+	}
+	if _, ok := c.store.fieldExpires["h2"]; ok {
+		t.Error("h2 has no field TTLs left but is still indexed")
+	}
+	if _, ok := c.store.fieldExpires["h3"]; !ok {
+		t.Error("copy with field TTLs is not indexed")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "pfadd", Arity: -2, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: pfaddCommand})
+	// PFCOUNT writes too: it caches the cardinality in the value.
+	RegisterCommand(&Command{Name: "pfcount", Arity: -2, Flags: flagWrite, FirstKey: 1, LastKey: -1, Step: 1, Handler: pfcountCommand})
+	RegisterCommand(&Command{Name: "pfmerge", Arity: -2, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, Handler: pfmergeCommand})
+}
+
+// pfaddCommand implements PFADD key [element ...], replying 1 if the
+// approximated cardinality may have changed, which includes creating the
+// key, and 0 otherwise.
+func pfaddCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	d, ok, err := c.store.lookupHLL(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	if !ok {
+		b = newHLL()
+	}
+	b, updated, err := hllAdd(b, args[2:], c.srv.cfg.HllSparseMaxBytes)
+	if err != nil {
+		return errorReply(err)
+	}
+	if updated {
+		hllInvalidateCache(b)
+	}
+	if !updated && ok {
+		return resp.Integer(0)
+	}
+	d.value = b
+	c.store.put(key, d)
+	return resp.Integer(1)
+}
+
+// pfcountCommand implements PFCOUNT key [key ...]. For a single key the
+// estimate is cached in the value until the next change; several keys are
+// counted as their union, without changing any of them.
+func pfcountCommand(c *Client, args []string) resp.Value {
+	if len(args) == 2 {
+		d, ok, err := c.store.lookupHLL(args[1])
+		if err != nil {
+			return errorReply(err)
+		}
+		if !ok {
+			return resp.Integer(0)
+		}
+		b := d.bytes()
+		if n, ok := hllCachedCount(b); ok {
+			return resp.Integer(int64(n))
+		}
+		var regs hllRegs
+		if err := regs.mergeInto(b); err != nil {
+			return errorReply(err)
+		}
+		n := regs.count()
+		hllSetCachedCount(b, n)
+		return resp.Integer(int64(n))
+	}
+
+	var regs hllRegs
+	for _, key := range args[1:] {
+		d, ok, err := c.store.lookupHLL(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if !ok {
+			continue
+		}
+		if err := regs.mergeInto(d.bytes()); err != nil {
+			return errorReply(err)
+		}
+	}
+	return resp.Integer(int64(regs.count()))
+}
+
+// pfmergeCommand implements PFMERGE destkey [sourcekey ...], storing the
+// union of the sources and the destination itself in the destination. The
+// result stays sparse only if every input was sparse and it still fits.
+func pfmergeCommand(c *Client, args []string) resp.Value {
+	var regs hllRegs
+	useDense := false
+	for _, key := range args[1:] {
+		d, ok, err := c.store.lookupHLL(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if !ok {
+			continue
+		}
+		b := d.bytes()
+		useDense = useDense || b[4] == hllDense
+		if err := regs.mergeInto(b); err != nil {
+			return errorReply(err)
+		}
+	}
+
+	var b []byte
+	if !useDense {
+		b, _ = regs.sparse(c.srv.cfg.HllSparseMaxBytes)
+	}
+	if b == nil {
+		b = regs.dense()
+	}
+	hllInvalidateCache(b)
+
+	dst := args[1]
+	d, _, _ := c.store.lookupString(dst)
+	d.value = b
+	c.store.put(dst, d)
+	return resp.OK
+}
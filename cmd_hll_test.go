@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestPfaddPfcount(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PFCOUNT", "h"}, resp.Integer(0)},
+		{[]string{"PFADD", "h"}, resp.Integer(1)},
+		{[]string{"PFADD", "h"}, resp.Integer(0)},
+		{[]string{"PFADD", "h", "a", "b", "c", "d", "e", "f", "g"}, resp.Integer(1)},
+		{[]string{"PFADD", "h", "a", "b"}, resp.Integer(0)},
+		{[]string{"PFCOUNT", "h"}, resp.Integer(7)},
+		{[]string{"PFCOUNT", "h"}, resp.Integer(7)},
+		{[]string{"TYPE", "h"}, resp.SimpleString("string")},
+		{[]string{"SET", "s", "not an hll"}, resp.OK},
+		{[]string{"PFADD", "s", "a"}, resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{[]string{"PFCOUNT", "s"}, resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{[]string{"RPUSH", "l", "x"}, resp.Integer(1)},
+		{[]string{"PFADD", "l", "a"}, wrongTypeReply},
+	})
+}
+
+func TestPfcountCacheAndCopy(t *testing.T) {
+	c := newTestClient()
+	do(c, "PFADD", "h", "x", "y")
+	do(c, "PFCOUNT", "h")
+	// A copy made through GET and SET is a valid HyperLogLog, cache
+	// included.
+	raw := do(c, "GET", "h")
+	do(c, "SET", "copy", raw.Str)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PFCOUNT", "copy"}, resp.Integer(2)},
+		{[]string{"PFADD", "copy", "z"}, resp.Integer(1)},
+		{[]string{"PFCOUNT", "copy"}, resp.Integer(3)},
+		{[]string{"PFCOUNT", "h"}, resp.Integer(2)},
+	})
+}
+
+func TestPfmerge(t *testing.T) {
+	c := newTestClient()
+	do(c, "PFADD", "a", "1", "2", "3")
+	do(c, "PFADD", "b", "3", "4", "5")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PFCOUNT", "a", "b", "missing"}, resp.Integer(5)},
+		{[]string{"PFMERGE", "dst", "a", "b"}, resp.OK},
+		{[]string{"PFCOUNT", "dst"}, resp.Integer(5)},
+		{[]string{"PFMERGE", "dst", "missing"}, resp.OK},
+		{[]string{"PFCOUNT", "dst"}, resp.Integer(5)},
+		{[]string{"PFMERGE", "empty"}, resp.OK},
+		{[]string{"PFCOUNT", "empty"}, resp.Integer(0)},
+		{[]string{"SET", "s", "v"}, resp.OK},
+		{[]string{"PFMERGE", "dst", "s"}, resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+	})
+
+	// Merging into a large dense HyperLogLog keeps it dense.
+	args := []string{"PFADD", "big"}
+	for i := 0; i < 5000; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	c.execute(args)
+	do(c, "PFMERGE", "big", "a")
+	if b := do(c, "GET", "big").Str; b[4] != hllDense {
+		t.Error("expected a dense HyperLogLog")
+	}
+	if n := do(c, "PFCOUNT", "big").Int; n < 4900 || n > 5100 {
+		t.Errorf("PFCOUNT big: got %d, want about 5000", n)
+	}
+}
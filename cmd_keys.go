@@ -0,0 +1,516 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "del", Arity: -2, Flags: flagWrite, FirstKey: 1, LastKey: -1, Step: 1, Handler: delCommand})
+	RegisterCommand(&Command{Name: "unlink", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: -1, Step: 1, Handler: unlinkCommand})
+	// TOUCH only reads in Redis, but it updates accessedAt in place here, so
+	// it needs the write lock.
+	RegisterCommand(&Command{Name: "touch", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: -1, Step: 1, Handler: touchCommand})
+	RegisterCommand(&Command{Name: "exists", Arity: -2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: -1, Step: 1, Handler: existsCommand})
+	RegisterCommand(&Command{Name: "rename", Arity: 3, Flags: flagWrite, FirstKey: 1, LastKey: 2, Step: 1, Handler: renameCommand})
+	RegisterCommand(&Command{Name: "renamenx", Arity: 3, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 2, Step: 1, Handler: renamenxCommand})
+	RegisterCommand(&Command{Name: "type", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: typeCommand})
+	RegisterCommand(&Command{Name: "copy", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 2, Step: 1, Handler: copyCommand})
+	RegisterCommand(&Command{Name: "dump", Arity: 2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: dumpCommand})
+	RegisterCommand(&Command{Name: "restore", Arity: -4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: restoreCommand})
+	// MIGRATE waits for the target with the store locked, as Redis does,
+	// so the keys move atomically.
+	RegisterCommand(&Command{Name: "migrate", Arity: -6, Flags: flagWrite, FirstKey: 3, LastKey: 3, Step: 1, Handler: migrateCommand})
+	RegisterCommand(&Command{Name: "scan", Arity: -2, Flags: flagReadonly, Handler: scanCommand})
+	RegisterCommand(&Command{Name: "randomkey", Arity: 1, Flags: flagReadonly, Handler: randomkeyCommand})
+	RegisterCommand(&Command{Name: "keys", Arity: 2, Flags: flagReadonly, Handler: keysCommand})
+}
+
+// delCommand implements DEL key [key ...], returning how many of the keys
+// were removed.
+func delCommand(c *Client, args []string) resp.Value {
+	var n int64
+	for _, key := range args[1:] {
+		if c.store.del(key) {
+			n++
+		}
+	}
+	return resp.Integer(n)
+}
+
+// unlinkCommand implements UNLINK key [key ...]. The keys disappear at once;
+// their values are released in the background.
+func unlinkCommand(c *Client, args []string) resp.Value {
+	var n int64
+	for _, key := range args[1:] {
+		if c.store.unlink(key) {
+			n++
+		}
+	}
+	return resp.Integer(n)
+}
+
+// touchCommand implements TOUCH key [key ...], returning how many of the
+// keys exist.
+func touchCommand(c *Client, args []string) resp.Value {
+	var n int64
+	for _, key := range args[1:] {
+		if c.store.touch(key) {
+			n++
+		}
+	}
+	return resp.Integer(n)
+}
+
+// existsCommand implements EXISTS key [key ...]. A key named more than once
+// is counted once per mention, as in Redis.
+func existsCommand(c *Client, args []string) resp.Value {
+	var n int64
+	for _, key := range args[1:] {
+		if _, ok := c.store.lookup(key); ok {
+			n++
+		}
+	}
+	return resp.Integer(n)
+}
+
+// keysCommand implements KEYS pattern. It walks the whole keyspace while
+// holding the lock, so SCAN is the better choice on large databases.
+func keysCommand(c *Client, args []string) resp.Value {
+	pattern := args[1]
+	all := pattern == "*"
+	now := time.Now()
+	keys := []string{}
+	for key, d := range c.store.data {
+		if d.expired(now) {
+			continue
+		}
+		if all || matchPattern(pattern, key, false) {
+			keys = append(keys, key)
+		}
+	}
+	return resp.BulkStrings(keys)
+}
+
+// typeCommand implements TYPE key.
+func typeCommand(c *Client, args []string) resp.Value {
+	d, ok := c.store.lookup(args[1])
+	if !ok {
+		return resp.SimpleString("none")
+	}
+	return resp.SimpleString(d.typeName())
+}
+
+var noSuchKeyReply = resp.Error("ERR no such key")
+
+// renameCommand implements RENAME key newkey. The value keeps its TTL.
+func renameCommand(c *Client, args []string) resp.Value {
+	src, dst := args[1], args[2]
+	d, ok := c.store.lookup(src)
+	if !ok {
+		return noSuchKeyReply
+	}
+	if src != dst {
+		c.store.remove(src)
+		c.store.put(dst, d)
+	}
+	return resp.OK
+}
+
+// renamenxCommand implements RENAMENX key newkey, which only renames when
+// newkey does not exist.
+func renamenxCommand(c *Client, args []string) resp.Value {
+	src, dst := args[1], args[2]
+	d, ok := c.store.lookup(src)
+	if !ok {
+		return noSuchKeyReply
+	}
+	if _, exists := c.store.lookup(dst); exists {
+		return resp.Integer(0)
+	}
+	c.store.remove(src)
+	c.store.put(dst, d)
+	return resp.Integer(1)
+}
+
+// copyCommand implements COPY source destination [DB destination-db]
+// [REPLACE]. The copy keeps the source's TTL.
+func copyCommand(c *Client, args []string) resp.Value {
+	src, dst := args[1], args[2]
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return syntaxErrorReply
+			}
+			i++
+			if err := checkDBIndex(args[i]); err != nil {
+				return errorReply(err)
+			}
+		default:
+			return syntaxErrorReply
+		}
+	}
+	if src == dst {
+		return resp.Error("ERR source and destination objects are the same")
+	}
+
+	d, ok := c.store.lookup(src)
+	if !ok {
+		return resp.Integer(0)
+	}
+	if _, exists := c.store.lookup(dst); exists && !replace {
+		return resp.Integer(0)
+	}
+	c.store.put(dst, d.clone())
+	return resp.Integer(1)
+}
+
+// dumpCommand implements DUMP key, replying with the value serialized in
+// the format RESTORE accepts, or nil if the key does not exist.
+func dumpCommand(c *Client, args []string) resp.Value {
+	d, ok := c.store.lookup(args[1])
+	if !ok {
+		return resp.NullBulk
+	}
+	payload, err := dumpValue(d.value)
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.BulkString(string(payload))
+}
+
+// restoreCommand implements RESTORE key ttl serialized-value [REPLACE]
+// [ABSTTL] [IDLETIME seconds] [FREQ frequency], storing a value
+// serialized by DUMP. The TTL is in milliseconds, 0 for none, and with
+// ABSTTL a Unix time at which the key expires; a key restored already
+// expired is not stored. FREQ is accepted for compatibility, but there
+// are no access frequencies to set.
+func restoreCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	ttl, ok := parseInt(args[2])
+	if !ok || ttl < 0 {
+		return resp.Error("ERR Invalid TTL value, must be >= 0")
+	}
+	var replace, absTTL bool
+	idle, freq := int64(-1), int64(-1)
+	for i := 4; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case opt == "IDLETIME" && i+1 < len(args) && freq < 0:
+			i++
+			if idle, ok = parseInt(args[i]); !ok {
+				return errorReply(errNotInteger)
+			}
+			if idle < 0 {
+				return resp.Error("ERR Invalid IDLETIME value, must be >= 0")
+			}
+		case opt == "FREQ" && i+1 < len(args) && idle < 0:
+			i++
+			if freq, ok = parseInt(args[i]); !ok {
+				return errorReply(errNotInteger)
+			}
+			if freq < 0 || freq > 255 {
+				return resp.Error("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return syntaxErrorReply
+		}
+	}
+	if _, exists := c.store.lookup(key); exists && !replace {
+		return resp.Error("BUSYKEY Target key name already exists.")
+	}
+	v, err := restoreValue([]byte(args[3]))
+	if err != nil {
+		return errorReply(err)
+	}
+
+	now := time.Now()
+	d := StoreData{value: v}
+	switch {
+	case absTTL:
+		if ttl > 0 {
+			d.expiresAt = time.UnixMilli(ttl)
+		}
+	case ttl > 0:
+		d.expiresAt = now.Add(time.Duration(ttl) * time.Millisecond)
+	}
+	empty := false
+	if h, ok := v.(*hashValue); ok {
+		h.purge(now)
+		empty = len(h.fields) == 0
+	}
+	if !d.expiresAt.IsZero() {
+		logged := slices.Clone(args)
+		logged[2] = strconv.FormatInt(d.expiresAt.UnixMilli(), 10)
+		if !absTTL {
+			logged = append(logged, "ABSTTL")
+		}
+		defer c.propagateExpiry(key, logged...)
+	}
+	if empty || d.expired(now) && !c.store.replaying() {
+		c.store.del(key)
+		return resp.OK
+	}
+	c.store.put(key, d)
+	if idle >= 0 {
+		d = c.store.data[key]
+		d.accessedAt = now.Add(-time.Duration(idle) * time.Second)
+		c.store.data[key] = d
+	}
+	c.store.notify(notifyGeneric, "restore", key)
+	return resp.OK
+}
+
+// migrateCommand implements MIGRATE host port key|"" destination-db
+// timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password]
+// [KEYS key [key ...]]. It sends each key that exists to the target, a
+// mini-redis or Redis server, as a RESTORE of its DUMP with the TTL left,
+// and deletes those the target stored unless COPY is given. The timeout,
+// in milliseconds, applies to each network operation.
+func migrateCommand(c *Client, args []string) resp.Value {
+	addr := net.JoinHostPort(args[1], args[2])
+	db, ok := parseInt(args[4])
+	if !ok {
+		return notIntegerReply
+	}
+	timeout, ok := parseInt(args[5])
+	if !ok {
+		return notIntegerReply
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	var copyKeys, replace bool
+	var auth []string
+	keys := []string{args[3]}
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COPY":
+			copyKeys = true
+		case opt == "REPLACE":
+			replace = true
+		case opt == "AUTH" && i+1 < len(args):
+			auth = []string{"AUTH", args[i+1]}
+			i++
+		case opt == "AUTH2" && i+2 < len(args):
+			auth = []string{"AUTH", args[i+1], args[i+2]}
+			i += 2
+		case opt == "KEYS":
+			if args[3] != "" {
+				return resp.Error("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return syntaxErrorReply
+		}
+	}
+
+	// The requests are pipelined: AUTH and SELECT, if needed, then one
+	// RESTORE per key, each reply read in turn afterwards.
+	var requests [][]string
+	if auth != nil {
+		requests = append(requests, auth)
+	}
+	if db != 0 {
+		requests = append(requests, []string{"SELECT", args[4]})
+	}
+	setup := len(requests)
+	var sent []string
+	for _, key := range keys {
+		d, ok := c.store.lookup(key)
+		if !ok {
+			continue
+		}
+		payload, err := dumpValue(d.value)
+		if err != nil {
+			return errorReply(err)
+		}
+		var ttl int64
+		if !d.expiresAt.IsZero() {
+			// A TTL of 0 would mean none, so one about to expire is sent
+			// as 1.
+			ttl = max(time.Until(d.expiresAt).Milliseconds(), 1)
+		}
+		restore := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		requests = append(requests, restore)
+		sent = append(sent, key)
+	}
+	if len(sent) == 0 {
+		return resp.SimpleString("NOKEY")
+	}
+
+	deadline := time.Duration(timeout) * time.Millisecond
+	conn, err := net.DialTimeout("tcp", addr, deadline)
+	if err != nil {
+		return resp.Error("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+	w := resp.NewWriter(conn)
+	conn.SetWriteDeadline(time.Now().Add(deadline))
+	for _, req := range requests {
+		w.WriteCommand(req...)
+	}
+	if err := w.Flush(); err != nil {
+		return resp.Error("IOERR error or timeout writing to target instance")
+	}
+
+	r := resp.NewReader(conn)
+	var failure string
+	var moved []string
+	for i := range requests {
+		conn.SetReadDeadline(time.Now().Add(deadline))
+		reply, err := r.ReadValue()
+		if err != nil {
+			failure = "IOERR error or timeout reading to target instance"
+			break
+		}
+		if reply.IsError() {
+			if failure == "" {
+				failure = "ERR Target instance replied with error: " + strings.TrimPrefix(reply.Str, "ERR ")
+			}
+			if i < setup {
+				// After a failed AUTH or SELECT, the RESTOREs did not go
+				// where they should, so none counts as done.
+				break
+			}
+			continue
+		}
+		if i >= setup {
+			moved = append(moved, sent[i-setup])
+		}
+	}
+
+	if !copyKeys && len(moved) > 0 {
+		for _, key := range moved {
+			c.store.del(key)
+		}
+		c.propagateAs(append([]string{"DEL"}, moved...))
+	}
+	if failure != "" {
+		return resp.Error(failure)
+	}
+	return resp.OK
+}
+
+// checkDBIndex validates a database number given to a command. There is a
+// single database, so only 0 is accepted.
+func checkDBIndex(arg string) error {
+	n, ok := parseInt(arg)
+	if !ok {
+		return errNotInteger
+	}
+	if n != 0 {
+		return errors.New("ERR DB index is out of range")
+	}
+	return nil
+}
+
+// scanOptions holds the options shared by the SCAN family of commands.
+type scanOptions struct {
+	match   string
+	count   int
+	typ     string
+	hasType bool
+}
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+// parseScanCursor parses a SCAN cursor, which is an unsigned 64-bit integer.
+func parseScanCursor(arg string) (uint64, error) {
+	cursor, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] and, if allowType is
+// set, [TYPE type].
+func parseScanOptions(args []string, allowType bool) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			opts.match = args[i+1]
+		case "COUNT":
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return opts, errNotInteger
+			}
+			if n < 1 {
+				return opts, errSyntax
+			}
+			opts.count = int(min(n, 1<<30))
+		case "TYPE":
+			if !allowType {
+				return opts, errSyntax
+			}
+			opts.typ = strings.ToLower(args[i+1])
+			opts.hasType = true
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+// matches reports whether an element passes the MATCH filter.
+func (o scanOptions) matches(s string) bool {
+	return o.match == "" || o.match == "*" || matchPattern(o.match, s, false)
+}
+
+// scanCommand implements SCAN cursor [MATCH pattern] [COUNT count]
+// [TYPE type]. COUNT is a hint: whole slots are returned at a time, so a
+// reply may hold more or fewer keys than asked for.
+func scanCommand(c *Client, args []string) resp.Value {
+	cursor, err := parseScanCursor(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	opts, err := parseScanOptions(args[2:], true)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	keys, next := c.store.scan(cursor, opts.count)
+	found := []string{}
+	for _, key := range keys {
+		if !opts.matches(key) {
+			continue
+		}
+		if opts.hasType && c.store.data[key].typeName() != opts.typ {
+			continue
+		}
+		found = append(found, key)
+	}
+	return resp.Array(resp.BulkString(strconv.FormatUint(next, 10)), resp.BulkStrings(found))
+}
+
+// randomkeyCommand implements RANDOMKEY.
+func randomkeyCommand(c *Client, args []string) resp.Value {
+	key, ok := c.store.randomKey()
+	if !ok {
+		return resp.NullBulk
+	}
+	return resp.BulkString(key)
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestExistsCountsEveryMention(t *testing.T) {
+	c := newTestClient()
+	c.store.Set("a", "1")
+	c.store.Set("b", "2")
+	c.store.Set("gone", "3")
+	c.store.setExpire("gone", time.Now().Add(-time.Second))
+	c.store.data["stale"] = StoreData{value: []byte("4"), expiresAt: time.Now().Add(-time.Second)}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXISTS", "a"}, resp.Integer(1)},
+		{[]string{"EXISTS", "a", "b", "missing"}, resp.Integer(2)},
+		{[]string{"EXISTS", "a", "a", "a"}, resp.Integer(3)},
+		{[]string{"EXISTS", "gone", "stale"}, resp.Integer(0)},
+		{[]string{"EXISTS"}, wrongArityReply("exists")},
+	})
+}
+
+func TestKeysMatchesPattern(t *testing.T) {
+	c := newTestClient()
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1"} {
+		c.store.Set(key, "x")
+	}
+	c.store.data["user:old"] = StoreData{value: []byte("x"), expiresAt: time.Now().Add(-time.Second)}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"order:1", "user:1", "user:10", "user:2"}},
+		{"user:?", []string{"user:1", "user:2"}},
+		{"user:*", []string{"user:1", "user:10", "user:2"}},
+		{"*:1", []string{"order:1", "user:1"}},
+		{"nothing*", []string{}},
+	}
+	for _, tt := range tests {
+		reply := do(c, "KEYS", tt.pattern)
+		got := []string{}
+		for _, v := range reply.Array {
+			got = append(got, v.Str)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("KEYS %s = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestScanVisitsEveryKeyOnce(t *testing.T) {
+	c := newTestClient()
+	want := map[string]bool{}
+	for i := 0; i < 500; i++ {
+		key := "key:" + strconv.Itoa(i)
+		c.store.Set(key, "x")
+		want[key] = true
+	}
+
+	seen := map[string]int{}
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > numSlots {
+			t.Fatal("SCAN did not terminate")
+		}
+		reply := do(c, "SCAN", cursor, "COUNT", "20")
+		if len(reply.Array) != 2 {
+			t.Fatalf("SCAN reply = %v", reply)
+		}
+		for _, v := range reply.Array[1].Array {
+			seen[v.Str]++
+		}
+		cursor = reply.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("SCAN returned %d distinct keys, want %d", len(seen), len(want))
+	}
+	for key, n := range seen {
+		if !want[key] || n != 1 {
+			t.Errorf("key %q seen %d times", key, n)
+		}
+	}
+}
+
+func TestScanOptions(t *testing.T) {
+	c := newTestClient()
+	c.store.Set("user:1", "x")
+	c.store.Set("user:2", "x")
+	c.store.Set("order:1", "x")
+
+	collect := func(args ...string) []string {
+		reply := do(c, append([]string{"SCAN", "0"}, args...)...)
+		if reply.Array[0].Str != "0" {
+			t.Fatalf("SCAN with a huge COUNT returned cursor %q", reply.Array[0].Str)
+		}
+		var keys []string
+		for _, v := range reply.Array[1].Array {
+			keys = append(keys, v.Str)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if got := collect("MATCH", "user:*", "COUNT", "1000"); !reflect.DeepEqual(got, []string{"user:1", "user:2"}) {
+		t.Errorf("SCAN MATCH = %q", got)
+	}
+	if got := collect("TYPE", "STRING", "COUNT", "1000"); len(got) != 3 {
+		t.Errorf("SCAN TYPE string = %q", got)
+	}
+	if got := collect("TYPE", "list", "COUNT", "1000"); len(got) != 0 {
+		t.Errorf("SCAN TYPE list = %q", got)
+	}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SCAN", "abc"}, resp.Error("ERR invalid cursor")},
+		{[]string{"SCAN", "-1"}, resp.Error("ERR invalid cursor")},
+		{[]string{"SCAN", "0", "COUNT", "0"}, syntaxErrorReply},
+		{[]string{"SCAN", "0", "COUNT", "x"}, notIntegerReply},
+		{[]string{"SCAN", "0", "MATCH"}, syntaxErrorReply},
+		{[]string{"SCAN", "0", "BOGUS", "1"}, syntaxErrorReply},
+		{[]string{"SCAN", "99999"}, resp.Array(resp.BulkString("0"), resp.Array())},
+	})
+}
+
+func TestRandomKey(t *testing.T) {
+	c := newTestClient()
+	if reply := do(c, "RANDOMKEY"); !reply.IsNull() {
+		t.Fatalf("RANDOMKEY on an empty store = %v, want nil", reply)
+	}
+
+	c.store.Set("a", "1")
+	c.store.Set("b", "2")
+	c.store.Set("c", "3")
+	c.store.Del("b")
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		seen[do(c, "RANDOMKEY").Str] = true
+	}
+	if !reflect.DeepEqual(seen, map[string]bool{"a": true, "c": true}) {
+		t.Errorf("RANDOMKEY returned %v, want a and c", seen)
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := "dead:" + strconv.Itoa(i)
+		c.store.Set(key, "x")
+		c.store.mu.Lock()
+		c.store.put(key, StoreData{value: []byte("x"), expiresAt: time.Now().Add(-time.Second)})
+		c.store.mu.Unlock()
+	}
+	c.store.Del("c")
+	for i := 0; i < 20; i++ {
+		if got := do(c, "RANDOMKEY"); got.Str != "a" {
+			t.Fatalf("RANDOMKEY = %v, want a among expired keys", got)
+		}
+	}
+}
+
+func TestRenameMovesValueAndTTL(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "a", "1", "EX", "100"}, resp.OK},
+		{[]string{"SET", "b", "2"}, resp.OK},
+		{[]string{"RENAME", "a", "b"}, resp.OK},
+		{[]string{"EXISTS", "a"}, resp.Integer(0)},
+		{[]string{"GET", "b"}, resp.BulkString("1")},
+		{[]string{"TTL", "b"}, resp.Integer(100)},
+		{[]string{"RENAME", "b", "b"}, resp.OK},
+		{[]string{"GET", "b"}, resp.BulkString("1")},
+		{[]string{"RENAME", "missing", "x"}, resp.Error("ERR no such key")},
+		{[]string{"SET", "c", "3"}, resp.OK},
+		{[]string{"RENAMENX", "b", "c"}, resp.Integer(0)},
+		{[]string{"GET", "c"}, resp.BulkString("3")},
+		{[]string{"RENAMENX", "b", "d"}, resp.Integer(1)},
+		{[]string{"GET", "d"}, resp.BulkString("1")},
+		{[]string{"TTL", "d"}, resp.Integer(100)},
+		{[]string{"RENAMENX", "missing", "x"}, resp.Error("ERR no such key")},
+	})
+}
+
+func TestType(t *testing.T) {
+	c := newTestClient()
+	c.store.Set("s", "x")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"TYPE", "s"}, resp.SimpleString("string")},
+		{[]string{"TYPE", "missing"}, resp.SimpleString("none")},
+	})
+}
+
+func TestCopyIsIndependentOfSource(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "src", "hello", "EX", "100"}, resp.OK},
+		{[]string{"COPY", "src", "dst"}, resp.Integer(1)},
+		{[]string{"TTL", "dst"}, resp.Integer(100)},
+		{[]string{"APPEND", "dst", " world"}, resp.Integer(11)},
+		{[]string{"GET", "src"}, resp.BulkString("hello")},
+		{[]string{"COPY", "src", "dst"}, resp.Integer(0)},
+		{[]string{"GET", "dst"}, resp.BulkString("hello world")},
+		{[]string{"COPY", "src", "dst", "REPLACE"}, resp.Integer(1)},
+		{[]string{"GET", "dst"}, resp.BulkString("hello")},
+		{[]string{"COPY", "missing", "dst", "REPLACE"}, resp.Integer(0)},
+		{[]string{"COPY", "src", "other", "DB", "0"}, resp.Integer(1)},
+		{[]string{"COPY", "src", "other", "DB", "1"}, resp.Error("ERR DB index is out of range")},
+		{[]string{"COPY", "src", "other", "DB"}, syntaxErrorReply},
+		{[]string{"COPY", "src", "other", "BOGUS"}, syntaxErrorReply},
+		{[]string{"COPY", "src", "src"}, resp.Error("ERR source and destination objects are the same")},
+	})
+}
+
+func TestUnlinkAndTouch(t *testing.T) {
+	c := newTestClient()
+	c.store.Set("a", "1")
+	c.store.Set("b", "2")
+	c.store.Set("c", "3")
+	c.store.mu.Lock()
+	c.store.data["c"] = StoreData{value: []byte("3"), accessedAt: time.Unix(0, 0)}
+	c.store.mu.Unlock()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"TOUCH", "a", "c", "missing"}, resp.Integer(2)},
+		{[]string{"UNLINK", "a", "b", "missing", "a"}, resp.Integer(2)},
+		{[]string{"EXISTS", "a", "b"}, resp.Integer(0)},
+		{[]string{"GET", "c"}, resp.BulkString("3")},
+	})
+	lazyfree.wait()
+
+	if at := c.store.data["c"].accessedAt; time.Since(at) > time.Minute {
+		t.Errorf("TOUCH left accessedAt at %v", at)
+	}
+
+	// DEL takes several keys too.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "a", "1"}, resp.OK},
+		{[]string{"DEL", "a", "c", "missing", "a"}, resp.Integer(2)},
+		{[]string{"EXISTS", "a", "c"}, resp.Integer(0)},
+	})
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	c := newTestClient()
+	for _, args := range [][]string{
+		{"SET", "str", "hello"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"SADD", "set", "x", "y"},
+		{"HSET", "hash", "f", "1", "g", "2"},
+		{"HPEXPIRE", "hash", "100000", "FIELDS", "1", "g"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "STREAMS", "stream", ">"},
+	} {
+		if reply := do(c, args...); reply.IsError() {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	reads := map[string][]string{
+		"str":    {"GET", "str"},
+		"list":   {"LRANGE", "list", "0", "-1"},
+		"set":    {"SMISMEMBER", "set", "x", "y", "z"},
+		"hash":   {"HMGET", "hash", "f", "g"},
+		"zset":   {"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		"stream": {"XRANGE", "stream", "-", "+"},
+	}
+	for key, read := range reads {
+		payload := do(c, "DUMP", key)
+		if got := do(c, "RESTORE", key+"-copy", "0", payload.Str); !reflect.DeepEqual(got, resp.OK) {
+			t.Errorf("RESTORE of %s = %+v", key, got)
+			continue
+		}
+		copied := append([]string{read[0], key + "-copy"}, read[2:]...)
+		if want, got := do(c, read...), do(c, copied...); !reflect.DeepEqual(got, want) {
+			t.Errorf("restored %s: %q = %+v, want %+v", key, copied, got, want)
+		}
+		if want, got := internals(c.store.data[key]), internals(c.store.data[key+"-copy"]); got != want {
+			t.Errorf("restored %s has %q, want %q", key, got, want)
+		}
+	}
+
+	// A string's payload is its RDB encoding, the RDB version and a
+	// CRC-64/Jones checksum.
+	body := "\x00\x05hello\x0b\x00"
+	want := body + string(binary.LittleEndian.AppendUint64(nil, crc64Jones(0, []byte(body))))
+	payload := do(c, "DUMP", "str").Str
+	if payload != want {
+		t.Errorf("DUMP str = %q, want %q", payload, want)
+	}
+	if got := crc64Jones(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("crc64Jones(123456789) = %x", got)
+	}
+
+	badChecksum := payload[:len(payload)-1] + "\x00"
+	newer := "\x00\x05hello\x63\x00"
+	newer += string(binary.LittleEndian.AppendUint64(nil, crc64Jones(0, []byte(newer))))
+	badData := "\x00\x09hello\x0b\x00"
+	badData += string(binary.LittleEndian.AppendUint64(nil, crc64Jones(0, []byte(badData))))
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"DUMP", "missing"}, resp.NullBulk},
+		{[]string{"RESTORE", "str", "0", payload}, resp.Error("BUSYKEY Target key name already exists.")},
+		{[]string{"RESTORE", "str", "0", payload, "REPLACE"}, resp.OK},
+		{[]string{"RESTORE", "k", "0", badChecksum}, resp.Error("ERR DUMP payload version or checksum are wrong")},
+		{[]string{"RESTORE", "k", "0", newer}, resp.Error("ERR DUMP payload version or checksum are wrong")},
+		{[]string{"RESTORE", "k", "0", badData}, resp.Error("ERR Bad data format")},
+		{[]string{"RESTORE", "k", "0", "x"}, resp.Error("ERR DUMP payload version or checksum are wrong")},
+		{[]string{"RESTORE", "k", "-1", payload}, resp.Error("ERR Invalid TTL value, must be >= 0")},
+		{[]string{"RESTORE", "k", "0", payload, "IDLETIME", "-1"}, resp.Error("ERR Invalid IDLETIME value, must be >= 0")},
+		{[]string{"RESTORE", "k", "0", payload, "FREQ", "256"}, resp.Error("ERR Invalid FREQ value, must be >= 0 and <= 255")},
+		{[]string{"RESTORE", "k", "0", payload, "IDLETIME", "1", "FREQ", "1"}, syntaxErrorReply},
+		{[]string{"RESTORE", "k", "0", payload, "NOW"}, syntaxErrorReply},
+		{[]string{"RESTORE", "ttl", "100000", payload}, resp.OK},
+		{[]string{"RESTORE", "abs", "4102444800000", payload, "ABSTTL"}, resp.OK},
+		{[]string{"PEXPIRETIME", "abs"}, resp.Integer(4102444800000)},
+		// A key restored with a deadline already past is not stored, and
+		// with REPLACE the one it replaces is deleted.
+		{[]string{"RESTORE", "str", past, payload, "ABSTTL", "REPLACE"}, resp.OK},
+		{[]string{"EXISTS", "str"}, resp.Integer(0)},
+		{[]string{"RESTORE", "idle", "0", payload, "IDLETIME", "1000"}, resp.OK},
+	})
+	if pttl := do(c, "PTTL", "ttl").Int; pttl <= 0 || pttl > 100000 {
+		t.Errorf("PTTL of a key restored with a TTL = %d", pttl)
+	}
+	if idle := time.Since(c.store.data["idle"].accessedAt); idle < 1000*time.Second {
+		t.Errorf("key restored with IDLETIME 1000 idle for %v", idle)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	target := NewServer(defaultConfig())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go target.Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	tc := newClient(nil, target)
+
+	c := newClient(nil, newAppendOnlyServer(t))
+	do(c, "SET", "a", "1")
+	do(c, "SET", "b", "2", "PX", "100000")
+	do(c, "RPUSH", "l", "x", "y")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MIGRATE", host, port, "a", "0", "1000"}, resp.OK},
+		{[]string{"EXISTS", "a"}, resp.Integer(0)},
+		{[]string{"MIGRATE", host, port, "", "0", "1000", "COPY", "KEYS", "b", "l", "missing"}, resp.OK},
+		{[]string{"EXISTS", "b", "l"}, resp.Integer(2)},
+		{[]string{"MIGRATE", host, port, "", "0", "1000", "KEYS", "b"}, resp.Error("ERR Target instance replied with error: BUSYKEY Target key name already exists.")},
+		{[]string{"EXISTS", "b"}, resp.Integer(1)},
+		{[]string{"MIGRATE", host, port, "", "0", "1000", "REPLACE", "KEYS", "b", "l"}, resp.OK},
+		{[]string{"EXISTS", "b", "l"}, resp.Integer(0)},
+		{[]string{"MIGRATE", host, port, "missing", "0", "1000"}, resp.SimpleString("NOKEY")},
+		{[]string{"MIGRATE", host, port, "k", "0", "1000", "KEYS", "a"}, resp.Error("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")},
+		{[]string{"MIGRATE", host, port, "k", "0", "1000", "NOW"}, syntaxErrorReply},
+	})
+	expectReply(t, tc, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "a"}, resp.BulkString("1")},
+		{[]string{"GET", "b"}, resp.BulkString("2")},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.Array(resp.BulkString("x"), resp.BulkString("y"))},
+		{[]string{"PTTL", "a"}, resp.Integer(-1)},
+	})
+	if pttl := do(tc, "PTTL", "b").Int; pttl <= 0 || pttl > 100000 {
+		t.Errorf("PTTL of a migrated key = %d", pttl)
+	}
+
+	// The keys moved are logged as deleted, several in one DEL, and stay
+	// deleted once the file is replayed.
+	var dels [][]string
+	for _, args := range appendOnlyCommands(t, c.srv) {
+		if args[0] == "DEL" {
+			dels = append(dels, args)
+		}
+	}
+	if want := [][]string{{"DEL", "a"}, {"DEL", "b", "l"}}; !reflect.DeepEqual(dels, want) {
+		t.Errorf("logged %q, want %q", dels, want)
+	}
+	loaded := newClient(nil, NewServer(c.srv.cfg))
+	if err := loaded.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	if got := do(loaded, "EXISTS", "a", "b", "l"); got.Int != 0 {
+		t.Errorf("after loading, %d of the migrated keys exist", got.Int)
+	}
+
+	// A key is kept when the target cannot take it.
+	do(c, "SET", "k", "v")
+	if got := do(c, "MIGRATE", host, port, "k", "1", "1000"); !got.IsError() || !strings.HasPrefix(got.Str, "ERR Target instance replied with error: ") {
+		t.Errorf("MIGRATE to another DB = %+v, want the target's error", got)
+	}
+	ln.Close()
+	if got := do(c, "MIGRATE", host, port, "k", "0", "100"); !reflect.DeepEqual(got, resp.Error("IOERR error or timeout connecting to the client")) {
+		t.Errorf("MIGRATE to a closed port = %+v", got)
+	}
+	if got := do(c, "EXISTS", "k"); got.Int != 1 {
+		t.Error("a key that failed to migrate was deleted")
+	}
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "lpush", Arity: -3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: lpushCommand})
+	RegisterCommand(&Command{Name: "rpush", Arity: -3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: rpushCommand})
+	RegisterCommand(&Command{Name: "lpop", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: lpopCommand})
+	RegisterCommand(&Command{Name: "rpop", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: rpopCommand})
+	RegisterCommand(&Command{Name: "lrange", Arity: 4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: lrangeCommand})
+	RegisterCommand(&Command{Name: "llen", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: llenCommand})
+	RegisterCommand(&Command{Name: "lindex", Arity: 3, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: lindexCommand})
+	RegisterCommand(&Command{Name: "lset", Arity: 4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: lsetCommand})
+	RegisterCommand(&Command{Name: "linsert", Arity: 5, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: linsertCommand})
+	RegisterCommand(&Command{Name: "lrem", Arity: 4, Flags: flagWrite, FirstKey: 1, LastKey: 1, Step: 1, Handler: lremCommand})
+	RegisterCommand(&Command{Name: "ltrim", Arity: 4, Flags: flagWrite, FirstKey: 1, LastKey: 1, Step: 1, Handler: ltrimCommand})
+	RegisterCommand(&Command{Name: "lmove", Arity: 5, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 2, Step: 1, Handler: lmoveCommand})
+	RegisterCommand(&Command{Name: "rpoplpush", Arity: 3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 2, Step: 1, Handler: rpoplpushCommand})
+	RegisterCommand(&Command{Name: "blpop", Arity: -3, Flags: flagWrite | flagBlocking, FirstKey: 1, LastKey: -2, Step: 1, Handler: blpopCommand})
+	RegisterCommand(&Command{Name: "brpop", Arity: -3, Flags: flagWrite | flagBlocking, FirstKey: 1, LastKey: -2, Step: 1, Handler: brpopCommand})
+	RegisterCommand(&Command{Name: "blmove", Arity: 6, Flags: flagWrite | flagDenyOOM | flagBlocking, FirstKey: 1, LastKey: 2, Step: 1, Handler: blmoveCommand})
+	RegisterCommand(&Command{Name: "brpoplpush", Arity: 4, Flags: flagWrite | flagDenyOOM | flagBlocking, FirstKey: 1, LastKey: 2, Step: 1, Handler: brpoplpushCommand})
+	RegisterCommand(&Command{Name: "lmpop", Arity: -4, Flags: flagWrite, Handler: lmpopCommand})
+	RegisterCommand(&Command{Name: "blmpop", Arity: -5, Flags: flagWrite | flagBlocking, Handler: blmpopCommand})
+	RegisterCommand(&Command{Name: "lpos", Arity: -3, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: lposCommand})
+}
+
+// lpushCommand implements LPUSH key element [element ...].
+func lpushCommand(c *Client, args []string) resp.Value {
+	return pushCommand(c, args, true)
+}
+
+// rpushCommand implements RPUSH key element [element ...].
+func rpushCommand(c *Client, args []string) resp.Value {
+	return pushCommand(c, args, false)
+}
+
+// pushCommand adds the elements one at a time to the head or tail of the
+// list, creating it if needed, and replies with the new length.
+func pushCommand(c *Client, args []string, head bool) resp.Value {
+	key := args[1]
+	l, err := c.store.lookupList(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		l = &listValue{}
+		c.store.put(key, StoreData{value: l})
+	}
+	for _, elem := range args[2:] {
+		if head {
+			l.PushFront(elem)
+		} else {
+			l.PushBack(elem)
+		}
+	}
+	c.store.signalModified(key)
+	return resp.Integer(int64(l.Len()))
+}
+
+// lpopCommand implements LPOP key [count].
+func lpopCommand(c *Client, args []string) resp.Value {
+	return popCommand(c, args, true)
+}
+
+// rpopCommand implements RPOP key [count].
+func rpopCommand(c *Client, args []string) resp.Value {
+	return popCommand(c, args, false)
+}
+
+// popCommand removes elements from the head or tail of a list. Without a
+// count it replies with a single element; with one, with an array of up to
+// count elements. A list left empty is deleted.
+func popCommand(c *Client, args []string, head bool) resp.Value {
+	if len(args) > 3 {
+		return syntaxErrorReply
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		var ok bool
+		if count, ok = parseInt(args[2]); !ok || count < 0 {
+			return errorReply(errNotPositive)
+		}
+	}
+
+	key := args[1]
+	l, err := c.store.lookupList(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		if len(args) == 3 {
+			return resp.NullArray
+		}
+		return resp.NullBulk
+	}
+
+	popped := popList(c, key, l, count, head)
+	if len(args) == 2 {
+		return resp.BulkString(popped[0])
+	}
+	return resp.BulkStrings(popped)
+}
+
+// popList removes up to count elements from the head or tail of the list
+// l at key and returns them, deleting the key if the list is left empty.
+func popList(c *Client, key string, l *listValue, count int64, head bool) []string {
+	popped := make([]string, min(count, int64(l.Len())))
+	for i := range popped {
+		if head {
+			popped[i] = l.PopFront()
+		} else {
+			popped[i] = l.PopBack()
+		}
+	}
+	if l.Len() == 0 {
+		c.store.remove(key)
+	} else if len(popped) > 0 {
+		c.store.signalModified(key)
+	}
+	return popped
+}
+
+// blpopCommand implements BLPOP key [key ...] timeout.
+func blpopCommand(c *Client, args []string) resp.Value {
+	return blockingPopCommand(c, args, true)
+}
+
+// brpopCommand implements BRPOP key [key ...] timeout.
+func brpopCommand(c *Client, args []string) resp.Value {
+	return blockingPopCommand(c, args, false)
+}
+
+// blockingPopCommand pops an element from the head or tail of the first
+// non-empty list among the keys and replies with [key, element]. If they
+// are all empty it blocks until one is pushed to, or replies nil once
+// timeout seconds have passed; 0 waits forever.
+func blockingPopCommand(c *Client, args []string, head bool) resp.Value {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return errorReply(err)
+	}
+	keys := args[1 : len(args)-1]
+	for _, key := range keys {
+		l, err := c.store.lookupList(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if l == nil {
+			continue
+		}
+		elem := popList(c, key, l, 1, head)[0]
+		return resp.Array(resp.BulkString(key), resp.BulkString(elem))
+	}
+	return c.block(args, keys, timeout, resp.NullArray)
+}
+
+// lmpopCommand implements LMPOP numkeys key [key ...] LEFT|RIGHT
+// [COUNT count]. It pops up to count elements from the first non-empty
+// list and replies with [key, [element ...]], or nil if every list is
+// empty.
+func lmpopCommand(c *Client, args []string) resp.Value {
+	keys, where, count, err := parseMpopArgs(args[1:], "LEFT", "RIGHT")
+	if err != nil {
+		return errorReply(err)
+	}
+	reply, ok, err := mpopList(c, keys, where == "LEFT", count)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.NullArray
+	}
+	return reply
+}
+
+// blmpopCommand implements BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+// [COUNT count]: like LMPOP, but blocking like BLPOP while every list is
+// empty.
+func blmpopCommand(c *Client, args []string) resp.Value {
+	timeout, err := parseTimeout(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	keys, where, count, err := parseMpopArgs(args[2:], "LEFT", "RIGHT")
+	if err != nil {
+		return errorReply(err)
+	}
+	reply, ok, err := mpopList(c, keys, where == "LEFT", count)
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return c.block(args, keys, timeout, resp.NullArray)
+	}
+	return reply
+}
+
+// mpopList pops up to count elements from the head or tail of the first
+// non-empty list among keys, replying with [key, [element ...]]. ok is
+// false if they are all empty.
+func mpopList(c *Client, keys []string, head bool, count int64) (reply resp.Value, ok bool, err error) {
+	for _, key := range keys {
+		l, err := c.store.lookupList(key)
+		if err != nil {
+			return resp.Value{}, false, err
+		}
+		if l != nil {
+			popped := popList(c, key, l, count, head)
+			return resp.Array(resp.BulkString(key), resp.BulkStrings(popped)), true, nil
+		}
+	}
+	return resp.Value{}, false, nil
+}
+
+// lrangeCommand implements LRANGE key start stop.
+func lrangeCommand(c *Client, args []string) resp.Value {
+	start, ok1 := parseInt(args[2])
+	end, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.Array()
+	}
+	lo, hi := normalizeRange(start, end, l.Len())
+	return resp.BulkStrings(l.Range(lo, hi))
+}
+
+// llenCommand implements LLEN key.
+func llenCommand(c *Client, args []string) resp.Value {
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.Integer(0)
+	}
+	return resp.Integer(int64(l.Len()))
+}
+
+// listIndex resolves a possibly negative list index against a list of n
+// elements, reporting false if it falls outside it.
+func listIndex(index int64, n int) (int, bool) {
+	if index < 0 {
+		index += int64(n)
+	}
+	if index < 0 || index >= int64(n) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+// lindexCommand implements LINDEX key index.
+func lindexCommand(c *Client, args []string) resp.Value {
+	index, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.NullBulk
+	}
+	i, ok := listIndex(index, l.Len())
+	if !ok {
+		return resp.NullBulk
+	}
+	return resp.BulkString(l.At(i))
+}
+
+// lsetCommand implements LSET key index element.
+func lsetCommand(c *Client, args []string) resp.Value {
+	index, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return noSuchKeyReply
+	}
+	i, ok := listIndex(index, l.Len())
+	if !ok {
+		return resp.Error("ERR index out of range")
+	}
+	l.Set(i, args[3])
+	c.store.signalModified(args[1])
+	return resp.OK
+}
+
+// linsertCommand implements LINSERT key BEFORE | AFTER pivot element. It
+// replies with the new length, -1 if pivot was not found and 0 if the key
+// does not exist.
+func linsertCommand(c *Client, args []string) resp.Value {
+	var after bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return syntaxErrorReply
+	}
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.Integer(0)
+	}
+	pivot := args[3]
+	for i := 0; i < l.Len(); i++ {
+		if l.At(i) != pivot {
+			continue
+		}
+		if after {
+			i++
+		}
+		l.Insert(i, args[4])
+		c.store.signalModified(args[1])
+		return resp.Integer(int64(l.Len()))
+	}
+	return resp.Integer(-1)
+}
+
+// lremCommand implements LREM key count element. A positive count removes
+// that many matches from the head, a negative one from the tail and 0
+// removes them all.
+func lremCommand(c *Client, args []string) resp.Value {
+	count, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	key, elem := args[1], args[3]
+	l, err := c.store.lookupList(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.Integer(0)
+	}
+
+	// Removing from the tail is the same as removing every match at or
+	// after the position of the count-th match counted from the end.
+	from := 0
+	if count < 0 {
+		seen := int64(0)
+		for i := l.Len() - 1; i >= 0 && seen > count; i-- {
+			if l.At(i) == elem {
+				seen--
+				from = i
+			}
+		}
+		count = 0
+	}
+	removed := int64(0)
+	l.Filter(func(i int, s string) bool {
+		if i < from || s != elem || (count > 0 && removed == count) {
+			return true
+		}
+		removed++
+		return false
+	})
+	if l.Len() == 0 {
+		c.store.remove(key)
+	} else if removed > 0 {
+		c.store.signalModified(key)
+	}
+	return resp.Integer(removed)
+}
+
+// ltrimCommand implements LTRIM key start stop, keeping only the elements
+// in the inclusive range. A list trimmed to nothing is deleted.
+func ltrimCommand(c *Client, args []string) resp.Value {
+	start, ok1 := parseInt(args[2])
+	end, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+	key := args[1]
+	l, err := c.store.lookupList(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return resp.OK
+	}
+	lo, hi := normalizeRange(start, end, l.Len())
+	for l.Len() > hi {
+		l.PopBack()
+	}
+	for i := 0; i < lo; i++ {
+		l.PopFront()
+	}
+	if l.Len() == 0 {
+		c.store.remove(key)
+	} else {
+		c.store.signalModified(key)
+	}
+	return resp.OK
+}
+
+// parseListSide parses the LEFT | RIGHT argument of LMOVE and friends,
+// reporting whether it names the head.
+func parseListSide(arg string) (left bool, err error) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, nil
+	case "RIGHT":
+		return false, nil
+	}
+	return false, errSyntax
+}
+
+// lmoveCommand implements LMOVE source destination LEFT | RIGHT
+// LEFT | RIGHT.
+func lmoveCommand(c *Client, args []string) resp.Value {
+	from, err := parseListSide(args[3])
+	if err != nil {
+		return errorReply(err)
+	}
+	to, err := parseListSide(args[4])
+	if err != nil {
+		return errorReply(err)
+	}
+	return listMove(c, args[1], args[2], from, to)
+}
+
+// rpoplpushCommand implements RPOPLPUSH source destination, the same as
+// LMOVE source destination RIGHT LEFT.
+func rpoplpushCommand(c *Client, args []string) resp.Value {
+	return listMove(c, args[1], args[2], false, true)
+}
+
+// blmoveCommand implements BLMOVE source destination LEFT | RIGHT
+// LEFT | RIGHT timeout, which is LMOVE waiting up to timeout seconds for
+// source to exist.
+func blmoveCommand(c *Client, args []string) resp.Value {
+	from, err := parseListSide(args[3])
+	if err != nil {
+		return errorReply(err)
+	}
+	to, err := parseListSide(args[4])
+	if err != nil {
+		return errorReply(err)
+	}
+	return blockingListMove(c, args, args[5], from, to)
+}
+
+// brpoplpushCommand implements BRPOPLPUSH source destination timeout, the
+// same as BLMOVE source destination RIGHT LEFT timeout.
+func brpoplpushCommand(c *Client, args []string) resp.Value {
+	return blockingListMove(c, args, args[3], false, true)
+}
+
+func blockingListMove(c *Client, args []string, timeoutArg string, fromLeft, toLeft bool) resp.Value {
+	timeout, err := parseTimeout(timeoutArg)
+	if err != nil {
+		return errorReply(err)
+	}
+	src := args[1]
+	l, err := c.store.lookupList(src)
+	if err != nil {
+		return errorReply(err)
+	}
+	if l == nil {
+		return c.block(args, []string{src}, timeout, resp.NullBulk)
+	}
+	return listMove(c, src, args[2], fromLeft, toLeft)
+}
+
+// listMove atomically pops an element from one end of src and pushes it
+// onto one end of dst, which may be the same list. It replies with the
+// element, or nil if src does not exist. Nothing changes if either key
+// holds another type.
+func listMove(c *Client, src, dst string, fromLeft, toLeft bool) resp.Value {
+	sl, err := c.store.lookupList(src)
+	if err != nil {
+		return errorReply(err)
+	}
+	if sl == nil {
+		return resp.NullBulk
+	}
+	dl, err := c.store.lookupList(dst)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var elem string
+	if fromLeft {
+		elem = sl.PopFront()
+	} else {
+		elem = sl.PopBack()
+	}
+	if sl.Len() == 0 && src != dst {
+		c.store.remove(src)
+	} else {
+		c.store.signalModified(src)
+	}
+	if dl == nil {
+		dl = &listValue{}
+		c.store.put(dst, StoreData{value: dl})
+	}
+	if toLeft {
+		dl.PushFront(elem)
+	} else {
+		dl.PushBack(elem)
+	}
+	c.store.signalModified(dst)
+	return resp.BulkString(elem)
+}
+
+// lposCommand implements LPOS key element [RANK rank] [COUNT num-matches]
+// [MAXLEN len]. RANK picks which match to start from, negative values
+// searching from the tail; COUNT asks for an array of up to that many
+// positions, 0 meaning all; MAXLEN limits how many elements are compared.
+func lposCommand(c *Client, args []string) resp.Value {
+	rank, count, maxlen := int64(1), int64(-1), int64(0)
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return syntaxErrorReply
+		}
+		n, ok := parseInt(args[i+1])
+		if !ok {
+			return notIntegerReply
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 {
+				return resp.Error("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			if n == math.MinInt64 {
+				return resp.Error("ERR value is out of range")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return resp.Error("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return resp.Error("ERR MAXLEN can't be negative")
+			}
+			maxlen = n
+		default:
+			return syntaxErrorReply
+		}
+	}
+
+	l, err := c.store.lookupList(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	positions := []resp.Value{}
+	if l != nil {
+		elem := args[2]
+		n := l.Len()
+		skip := rank - 1
+		step, i := 1, 0
+		if rank < 0 {
+			skip = -rank - 1
+			step, i = -1, n-1
+		}
+		for scanned := int64(0); i >= 0 && i < n && (maxlen == 0 || scanned < maxlen); i, scanned = i+step, scanned+1 {
+			if l.At(i) != elem {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			positions = append(positions, resp.Integer(int64(i)))
+			if count != 0 && int64(len(positions)) == max(count, 1) {
+				break
+			}
+		}
+	}
+
+	if count >= 0 {
+		return resp.Array(positions...)
+	}
+	if len(positions) == 0 {
+		return resp.NullBulk
+	}
+	return positions[0]
+}
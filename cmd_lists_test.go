@@ -0,0 +1,332 @@
+package main
+
+import (
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestPushPopAndRange(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"RPUSH", "l", "b", "c"}, resp.Integer(2)},
+		{[]string{"LPUSH", "l", "a", "z"}, resp.Integer(4)},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.BulkStrings([]string{"z", "a", "b", "c"})},
+		{[]string{"LRANGE", "l", "1", "2"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"LRANGE", "l", "-2", "100"}, resp.BulkStrings([]string{"b", "c"})},
+		{[]string{"LRANGE", "l", "-100", "0"}, resp.BulkStrings([]string{"z"})},
+		{[]string{"LRANGE", "l", "3", "1"}, resp.Array()},
+		{[]string{"LRANGE", "l", "10", "20"}, resp.Array()},
+		{[]string{"LRANGE", "l", "x", "1"}, notIntegerReply},
+		{[]string{"LLEN", "l"}, resp.Integer(4)},
+		{[]string{"LPOP", "l"}, resp.BulkString("z")},
+		{[]string{"RPOP", "l"}, resp.BulkString("c")},
+		{[]string{"LPOP", "l", "0"}, resp.Array()},
+		{[]string{"RPUSH", "l", "d", "e"}, resp.Integer(4)},
+		{[]string{"RPOP", "l", "2"}, resp.BulkStrings([]string{"e", "d"})},
+		{[]string{"LPOP", "l", "10"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"EXISTS", "l"}, resp.Integer(0)},
+		{[]string{"LPOP", "l"}, resp.NullBulk},
+		{[]string{"LPOP", "l", "1"}, resp.NullArray},
+		{[]string{"LPOP", "l", "-1"}, resp.Error("ERR value is out of range, must be positive")},
+		{[]string{"LPOP", "l", "1", "2"}, syntaxErrorReply},
+		{[]string{"LLEN", "l"}, resp.Integer(0)},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.Array()},
+	})
+}
+
+func TestListTypeChecks(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "s", "v")
+	do(c, "RPUSH", "l", "a")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"TYPE", "l"}, resp.SimpleString("list")},
+		{[]string{"LPUSH", "s", "x"}, wrongTypeReply},
+		{[]string{"LPOP", "s"}, wrongTypeReply},
+		{[]string{"LRANGE", "s", "0", "-1"}, wrongTypeReply},
+		{[]string{"LLEN", "s"}, wrongTypeReply},
+		{[]string{"GET", "l"}, wrongTypeReply},
+		{[]string{"GETRANGE", "l", "0", "-1"}, wrongTypeReply},
+		{[]string{"STRLEN", "l"}, wrongTypeReply},
+		{[]string{"APPEND", "l", "x"}, wrongTypeReply},
+		{[]string{"SETRANGE", "l", "0", "x"}, wrongTypeReply},
+		{[]string{"INCR", "l"}, wrongTypeReply},
+		{[]string{"INCRBYFLOAT", "l", "1"}, wrongTypeReply},
+		{[]string{"GETSET", "l", "x"}, wrongTypeReply},
+		{[]string{"GETDEL", "l"}, wrongTypeReply},
+		{[]string{"GETEX", "l", "PERSIST"}, wrongTypeReply},
+		{[]string{"SET", "l", "x", "GET"}, wrongTypeReply},
+		{[]string{"MGET", "s", "l"}, resp.Array(resp.BulkString("v"), resp.NullBulk)},
+		{[]string{"LLEN", "l"}, resp.Integer(1)},
+		{[]string{"SET", "l", "x"}, resp.OK},
+		{[]string{"GET", "l"}, resp.BulkString("x")},
+	})
+}
+
+func TestCopyList(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"RPUSH", "l", "a", "b"}, resp.Integer(2)},
+		{[]string{"COPY", "l", "l2"}, resp.Integer(1)},
+		{[]string{"RPUSH", "l2", "c"}, resp.Integer(3)},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"RENAME", "l2", "l3"}, resp.OK},
+		{[]string{"LLEN", "l3"}, resp.Integer(3)},
+		{[]string{"UNLINK", "l3"}, resp.Integer(1)},
+	})
+	lazyfree.wait()
+}
+
+func TestListPositionalCommands(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "l", "a", "b", "c")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LINDEX", "l", "0"}, resp.BulkString("a")},
+		{[]string{"LINDEX", "l", "-1"}, resp.BulkString("c")},
+		{[]string{"LINDEX", "l", "3"}, resp.NullBulk},
+		{[]string{"LINDEX", "l", "-4"}, resp.NullBulk},
+		{[]string{"LINDEX", "missing", "0"}, resp.NullBulk},
+		{[]string{"LINDEX", "l", "x"}, notIntegerReply},
+		{[]string{"LSET", "l", "-2", "B"}, resp.OK},
+		{[]string{"LSET", "l", "3", "x"}, resp.Error("ERR index out of range")},
+		{[]string{"LSET", "missing", "0", "x"}, resp.Error("ERR no such key")},
+		{[]string{"LINSERT", "l", "BEFORE", "B", "x"}, resp.Integer(4)},
+		{[]string{"LINSERT", "l", "after", "c", "y"}, resp.Integer(5)},
+		{[]string{"LINSERT", "l", "BEFORE", "nope", "z"}, resp.Integer(-1)},
+		{[]string{"LINSERT", "missing", "BEFORE", "a", "z"}, resp.Integer(0)},
+		{[]string{"LINSERT", "l", "MIDDLE", "a", "z"}, syntaxErrorReply},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.BulkStrings([]string{"a", "x", "B", "c", "y"})},
+	})
+}
+
+func TestLRemDirections(t *testing.T) {
+	c := newTestClient()
+	reset := func() {
+		do(c, "DEL", "l")
+		do(c, "RPUSH", "l", "x", "a", "x", "b", "x", "c", "x")
+	}
+
+	tests := []struct {
+		count   string
+		removed int64
+		left    []string
+	}{
+		{"2", 2, []string{"a", "b", "x", "c", "x"}},
+		{"-2", 2, []string{"x", "a", "x", "b", "c"}},
+		{"0", 4, []string{"a", "b", "c"}},
+		{"-10", 4, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		reset()
+		if got := do(c, "LREM", "l", tt.count, "x"); got.Int != tt.removed {
+			t.Errorf("LREM %s removed %d, want %d", tt.count, got.Int, tt.removed)
+		}
+		expectReply(t, c, []struct {
+			args []string
+			want resp.Value
+		}{
+			{[]string{"LRANGE", "l", "0", "-1"}, resp.BulkStrings(tt.left)},
+		})
+	}
+
+	do(c, "DEL", "l")
+	do(c, "RPUSH", "l", "x", "x")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LREM", "l", "0", "x"}, resp.Integer(2)},
+		{[]string{"EXISTS", "l"}, resp.Integer(0)},
+		{[]string{"LREM", "l", "0", "x"}, resp.Integer(0)},
+	})
+}
+
+func TestLTrim(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "l", "a", "b", "c", "d", "e")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LTRIM", "l", "1", "-2"}, resp.OK},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.BulkStrings([]string{"b", "c", "d"})},
+		{[]string{"LTRIM", "l", "-100", "100"}, resp.OK},
+		{[]string{"LLEN", "l"}, resp.Integer(3)},
+		{[]string{"LTRIM", "l", "2", "1"}, resp.OK},
+		{[]string{"EXISTS", "l"}, resp.Integer(0)},
+		{[]string{"LTRIM", "missing", "0", "1"}, resp.OK},
+		{[]string{"SET", "s", "v"}, resp.OK},
+		{[]string{"LTRIM", "s", "0", "1"}, wrongTypeReply},
+		{[]string{"LREM", "s", "0", "v"}, wrongTypeReply},
+		{[]string{"LSET", "s", "0", "v"}, wrongTypeReply},
+	})
+}
+
+func TestLMove(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "queue", "a", "b", "c")
+	do(c, "SET", "s", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LMOVE", "queue", "processing", "LEFT", "RIGHT"}, resp.BulkString("a")},
+		{[]string{"RPOPLPUSH", "queue", "processing"}, resp.BulkString("c")},
+		{[]string{"LRANGE", "processing", "0", "-1"}, resp.BulkStrings([]string{"c", "a"})},
+		{[]string{"LMOVE", "processing", "processing", "RIGHT", "LEFT"}, resp.BulkString("a")},
+		{[]string{"LRANGE", "processing", "0", "-1"}, resp.BulkStrings([]string{"a", "c"})},
+		{[]string{"LMOVE", "queue", "processing", "left", "left"}, resp.BulkString("b")},
+		{[]string{"EXISTS", "queue"}, resp.Integer(0)},
+		{[]string{"LMOVE", "queue", "processing", "LEFT", "LEFT"}, resp.NullBulk},
+		{[]string{"LMOVE", "processing", "s", "LEFT", "LEFT"}, wrongTypeReply},
+		{[]string{"LLEN", "processing"}, resp.Integer(3)},
+		{[]string{"LMOVE", "s", "processing", "LEFT", "LEFT"}, wrongTypeReply},
+		{[]string{"LMOVE", "processing", "x", "UP", "LEFT"}, syntaxErrorReply},
+		{[]string{"LMOVE", "processing", "x", "LEFT", "DOWN"}, syntaxErrorReply},
+	})
+
+	do(c, "DEL", "processing")
+	do(c, "RPUSH", "one", "x")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"RPOPLPUSH", "one", "one"}, resp.BulkString("x")},
+		{[]string{"LRANGE", "one", "0", "-1"}, resp.BulkStrings([]string{"x"})},
+	})
+}
+
+func TestLMPop(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "b", "1", "2", "3")
+	do(c, "SET", "s", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LMPOP", "2", "a", "b", "LEFT"}, resp.Array(resp.BulkString("b"), resp.BulkStrings([]string{"1"}))},
+		{[]string{"LMPOP", "2", "a", "b", "right", "COUNT", "5"}, resp.Array(resp.BulkString("b"), resp.BulkStrings([]string{"3", "2"}))},
+		{[]string{"EXISTS", "b"}, resp.Integer(0)},
+		{[]string{"LMPOP", "2", "a", "b", "LEFT"}, resp.NullArray},
+		{[]string{"LMPOP", "2", "a", "s", "LEFT"}, wrongTypeReply},
+		{[]string{"LMPOP", "0", "a", "LEFT"}, resp.Error("ERR numkeys should be greater than 0")},
+		{[]string{"LMPOP", "3", "a", "b", "LEFT"}, syntaxErrorReply},
+		{[]string{"LMPOP", "1", "a", "UP"}, syntaxErrorReply},
+		{[]string{"LMPOP", "1", "a", "LEFT", "COUNT", "-1"}, resp.Error("ERR count should be greater than 0")},
+	})
+}
+
+func TestLPos(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LPOS", "l", "c"}, resp.Integer(2)},
+		{[]string{"LPOS", "l", "nope"}, resp.NullBulk},
+		{[]string{"LPOS", "missing", "c"}, resp.NullBulk},
+		{[]string{"LPOS", "l", "c", "RANK", "2"}, resp.Integer(6)},
+		{[]string{"LPOS", "l", "c", "RANK", "-1"}, resp.Integer(7)},
+		{[]string{"LPOS", "l", "c", "RANK", "4"}, resp.NullBulk},
+		{[]string{"LPOS", "l", "c", "COUNT", "2"}, ints(2, 6)},
+		{[]string{"LPOS", "l", "c", "COUNT", "0"}, ints(2, 6, 7)},
+		{[]string{"LPOS", "l", "c", "RANK", "-1", "COUNT", "2"}, ints(7, 6)},
+		{[]string{"LPOS", "l", "c", "RANK", "2", "COUNT", "0"}, ints(6, 7)},
+		{[]string{"LPOS", "l", "c", "COUNT", "0", "MAXLEN", "7"}, ints(2, 6)},
+		{[]string{"LPOS", "l", "c", "RANK", "-1", "MAXLEN", "1"}, resp.Integer(7)},
+		{[]string{"LPOS", "l", "nope", "COUNT", "0"}, resp.Array()},
+		{[]string{"LPOS", "missing", "c", "COUNT", "1"}, resp.Array()},
+		{[]string{"LPOS", "l", "c", "RANK", "0"}, resp.Error("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")},
+		{[]string{"LPOS", "l", "c", "COUNT", "-1"}, resp.Error("ERR COUNT can't be negative")},
+		{[]string{"LPOS", "l", "c", "MAXLEN", "-1"}, resp.Error("ERR MAXLEN can't be negative")},
+		{[]string{"LPOS", "l", "c", "RANK"}, syntaxErrorReply},
+		{[]string{"LPOS", "l", "c", "BOGUS", "1"}, syntaxErrorReply},
+		{[]string{"LPOS", "l", "c", "RANK", "x"}, notIntegerReply},
+	})
+}
+
+func TestBlpopBrpop(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "b", "1", "2", "3")
+	do(c, "SET", "str", "x")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BLPOP", "a", "b", "1"}, resp.Array(resp.BulkString("b"), resp.BulkString("1"))},
+		{[]string{"BRPOP", "a", "b", "0"}, resp.Array(resp.BulkString("b"), resp.BulkString("3"))},
+		{[]string{"BRPOP", "b", "0.5"}, resp.Array(resp.BulkString("b"), resp.BulkString("2"))},
+		{[]string{"EXISTS", "b"}, resp.Integer(0)},
+		{[]string{"BLPOP", "str", "b", "0"}, wrongTypeReply},
+	})
+
+	r := doBlocking(c, "BRPOP", "a", "b", "0")
+	other := newClient(nil, c.srv)
+	do(other, "RPUSH", "b", "x", "y")
+	expectUnblocked(t, r, resp.Array(resp.BulkString("b"), resp.BulkString("y")))
+}
+
+func TestBlmoveBlmpop(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "src", "1", "2", "3")
+	do(c, "SET", "str", "x")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BLMOVE", "src", "dst", "LEFT", "RIGHT", "0"}, resp.BulkString("1")},
+		{[]string{"BRPOPLPUSH", "src", "dst", "0"}, resp.BulkString("3")},
+		{[]string{"LRANGE", "dst", "0", "-1"}, resp.BulkStrings([]string{"3", "1"})},
+		{[]string{"BLMOVE", "src", "dst", "UP", "RIGHT", "0"}, syntaxErrorReply},
+		{[]string{"BLMOVE", "str", "dst", "LEFT", "RIGHT", "0"}, wrongTypeReply},
+		{[]string{"BLMPOP", "0", "2", "missing", "dst", "RIGHT", "COUNT", "5"}, resp.Array(resp.BulkString("dst"), resp.BulkStrings([]string{"1", "3"}))},
+		{[]string{"BLMPOP", "0", "1", "src", "LEFT"}, resp.Array(resp.BulkString("src"), resp.BulkStrings([]string{"2"}))},
+		{[]string{"BLMPOP", "0.01", "1", "src", "LEFT"}, resp.NullArray},
+		{[]string{"BLMPOP", "0", "0", "src", "LEFT"}, resp.Error("ERR numkeys should be greater than 0")},
+		{[]string{"BLMPOP", "0", "2", "src", "LEFT"}, syntaxErrorReply},
+		{[]string{"BLMPOP", "0", "1", "src", "UP"}, syntaxErrorReply},
+		{[]string{"BLMPOP", "0", "1", "src", "LEFT", "COUNT", "0"}, resp.Error("ERR count should be greater than 0")},
+		{[]string{"BLMPOP", "x", "1", "src", "LEFT"}, resp.Error("ERR timeout is not a float or out of range")},
+	})
+}
+
+func TestBlmoveWakesChain(t *testing.T) {
+	c := newTestClient()
+	mover, popper := newClient(nil, c.srv), newClient(nil, c.srv)
+
+	// Serving BLMOVE pushes to b, which in turn serves the BLPOP on it.
+	moved := doBlocking(mover, "BLMOVE", "a", "b", "LEFT", "LEFT", "0")
+	popped := doBlocking(popper, "BLMPOP", "0", "1", "b", "RIGHT", "COUNT", "2")
+	do(c, "RPUSH", "a", "job")
+	expectUnblocked(t, moved, resp.BulkString("job"))
+	expectUnblocked(t, popped, resp.Array(resp.BulkString("b"), resp.BulkStrings([]string{"job"})))
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXISTS", "a", "b"}, resp.Integer(0)},
+	})
+}
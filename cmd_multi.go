@@ -0,0 +1,190 @@
+package main
+
+import "go-http-practice/resp"
+
+func init() {
+	RegisterCommand(&Command{Name: "multi", Arity: 1, Flags: flagNoScript | flagLoading | flagStale | flagFast, Handler: multiCommand})
+	RegisterCommand(&Command{Name: "exec", Arity: 1, Flags: flagNoScript | flagLoading | flagStale, Handler: execCommand})
+	RegisterCommand(&Command{Name: "discard", Arity: 1, Flags: flagNoScript | flagLoading | flagStale | flagFast, Handler: discardCommand})
+	RegisterCommand(&Command{Name: "watch", Arity: -2, Flags: flagNoScript | flagLoading | flagStale | flagFast, FirstKey: 1, LastKey: -1, Step: 1, Handler: watchCommand})
+	RegisterCommand(&Command{Name: "unwatch", Arity: 1, Flags: flagNoScript | flagLoading | flagStale | flagFast, Handler: unwatchCommand})
+}
+
+// transaction holds the commands queued by a client since MULTI.
+type transaction struct {
+	queued [][]string
+	// aborted is set when a command could not be queued, for instance
+	// because of a wrong number of arguments; EXEC then runs nothing.
+	aborted bool
+}
+
+// runsInMulti lists the commands run straight away, rather than queued,
+// inside a transaction.
+var runsInMulti = map[string]bool{
+	"exec":    true,
+	"discard": true,
+	"multi":   true,
+	"watch":   true,
+	"quit":    true,
+	"reset":   true,
+}
+
+// multiCommand implements MULTI, starting a transaction: the commands that
+// follow are queued until EXEC or DISCARD.
+func multiCommand(c *Client, args []string) resp.Value {
+	if c.multi != nil {
+		return resp.Error("ERR MULTI calls can not be nested")
+	}
+	c.multi = &transaction{}
+	return resp.OK
+}
+
+// execCommand implements EXEC. It runs the queued commands under a single
+// acquisition of the store's write lock, so no other client sees or acts
+// between them, and replies with their replies. A command that fails at
+// run time, for instance with WRONGTYPE, does not stop the rest. Blocking
+// commands do not wait and behave as if they had timed out. If a WATCHed
+// key was modified, nothing runs and the reply is nil. Either way the keys
+// are no longer watched afterwards.
+func execCommand(c *Client, args []string) resp.Value {
+	tx := c.multi
+	if tx == nil {
+		return resp.Error("ERR EXEC without MULTI")
+	}
+	c.multi = nil
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	dirty := c.watchedKeyModified()
+	c.store.unwatch(c)
+	if tx.aborted {
+		return resp.Error("EXECABORT Transaction discarded because of previous errors.")
+	}
+	if dirty {
+		return resp.NullArray
+	}
+	defer c.store.actFor(c)()
+	defer c.store.serveBlocked()
+	defer c.flushEffects()
+	c.execing = true
+	defer func() { c.execing = false }()
+
+	replies := make([]resp.Value, len(tx.queued))
+	for i, args := range tx.queued {
+		cmd := lookupCommand(args[0])
+		if cmd.Flags&flagWrite != 0 {
+			c.expireKeys(cmd, args)
+		}
+		dirty := c.store.dirty
+		replies[i] = cmd.handler(c, args)
+		c.propagate(cmd, args, dirty)
+		c.trackKeys(cmd, args)
+		c.srv.monitors.feed(c, cmd, args, false)
+	}
+	return resp.Array(replies...)
+}
+
+// discardCommand implements DISCARD, dropping the queued commands and
+// unwatching every key.
+func discardCommand(c *Client, args []string) resp.Value {
+	if c.multi == nil {
+		return resp.Error("ERR DISCARD without MULTI")
+	}
+	c.multi = nil
+	c.unwatchAll()
+	return resp.OK
+}
+
+// watchCommand implements WATCH key [key ...], making the next EXEC fail
+// if any of the keys is modified before it runs. Keys stay watched until
+// EXEC, DISCARD or UNWATCH.
+func watchCommand(c *Client, args []string) resp.Value {
+	if c.multi != nil {
+		return resp.Error("ERR WATCH inside MULTI is not allowed")
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	for _, key := range args[1:] {
+		c.store.watch(c, key)
+	}
+	return resp.OK
+}
+
+// unwatchCommand implements UNWATCH, forgetting every watched key.
+func unwatchCommand(c *Client, args []string) resp.Value {
+	c.unwatchAll()
+	return resp.OK
+}
+
+// watch adds key to the keys watched by c. The caller must hold mu for
+// writing.
+func (s *Store) watch(c *Client, key string) {
+	if _, ok := c.watched[key]; ok {
+		return
+	}
+	if s.watchers == nil {
+		s.watchers = make(map[string]map[*Client]struct{})
+	}
+	if s.watchers[key] == nil {
+		s.watchers[key] = make(map[*Client]struct{})
+	}
+	s.watchers[key][c] = struct{}{}
+	if c.watched == nil {
+		c.watched = make(map[string]bool)
+	}
+	_, c.watched[key] = s.lookup(key)
+}
+
+// unwatch forgets every key watched by c and clears its dirty flag. The
+// caller must hold mu for writing.
+func (s *Store) unwatch(c *Client) {
+	for key := range c.watched {
+		delete(s.watchers[key], c)
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+	c.watched = nil
+	c.dirty = false
+}
+
+// signalModified marks the clients watching key as dirty, which fails
+// their next EXEC, and invalidates the key for the clients tracking it.
+// put and remove call it; commands that modify a value in place call it
+// themselves. It also counts the change in dirty. The caller must hold mu
+// for writing.
+func (s *Store) signalModified(key string) {
+	s.dirty++
+	for c := range s.watchers[key] {
+		c.dirty = true
+	}
+	if s.tracking != nil {
+		s.tracking.invalidate(key, s.current)
+	}
+}
+
+// unwatchAll unwatches every key watched by the client, taking the store
+// lock if there are any.
+func (c *Client) unwatchAll() {
+	if len(c.watched) == 0 {
+		return
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.store.unwatch(c)
+}
+
+// watchedKeyModified reports whether a key watched by the client was
+// modified since WATCH. A key that expired in the meantime counts, even if
+// the janitor has not removed it yet. The caller must hold the store lock.
+func (c *Client) watchedKeyModified() bool {
+	if c.dirty {
+		return true
+	}
+	for key, existed := range c.watched {
+		if _, ok := c.store.lookup(key); existed && !ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+var queued = resp.SimpleString("QUEUED")
+
+func TestMultiExec(t *testing.T) {
+	c := newTestClient()
+	other := newClient(nil, c.srv)
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"SET", "k", "1"}, queued},
+		{[]string{"INCR", "k"}, queued},
+		{[]string{"LPUSH", "k", "x"}, queued},
+		{[]string{"GET", "k"}, queued},
+		{[]string{"MULTI"}, resp.Error("ERR MULTI calls can not be nested")},
+	})
+	// Nothing has run yet.
+	expectReply(t, other, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXISTS", "k"}, resp.Integer(0)},
+	})
+	// A command failing at run time does not stop the others.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EXEC"}, resp.Array(resp.OK, resp.Integer(2), wrongTypeReply, resp.BulkString("2"))},
+		{[]string{"EXEC"}, resp.Error("ERR EXEC without MULTI")},
+		{[]string{"DISCARD"}, resp.Error("ERR DISCARD without MULTI")},
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"EXEC"}, resp.Array()},
+	})
+}
+
+func TestMultiDiscard(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"SET", "k", "1"}, queued},
+		{[]string{"DISCARD"}, resp.OK},
+		{[]string{"EXISTS", "k"}, resp.Integer(0)},
+	})
+}
+
+func TestMultiQueueErrorsAbort(t *testing.T) {
+	c := newTestClient()
+	for _, bad := range [][]string{
+		{"NOSUCHCOMMAND"},
+		{"SET", "k"},
+		{"SUBSCRIBE", "ch"},
+	} {
+		do(c, "MULTI")
+		do(c, "SET", "k", "1")
+		if got := do(c, bad...); !got.IsError() {
+			t.Errorf("%q inside MULTI = %+v, want an error", bad, got)
+		}
+		expectReply(t, c, []struct {
+			args []string
+			want resp.Value
+		}{
+			{[]string{"SET", "k", "2"}, queued},
+			{[]string{"EXEC"}, resp.Error("EXECABORT Transaction discarded because of previous errors.")},
+			{[]string{"EXISTS", "k"}, resp.Integer(0)},
+		})
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"SUBSCRIBE", "ch"}, resp.Error("ERR Command not allowed inside a transaction")},
+		{[]string{"DISCARD"}, resp.OK},
+	})
+}
+
+func TestMultiBlockingCommands(t *testing.T) {
+	c := newTestClient()
+	other := newClient(nil, c.srv)
+	waiting := doBlocking(other, "BLPOP", "q", "0")
+
+	// Blocking commands inside a transaction time out at once, and writes
+	// in it serve the clients that were already blocked.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"BLPOP", "empty", "0"}, queued},
+		{[]string{"RPUSH", "q", "a", "b"}, queued},
+		{[]string{"BLPOP", "q", "0"}, queued},
+		{[]string{"EXEC"}, resp.Array(
+			resp.NullArray,
+			resp.Integer(2),
+			resp.Array(resp.BulkString("q"), resp.BulkString("a")),
+		)},
+	})
+	expectUnblocked(t, waiting, resp.Array(resp.BulkString("q"), resp.BulkString("b")))
+	if n := waiterCount(c.store); n != 0 {
+		t.Errorf("%d waiters left, want none", n)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := newTestClient()
+	other := newClient(nil, c.srv)
+
+	// An untouched watched key lets the transaction run.
+	do(c, "SET", "k", "1")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"WATCH", "k", "missing"}, resp.OK},
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"WATCH", "k"}, resp.Error("ERR WATCH inside MULTI is not allowed")},
+		{[]string{"INCR", "k"}, queued},
+		{[]string{"EXEC"}, resp.Array(resp.Integer(2))},
+	})
+
+	// Writes, deletions and creations by another client abort it, in-place
+	// changes to aggregates included; reads and TOUCH do not.
+	for _, tc := range []struct {
+		key  string
+		args []string
+	}{
+		{"k", []string{"SET", "k", "2"}},
+		{"k", []string{"DEL", "k"}},
+		{"k", []string{"EXPIRE", "k", "100"}},
+		{"new", []string{"SET", "new", "1"}},
+		{"l", []string{"RPUSH", "l", "b"}},
+		{"l", []string{"LPOP", "l"}},
+		{"h", []string{"HSET", "h", "f", "w"}},
+		{"s", []string{"SADD", "s", "n"}},
+		{"z", []string{"ZADD", "z", "2", "m"}},
+		{"x", []string{"XADD", "x", "*", "f", "v"}},
+		{"k", []string{"FLUSHDB"}},
+	} {
+		do(c, "FLUSHDB")
+		do(c, "SET", "k", "1")
+		do(c, "RPUSH", "l", "a", "z")
+		do(c, "HSET", "h", "f", "v")
+		do(c, "SADD", "s", "m")
+		do(c, "ZADD", "z", "1", "m")
+		do(c, "XADD", "x", "*", "f", "v")
+		do(c, "WATCH", tc.key)
+		do(other, "GET", tc.key)
+		do(other, "TOUCH", tc.key)
+		do(other, tc.args...)
+		do(c, "MULTI")
+		do(c, "SET", "done", "1")
+		if got := do(c, "EXEC"); !reflect.DeepEqual(got, resp.NullArray) {
+			t.Errorf("EXEC after %q = %+v, want nil", tc.args, got)
+		}
+		if got := do(c, "EXISTS", "done"); !reflect.DeepEqual(got, resp.Integer(0)) {
+			t.Errorf("EXEC after %q ran the transaction", tc.args)
+		}
+	}
+
+	// EXEC, DISCARD and UNWATCH all unwatch.
+	for _, end := range [][]string{{"EXEC"}, {"DISCARD"}, {"UNWATCH"}} {
+		do(c, "WATCH", "k")
+		if end[0] != "UNWATCH" {
+			do(c, "MULTI")
+		}
+		do(c, end...)
+		do(other, "SET", "k", "3")
+		do(c, "MULTI")
+		if got := do(c, "EXEC"); !reflect.DeepEqual(got, resp.Array()) {
+			t.Errorf("EXEC after %s = %+v, want an empty array", end[0], got)
+		}
+	}
+	if n := len(c.store.watchers); n != 0 {
+		t.Errorf("%d watched keys left, want none", n)
+	}
+}
+
+func TestWatchExpired(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "v", "PX", "1")
+	do(c, "WATCH", "k")
+	time.Sleep(5 * time.Millisecond)
+	do(c, "MULTI")
+	if got := do(c, "EXEC"); !reflect.DeepEqual(got, resp.NullArray) {
+		t.Errorf("EXEC after the watched key expired = %+v, want nil", got)
+	}
+}
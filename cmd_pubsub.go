@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "subscribe", Arity: -2, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, Handler: subscribeCommand})
+	RegisterCommand(&Command{Name: "unsubscribe", Arity: -1, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, Handler: unsubscribeCommand})
+	RegisterCommand(&Command{Name: "psubscribe", Arity: -2, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, Handler: psubscribeCommand})
+	RegisterCommand(&Command{Name: "punsubscribe", Arity: -1, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, Handler: punsubscribeCommand})
+	RegisterCommand(&Command{Name: "publish", Arity: 3, Flags: flagPubSub | flagLoading | flagStale | flagFast, Handler: publishCommand})
+	RegisterCommand(&Command{Name: "ssubscribe", Arity: -2, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, FirstKey: 1, LastKey: -1, Step: 1, Handler: ssubscribeCommand})
+	RegisterCommand(&Command{Name: "sunsubscribe", Arity: -1, Flags: flagPubSub | flagNoScript | flagLoading | flagStale | flagNoMulti, FirstKey: 1, LastKey: -1, Step: 1, Handler: sunsubscribeCommand})
+	RegisterCommand(&Command{Name: "spublish", Arity: 3, Flags: flagPubSub | flagLoading | flagStale | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: spublishCommand})
+	RegisterCommand(&Command{Name: "pubsub", Arity: -2, Flags: flagPubSub | flagLoading | flagStale, Handler: pubsubCommand})
+}
+
+// subscribeCommand implements SUBSCRIBE channel [channel ...]. Each channel
+// is confirmed with a ["subscribe", channel, count] reply of its own.
+func subscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.subscribe(c, args[1:], channelSub)
+	return noReply
+}
+
+// unsubscribeCommand implements UNSUBSCRIBE [channel ...]. Without channels
+// it unsubscribes from all of them. Each channel is confirmed with an
+// ["unsubscribe", channel, count] reply of its own.
+func unsubscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.unsubscribe(c, args[1:], channelSub)
+	return noReply
+}
+
+// psubscribeCommand implements PSUBSCRIBE pattern [pattern ...]. Messages
+// to channels matching a pattern arrive as ["pmessage", pattern, channel,
+// message], and each pattern is confirmed with a ["psubscribe", pattern,
+// count] reply of its own.
+func psubscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.subscribe(c, args[1:], patternSub)
+	return noReply
+}
+
+// punsubscribeCommand implements PUNSUBSCRIBE [pattern ...]. Without
+// patterns it unsubscribes from all of them.
+func punsubscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.unsubscribe(c, args[1:], patternSub)
+	return noReply
+}
+
+// publishCommand implements PUBLISH channel message and replies with the
+// number of subscribers that received the message, counting each pattern a
+// client matched through.
+func publishCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(int64(c.srv.pubsub.publish(args[1], args[2])))
+}
+
+// ssubscribeCommand implements SSUBSCRIBE shardchannel [shardchannel ...].
+// Messages arrive as ["smessage", channel, message], and each channel is
+// confirmed with a ["ssubscribe", channel, count] reply of its own, count
+// being the number of shard channel subscriptions.
+func ssubscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.subscribe(c, args[1:], shardSub)
+	return noReply
+}
+
+// sunsubscribeCommand implements SUNSUBSCRIBE [shardchannel ...]. Without
+// channels it unsubscribes from all shard channels.
+func sunsubscribeCommand(c *Client, args []string) resp.Value {
+	c.srv.pubsub.unsubscribe(c, args[1:], shardSub)
+	return noReply
+}
+
+// spublishCommand implements SPUBLISH shardchannel message and replies with
+// the number of subscribers that received the message.
+func spublishCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(int64(c.srv.pubsub.spublish(args[1], args[2])))
+}
+
+var pubsubHelp = []string{
+	"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CHANNELS [<pattern>]",
+	"    Return the currently active channels matching a <pattern> (default: '*').",
+	"NUMPAT",
+	"    Return number of subscriptions to patterns.",
+	"NUMSUB [<channel> ...]",
+	"    Return the number of subscribers for the specified channels, excluding",
+	"    pattern subscriptions(default: no channels).",
+	"SHARDCHANNELS [<pattern>]",
+	"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+	"SHARDNUMSUB [<shardchannel> ...]",
+	"    Return the number of subscribers for the specified shard level channel(s)",
+	"HELP",
+	"    Print this help.",
+}
+
+// pubsubCommand implements PUBSUB CHANNELS, NUMSUB, NUMPAT, SHARDCHANNELS,
+// SHARDNUMSUB and HELP.
+func pubsubCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"channels": -2, "numsub": -2, "numpat": 2, "shardchannels": -2, "shardnumsub": -2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try PUBSUB HELP.", args[1]))
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) || ((sub == "channels" || sub == "shardchannels") && len(args) > 3) {
+		return wrongArityReply("pubsub|" + sub)
+	}
+
+	ps := c.srv.pubsub
+	kind := channelSub
+	if strings.HasPrefix(sub, "shard") {
+		kind = shardSub
+	}
+	switch sub {
+	case "channels", "shardchannels":
+		pattern := "*"
+		if len(args) == 3 {
+			pattern = args[2]
+		}
+		return resp.BulkStrings(ps.activeChannels(pattern, kind))
+	case "numsub", "shardnumsub":
+		kvs := make([]resp.Value, 0, 2*len(args[2:]))
+		for _, ch := range args[2:] {
+			kvs = append(kvs, resp.BulkString(ch), resp.Integer(int64(ps.numSub(ch, kind))))
+		}
+		return resp.Map(kvs...)
+	case "numpat":
+		return resp.Integer(int64(ps.numPat()))
+	default:
+		return resp.BulkStrings(pubsubHelp)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+// written returns the values buffered on the output of c, which has no
+// connection to write them to, and empties it.
+func written(t *testing.T, c *Client) []resp.Value {
+	t.Helper()
+	c.out.mu.Lock()
+	buf := c.out.buf
+	c.out.buf = nil
+	c.out.mu.Unlock()
+
+	var vals []resp.Value
+	r := resp.NewReader(bytes.NewReader(buf))
+	for {
+		v, err := r.ReadValue()
+		if err == io.EOF {
+			return vals
+		}
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		vals = append(vals, v)
+	}
+}
+
+func expectWritten(t *testing.T, c *Client, want ...resp.Value) {
+	t.Helper()
+	if got := written(t, c); !reflect.DeepEqual(got, want) {
+		t.Errorf("output = %+v, want %+v", got, want)
+	}
+}
+
+// pushed builds a RESP2 message or confirmation as it is read back.
+func pushed(elems ...resp.Value) resp.Value {
+	return resp.Array(elems...)
+}
+
+func TestSubscribePublish(t *testing.T) {
+	c := newTestClient()
+	sub := newClient(nil, c.srv)
+	other := newClient(nil, c.srv)
+
+	if got := do(sub, "SUBSCRIBE", "news", "sport", "news"); got.Type != 0 {
+		t.Errorf("SUBSCRIBE replied %+v, want its replies written", got)
+	}
+	expectWritten(t, sub,
+		pushed(resp.BulkString("subscribe"), resp.BulkString("news"), resp.Integer(1)),
+		pushed(resp.BulkString("subscribe"), resp.BulkString("sport"), resp.Integer(2)),
+		pushed(resp.BulkString("subscribe"), resp.BulkString("news"), resp.Integer(2)),
+	)
+	do(other, "SUBSCRIBE", "news")
+	written(t, other)
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PUBLISH", "news", "hello"}, resp.Integer(2)},
+		{[]string{"PUBLISH", "sport", "goal"}, resp.Integer(1)},
+		{[]string{"PUBLISH", "weather", "rain"}, resp.Integer(0)},
+	})
+	expectWritten(t, sub,
+		pushed(resp.BulkString("message"), resp.BulkString("news"), resp.BulkString("hello")),
+		pushed(resp.BulkString("message"), resp.BulkString("sport"), resp.BulkString("goal")),
+	)
+	expectWritten(t, other,
+		pushed(resp.BulkString("message"), resp.BulkString("news"), resp.BulkString("hello")),
+	)
+
+	// Subscriber mode only allows a few commands.
+	expectReply(t, sub, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.Error("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")},
+		{[]string{"PING"}, resp.BulkStrings([]string{"pong", ""})},
+		{[]string{"PING", "hi"}, resp.BulkStrings([]string{"pong", "hi"})},
+	})
+
+	do(sub, "UNSUBSCRIBE", "news", "nope")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("unsubscribe"), resp.BulkString("news"), resp.Integer(1)),
+		pushed(resp.BulkString("unsubscribe"), resp.BulkString("nope"), resp.Integer(1)),
+	)
+	do(sub, "UNSUBSCRIBE")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("unsubscribe"), resp.BulkString("sport"), resp.Integer(0)),
+	)
+	do(sub, "UNSUBSCRIBE")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("unsubscribe"), resp.NullBulk, resp.Integer(0)),
+	)
+
+	expectReply(t, sub, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PING"}, resp.SimpleString("PONG")},
+		{[]string{"PUBLISH", "news", "again"}, resp.Integer(1)},
+	})
+	if len(c.srv.pubsub.subs[channelSub]) != 1 {
+		t.Errorf("channels = %v, want only news left", c.srv.pubsub.subs[channelSub])
+	}
+}
+
+func TestPatternSubscribe(t *testing.T) {
+	c := newTestClient()
+	sub := newClient(nil, c.srv)
+
+	do(sub, "PSUBSCRIBE", "events.*", "events.login")
+	do(sub, "SUBSCRIBE", "events.login")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("psubscribe"), resp.BulkString("events.*"), resp.Integer(1)),
+		pushed(resp.BulkString("psubscribe"), resp.BulkString("events.login"), resp.Integer(2)),
+		pushed(resp.BulkString("subscribe"), resp.BulkString("events.login"), resp.Integer(3)),
+	)
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PUBLISH", "events.signup", "ann"}, resp.Integer(1)},
+		{[]string{"PUBLISH", "events", "nobody"}, resp.Integer(0)},
+	})
+	expectWritten(t, sub,
+		pushed(resp.BulkString("pmessage"), resp.BulkString("events.*"), resp.BulkString("events.signup"), resp.BulkString("ann")),
+	)
+
+	// A client subscribed several ways gets a message for each.
+	if got := do(c, "PUBLISH", "events.login", "bob"); !reflect.DeepEqual(got, resp.Integer(3)) {
+		t.Errorf("PUBLISH = %+v, want 3", got)
+	}
+	if got := written(t, sub); len(got) != 3 {
+		t.Errorf("output = %+v, want 3 messages", got)
+	}
+
+	do(sub, "PUNSUBSCRIBE", "events.login")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("punsubscribe"), resp.BulkString("events.login"), resp.Integer(2)),
+	)
+	expectReply(t, sub, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.Error("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")},
+	})
+	do(sub, "PUNSUBSCRIBE")
+	do(sub, "PUNSUBSCRIBE")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("punsubscribe"), resp.BulkString("events.*"), resp.Integer(1)),
+		pushed(resp.BulkString("punsubscribe"), resp.NullBulk, resp.Integer(1)),
+	)
+	if len(c.srv.pubsub.subs[patternSub]) != 0 {
+		t.Errorf("patterns = %v, want none left", c.srv.pubsub.subs[patternSub])
+	}
+}
+
+func TestSubscribeRESP3(t *testing.T) {
+	c := newTestClient()
+	c.proto = 3
+	do(c, "SUBSCRIBE", "ch")
+	do(c, "PUBLISH", "ch", "msg")
+
+	// RESP3 clients get push messages and can keep running any command.
+	c.out.mu.Lock()
+	got := string(c.out.buf)
+	c.out.mu.Unlock()
+	want := ">3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n" +
+		">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "k", "v"}, resp.OK},
+		{[]string{"PING"}, resp.SimpleString("PONG")},
+	})
+}
+
+func TestPublishToConnection(t *testing.T) {
+	srv := NewServer(defaultConfig())
+	server, conn := net.Pipe()
+	defer conn.Close()
+	go srv.handleConnection(server)
+
+	go conn.Write([]byte("SUBSCRIBE jobs\r\n"))
+	reader := resp.NewReader(conn)
+	want := pushed(resp.BulkString("subscribe"), resp.BulkString("jobs"), resp.Integer(1))
+	if got, err := reader.ReadValue(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, %v; want %+v", got, err, want)
+	}
+
+	// The message is written while the subscriber's connection sits idle
+	// waiting for its next request.
+	if n := do(newClient(nil, srv), "PUBLISH", "jobs", "build"); !reflect.DeepEqual(n, resp.Integer(1)) {
+		t.Fatalf("PUBLISH = %+v, want 1", n)
+	}
+	want = pushed(resp.BulkString("message"), resp.BulkString("jobs"), resp.BulkString("build"))
+	if got, err := reader.ReadValue(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, %v; want %+v", got, err, want)
+	}
+
+	// Closing the connection drops its subscriptions.
+	conn.Close()
+	waitFor(t, func() bool {
+		srv.pubsub.mu.RLock()
+		defer srv.pubsub.mu.RUnlock()
+		return len(srv.pubsub.subs[channelSub]) == 0
+	})
+}
+
+func TestPublishOutputLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ClientOutputBufferLimitPubSub = 100
+	c := newClient(nil, NewServer(cfg))
+	do(c, "SUBSCRIBE", "ch")
+
+	pub := newClient(nil, c.srv)
+	expectReply(t, pub, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PUBLISH", "ch", "short"}, resp.Integer(1)},
+		{[]string{"PUBLISH", "ch", string(make([]byte, 64))}, resp.Integer(0)},
+		{[]string{"PUBLISH", "ch", "short"}, resp.Integer(0)},
+	})
+	if err := c.out.flush(); err != errOutputLimit {
+		t.Errorf("flush = %v, want %v", err, errOutputLimit)
+	}
+}
+
+func TestPubsubIntrospection(t *testing.T) {
+	c := newTestClient()
+	a, b := newClient(nil, c.srv), newClient(nil, c.srv)
+	do(a, "SUBSCRIBE", "news.tech", "sport")
+	do(b, "SUBSCRIBE", "news.tech", "news.art")
+	do(b, "PSUBSCRIBE", "news.*", "sport*")
+	do(a, "PSUBSCRIBE", "news.*")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"PUBSUB", "CHANNELS"}, resp.BulkStrings([]string{"news.art", "news.tech", "sport"})},
+		{[]string{"PUBSUB", "channels", "news.*"}, resp.BulkStrings([]string{"news.art", "news.tech"})},
+		{[]string{"PUBSUB", "CHANNELS", "weather"}, resp.Array()},
+		{[]string{"PUBSUB", "NUMSUB", "news.tech", "sport", "weather"}, resp.Map(
+			resp.BulkString("news.tech"), resp.Integer(2),
+			resp.BulkString("sport"), resp.Integer(1),
+			resp.BulkString("weather"), resp.Integer(0),
+		)},
+		{[]string{"PUBSUB", "NUMSUB"}, resp.Map()},
+		{[]string{"PUBSUB", "NUMPAT"}, resp.Integer(2)},
+		{[]string{"PUBSUB", "NUMPAT", "x"}, resp.Error("ERR wrong number of arguments for 'pubsub|numpat' command")},
+		{[]string{"PUBSUB", "CHANNELS", "a", "b"}, resp.Error("ERR wrong number of arguments for 'pubsub|channels' command")},
+		{[]string{"PUBSUB", "FOO"}, resp.Error("ERR unknown subcommand 'FOO'. Try PUBSUB HELP.")},
+	})
+	if got := do(c, "PUBSUB", "HELP"); len(got.Array) == 0 {
+		t.Errorf("PUBSUB HELP = %v, want help lines", got)
+	}
+}
+
+func TestShardedPubsub(t *testing.T) {
+	c := newTestClient()
+	sub := newClient(nil, c.srv)
+
+	do(sub, "SUBSCRIBE", "orders")
+	do(sub, "SSUBSCRIBE", "orders", "{user1}.carts")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("subscribe"), resp.BulkString("orders"), resp.Integer(1)),
+		pushed(resp.BulkString("ssubscribe"), resp.BulkString("orders"), resp.Integer(1)),
+		pushed(resp.BulkString("ssubscribe"), resp.BulkString("{user1}.carts"), resp.Integer(2)),
+	)
+
+	// Shard channels and plain channels of the same name are separate.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SPUBLISH", "orders", "o1"}, resp.Integer(1)},
+		{[]string{"PUBLISH", "{user1}.carts", "c1"}, resp.Integer(0)},
+		{[]string{"PUBSUB", "SHARDCHANNELS"}, resp.BulkStrings([]string{"orders", "{user1}.carts"})},
+		{[]string{"PUBSUB", "SHARDCHANNELS", "{user1}*"}, resp.BulkStrings([]string{"{user1}.carts"})},
+		{[]string{"PUBSUB", "CHANNELS"}, resp.BulkStrings([]string{"orders"})},
+		{[]string{"PUBSUB", "SHARDNUMSUB", "orders", "none"}, resp.Map(
+			resp.BulkString("orders"), resp.Integer(1),
+			resp.BulkString("none"), resp.Integer(0),
+		)},
+	})
+	expectWritten(t, sub,
+		pushed(resp.BulkString("smessage"), resp.BulkString("orders"), resp.BulkString("o1")),
+	)
+
+	do(sub, "UNSUBSCRIBE")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("unsubscribe"), resp.BulkString("orders"), resp.Integer(0)),
+	)
+	// Still in subscriber mode through the shard channels.
+	expectReply(t, sub, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.Error("ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")},
+	})
+	do(sub, "SUNSUBSCRIBE", "orders")
+	do(sub, "SUNSUBSCRIBE", "{user1}.carts")
+	do(sub, "SUNSUBSCRIBE")
+	expectWritten(t, sub,
+		pushed(resp.BulkString("sunsubscribe"), resp.BulkString("orders"), resp.Integer(1)),
+		pushed(resp.BulkString("sunsubscribe"), resp.BulkString("{user1}.carts"), resp.Integer(0)),
+		pushed(resp.BulkString("sunsubscribe"), resp.NullBulk, resp.Integer(0)),
+	)
+	if len(c.srv.pubsub.subs[shardSub]) != 0 {
+		t.Errorf("shard channels = %v, want none left", c.srv.pubsub.subs[shardSub])
+	}
+}
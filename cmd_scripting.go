@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "eval", Arity: -3, Flags: flagNoScript | flagStale, Handler: evalCommand})
+	RegisterCommand(&Command{Name: "evalsha", Arity: -3, Flags: flagNoScript | flagStale, Handler: evalshaCommand})
+	RegisterCommand(&Command{Name: "script", Arity: -2, Flags: flagNoScript, Handler: scriptCommand})
+}
+
+// evalCommand implements EVAL script numkeys [key ...] [arg ...]. The
+// script is cached, so later calls can use EVALSHA with its SHA1 digest.
+func evalCommand(c *Client, args []string) resp.Value {
+	keys, argv, err := parseScriptArgs(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	sha, proto, err := c.srv.scripts.load(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return runScript(c, sha, proto, keys, argv)
+}
+
+// evalshaCommand implements EVALSHA sha1 numkeys [key ...] [arg ...],
+// running a script cached by EVAL.
+func evalshaCommand(c *Client, args []string) resp.Value {
+	keys, argv, err := parseScriptArgs(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	proto := c.srv.scripts.get(args[1])
+	if proto == nil {
+		return resp.Error("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return runScript(c, strings.ToLower(args[1]), proto, keys, argv)
+}
+
+// parseScriptArgs splits numkeys [key ...] [arg ...] into KEYS and ARGV.
+func parseScriptArgs(args []string) (keys, argv []string, err error) {
+	numkeys, ok := parseInt(args[0])
+	switch {
+	case !ok:
+		return nil, nil, errNotInteger
+	case numkeys < 0:
+		return nil, nil, errors.New("ERR Number of keys can't be negative")
+	case numkeys > int64(len(args)-1):
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+numkeys], args[1+numkeys:], nil
+}
+
+var scriptHelp = []string{
+	"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"EXISTS <sha1> [<sha1> ...]",
+	"    Return information about the existence of the scripts in the script cache.",
+	"FLUSH [ASYNC|SYNC]",
+	"    Flush the Lua scripts cache.",
+	"LOAD <script>",
+	"    Load a script into the scripts cache without executing it.",
+	"HELP",
+	"    Print this help.",
+}
+
+// scriptCommand implements SCRIPT LOAD, EXISTS, FLUSH and HELP, which
+// manage the cache of scripts EVALSHA runs.
+func scriptCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"load": 3, "exists": -3, "flush": -2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try SCRIPT HELP.", args[1]))
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) || (sub == "flush" && len(args) > 3) {
+		return wrongArityReply("script|" + sub)
+	}
+
+	scripts := c.srv.scripts
+	switch sub {
+	case "load":
+		sha, _, err := scripts.load(args[2])
+		if err != nil {
+			return errorReply(err)
+		}
+		return resp.BulkString(sha)
+	case "exists":
+		found := make([]resp.Value, len(args)-2)
+		for i, sha := range args[2:] {
+			found[i] = resp.Integer(0)
+			if scripts.get(sha) != nil {
+				found[i] = resp.Integer(1)
+			}
+		}
+		return resp.Array(found...)
+	case "flush":
+		// Compiled scripts are plain Go values, so ASYNC has nothing to
+		// hand to the background and behaves like SYNC.
+		if len(args) == 3 && !strings.EqualFold(args[2], "ASYNC") && !strings.EqualFold(args[2], "SYNC") {
+			return resp.Error("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		}
+		scripts.flush()
+		return resp.OK
+	default:
+		return resp.BulkStrings(scriptHelp)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestEval(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EVAL", "return 1", "0"}, resp.Integer(1)},
+		{[]string{"EVAL", "return {KEYS[1], ARGV[1], ARGV[2]}", "1", "k", "a", "b"}, resp.BulkStrings([]string{"k", "a", "b"})},
+		{[]string{"EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "k", "v"}, resp.OK},
+		{[]string{"EVAL", "return redis.call('GET', KEYS[1])", "1", "k"}, resp.BulkString("v")},
+		{[]string{"EVAL", "return redis.call('INCRBY', 'n', 5)", "0"}, resp.Integer(5)},
+		// Lua to Redis conversions.
+		{[]string{"EVAL", "return 3.99", "0"}, resp.Integer(3)},
+		{[]string{"EVAL", "return true", "0"}, resp.Integer(1)},
+		{[]string{"EVAL", "return false", "0"}, resp.NullBulk},
+		{[]string{"EVAL", "return nil", "0"}, resp.NullBulk},
+		{[]string{"EVAL", "return {1, 2, nil, 3}", "0"}, resp.Array(resp.Integer(1), resp.Integer(2))},
+		{[]string{"EVAL", "return redis.status_reply('FINE')", "0"}, resp.SimpleString("FINE")},
+		{[]string{"EVAL", "return redis.error_reply('MY error')", "0"}, resp.Error("MY error")},
+		// Redis to Lua conversions: a missing key is false, a status reply
+		// a table with an ok field.
+		{[]string{"EVAL", "return redis.call('GET', 'missing') == false", "0"}, resp.Integer(1)},
+		{[]string{"EVAL", "return redis.call('SET', 'k', 'v').ok", "0"}, resp.BulkString("OK")},
+		{[]string{"EVAL", "return redis.sha1hex('')", "0"}, resp.BulkString("da39a3ee5e6b4b0d3255bfef95601890afd80709")},
+		// Replies shaped for RESP3 reach the script as RESP2.
+		{[]string{"EVAL", "redis.call('HSET', 'h', 'f', 'v') return redis.call('HGETALL', 'h')", "0"}, resp.BulkStrings([]string{"f", "v"})},
+		// Blocking commands do not wait.
+		{[]string{"EVAL", "return redis.call('BLPOP', 'empty', 0)", "0"}, resp.NullBulk},
+	})
+}
+
+func TestEvalErrors(t *testing.T) {
+	c := newTestClient()
+	do(c, "LPUSH", "l", "x")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EVAL", "return 1", "x"}, notIntegerReply},
+		{[]string{"EVAL", "return 1", "-1"}, resp.Error("ERR Number of keys can't be negative")},
+		{[]string{"EVAL", "return 1", "2", "k"}, resp.Error("ERR Number of keys can't be greater than number of args")},
+		// redis.call raises error replies, redis.pcall returns them.
+		{[]string{"EVAL", "return redis.call('GET', 'l')", "0"}, wrongTypeReply},
+		{[]string{"EVAL", "return redis.pcall('GET', 'l')", "0"}, wrongTypeReply},
+		{[]string{"EVAL", "local r = redis.pcall('GET', 'l') return r.err ~= nil", "0"}, resp.Integer(1)},
+		{[]string{"EVAL", "return redis.call('NOSUCH')", "0"}, resp.Error("ERR Unknown Redis command called from script")},
+		{[]string{"EVAL", "return redis.call('EVAL', 'return 1', '0')", "0"}, resp.Error("ERR This Redis command is not allowed from script")},
+		{[]string{"EVAL", "return redis.call('GET')", "0"}, resp.Error("ERR Wrong number of args calling Redis command from script")},
+	})
+
+	for _, tc := range []struct {
+		script, want string
+	}{
+		{"return +", "ERR Error compiling script"},
+		{"x = 1", "Script attempted to create global variable 'x'"},
+		{"return y", "Script attempted to access nonexistent global variable 'y'"},
+		{"error('boom')", "boom"},
+		{"return dofile('/etc/passwd')", "nonexistent global variable 'dofile'"},
+	} {
+		got := do(c, "EVAL", tc.script, "0")
+		if !got.IsError() || !strings.Contains(got.Str, tc.want) {
+			t.Errorf("EVAL %q = %+v, want an error containing %q", tc.script, got, tc.want)
+		}
+	}
+}
+
+func TestEvalTimeLimit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.LuaTimeLimit = 50
+	srv := NewServer(cfg)
+	c, other := newClient(nil, srv), newClient(nil, srv)
+
+	// A script looping past the limit is aborted, keeping the writes it
+	// made before.
+	start := time.Now()
+	got := do(c, "EVAL", "redis.call('SET', 'k', 'v') while true do end", "0")
+	if !got.IsError() || !strings.Contains(got.Str, "lua-time-limit") {
+		t.Errorf("looping EVAL = %+v, want a lua-time-limit error", got)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("looping EVAL took %v, want about 50ms", d)
+	}
+	expectReply(t, other, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.BulkString("v")},
+		{[]string{"EVAL", "return 1", "0"}, resp.Integer(1)},
+	})
+	// So is a function, and its library still works afterwards.
+	lib := "#!lua name=loops\n" +
+		"redis.register_function('spin', function() while true do end end)\n" +
+		"redis.register_function('one', function() return 1 end)"
+	do(c, "FUNCTION", "LOAD", lib)
+	if got := do(c, "FCALL", "spin", "0"); !got.IsError() || !strings.Contains(got.Str, "lua-time-limit") {
+		t.Errorf("FCALL spin = %+v, want a lua-time-limit error", got)
+	}
+	if got := do(c, "FCALL", "one", "0"); !reflect.DeepEqual(got, resp.Integer(1)) {
+		t.Errorf("FCALL one = %+v, want 1", got)
+	}
+
+	// Other clients are answered with BUSY while such a script is being
+	// aborted.
+	srv.scriptBusy.Store(true)
+	if got := do(other, "GET", "k"); !reflect.DeepEqual(got, errBusyScript) {
+		t.Errorf("GET during an aborted script = %+v, want %+v", got, errBusyScript)
+	}
+	srv.scriptBusy.Store(false)
+}
+
+func TestEvalsha(t *testing.T) {
+	c := newTestClient()
+	const script = "return ARGV[1]"
+	sha := sha1hex(script)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EVALSHA", sha, "0", "a"}, resp.Error("NOSCRIPT No matching script. Please use EVAL.")},
+		{[]string{"EVAL", script, "0", "a"}, resp.BulkString("a")},
+		{[]string{"EVALSHA", sha, "0", "b"}, resp.BulkString("b")},
+		{[]string{"EVALSHA", strings.ToUpper(sha), "0", "c"}, resp.BulkString("c")},
+	})
+}
+
+func TestEvalInTransaction(t *testing.T) {
+	c := newTestClient()
+	other := newClient(nil, c.srv)
+	waiting := doBlocking(other, "BLPOP", "q", "0")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MULTI"}, resp.OK},
+		{[]string{"EVAL", "return redis.call('RPUSH', 'q', 'a')", "0"}, queued},
+		{[]string{"EXEC"}, resp.Array(resp.Integer(1))},
+	})
+	expectUnblocked(t, waiting, resp.Array(resp.BulkString("q"), resp.BulkString("a")))
+
+	// A script's writes serve blocked clients once it finishes.
+	waiting = doBlocking(other, "BLPOP", "q", "0")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"EVAL", "redis.call('RPUSH', 'q', 'b', 'c') return redis.call('LPOP', 'q')", "0"}, resp.BulkString("b")},
+	})
+	expectUnblocked(t, waiting, resp.Array(resp.BulkString("q"), resp.BulkString("c")))
+}
+
+func TestScriptCommand(t *testing.T) {
+	c := newTestClient()
+	const script = "return 'hi'"
+	sha := sha1hex(script)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SCRIPT", "LOAD", script}, resp.BulkString(sha)},
+		{[]string{"SCRIPT", "EXISTS", sha, "ffff", strings.ToUpper(sha)}, resp.Array(resp.Integer(1), resp.Integer(0), resp.Integer(1))},
+		{[]string{"EVALSHA", sha, "0"}, resp.BulkString("hi")},
+		{[]string{"SCRIPT", "FLUSH", "NOW"}, resp.Error("ERR SCRIPT FLUSH only support SYNC|ASYNC option")},
+		{[]string{"SCRIPT", "FLUSH", "ASYNC"}, resp.OK},
+		{[]string{"SCRIPT", "EXISTS", sha}, resp.Array(resp.Integer(0))},
+		{[]string{"EVALSHA", sha, "0"}, resp.Error("NOSCRIPT No matching script. Please use EVAL.")},
+		{[]string{"SCRIPT", "EXISTS"}, wrongArityReply("script|exists")},
+		{[]string{"SCRIPT", "NOPE"}, resp.Error("ERR unknown subcommand 'NOPE'. Try SCRIPT HELP.")},
+	})
+	if got := do(c, "SCRIPT", "LOAD", "return +"); !got.IsError() {
+		t.Errorf("SCRIPT LOAD of a broken script = %+v, want an error", got)
+	}
+}
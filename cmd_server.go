@@ -0,0 +1,616 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "dbsize", Arity: 1, Flags: flagReadonly | flagFast, Handler: dbsizeCommand})
+	RegisterCommand(&Command{Name: "flushdb", Arity: -1, Flags: flagWrite, Handler: flushCommand})
+	RegisterCommand(&Command{Name: "flushall", Arity: -1, Flags: flagWrite, Handler: flushCommand})
+	RegisterCommand(&Command{Name: "monitor", Arity: 1, Flags: flagAdmin | flagNoScript | flagLoading | flagStale | flagNoMulti, Handler: monitorCommand})
+	RegisterCommand(&Command{Name: "slowlog", Arity: -2, Flags: flagAdmin | flagLoading | flagStale, Handler: slowlogCommand})
+	RegisterCommand(&Command{Name: "latency", Arity: -2, Flags: flagAdmin | flagNoScript | flagLoading | flagStale, Handler: latencyCommand})
+	RegisterCommand(&Command{Name: "command", Arity: -1, Flags: flagLoading | flagStale, Handler: commandCommand})
+	RegisterCommand(&Command{Name: "save", Arity: 1, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: saveCommand})
+	RegisterCommand(&Command{Name: "bgsave", Arity: -1, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: bgsaveCommand})
+	RegisterCommand(&Command{Name: "lastsave", Arity: 1, Flags: flagFast | flagLoading | flagStale, Handler: lastsaveCommand})
+	RegisterCommand(&Command{Name: "bgrewriteaof", Arity: 1, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: bgrewriteaofCommand})
+	// DEBUG takes the store lock itself, like SAVE.
+	RegisterCommand(&Command{Name: "debug", Arity: -2, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: debugCommand})
+	RegisterCommand(&Command{Name: "shutdown", Arity: -1, Flags: flagAdmin | flagNoScript | flagNoMulti | flagLoading | flagStale, Handler: shutdownCommand})
+	RegisterCommand(&Command{Name: "info", Arity: -1, Flags: flagReadonly | flagLoading | flagStale, Handler: infoCommand})
+	RegisterCommand(&Command{Name: "wait", Arity: 3, Flags: flagNoScript | flagBlocking, Handler: waitCommand})
+}
+
+// monitorCommand implements MONITOR, which streams a line for every
+// command the server runs to the connection until it disconnects or sends
+// RESET. See monitor.go.
+func monitorCommand(c *Client, args []string) resp.Value {
+	c.srv.monitors.add(c)
+	return noReply
+}
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+// slowlogCommand implements SLOWLOG GET [count], LEN, RESET and HELP. See
+// slowlog.go.
+func slowlogCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"get": -2, "len": 2, "reset": 2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Errorf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", args[1])
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) || (sub == "get" && len(args) > 3) {
+		return wrongArityReply("slowlog|" + sub)
+	}
+
+	switch sub {
+	case "get":
+		count := int64(10)
+		if len(args) == 3 {
+			var ok bool
+			if count, ok = parseInt(args[2]); !ok || count < -1 {
+				return resp.Error("ERR count should be greater than or equal to -1")
+			}
+		}
+		entries := c.srv.slowlog.latest(int(count))
+		vals := make([]resp.Value, len(entries))
+		for i, e := range entries {
+			vals[i] = resp.Array(
+				resp.Integer(e.id),
+				resp.Integer(e.at.Unix()),
+				resp.Integer(e.duration.Microseconds()),
+				resp.BulkStrings(e.args),
+				resp.BulkString(e.addr),
+				resp.BulkString(e.name),
+			)
+		}
+		return resp.Array(vals...)
+	case "len":
+		return resp.Integer(int64(c.srv.slowlog.len()))
+	case "reset":
+		c.srv.slowlog.reset()
+		return resp.OK
+	default:
+		return resp.BulkStrings(slowlogHelp)
+	}
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HELP",
+	"    Print this help.",
+}
+
+// latencyCommand implements LATENCY LATEST, HISTORY event, RESET
+// [event ...], DOCTOR and HELP, which report on the latency spikes seen
+// by the latency monitor. See latency.go.
+func latencyCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"latest": 2, "history": 3, "reset": -2, "doctor": 2, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Errorf("ERR unknown subcommand '%s'. Try LATENCY HELP.", args[1])
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return wrongArityReply("latency|" + sub)
+	}
+
+	monitor := c.srv.latency
+	switch sub {
+	case "latest":
+		names, latest, worst := monitor.latest()
+		vals := make([]resp.Value, len(names))
+		for i, name := range names {
+			vals[i] = resp.Array(
+				resp.BulkString(name),
+				resp.Integer(latest[i].at.Unix()),
+				resp.Integer(latest[i].latency.Milliseconds()),
+				resp.Integer(worst[i].Milliseconds()),
+			)
+		}
+		return resp.Array(vals...)
+	case "history":
+		samples := monitor.history(args[2])
+		vals := make([]resp.Value, len(samples))
+		for i, s := range samples {
+			vals[i] = resp.Array(resp.Integer(s.at.Unix()), resp.Integer(s.latency.Milliseconds()))
+		}
+		return resp.Array(vals...)
+	case "reset":
+		return resp.Integer(int64(monitor.reset(args[2:])))
+	case "doctor":
+		return resp.Value{Type: resp.TypeVerbatim, Str: "txt:" + monitor.doctor()}
+	default:
+		return resp.BulkStrings(latencyHelp)
+	}
+}
+
+var commandHelp = []string{
+	"COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"(no subcommand)",
+	"    Return details about all Redis commands.",
+	"COUNT",
+	"    Return the total number of commands in this Redis server.",
+	"DOCS [<command-name> ...]",
+	"    Return documentation details about multiple Redis commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"GETKEYS <full-command>",
+	"    Return the keys from a full Redis command.",
+	"INFO [<command-name> ...]",
+	"    Return details about multiple Redis commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"HELP",
+	"    Print this help.",
+}
+
+// commandCommand implements COMMAND and its subcommands COUNT, INFO,
+// DOCS, GETKEYS and HELP, which describe the command table so that
+// clients can learn, for instance, where the keys of each command are.
+func commandCommand(c *Client, args []string) resp.Value {
+	if len(args) == 1 {
+		return commandInfos(sortedCommandNames())
+	}
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"count": 2, "info": -2, "docs": -2, "getkeys": -3, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Errorf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[1])
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return wrongArityReply("command|" + sub)
+	}
+
+	switch sub {
+	case "count":
+		return resp.Integer(int64(len(commandTable)))
+	case "info":
+		names := args[2:]
+		if len(names) == 0 {
+			names = sortedCommandNames()
+		}
+		return commandInfos(names)
+	case "docs":
+		// There are no docs in the command table, so each command gets an
+		// empty map, as Redis gives commands without docs.
+		names := args[2:]
+		if len(names) == 0 {
+			names = sortedCommandNames()
+		}
+		var kvs []resp.Value
+		for _, name := range names {
+			if cmd := lookupCommand(name); cmd != nil {
+				kvs = append(kvs, resp.BulkString(cmd.Name), resp.Map())
+			}
+		}
+		return resp.Map(kvs...)
+	case "getkeys":
+		cmd := lookupCommand(args[2])
+		switch {
+		case cmd == nil:
+			return resp.Error("ERR Invalid command specified")
+		case !cmd.checkArity(len(args) - 2):
+			return resp.Error("ERR Invalid number of arguments specified for command")
+		}
+		keys := cmd.keys(args[2:])
+		if len(keys) == 0 {
+			return resp.Error("ERR The command has no key arguments")
+		}
+		return resp.BulkStrings(keys)
+	default:
+		return resp.BulkStrings(commandHelp)
+	}
+}
+
+// sortedCommandNames returns the names of every command, sorted.
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// commandInfos describes the named commands, with a null for unknown ones.
+func commandInfos(names []string) resp.Value {
+	infos := make([]resp.Value, len(names))
+	for i, name := range names {
+		if cmd := lookupCommand(name); cmd != nil {
+			infos[i] = cmd.info()
+		} else {
+			infos[i] = resp.NullArray
+		}
+	}
+	return resp.Array(infos...)
+}
+
+// info describes cmd the way COMMAND INFO does in Redis 7: name, arity,
+// flags, key positions, ACL categories, tips, key specs and subcommands.
+// The ACL categories and key specs are derived from the flags and key
+// positions; tips and subcommands are not modelled and are left empty.
+func (cmd *Command) info() resp.Value {
+	var flags, categories []resp.Value
+	for _, name := range cmd.Flags.names() {
+		flags = append(flags, resp.SimpleString(name))
+	}
+	for _, cat := range []struct {
+		flag commandFlags
+		name string
+	}{
+		{flagWrite, "@write"},
+		{flagReadonly, "@read"},
+		{flagAdmin, "@admin"},
+		{flagAdmin, "@dangerous"},
+		{flagPubSub, "@pubsub"},
+		{flagBlocking, "@blocking"},
+	} {
+		if cmd.Flags&cat.flag != 0 {
+			categories = append(categories, resp.SimpleString(cat.name))
+		}
+	}
+	if cmd.Flags&flagFast != 0 {
+		categories = append(categories, resp.SimpleString("@fast"))
+	} else {
+		categories = append(categories, resp.SimpleString("@slow"))
+	}
+
+	specs := []resp.Value{}
+	if cmd.FirstKey > 0 {
+		access := []string{"RW"}
+		if cmd.Flags&flagWrite == 0 {
+			access = []string{"RO", "ACCESS"}
+		}
+		// The last key of a range spec counts from the first one, unless it
+		// counts from the end.
+		last := cmd.LastKey
+		if last >= 0 {
+			last -= cmd.FirstKey
+		}
+		specs = append(specs, resp.Map(
+			resp.BulkString("flags"), resp.Set(resp.BulkStrings(access).Array...),
+			resp.BulkString("begin_search"), resp.Map(
+				resp.BulkString("type"), resp.BulkString("index"),
+				resp.BulkString("spec"), resp.Map(resp.BulkString("index"), resp.Integer(int64(cmd.FirstKey))),
+			),
+			resp.BulkString("find_keys"), resp.Map(
+				resp.BulkString("type"), resp.BulkString("range"),
+				resp.BulkString("spec"), resp.Map(
+					resp.BulkString("lastkey"), resp.Integer(int64(last)),
+					resp.BulkString("keystep"), resp.Integer(int64(max(cmd.Step, 1))),
+					resp.BulkString("limit"), resp.Integer(0),
+				),
+			),
+		))
+	}
+
+	return resp.Array(
+		resp.BulkString(cmd.Name),
+		resp.Integer(int64(cmd.Arity)),
+		resp.Set(flags...),
+		resp.Integer(int64(cmd.FirstKey)),
+		resp.Integer(int64(cmd.LastKey)),
+		resp.Integer(int64(cmd.Step)),
+		resp.Set(categories...),
+		resp.Array(),
+		resp.Array(specs...),
+		resp.Array(),
+	)
+}
+
+// dbsizeCommand implements DBSIZE. Like Redis it counts keys that have
+// expired but not been reclaimed yet.
+func dbsizeCommand(c *Client, args []string) resp.Value {
+	return resp.Integer(int64(len(c.store.data)))
+}
+
+// flushCommand implements FLUSHDB [ASYNC | SYNC] and FLUSHALL [ASYNC | SYNC],
+// which are the same thing with a single database. Either way the store is
+// swapped for an empty one in constant time; ASYNC also moves the work of
+// tearing down the old contents to the background reclaimer.
+func flushCommand(c *Client, args []string) resp.Value {
+	async := false
+	switch {
+	case len(args) == 1:
+	case len(args) == 2 && strings.EqualFold(args[1], "ASYNC"):
+		async = true
+	case len(args) == 2 && strings.EqualFold(args[1], "SYNC"):
+	default:
+		return syntaxErrorReply
+	}
+
+	old := c.store.flush()
+	if async {
+		lazyfree.free(old)
+	} else {
+		release(old)
+	}
+	return resp.OK
+}
+
+// saveCommand implements SAVE, which writes a snapshot of the store to
+// the dbfilename. Writes wait until it is done. See rdb.go.
+func saveCommand(c *Client, args []string) resp.Value {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	if err := c.srv.saveSync(); err != nil {
+		if err == errBgsaveInProgress {
+			return errorReply(err)
+		}
+		log.Printf("Error saving DB on disk: %v", err)
+		return resp.Error("ERR " + err.Error())
+	}
+	return resp.OK
+}
+
+// bgsaveCommand implements BGSAVE [SCHEDULE], which copies the store and
+// writes the copy to the dbfilename in the background. Unlike Redis it
+// cannot be queued in a transaction, as it takes the store lock itself.
+// With nothing else to wait for, SCHEDULE starts the save at once.
+func bgsaveCommand(c *Client, args []string) resp.Value {
+	if len(args) > 2 || len(args) == 2 && !strings.EqualFold(args[1], "SCHEDULE") {
+		return syntaxErrorReply
+	}
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	if err := c.srv.bgsave(); err != nil {
+		return errorReply(err)
+	}
+	return resp.SimpleString("Background saving started")
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE], which saves the data
+// to the dbfilename, syncs and closes the AOF and has Serve return, so the
+// process exits. Without an argument the data is saved if there are save
+// rules. A BGSAVE or AOF rewrite in progress is waited for, and the store
+// is left locked, so no write is acknowledged after the final save. If
+// saving fails the server goes on running, and on success the client gets
+// no reply.
+func shutdownCommand(c *Client, args []string) resp.Value {
+	save := len(c.srv.cfg.SaveRules) > 0
+	var saveGiven, noSaveGiven bool
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			save, saveGiven = true, true
+		case "NOSAVE":
+			save, noSaveGiven = false, true
+		default:
+			return syntaxErrorReply
+		}
+	}
+	if saveGiven && noSaveGiven {
+		return syntaxErrorReply
+	}
+
+	srv := c.srv
+	// Both need the store's lock to finish.
+	srv.rdb.done.Wait()
+	srv.aofRewrite.done.Wait()
+	c.store.mu.Lock()
+	log.Print("User requested shutdown...")
+	if save {
+		log.Print("Saving the final RDB snapshot before exiting.")
+		if err := srv.saveSync(); err != nil {
+			c.store.mu.Unlock()
+			log.Printf("Error trying to save the DB, can't exit: %v", err)
+			return resp.Error("ERR Errors trying to SHUTDOWN. Check logs.")
+		}
+	}
+	if srv.aof != nil {
+		log.Print("Calling fsync() on the AOF file.")
+		if err := srv.aof.close(); err != nil {
+			log.Printf("Error closing the AOF: %v", err)
+		}
+		srv.aof = nil
+	}
+	srv.shutdownOnce.Do(func() { close(srv.shutdown) })
+	return noReply
+}
+
+// lastsaveCommand implements LASTSAVE, replying with the Unix time of the
+// last successful save, or of the server start before the first.
+func lastsaveCommand(c *Client, args []string) resp.Value {
+	st := c.srv.rdb
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return resp.Integer(st.lastSave.Unix())
+}
+
+var debugHelp = []string{
+	"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"RELOAD [option ...]",
+	"    Save the RDB on disk and reload it back to memory. Valid <option> values:",
+	"    * MERGE: conflicting keys will be loaded from RDB.",
+	"    * NOFLUSH: the existing database will not be removed before load.",
+	"    * NOSAVE: the database will be loaded from an existing RDB file.",
+	"HELP",
+	"    Print this help.",
+}
+
+// debugCommand implements DEBUG RELOAD [MERGE] [NOFLUSH] [NOSAVE] and
+// DEBUG HELP. RELOAD saves the store to the dbfilename and loads it back
+// in place of the store's content, which shows whether every value
+// survives a round trip to disk. NOSAVE loads the file as it is, and
+// NOFLUSH keeps the keys in the store. Keys loaded always replace those of
+// the same name, so MERGE, which asks for that, changes nothing.
+func debugCommand(c *Client, args []string) resp.Value {
+	switch strings.ToLower(args[1]) {
+	case "reload":
+	case "help":
+		if len(args) == 2 {
+			return resp.BulkStrings(debugHelp)
+		}
+		return wrongArityReply("debug|help")
+	default:
+		return resp.Errorf("ERR unknown subcommand '%s'. Try DEBUG HELP.", args[1])
+	}
+
+	save, flush := true, true
+	for _, opt := range args[2:] {
+		switch strings.ToUpper(opt) {
+		case "MERGE":
+		case "NOFLUSH":
+			flush = false
+		case "NOSAVE":
+			save = false
+		default:
+			return syntaxErrorReply
+		}
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	if save {
+		if err := c.srv.saveSync(); err != nil {
+			if err == errBgsaveInProgress {
+				return errorReply(err)
+			}
+			log.Printf("Error saving DB on disk: %v", err)
+			return resp.Error("ERR " + err.Error())
+		}
+	}
+	f, err := os.Open(c.srv.cfg.dbPath())
+	if err == nil {
+		defer f.Close()
+		if flush {
+			release(c.store.flush())
+		}
+		err = c.store.readSnapshot(f)
+	}
+	if err != nil {
+		log.Printf("Error loading %s: %v", c.srv.cfg.dbPath(), err)
+		return resp.Error("ERR Error trying to load the RDB dump, check server logs.")
+	}
+	// What was just loaded is on disk already.
+	c.srv.rdb.mu.Lock()
+	c.srv.rdb.savedDirty = c.store.dirty
+	c.srv.rdb.mu.Unlock()
+	return resp.OK
+}
+
+// bgrewriteaofCommand implements BGREWRITEAOF, which rewrites the
+// appendfilename in the background as the shortest commands rebuilding
+// the current dataset. Like BGSAVE it takes the store lock itself, so it
+// cannot be queued in a transaction.
+func bgrewriteaofCommand(c *Client, args []string) resp.Value {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+	if err := c.srv.bgrewriteaof(); err != nil {
+		return errorReply(err)
+	}
+	return resp.SimpleString("Background append only file rewriting started")
+}
+
+// infoSections are the sections of INFO, in the order they are reported.
+var infoSections = []struct {
+	name  string
+	write func(srv *Server, w io.Writer)
+}{
+	{"server", func(srv *Server, w io.Writer) {
+		uptime := time.Since(srv.started)
+		fmt.Fprintf(w, "redis_version:%s\r\n", serverVersion)
+		fmt.Fprint(w, "redis_mode:standalone\r\n")
+		fmt.Fprintf(w, "process_id:%d\r\n", os.Getpid())
+		fmt.Fprintf(w, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+		fmt.Fprintf(w, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	}},
+	{"clients", func(srv *Server, w io.Writer) {
+		srv.clientsMu.Lock()
+		defer srv.clientsMu.Unlock()
+		fmt.Fprintf(w, "connected_clients:%d\r\n", len(srv.clients))
+	}},
+	{"persistence", func(srv *Server, w io.Writer) {
+		srv.rdb.info(w, srv.store.dirty)
+		srv.aofInfo(w)
+	}},
+	{"replication", func(srv *Server, w io.Writer) {
+		srv.repl.info(w)
+	}},
+	{"keyspace", func(srv *Server, w io.Writer) {
+		if n := len(srv.store.data); n > 0 {
+			fmt.Fprintf(w, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", n, len(srv.store.expires))
+		}
+	}},
+}
+
+// infoCommand implements INFO [section ...], which reports on the server
+// in "field:value" lines grouped under "# Section" headers. With no
+// section, or default, all or everything, every section is reported. It is
+// flagged readonly so the store is locked for the keyspace section.
+func infoCommand(c *Client, args []string) resp.Value {
+	all := len(args) == 1
+	want := make(map[string]bool)
+	for _, arg := range args[1:] {
+		switch name := strings.ToLower(arg); name {
+		case "default", "all", "everything":
+			all = true
+		default:
+			want[name] = true
+		}
+	}
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && !want[sec.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(sec.name[:1])+sec.name[1:])
+		sec.write(c.srv, &b)
+	}
+	return resp.Value{Type: resp.TypeVerbatim, Str: "txt:" + b.String()}
+}
+
+// waitCommand implements WAIT numreplicas timeout, which waits until the
+// client's writes so far reach numreplicas replicas, or timeout
+// milliseconds pass (0 waiting for ever), and replies with how many
+// replicas have them, as they acknowledge when asked with REPLCONF GETACK.
+func waitCommand(c *Client, args []string) resp.Value {
+	numreplicas, ok := parseInt(args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	timeout, err := parseTimeoutMillis(args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if c.srv.repl.isReplica.Load() {
+		return resp.Error("ERR WAIT cannot be used with replica instances.")
+	}
+	return c.srv.repl.waitForAcks(c, args, int(numreplicas), timeout)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestDBSizeAndFlush(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"DBSIZE"}, resp.Integer(0)},
+		{[]string{"MSET", "a", "1", "b", "2", "c", "3"}, resp.OK},
+		{[]string{"EXPIRE", "c", "100"}, resp.Integer(1)},
+		{[]string{"DBSIZE"}, resp.Integer(3)},
+		{[]string{"FLUSHDB"}, resp.OK},
+		{[]string{"DBSIZE"}, resp.Integer(0)},
+		{[]string{"SET", "a", "1", "EX", "100"}, resp.OK},
+		{[]string{"FLUSHALL", "ASYNC"}, resp.OK},
+		{[]string{"EXISTS", "a"}, resp.Integer(0)},
+		{[]string{"SET", "a", "1"}, resp.OK},
+		{[]string{"RANDOMKEY"}, resp.BulkString("a")},
+		{[]string{"FLUSHDB", "sync"}, resp.OK},
+		{[]string{"RANDOMKEY"}, resp.NullBulk},
+		{[]string{"FLUSHDB", "LATER"}, syntaxErrorReply},
+		{[]string{"FLUSHALL", "ASYNC", "SYNC"}, syntaxErrorReply},
+	})
+	lazyfree.wait()
+
+	if len(c.store.expires) != 0 {
+		t.Errorf("flush left %d keys in the expires index", len(c.store.expires))
+	}
+}
+
+func TestWait(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"WAIT", "0", "0"}, resp.Integer(0)},
+		{[]string{"WAIT", "1", "10"}, resp.Integer(0)},
+		{[]string{"WAIT", "x", "10"}, notIntegerReply},
+		{[]string{"WAIT", "1", "-1"}, resp.Error("ERR timeout is negative")},
+	})
+	if len(c.store.waiters) != 0 {
+		t.Errorf("waiters = %v, want none left", c.store.waiters)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	mon := newTestClient()
+	c := newClient(nil, mon.srv)
+	if got := do(mon, "MONITOR"); got.Type != 0 {
+		t.Fatalf("MONITOR replied %+v, want its reply written", got)
+	}
+	expectWritten(t, mon, resp.OK)
+
+	do(c, "SET", "k", "a \"b\"\n\x01")
+	do(c, "MULTI")
+	do(c, "GET", "k")
+	do(c, "EXEC")
+	do(c, "EVAL", "return redis.call('GET', KEYS[1])", "1", "k")
+	do(c, "MONITOR")
+
+	// Queued commands are fed when EXEC runs them, admin commands such as
+	// MONITOR not at all.
+	want := []string{
+		`[0 ] "SET" "k" "a \"b\"\n\x01"`,
+		`[0 ] "MULTI"`,
+		`[0 ] "GET" "k"`,
+		`[0 ] "EXEC"`,
+		`[0 lua] "GET" "k"`,
+		`[0 ] "EVAL" "return redis.call('GET', KEYS[1])" "1" "k"`,
+	}
+	stamp := regexp.MustCompile(`^\d+\.\d{6} `)
+	got := written(t, mon)
+	if len(got) != len(want) {
+		t.Fatalf("monitor got %d lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i, line := range got {
+		if !stamp.MatchString(line.Str) || stamp.ReplaceAllString(line.Str, "") != want[i] {
+			t.Errorf("line %d = %q, want a timestamp then %q", i, line.Str, want[i])
+		}
+	}
+
+	do(mon, "RESET")
+	do(c, "PING")
+	expectWritten(t, mon)
+}
+
+func TestSlowlog(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SlowlogLogSlowerThan = 0
+	cfg.SlowlogMaxLen = 3
+	c := newClient(nil, NewServer(cfg))
+	c.name = "worker"
+
+	do(c, "SET", "k", strings.Repeat("x", 200))
+	do(c, "GET", "k")
+	args := []string{"EXISTS"}
+	for i := 0; i < 40; i++ {
+		args = append(args, "k")
+	}
+	do(c, args...)
+	do(c, "PING")
+
+	// The log keeps the last three commands, newest first.
+	got := do(c, "SLOWLOG", "GET", "-1")
+	if len(got.Array) != 3 {
+		t.Fatalf("SLOWLOG GET -1 returned %d entries, want 3", len(got.Array))
+	}
+	newest, exists := got.Array[0].Array, got.Array[1].Array
+	if newest[0].Int != 3 || !reflect.DeepEqual(newest[3], resp.BulkStrings([]string{"PING"})) || newest[5].Str != "worker" {
+		t.Errorf("newest entry = %+v, want PING with ID 3 by worker", newest)
+	}
+	if n := len(exists[3].Array); n != slowlogMaxArgs || exists[3].Array[n-1].Str != "... (10 more arguments)" {
+		t.Errorf("EXISTS entry arguments = %+v, want %d ending with the count left out", exists[3], slowlogMaxArgs)
+	}
+	if got := do(c, "SLOWLOG", "GET", "1"); len(got.Array) != 1 || got.Array[0].Array[3].Array[0].Str != "SLOWLOG" {
+		t.Errorf("SLOWLOG GET 1 = %+v, want the previous SLOWLOG GET", got)
+	}
+
+	do(c, "SLOWLOG", "RESET")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SLOWLOG", "LEN"}, resp.Integer(1)},
+		{[]string{"SLOWLOG", "GET", "-2"}, resp.Error("ERR count should be greater than or equal to -1")},
+		{[]string{"SLOWLOG", "NOPE"}, resp.Error("ERR unknown subcommand 'NOPE'. Try SLOWLOG HELP.")},
+	})
+}
+
+func TestLatency(t *testing.T) {
+	c := newTestClient()
+	if got := do(c, "LATENCY", "DOCTOR"); !strings.Contains(got.Str, "Latency monitoring is disabled") {
+		t.Errorf("LATENCY DOCTOR = %q, want it to say monitoring is disabled", got.Str)
+	}
+
+	cfg := defaultConfig()
+	cfg.LatencyMonitorThreshold = 10
+	c = newClient(nil, NewServer(cfg))
+	monitor := c.srv.latency
+	at := time.Unix(1700000000, 0)
+	monitor.now = func() time.Time { return at }
+	monitor.add(latencyCommandEvent, 5*time.Millisecond)
+	monitor.add(latencyCommandEvent, 20*time.Millisecond)
+	monitor.add(latencyCommandEvent, 15*time.Millisecond)
+	monitor.add(latencyExpireCycleEvent, 30*time.Millisecond)
+
+	// Spikes in the same second merge into one sample, the worst.
+	got := do(c, "LATENCY", "HISTORY", "command")
+	if len(got.Array) != 1 || got.Array[0].Array[0].Int != at.Unix() || got.Array[0].Array[1].Int != 20 {
+		t.Errorf("LATENCY HISTORY command = %+v, want one 20ms sample", got)
+	}
+	got = do(c, "LATENCY", "LATEST")
+	if len(got.Array) != 2 || got.Array[0].Array[0].Str != "command" || got.Array[1].Array[3].Int != 30 {
+		t.Errorf("LATENCY LATEST = %+v, want command and expire-cycle, the latter worst at 30ms", got)
+	}
+	if got := do(c, "LATENCY", "DOCTOR"); !strings.Contains(got.Str, "1. command: 1 latency spikes (average 20ms") {
+		t.Errorf("LATENCY DOCTOR = %q, want a line about command", got.Str)
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"LATENCY", "RESET", "command", "nope"}, resp.Integer(1)},
+		{[]string{"LATENCY", "HISTORY", "command"}, resp.Array()},
+		{[]string{"LATENCY", "RESET"}, resp.Integer(1)},
+		{[]string{"LATENCY", "LATEST"}, resp.Array()},
+		{[]string{"LATENCY", "HISTORY"}, wrongArityReply("latency|history")},
+	})
+}
+
+func TestCommand(t *testing.T) {
+	c := newTestClient()
+	if got := do(c, "COMMAND"); len(got.Array) != len(commandTable) {
+		t.Errorf("COMMAND described %d commands, want %d", len(got.Array), len(commandTable))
+	}
+	got := do(c, "COMMAND", "INFO", "get", "nope", "ping")
+	if len(got.Array) != 3 || !got.Array[1].IsNull() {
+		t.Fatalf("COMMAND INFO get nope ping = %+v, want two infos around a null", got)
+	}
+	get := got.Array[0].Array
+	wantHead := []resp.Value{
+		resp.BulkString("get"), resp.Integer(2),
+		resp.Set(resp.SimpleString("readonly"), resp.SimpleString("fast")),
+		resp.Integer(1), resp.Integer(1), resp.Integer(1),
+		resp.Set(resp.SimpleString("@read"), resp.SimpleString("@fast")),
+	}
+	if !reflect.DeepEqual(get[:len(wantHead)], wantHead) {
+		t.Errorf("COMMAND INFO get = %+v, want it to start with %+v", get, wantHead)
+	}
+	if specs := got.Array[2].Array[8]; len(specs.Array) != 0 {
+		t.Errorf("ping has key specs %+v, want none", specs)
+	}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"COMMAND", "COUNT"}, resp.Integer(int64(len(commandTable)))},
+		{[]string{"COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"COMMAND", "GETKEYS", "BLPOP", "a", "b", "0"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"COMMAND", "GETKEYS", "PING"}, resp.Error("ERR The command has no key arguments")},
+		{[]string{"COMMAND", "GETKEYS", "GET"}, resp.Error("ERR Invalid number of arguments specified for command")},
+		{[]string{"COMMAND", "GETKEYS", "NOPE", "x"}, resp.Error("ERR Invalid command specified")},
+		{[]string{"COMMAND", "DOCS", "get", "nope"}, resp.Map(resp.BulkString("get"), resp.Map())},
+	})
+}
+
+func TestInfo(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "a", "1")
+	do(c, "SET", "b", "1", "EX", "100")
+
+	info := do(c, "INFO").Str
+	if !strings.HasPrefix(info, "txt:# Server\r\nredis_version:"+serverVersion+"\r\n") {
+		t.Errorf("INFO starts with %q", info[:min(len(info), 60)])
+	}
+	for _, want := range []string{"\r\n\r\n# Clients\r\nconnected_clients:1\r\n", "\r\n# Persistence\r\nloading:0\r\n", "\r\n# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("INFO lacks %q:\n%s", want, info)
+		}
+	}
+	if got := do(c, "INFO", "KEYSPACE", "nosuchsection").Str; got != "txt:# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\n" {
+		t.Errorf("INFO KEYSPACE = %q", got)
+	}
+	if got := do(c, "INFO", "everything"); got.Str != info {
+		t.Errorf("INFO everything = %q, want %q", got.Str, info)
+	}
+}
+
+func TestDebugReload(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	long := strings.Repeat("abc", 100)
+	for _, args := range [][]string{
+		{"SET", "str", long},
+		{"SET", "ttl", "x", "PX", "100000"},
+		{"RPUSH", "list", "a", long, "1"},
+		{"SADD", "set", "x", "2"},
+		{"HSET", "hash", "f", "1", "g", long},
+		{"HPEXPIRE", "hash", "100000", "FIELDS", "1", "g"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "STREAMS", "stream", ">"},
+	} {
+		if reply := do(c, args...); reply.Type == resp.TypeError {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	reads := [][]string{
+		{"GET", "str"},
+		{"PEXPIRETIME", "ttl"},
+		{"LRANGE", "list", "0", "-1"},
+		{"SMISMEMBER", "set", "x", "2"},
+		{"HMGET", "hash", "f", "g"},
+		{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		{"XRANGE", "stream", "-", "+"},
+		{"DBSIZE"},
+	}
+	want := make([]resp.Value, len(reads))
+	for i, args := range reads {
+		want[i] = do(c, args...)
+	}
+	before := map[string]string{}
+	for _, key := range []string{"hash", "stream"} {
+		before[key] = internals(c.store.data[key])
+	}
+
+	if got := do(c, "DEBUG", "RELOAD"); !reflect.DeepEqual(got, resp.OK) {
+		t.Fatalf("DEBUG RELOAD = %+v", got)
+	}
+	for i, args := range reads {
+		if got := do(c, args...); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("after DEBUG RELOAD, %q = %+v, want %+v", args, got, want[i])
+		}
+	}
+	for key, w := range before {
+		if g := internals(c.store.data[key]); g != w {
+			t.Errorf("after DEBUG RELOAD, %s has %q, want %q", key, g, w)
+		}
+	}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		// NOSAVE loads the file as it is, which drops what was not saved,
+		// unless NOFLUSH keeps it.
+		{[]string{"SET", "new", "v"}, resp.OK},
+		{[]string{"DEL", "str"}, resp.Integer(1)},
+		{[]string{"DEBUG", "RELOAD", "NOSAVE", "NOFLUSH", "MERGE"}, resp.OK},
+		{[]string{"EXISTS", "str", "new"}, resp.Integer(2)},
+		{[]string{"DEBUG", "RELOAD", "NOSAVE"}, resp.OK},
+		{[]string{"EXISTS", "str", "new"}, resp.Integer(1)},
+		{[]string{"DEBUG", "RELOAD", "NOW"}, syntaxErrorReply},
+		{[]string{"DEBUG", "NOPE"}, resp.Error("ERR unknown subcommand 'NOPE'. Try DEBUG HELP.")},
+	})
+
+	// Without a file, nothing is flushed.
+	c.srv.cfg.DBFilename += ".missing"
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"DEBUG", "RELOAD", "NOSAVE"}, resp.Error("ERR Error trying to load the RDB dump, check server logs.")},
+		{[]string{"DBSIZE"}, want[len(want)-1]},
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		args  []string
+		rules []saveRule
+		saved bool
+	}{
+		{[]string{"SHUTDOWN"}, defaultSaveRules, true},
+		{[]string{"SHUTDOWN"}, nil, false},
+		{[]string{"SHUTDOWN", "save"}, nil, true},
+		{[]string{"SHUTDOWN", "NOSAVE"}, defaultSaveRules, false},
+	} {
+		srv := newAppendOnlyServer(t)
+		srv.cfg.SaveRules = tc.rules
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() { served <- srv.Serve(ln) }()
+		c := newClient(nil, srv)
+		do(c, "SET", "k", "v")
+		expectReply(t, c, []struct {
+			args []string
+			want resp.Value
+		}{
+			{[]string{"SHUTDOWN", "SAVE", "NOSAVE"}, syntaxErrorReply},
+			{[]string{"SHUTDOWN", "NOW"}, syntaxErrorReply},
+		})
+		if got := do(c, tc.args...); got.Type != 0 {
+			t.Errorf("%q = %+v, want no reply", tc.args, got)
+		}
+		if err := <-served; err != errShutdown {
+			t.Errorf("after %q, Serve returned %v", tc.args, err)
+		}
+		if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			t.Errorf("after %q, the listener still accepts connections", tc.args)
+		}
+		if _, err := os.Stat(srv.cfg.dbPath()); (err == nil) != tc.saved {
+			t.Errorf("after %q with save rules %v, saved = %v", tc.args, tc.rules, err == nil)
+		}
+		// The AOF was synced and closed either way.
+		if cmds := appendOnlyCommands(t, srv); len(cmds) != 1 || srv.aof != nil {
+			t.Errorf("after %q, the AOF holds %q and is open: %v", tc.args, cmds, srv.aof != nil)
+		}
+	}
+
+	// If the final save fails, the server keeps running.
+	c := newClient(nil, newSnapshotServer(t))
+	c.srv.cfg.Dir = filepath.Join(t.TempDir(), "missing")
+	if got := do(c, "SHUTDOWN", "SAVE"); !reflect.DeepEqual(got, resp.Error("ERR Errors trying to SHUTDOWN. Check logs.")) {
+		t.Errorf("SHUTDOWN SAVE failing = %+v", got)
+	}
+	if got := do(c, "SET", "k", "v"); !reflect.DeepEqual(got, resp.OK) {
+		t.Errorf("SET after a failed SHUTDOWN = %+v", got)
+	}
+	select {
+	case <-c.srv.shutdown:
+		t.Error("the server shut down after failing to save")
+	default:
+	}
+}
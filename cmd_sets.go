@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "sadd", Arity: -3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: saddCommand})
+	RegisterCommand(&Command{Name: "srem", Arity: -3, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: sremCommand})
+	RegisterCommand(&Command{Name: "smembers", Arity: 2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: smembersCommand})
+	RegisterCommand(&Command{Name: "sismember", Arity: 3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: sismemberCommand})
+	RegisterCommand(&Command{Name: "scard", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: scardCommand})
+	RegisterCommand(&Command{Name: "sinter", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraCommand})
+	RegisterCommand(&Command{Name: "sunion", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraCommand})
+	RegisterCommand(&Command{Name: "sdiff", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraCommand})
+	RegisterCommand(&Command{Name: "sinterstore", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "sunionstore", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "sdiffstore", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 1, Handler: setAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "sintercard", Arity: -3, Flags: flagReadonly, Handler: sintercardCommand})
+	RegisterCommand(&Command{Name: "smismember", Arity: -3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: smismemberCommand})
+	RegisterCommand(&Command{Name: "spop", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: spopCommand})
+	RegisterCommand(&Command{Name: "srandmember", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: srandmemberCommand})
+}
+
+// setReply builds the reply for a collection of members: a set for RESP3
+// clients, an array for RESP2 ones.
+func setReply(members []string) resp.Value {
+	return resp.Set(resp.BulkStrings(members).Array...)
+}
+
+// saddCommand implements SADD key member [member ...], replying with the
+// number of members that were not already in the set.
+func saddCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	s, err := c.store.lookupSet(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if s == nil {
+		s = newSet()
+		c.store.put(key, StoreData{value: s})
+	}
+	var added int64
+	for _, m := range args[2:] {
+		if _, ok := s.members[m]; !ok {
+			s.members[m] = struct{}{}
+			added++
+		}
+	}
+	if added > 0 {
+		c.store.signalModified(key)
+	}
+	return resp.Integer(added)
+}
+
+// sremCommand implements SREM key member [member ...]. A set left empty is
+// deleted.
+func sremCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	s, err := c.store.lookupSet(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if s == nil {
+		return resp.Integer(0)
+	}
+	var removed int64
+	for _, m := range args[2:] {
+		if _, ok := s.members[m]; ok {
+			delete(s.members, m)
+			removed++
+		}
+	}
+	if len(s.members) == 0 {
+		c.store.remove(key)
+	} else if removed > 0 {
+		c.store.signalModified(key)
+	}
+	return resp.Integer(removed)
+}
+
+// smembersCommand implements SMEMBERS key.
+func smembersCommand(c *Client, args []string) resp.Value {
+	s, err := c.store.lookupSet(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply(s.list())
+}
+
+// sismemberCommand implements SISMEMBER key member.
+func sismemberCommand(c *Client, args []string) resp.Value {
+	s, err := c.store.lookupSet(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if s.has(args[2]) {
+		return resp.Integer(1)
+	}
+	return resp.Integer(0)
+}
+
+// scardCommand implements SCARD key.
+func scardCommand(c *Client, args []string) resp.Value {
+	s, err := c.store.lookupSet(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(int64(s.size()))
+}
+
+// setOp is one of the set algebra operations.
+type setOp int
+
+const (
+	setInter setOp = iota
+	setUnion
+	setDiff
+)
+
+// setOpFor maps a command name, with or without the STORE suffix, to its
+// operation.
+func setOpFor(name string) setOp {
+	switch name := strings.ToLower(name); {
+	case strings.HasPrefix(name, "sinter"):
+		return setInter
+	case strings.HasPrefix(name, "sunion"):
+		return setUnion
+	default:
+		return setDiff
+	}
+}
+
+// computeSetOp applies op to the sets at keys, treating missing keys as
+// empty sets. For setDiff the result is the first set minus all the others.
+// It fails with errWrongType if any key holds another type.
+func computeSetOp(st *Store, op setOp, keys []string) (map[string]struct{}, error) {
+	sets := make([]*setValue, len(keys))
+	for i, key := range keys {
+		s, err := st.lookupSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = s
+	}
+
+	result := make(map[string]struct{})
+	switch op {
+	case setInter:
+		// Walk the smallest set and probe the others.
+		smallest := sets[0]
+		for _, s := range sets {
+			if s.size() < smallest.size() {
+				smallest = s
+			}
+		}
+		if smallest == nil {
+			return result, nil
+		}
+	members:
+		for m := range smallest.members {
+			for _, s := range sets {
+				if !s.has(m) {
+					continue members
+				}
+			}
+			result[m] = struct{}{}
+		}
+	case setUnion:
+		for _, s := range sets {
+			if s != nil {
+				for m := range s.members {
+					result[m] = struct{}{}
+				}
+			}
+		}
+	case setDiff:
+		if sets[0] == nil {
+			return result, nil
+		}
+	diff:
+		for m := range sets[0].members {
+			for _, s := range sets[1:] {
+				if s.has(m) {
+					continue diff
+				}
+			}
+			result[m] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+// setAlgebraCommand implements SINTER, SUNION and SDIFF key [key ...].
+func setAlgebraCommand(c *Client, args []string) resp.Value {
+	result, err := computeSetOp(c.store, setOpFor(args[0]), args[1:])
+	if err != nil {
+		return errorReply(err)
+	}
+	return setReply((&setValue{members: result}).list())
+}
+
+// setAlgebraStoreCommand implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+// destination key [key ...]. The destination is overwritten, or deleted if
+// the result is empty, and the reply is the size of the result.
+func setAlgebraStoreCommand(c *Client, args []string) resp.Value {
+	dst := args[1]
+	result, err := computeSetOp(c.store, setOpFor(args[0]), args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(result) == 0 {
+		c.store.remove(dst)
+		return resp.Integer(0)
+	}
+	c.store.put(dst, StoreData{value: &setValue{members: result}})
+	return resp.Integer(int64(len(result)))
+}
+
+// smismemberCommand implements SMISMEMBER key member [member ...].
+func smismemberCommand(c *Client, args []string) resp.Value {
+	s, err := c.store.lookupSet(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	replies := make([]resp.Value, 0, len(args)-2)
+	for _, m := range args[2:] {
+		if s.has(m) {
+			replies = append(replies, resp.Integer(1))
+		} else {
+			replies = append(replies, resp.Integer(0))
+		}
+	}
+	return resp.Array(replies...)
+}
+
+// sintercardCommand implements SINTERCARD numkeys key [key ...]
+// [LIMIT limit]. It counts the intersection without building it, stopping
+// early once limit members have been found; a limit of 0 means no limit.
+func sintercardCommand(c *Client, args []string) resp.Value {
+	numkeys, ok := parseInt(args[1])
+	if !ok {
+		return notIntegerReply
+	}
+	if numkeys <= 0 {
+		return resp.Error("ERR numkeys should be greater than 0")
+	}
+	if numkeys > int64(len(args)-2) {
+		return resp.Error("ERR Number of keys can't be greater than number of args")
+	}
+	keys := args[2 : 2+numkeys]
+	rest := args[2+numkeys:]
+	var limit int64
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 2 && strings.EqualFold(rest[0], "LIMIT"):
+		if limit, ok = parseInt(rest[1]); !ok {
+			return notIntegerReply
+		}
+		if limit < 0 {
+			return resp.Error("ERR LIMIT can't be negative")
+		}
+	default:
+		return syntaxErrorReply
+	}
+
+	sets := make([]*setValue, len(keys))
+	for i, key := range keys {
+		s, err := c.store.lookupSet(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		sets[i] = s
+	}
+	smallest := sets[0]
+	for _, s := range sets {
+		if s.size() < smallest.size() {
+			smallest = s
+		}
+	}
+	if smallest == nil {
+		return resp.Integer(0)
+	}
+	var n int64
+members:
+	for m := range smallest.members {
+		for _, s := range sets {
+			if !s.has(m) {
+				continue members
+			}
+		}
+		n++
+		if n == limit {
+			break
+		}
+	}
+	return resp.Integer(n)
+}
+
+// parseSetCount parses the count argument of SPOP and SRANDMEMBER.
+func parseSetCount(arg string, allowNegative bool) (int64, error) {
+	n, ok := parseInt(arg)
+	if !allowNegative && (!ok || n < 0) {
+		return 0, errNotPositive
+	}
+	if !ok {
+		return 0, errNotInteger
+	}
+	if n < -math.MaxInt64/2 {
+		return 0, errors.New("ERR value is out of range")
+	}
+	return n, nil
+}
+
+// spopCommand implements SPOP key [count], removing and returning random
+// members. A set left empty is deleted.
+func spopCommand(c *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxErrorReply
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		var err error
+		if count, err = parseSetCount(args[2], false); err != nil {
+			return errorReply(err)
+		}
+	}
+	key := args[1]
+	s, err := c.store.lookupSet(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if s == nil {
+		if len(args) == 3 {
+			return setReply(nil)
+		}
+		return resp.NullBulk
+	}
+
+	members := s.list()
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	popped := members[:min(count, int64(len(members)))]
+	for _, m := range popped {
+		delete(s.members, m)
+	}
+	if len(s.members) == 0 {
+		c.store.remove(key)
+	} else if len(popped) > 0 {
+		c.store.signalModified(key)
+	}
+	// The members are picked at random, so the AOF gets which ones.
+	c.propagateAs(append([]string{"SREM", key}, popped...))
+	if len(args) == 2 {
+		return resp.BulkString(popped[0])
+	}
+	return setReply(popped)
+}
+
+// srandmemberCommand implements SRANDMEMBER key [count]. A positive count
+// returns up to count distinct members; a negative one returns exactly
+// -count members, possibly repeated.
+func srandmemberCommand(c *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxErrorReply
+	}
+	var count int64
+	if len(args) == 3 {
+		var err error
+		if count, err = parseSetCount(args[2], true); err != nil {
+			return errorReply(err)
+		}
+	}
+	s, err := c.store.lookupSet(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	members := s.list()
+	if len(args) == 2 {
+		if len(members) == 0 {
+			return resp.NullBulk
+		}
+		return resp.BulkString(members[rand.IntN(len(members))])
+	}
+	if len(members) == 0 {
+		return resp.Array()
+	}
+	if count >= 0 {
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		return resp.BulkStrings(members[:min(count, int64(len(members)))])
+	}
+	picked := make([]string, -count)
+	for i := range picked {
+		picked[i] = members[rand.IntN(len(members))]
+	}
+	return resp.BulkStrings(picked)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestSetBasics(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SADD", "tags", "go", "redis", "go"}, resp.Integer(2)},
+		{[]string{"SADD", "tags", "go", "tcp"}, resp.Integer(1)},
+		{[]string{"SCARD", "tags"}, resp.Integer(3)},
+		{[]string{"SCARD", "missing"}, resp.Integer(0)},
+		{[]string{"SISMEMBER", "tags", "go"}, resp.Integer(1)},
+		{[]string{"SISMEMBER", "tags", "rust"}, resp.Integer(0)},
+		{[]string{"SISMEMBER", "missing", "go"}, resp.Integer(0)},
+		{[]string{"TYPE", "tags"}, resp.SimpleString("set")},
+		{[]string{"SREM", "tags", "go", "rust"}, resp.Integer(1)},
+		{[]string{"SMEMBERS", "missing"}, resp.Set()},
+	})
+
+	members := do(c, "SMEMBERS", "tags")
+	if members.Type != resp.TypeSet {
+		t.Errorf("SMEMBERS reply type = %v, want a set", members.Type)
+	}
+	if got := sortedStrs(members); !reflect.DeepEqual(got, []string{"redis", "tcp"}) {
+		t.Errorf("SMEMBERS = %q", got)
+	}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SREM", "tags", "redis", "tcp"}, resp.Integer(2)},
+		{[]string{"EXISTS", "tags"}, resp.Integer(0)},
+		{[]string{"SREM", "tags", "x"}, resp.Integer(0)},
+		{[]string{"SET", "s", "v"}, resp.OK},
+		{[]string{"SADD", "s", "x"}, wrongTypeReply},
+		{[]string{"SREM", "s", "x"}, wrongTypeReply},
+		{[]string{"SMEMBERS", "s"}, wrongTypeReply},
+		{[]string{"SISMEMBER", "s", "x"}, wrongTypeReply},
+		{[]string{"SCARD", "s"}, wrongTypeReply},
+	})
+}
+
+func TestSetAlgebra(t *testing.T) {
+	c := newTestClient()
+	do(c, "SADD", "a", "1", "2", "3", "4")
+	do(c, "SADD", "b", "3", "4", "5")
+	do(c, "SADD", "c", "4", "5", "6")
+	do(c, "SET", "s", "v")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"SINTER", "a", "b"}, []string{"3", "4"}},
+		{[]string{"SINTER", "a", "b", "c"}, []string{"4"}},
+		{[]string{"SINTER", "a", "missing"}, []string{}},
+		{[]string{"SUNION", "a", "b", "missing"}, []string{"1", "2", "3", "4", "5"}},
+		{[]string{"SDIFF", "a", "b"}, []string{"1", "2"}},
+		{[]string{"SDIFF", "a", "b", "c"}, []string{"1", "2"}},
+		{[]string{"SDIFF", "missing", "a"}, []string{}},
+		{[]string{"SDIFF", "a"}, []string{"1", "2", "3", "4"}},
+	}
+	for _, tt := range tests {
+		if got := sortedStrs(do(c, tt.args...)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SINTER", "a", "s"}, wrongTypeReply},
+		{[]string{"SUNION", "s"}, wrongTypeReply},
+		{[]string{"SDIFF", "a", "s"}, wrongTypeReply},
+		{[]string{"SINTERSTORE", "dst", "a", "b"}, resp.Integer(2)},
+		{[]string{"SCARD", "dst"}, resp.Integer(2)},
+		{[]string{"SUNIONSTORE", "a", "a", "c"}, resp.Integer(6)},
+		{[]string{"SCARD", "a"}, resp.Integer(6)},
+		{[]string{"SDIFFSTORE", "s", "b", "c"}, resp.Integer(1)},
+		{[]string{"TYPE", "s"}, resp.SimpleString("set")},
+		{[]string{"SINTERSTORE", "s", "b", "missing"}, resp.Integer(0)},
+		{[]string{"EXISTS", "s"}, resp.Integer(0)},
+		{[]string{"SET", "str", "v"}, resp.OK},
+		{[]string{"SUNIONSTORE", "dst", "a", "str"}, wrongTypeReply},
+		{[]string{"SCARD", "dst"}, resp.Integer(2)},
+	})
+}
+
+func TestSetSamplingAndCounting(t *testing.T) {
+	c := newTestClient()
+	do(c, "SADD", "a", "1", "2", "3", "4")
+	do(c, "SADD", "b", "2", "3", "4", "5")
+	do(c, "SET", "s", "v")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SMISMEMBER", "a", "1", "9", "4"}, ints(1, 0, 1)},
+		{[]string{"SMISMEMBER", "missing", "1"}, ints(0)},
+		{[]string{"SINTERCARD", "2", "a", "b"}, resp.Integer(3)},
+		{[]string{"SINTERCARD", "2", "a", "b", "LIMIT", "2"}, resp.Integer(2)},
+		{[]string{"SINTERCARD", "2", "a", "b", "LIMIT", "0"}, resp.Integer(3)},
+		{[]string{"SINTERCARD", "1", "a"}, resp.Integer(4)},
+		{[]string{"SINTERCARD", "2", "a", "missing"}, resp.Integer(0)},
+		{[]string{"SINTERCARD", "0", "a"}, resp.Error("ERR numkeys should be greater than 0")},
+		{[]string{"SINTERCARD", "3", "a", "b"}, resp.Error("ERR Number of keys can't be greater than number of args")},
+		{[]string{"SINTERCARD", "2", "a", "b", "LIMIT", "-1"}, resp.Error("ERR LIMIT can't be negative")},
+		{[]string{"SINTERCARD", "2", "a", "b", "BOGUS"}, syntaxErrorReply},
+		{[]string{"SINTERCARD", "2", "a", "s"}, wrongTypeReply},
+		{[]string{"SPOP", "missing"}, resp.NullBulk},
+		{[]string{"SPOP", "missing", "2"}, resp.Set()},
+		{[]string{"SPOP", "a", "-1"}, resp.Error("ERR value is out of range, must be positive")},
+		{[]string{"SPOP", "a", "x"}, resp.Error("ERR value is out of range, must be positive")},
+		{[]string{"SRANDMEMBER", "missing"}, resp.NullBulk},
+		{[]string{"SRANDMEMBER", "missing", "-3"}, resp.Array()},
+		{[]string{"SRANDMEMBER", "a", "x"}, notIntegerReply},
+		{[]string{"SRANDMEMBER", "a", "0"}, resp.Array()},
+		{[]string{"SPOP", "s"}, wrongTypeReply},
+		{[]string{"SRANDMEMBER", "s"}, wrongTypeReply},
+		{[]string{"SMISMEMBER", "s", "x"}, wrongTypeReply},
+	})
+
+	if got := sortedStrs(do(c, "SRANDMEMBER", "a", "10")); !reflect.DeepEqual(got, []string{"1", "2", "3", "4"}) {
+		t.Errorf("SRANDMEMBER 10 = %q", got)
+	}
+	if got := do(c, "SRANDMEMBER", "a", "-7"); len(got.Array) != 7 {
+		t.Errorf("SRANDMEMBER -7 returned %d members", len(got.Array))
+	}
+	if do(c, "SCARD", "a").Int != 4 {
+		t.Error("SRANDMEMBER changed the set")
+	}
+
+	popped := map[string]bool{do(c, "SPOP", "a").Str: true}
+	for _, v := range do(c, "SPOP", "a", "2").Array {
+		popped[v.Str] = true
+	}
+	if len(popped) != 3 || do(c, "SCARD", "a").Int != 1 {
+		t.Errorf("SPOP popped %v, leaving %d", popped, do(c, "SCARD", "a").Int)
+	}
+	do(c, "SPOP", "a", "5")
+	if do(c, "EXISTS", "a").Int != 0 {
+		t.Error("SPOP left an empty set behind")
+	}
+}
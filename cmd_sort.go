@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "sort", Arity: -2, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: sortCommand})
+}
+
+// sortOptions are the arguments SORT takes after the key.
+type sortOptions struct {
+	by     string // "" to sort by the elements themselves
+	noSort bool   // BY pattern without '*'
+	gets   []string
+	offset int64
+	count  int64 // -1 for all
+	desc   bool
+	alpha  bool
+	store  string
+}
+
+// parseSortOptions parses [BY pattern] [LIMIT offset count]
+// [GET pattern ...] [ASC|DESC] [ALPHA] [STORE destination].
+func parseSortOptions(args []string) (sortOptions, error) {
+	opts := sortOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "ASC":
+			opts.desc = false
+		case "DESC":
+			opts.desc = true
+		case "ALPHA":
+			opts.alpha = true
+		case "LIMIT":
+			if more < 2 {
+				return opts, errSyntax
+			}
+			offset, ok1 := parseInt(args[i+1])
+			count, ok2 := parseInt(args[i+2])
+			if !ok1 || !ok2 {
+				return opts, errNotInteger
+			}
+			opts.offset, opts.count = max(offset, 0), count
+			if count < 0 {
+				opts.count = -1
+			}
+			i += 2
+		case "BY":
+			if more < 1 {
+				return opts, errSyntax
+			}
+			i++
+			opts.by = args[i]
+			opts.noSort = !strings.Contains(opts.by, "*")
+		case "GET":
+			if more < 1 {
+				return opts, errSyntax
+			}
+			i++
+			opts.gets = append(opts.gets, args[i])
+		case "STORE":
+			if more < 1 {
+				return opts, errSyntax
+			}
+			i++
+			opts.store = args[i]
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+var errSortScore = errors.New("ERR One or more scores can't be converted into double")
+
+// sortCommand implements SORT key [BY pattern] [LIMIT offset count]
+// [GET pattern ...] [ASC|DESC] [ALPHA] [STORE destination]. It sorts the
+// elements of a list, set or sorted set, numerically unless ALPHA is given,
+// by their own value or by the values of the keys BY names, and replies
+// with them or with the values of the GET patterns. With STORE the result
+// is saved as a list at destination instead, and its length returned.
+func sortCommand(c *Client, args []string) resp.Value {
+	opts, err := parseSortOptions(args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	var elems []string
+	d, ok := c.store.lookup(args[1])
+	if ok {
+		switch v := d.value.(type) {
+		case *listValue:
+			elems = v.Range(0, v.Len())
+		case *setValue:
+			elems = v.list()
+			if opts.noSort {
+				// Sets have no order of their own; sort them anyway so
+				// the reply does not depend on map iteration.
+				opts.noSort, opts.by, opts.alpha = false, "", true
+			}
+		case *zsetValue:
+			elems = make([]string, 0, v.size())
+			for x := v.zsl.first(); x != nil; x = x.next() {
+				elems = append(elems, x.member)
+			}
+		default:
+			return wrongTypeReply
+		}
+	}
+
+	if !opts.noSort {
+		if err := sortElements(c.store, elems, opts); err != nil {
+			return errorReply(err)
+		}
+	} else if opts.desc {
+		slices.Reverse(elems)
+	}
+
+	lo := min(opts.offset, int64(len(elems)))
+	hi := int64(len(elems))
+	if opts.count >= 0 {
+		hi = min(lo+opts.count, hi)
+	}
+	elems = elems[lo:hi]
+
+	var out []resp.Value
+	var stored []string
+	for _, elem := range elems {
+		if len(opts.gets) == 0 {
+			out = append(out, resp.BulkString(elem))
+			stored = append(stored, elem)
+			continue
+		}
+		for _, pattern := range opts.gets {
+			v, ok := sortLookup(c.store, pattern, elem)
+			if ok {
+				out = append(out, resp.BulkString(v))
+			} else {
+				out = append(out, resp.NullBulk)
+			}
+			stored = append(stored, v)
+		}
+	}
+
+	if opts.store == "" {
+		return resp.Array(out...)
+	}
+	if len(stored) == 0 {
+		c.store.remove(opts.store)
+		return resp.Integer(0)
+	}
+	l := &listValue{}
+	for _, v := range stored {
+		l.PushBack(v)
+	}
+	c.store.put(opts.store, StoreData{value: l})
+	return resp.Integer(int64(len(stored)))
+}
+
+// sortElements sorts elems in place as opts asks. Ties are broken by
+// comparing the elements, which keeps the result deterministic.
+func sortElements(s *Store, elems []string, opts sortOptions) error {
+	type item struct {
+		elem   string
+		weight string
+		hasVal bool
+		score  float64
+	}
+	items := make([]item, len(elems))
+	for i, elem := range elems {
+		it := item{elem: elem, weight: elem, hasVal: true}
+		if opts.by != "" {
+			it.weight, it.hasVal = sortLookup(s, opts.by, elem)
+		}
+		if !opts.alpha && it.hasVal {
+			score, ok := parseFloat(it.weight)
+			if !ok {
+				return errSortScore
+			}
+			it.score = score
+		}
+		items[i] = it
+	}
+
+	slices.SortFunc(items, func(a, b item) int {
+		var cmp int
+		switch {
+		case !opts.alpha:
+			// A missing weight counts as 0.
+			switch {
+			case a.score < b.score:
+				cmp = -1
+			case a.score > b.score:
+				cmp = 1
+			}
+		case !a.hasVal || !b.hasVal:
+			// Missing weights sort first.
+			switch {
+			case a.hasVal:
+				cmp = 1
+			case b.hasVal:
+				cmp = -1
+			}
+		default:
+			cmp = strings.Compare(a.weight, b.weight)
+		}
+		if cmp == 0 {
+			cmp = strings.Compare(a.elem, b.elem)
+		}
+		if opts.desc {
+			cmp = -cmp
+		}
+		return cmp
+	})
+	for i, it := range items {
+		elems[i] = it.elem
+	}
+	return nil
+}
+
+// sortLookup resolves a BY or GET pattern for elem. "#" stands for elem
+// itself; otherwise the first '*' in pattern is replaced by elem to name a
+// string key, or, with a "->field" suffix, a field of a hash key. ok is
+// false if the pattern has no '*' or the key, field or value of the right
+// type is missing.
+func sortLookup(s *Store, pattern, elem string) (string, bool) {
+	if pattern == "#" {
+		return elem, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern = pattern[:star+1+arrow]
+		field = pattern[star+1+arrow+2:]
+	}
+	key := keyPattern[:star] + elem + keyPattern[star+1:]
+
+	if field == "" {
+		d, ok, err := s.lookupString(key)
+		if !ok || err != nil {
+			return "", false
+		}
+		return string(d.bytes()), true
+	}
+	h, err := s.lookupHash(key)
+	if h == nil || err != nil {
+		return "", false
+	}
+	return h.get(field)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestSort(t *testing.T) {
+	c := newTestClient()
+	do(c, "RPUSH", "nums", "3", "10", "1", "2")
+	do(c, "RPUSH", "words", "pear", "apple", "fig")
+	do(c, "SADD", "ids", "2", "1", "3")
+	do(c, "ZADD", "z", "1", "c", "2", "b", "3", "a")
+	do(c, "MSET", "w_1", "30", "w_2", "10", "w_3", "20", "name_1", "one", "name_3", "three")
+	do(c, "HSET", "user_1", "age", "40")
+	do(c, "HSET", "user_2", "age", "20")
+	do(c, "HSET", "user_3", "age", "30")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SORT", "nums"}, resp.BulkStrings([]string{"1", "2", "3", "10"})},
+		{[]string{"SORT", "nums", "DESC"}, resp.BulkStrings([]string{"10", "3", "2", "1"})},
+		{[]string{"SORT", "nums", "ALPHA"}, resp.BulkStrings([]string{"1", "10", "2", "3"})},
+		{[]string{"SORT", "nums", "LIMIT", "1", "2"}, resp.BulkStrings([]string{"2", "3"})},
+		{[]string{"SORT", "nums", "LIMIT", "-5", "-1"}, resp.BulkStrings([]string{"1", "2", "3", "10"})},
+		{[]string{"SORT", "nums", "LIMIT", "9", "1"}, resp.Array()},
+		{[]string{"SORT", "words"}, errorReply(errSortScore)},
+		{[]string{"SORT", "words", "ALPHA", "DESC"}, resp.BulkStrings([]string{"pear", "fig", "apple"})},
+		{[]string{"SORT", "ids"}, resp.BulkStrings([]string{"1", "2", "3"})},
+		{[]string{"SORT", "z", "ALPHA"}, resp.BulkStrings([]string{"a", "b", "c"})},
+		{[]string{"SORT", "missing"}, resp.Array()},
+		{[]string{"SORT", "w_1"}, wrongTypeReply},
+
+		// BY and GET patterns, with hash fields and missing keys.
+		{[]string{"SORT", "ids", "BY", "w_*"}, resp.BulkStrings([]string{"2", "3", "1"})},
+		{[]string{"SORT", "ids", "BY", "user_*->age", "DESC"}, resp.BulkStrings([]string{"1", "3", "2"})},
+		{[]string{"SORT", "ids", "BY", "name_*", "ALPHA"}, resp.BulkStrings([]string{"2", "1", "3"})},
+		{[]string{"SORT", "ids", "BY", "missing_*"}, resp.BulkStrings([]string{"1", "2", "3"})},
+		{[]string{"SORT", "ids", "BY", "name_*"}, errorReply(errSortScore)},
+		{[]string{"SORT", "ids", "GET", "#", "GET", "name_*", "GET", "user_*->age"}, resp.Array(
+			resp.BulkString("1"), resp.BulkString("one"), resp.BulkString("40"),
+			resp.BulkString("2"), resp.NullBulk, resp.BulkString("20"),
+			resp.BulkString("3"), resp.BulkString("three"), resp.BulkString("30"),
+		)},
+
+		// A BY pattern without '*' skips sorting.
+		{[]string{"SORT", "nums", "BY", "nosort"}, resp.BulkStrings([]string{"3", "10", "1", "2"})},
+		{[]string{"SORT", "z", "BY", "nosort", "DESC", "LIMIT", "0", "2"}, resp.BulkStrings([]string{"a", "b"})},
+		{[]string{"SORT", "words", "BY", "nosort", "GET", "nokey"}, resp.Array(resp.NullBulk, resp.NullBulk, resp.NullBulk)},
+
+		{[]string{"SORT", "nums", "STORE", "out", "DESC", "GET", "name_*"}, resp.Integer(4)},
+		{[]string{"LRANGE", "out", "0", "-1"}, resp.BulkStrings([]string{"", "three", "", "one"})},
+		{[]string{"SORT", "missing", "STORE", "out"}, resp.Integer(0)},
+		{[]string{"EXISTS", "out"}, resp.Integer(0)},
+
+		{[]string{"SORT", "nums", "LIMIT", "1"}, syntaxErrorReply},
+		{[]string{"SORT", "nums", "LIMIT", "a", "1"}, notIntegerReply},
+		{[]string{"SORT", "nums", "BY"}, syntaxErrorReply},
+		{[]string{"SORT", "nums", "SIDEWAYS"}, syntaxErrorReply},
+	})
+}
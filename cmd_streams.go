@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "xadd", Arity: -5, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: xaddCommand})
+	RegisterCommand(&Command{Name: "xtrim", Arity: -4, Flags: flagWrite, FirstKey: 1, LastKey: 1, Step: 1, Handler: xtrimCommand})
+	RegisterCommand(&Command{Name: "xlen", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: xlenCommand})
+	RegisterCommand(&Command{Name: "xrange", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: xrangeCommand})
+	RegisterCommand(&Command{Name: "xrevrange", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: xrangeCommand})
+	RegisterCommand(&Command{Name: "xread", Arity: -4, Flags: flagReadonly | flagBlocking, Handler: xreadCommand})
+	RegisterCommand(&Command{Name: "xreadgroup", Arity: -7, Flags: flagWrite | flagBlocking, Handler: xreadgroupCommand})
+	RegisterCommand(&Command{Name: "xgroup", Arity: -2, Flags: flagWrite, FirstKey: 2, LastKey: 2, Step: 1, Handler: xgroupCommand})
+	RegisterCommand(&Command{Name: "xack", Arity: -4, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: xackCommand})
+	RegisterCommand(&Command{Name: "xpending", Arity: -3, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: xpendingCommand})
+	RegisterCommand(&Command{Name: "xclaim", Arity: -6, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: xclaimCommand})
+	RegisterCommand(&Command{Name: "xautoclaim", Arity: -6, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: xautoclaimCommand})
+}
+
+// entryReply builds the [id, [field, value, ...]] reply for an entry.
+func entryReply(e streamEntry) resp.Value {
+	return resp.Array(resp.BulkString(e.id.String()), resp.BulkStrings(e.fields))
+}
+
+func entriesReply(entries []streamEntry) resp.Value {
+	out := make([]resp.Value, len(entries))
+	for i, e := range entries {
+		out[i] = entryReply(e)
+	}
+	return resp.Array(out...)
+}
+
+// parseStreamTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] at
+// the start of args and returns the number of arguments it used. An
+// approximate trim without LIMIT removes at most 100 nodes at a time.
+func parseStreamTrim(c *Client, args []string) (streamTrim, int, error) {
+	var t streamTrim
+	t.byMinID = strings.EqualFold(args[0], "MINID")
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		t.approx = args[i] == "~"
+		i++
+	}
+	if i == len(args) {
+		return t, i, errSyntax
+	}
+	if t.byMinID {
+		var err error
+		if t.minID, err = parseStreamID(args[i], 0); err != nil {
+			return t, i, err
+		}
+	} else {
+		var ok bool
+		if t.maxLen, ok = parseInt(args[i]); !ok {
+			return t, i, errNotInteger
+		}
+		if t.maxLen < 0 {
+			return t, i, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(args[i], "LIMIT") {
+		if !t.approx {
+			return t, i, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		var ok bool
+		if t.limit, ok = parseInt(args[i+1]); !ok {
+			return t, i, errNotInteger
+		}
+		if t.limit < 0 {
+			return t, i, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		return t, i + 2, nil
+	}
+	if t.approx {
+		t.limit = 100 * int64(max(c.srv.cfg.StreamNodeMaxEntries, 1))
+	}
+	return t, i, nil
+}
+
+var errTrimStrategies = errors.New("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+
+// xaddCommand implements XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~]
+// threshold [LIMIT count]] <* | id> field value [field value ...],
+// replying with the ID of the new entry. The ID may be "*" to generate one
+// from the clock, "ms-*" to pick only the sequence number, or given in
+// full, and must be greater than the stream's last ID. The stream is
+// trimmed after the entry is added.
+func xaddCommand(c *Client, args []string) resp.Value {
+	var nomkstream, hasTrim bool
+	var trim streamTrim
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			nomkstream = true
+		case "MAXLEN", "MINID":
+			if hasTrim {
+				return errorReply(errTrimStrategies)
+			}
+			t, n, err := parseStreamTrim(c, args[i:])
+			if err != nil {
+				return errorReply(err)
+			}
+			trim, hasTrim = t, true
+			i += n - 1
+		default:
+			break options
+		}
+	}
+	if i >= len(args) {
+		return syntaxErrorReply
+	}
+	idArg, fields := args[i], args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return wrongArityReply("xadd")
+	}
+
+	// Validate the ID before looking at the stream.
+	var id streamID
+	var autoSeq bool
+	switch {
+	case idArg == "*":
+	case strings.HasSuffix(idArg, "-*"):
+		var err error
+		if id, err = parseStreamID(strings.TrimSuffix(idArg, "-*"), 0); err != nil {
+			return errorReply(err)
+		}
+		autoSeq = true
+	default:
+		var err error
+		if id, err = parseStreamID(idArg, 0); err != nil {
+			return errorReply(err)
+		}
+		if id == (streamID{}) {
+			return resp.Error("ERR The ID specified in XADD must be greater than 0-0")
+		}
+	}
+
+	key := args[1]
+	s, err := c.store.lookupStream(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	created := s == nil
+	if created {
+		if nomkstream {
+			return resp.NullBulk
+		}
+		s = newStream()
+	}
+
+	switch {
+	case idArg == "*":
+		if id, err = s.nextID(time.Now()); err != nil {
+			return errorReply(err)
+		}
+	case autoSeq && id.ms == s.lastID.ms:
+		var ok bool
+		if id, ok = s.lastID.next(); !ok || id.ms != s.lastID.ms {
+			return errorReply(errStreamTopItem)
+		}
+	}
+	if id.compare(s.lastID) <= 0 {
+		return errorReply(errStreamTopItem)
+	}
+	if created {
+		c.store.put(key, StoreData{value: s})
+	}
+	s.add(id, append([]string(nil), fields...))
+	c.store.signalReady(key)
+	c.store.signalModified(key)
+	if hasTrim {
+		s.trim(trim, c.srv.cfg.StreamNodeMaxEntries)
+	}
+	if idArg == "*" || autoSeq {
+		logged := slices.Clone(args)
+		logged[i] = id.String()
+		c.propagateAs(logged)
+	}
+	return resp.BulkString(id.String())
+}
+
+// xtrimCommand implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT
+// count], replying with the number of entries removed.
+func xtrimCommand(c *Client, args []string) resp.Value {
+	opt := strings.ToUpper(args[2])
+	if opt != "MAXLEN" && opt != "MINID" {
+		return syntaxErrorReply
+	}
+	trim, n, err := parseStreamTrim(c, args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+	if rest := args[2+n:]; len(rest) > 0 {
+		if opt := strings.ToUpper(rest[0]); opt == "MAXLEN" || opt == "MINID" {
+			return errorReply(errTrimStrategies)
+		}
+		return syntaxErrorReply
+	}
+	s, err := c.store.lookupStream(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if s == nil {
+		return resp.Integer(0)
+	}
+	removed := s.trim(trim, c.srv.cfg.StreamNodeMaxEntries)
+	if removed > 0 {
+		c.store.signalModified(args[1])
+	}
+	return resp.Integer(int64(removed))
+}
+
+var errStreamTopItem = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+// xlenCommand implements XLEN key.
+func xlenCommand(c *Client, args []string) resp.Value {
+	s, err := c.store.lookupStream(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(int64(s.length()))
+}
+
+// parseRangeID parses one end of an XRANGE interval: "-" and "+" are the
+// smallest and largest IDs, a missing sequence number covers the whole
+// millisecond, and a "(" prefix makes the end exclusive.
+func parseRangeID(arg string, isEnd bool) (streamID, error) {
+	switch arg {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	rest, exclusive := strings.CutPrefix(arg, "(")
+	var missingSeq uint64
+	if isEnd {
+		missingSeq = maxStreamID.seq
+	}
+	id, err := parseStreamID(rest, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	var ok bool
+	if isEnd {
+		id, ok = id.prev()
+	} else {
+		id, ok = id.next()
+	}
+	if !ok {
+		if isEnd {
+			return id, errors.New("ERR invalid end ID for the interval")
+		}
+		return id, errors.New("ERR invalid start ID for the interval")
+	}
+	return id, nil
+}
+
+// xrangeCommand implements XRANGE key start end [COUNT count] and
+// XREVRANGE key end start [COUNT count].
+func xrangeCommand(c *Client, args []string) resp.Value {
+	rev := strings.EqualFold(args[0], "xrevrange")
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := parseRangeID(startArg, false)
+	if err != nil {
+		return errorReply(err)
+	}
+	end, err := parseRangeID(endArg, true)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	count := int64(-1)
+	for i := 4; i < len(args); i++ {
+		if !strings.EqualFold(args[i], "COUNT") || i+1 == len(args) {
+			return syntaxErrorReply
+		}
+		var ok bool
+		if count, ok = parseInt(args[i+1]); !ok {
+			return notIntegerReply
+		}
+		count = max(count, 0)
+		i++
+	}
+
+	s, err := c.store.lookupStream(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if count == 0 {
+		return resp.NullArray
+	}
+	return entriesReply(s.between(start, end, int(min(count, int64(s.length()))), rev))
+}
+
+// xreadOptions are the arguments of XREAD and XREADGROUP.
+type xreadOptions struct {
+	group, consumer string
+	hasGroup        bool
+	count           int // -1 for no limit
+	block           bool
+	timeout         time.Duration
+	noack           bool
+	keys, ids       []string
+	// idsAt is the position of the first ID in the arguments.
+	idsAt int
+}
+
+// parseXreadOptions parses [GROUP group consumer] [COUNT count] [BLOCK
+// milliseconds] [NOACK] STREAMS key [key ...] id [id ...], where GROUP and
+// NOACK are only allowed for XREADGROUP.
+func parseXreadOptions(args []string) (xreadOptions, error) {
+	name := strings.ToLower(args[0])
+	isGroup := name == "xreadgroup"
+	opts := xreadOptions{count: -1}
+	i := 1
+	for ; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 {
+				return opts, errSyntax
+			}
+			if len(rest)%2 != 0 {
+				return opts, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", name)
+			}
+			opts.keys, opts.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			opts.idsAt = i + 1 + len(rest)/2
+			if isGroup && !opts.hasGroup {
+				return opts, errors.New("ERR Missing GROUP option for XREADGROUP")
+			}
+			return opts, nil
+		case opt == "COUNT" && left >= 1:
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return opts, errNotInteger
+			}
+			if n > 0 {
+				opts.count = int(min(n, 1<<30))
+			}
+			i++
+		case opt == "BLOCK" && left >= 1:
+			var err error
+			if opts.timeout, err = parseTimeoutMillis(args[i+1]); err != nil {
+				return opts, err
+			}
+			opts.block = true
+			i++
+		case opt == "GROUP" && left >= 2:
+			if !isGroup {
+				return opts, errors.New("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			opts.group, opts.consumer, opts.hasGroup = args[i+1], args[i+2], true
+			i += 2
+		case opt == "NOACK" && isGroup:
+			opts.noack = true
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, errSyntax
+}
+
+// xreadCommand implements XREAD [COUNT count] [BLOCK milliseconds]
+// STREAMS key [key ...] id [id ...], replying with the entries after each
+// ID for every stream that has some, or nil if none has. With BLOCK it
+// waits for an entry to be added instead, for the given time or, with 0,
+// for ever. The ID "$" stands for the stream's last ID, so it only makes
+// sense with BLOCK, to wait for entries added from now on.
+func xreadCommand(c *Client, args []string) resp.Value {
+	opts, err := parseXreadOptions(args)
+	if err != nil {
+		return errorReply(err)
+	}
+	streams := make([]*streamValue, len(opts.keys))
+	after := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		s, err := c.store.lookupStream(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		streams[i] = s
+		if opts.ids[i] == "$" {
+			if s != nil {
+				after[i] = s.lastID
+			}
+			continue
+		}
+		if after[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+			return errorReply(err)
+		}
+	}
+
+	var results [][2]resp.Value
+	for i, s := range streams {
+		start, ok := after[i].next()
+		if !ok {
+			continue
+		}
+		if entries := s.between(start, maxStreamID, opts.count, false); len(entries) > 0 {
+			results = append(results, [2]resp.Value{resp.BulkString(opts.keys[i]), entriesReply(entries)})
+		}
+	}
+	if len(results) > 0 {
+		return streamsReply(c, results)
+	}
+	if !opts.block {
+		return resp.NullArray
+	}
+	// "$" must keep meaning the last ID as of now when the command is
+	// retried, so block on the resolved IDs instead.
+	blocked := slices.Clone(args)
+	for i, id := range after {
+		blocked[opts.idsAt+i] = id.String()
+	}
+	return c.block(blocked, opts.keys, opts.timeout, resp.NullArray)
+}
+
+// xreadgroupCommand implements XREADGROUP GROUP group consumer [COUNT
+// count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...].
+// The ID ">" delivers entries never delivered to the group, adding them to
+// the PEL unless NOACK is given, and with BLOCK waits for some like XREAD
+// does; any other ID replays the consumer's own pending entries after it,
+// those since deleted from the stream showing as nil.
+func xreadgroupCommand(c *Client, args []string) resp.Value {
+	opts, err := parseXreadOptions(args)
+	if err != nil {
+		return errorReply(err)
+	}
+	streams := make([]*streamValue, len(opts.keys))
+	groups := make([]*streamGroup, len(opts.keys))
+	after := make([]streamID, len(opts.keys))
+	for i, key := range opts.keys {
+		s, err := c.store.lookupStream(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		switch {
+		case c.blocked != nil && s == nil:
+			// Retried after the stream it waited on was deleted.
+			return resp.Error("UNBLOCKED the stream key no longer exists")
+		case c.blocked != nil && s.groups[opts.group] == nil:
+			return resp.Error("UNBLOCKED the consumer group this client was blocked on no longer exists")
+		case s == nil || s.groups[opts.group] == nil:
+			return resp.Error(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, opts.group))
+		}
+		streams[i], groups[i] = s, s.groups[opts.group]
+		switch opts.ids[i] {
+		case ">":
+			after[i] = groups[i].lastID
+		case "$":
+			return resp.Error("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		default:
+			if after[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+				return errorReply(err)
+			}
+		}
+	}
+
+	now := time.Now()
+	var results [][2]resp.Value
+	for i, s := range streams {
+		g := groups[i]
+		cons, created := g.consumer(opts.consumer, now)
+		cons.seenAt = now
+		if created {
+			c.store.dirty++
+		}
+		start, ok := after[i].next()
+		key := resp.BulkString(opts.keys[i])
+
+		if opts.ids[i] != ">" {
+			replies := []resp.Value{}
+			for _, p := range sortedPending(cons.pending) {
+				if !ok || p.id.compare(start) < 0 {
+					continue
+				}
+				if opts.count >= 0 && len(replies) == opts.count {
+					break
+				}
+				if e, found := s.entry(p.id); found {
+					replies = append(replies, entryReply(e))
+				} else {
+					replies = append(replies, resp.Array(resp.BulkString(p.id.String()), resp.NullArray))
+				}
+			}
+			results = append(results, [2]resp.Value{key, resp.Array(replies...)})
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+		entries := s.between(start, maxStreamID, opts.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		for _, e := range entries {
+			if !opts.noack {
+				g.deliver(e.id, cons, now)
+			}
+		}
+		g.lastID = entries[len(entries)-1].id
+		c.store.dirty++
+		results = append(results, [2]resp.Value{key, entriesReply(entries)})
+	}
+	if len(results) > 0 {
+		return streamsReply(c, results)
+	}
+	if !opts.block {
+		return resp.NullArray
+	}
+	return c.block(args, opts.keys, opts.timeout, resp.NullArray)
+}
+
+// streamsReply builds the per-stream reply of XREAD and XREADGROUP: a map
+// from key to entries for RESP3 clients and an array of [key, entries]
+// pairs for RESP2 ones.
+func streamsReply(c *Client, results [][2]resp.Value) resp.Value {
+	if c.proto >= 3 {
+		m := make([]resp.Value, 0, 2*len(results))
+		for _, r := range results {
+			m = append(m, r[0], r[1])
+		}
+		return resp.Map(m...)
+	}
+	out := make([]resp.Value, len(results))
+	for i, r := range results {
+		out[i] = resp.Array(r[0], r[1])
+	}
+	return resp.Array(out...)
+}
+
+// noGroupReply is the error for a missing stream or consumer group.
+func noGroupReply(key, group string) resp.Value {
+	return resp.Error(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+}
+
+// lookupGroup returns the stream at key and its named group; either may
+// be nil.
+func (s *Store) lookupGroup(key, group string) (*streamValue, *streamGroup, error) {
+	st, err := s.lookupStream(key)
+	if err != nil || st == nil {
+		return st, nil, err
+	}
+	return st, st.groups[group], nil
+}
+
+var xgroupHelp = []string{
+	"XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CREATE <key> <groupname> <id|$> [MKSTREAM]",
+	"    Create a new consumer group. Options are:",
+	"    * MKSTREAM",
+	"      Create the empty stream if it does not exist.",
+	"CREATECONSUMER <key> <groupname> <consumer>",
+	"    Create a new consumer in the specified group.",
+	"DELCONSUMER <key> <groupname> <consumer>",
+	"    Remove the specified consumer.",
+	"DESTROY <key> <groupname>",
+	"    Remove the specified group.",
+	"SETID <key> <groupname> <id|$>",
+	"    Set the current group ID.",
+	"HELP",
+	"    Print this help.",
+}
+
+// xgroupCommand implements XGROUP CREATE, SETID, DESTROY, CREATECONSUMER,
+// DELCONSUMER and HELP.
+func xgroupCommand(c *Client, args []string) resp.Value {
+	sub := strings.ToLower(args[1])
+	arity := map[string]int{"create": -5, "setid": 5, "destroy": 4, "createconsumer": 5, "delconsumer": 5, "help": 2}
+	n, known := arity[sub]
+	if !known {
+		return resp.Error(fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", args[1]))
+	}
+	if (n > 0 && len(args) != n) || (n < 0 && len(args) < -n) {
+		return wrongArityReply("xgroup|" + sub)
+	}
+	if sub == "help" {
+		return resp.BulkStrings(xgroupHelp)
+	}
+
+	key, group := args[2], args[3]
+	s, g, err := c.store.lookupGroup(key, group)
+	if err != nil {
+		return errorReply(err)
+	}
+	var mkstream bool
+	if sub == "create" {
+		for _, opt := range args[5:] {
+			if !strings.EqualFold(opt, "MKSTREAM") {
+				return syntaxErrorReply
+			}
+			mkstream = true
+		}
+	}
+	if s == nil && !mkstream {
+		return resp.Error("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	if g == nil && sub != "create" {
+		return resp.Error(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", group, key))
+	}
+
+	switch sub {
+	case "create", "setid":
+		var id streamID
+		if args[4] == "$" {
+			if s != nil {
+				id = s.lastID
+			}
+		} else if id, err = parseStreamID(args[4], 0); err != nil {
+			return errorReply(err)
+		}
+		if sub == "setid" {
+			g.lastID = id
+			c.store.signalModified(key)
+			return resp.OK
+		}
+		if g != nil {
+			return resp.Error("BUSYGROUP Consumer Group name already exists")
+		}
+		if s == nil {
+			s = newStream()
+			c.store.put(key, StoreData{value: s})
+		}
+		if s.groups == nil {
+			s.groups = make(map[string]*streamGroup)
+		}
+		s.groups[group] = newStreamGroup(id)
+		c.store.signalModified(key)
+		return resp.OK
+	case "destroy":
+		delete(s.groups, group)
+		c.store.signalReady(key)
+		c.store.signalModified(key)
+		return resp.Integer(1)
+	case "createconsumer":
+		if _, created := g.consumer(args[4], time.Now()); created {
+			c.store.dirty++
+			return resp.Integer(1)
+		}
+		return resp.Integer(0)
+	default: // delconsumer
+		if g.consumers[args[4]] != nil {
+			c.store.dirty++
+		}
+		return resp.Integer(int64(g.removeConsumer(args[4])))
+	}
+}
+
+// xackCommand implements XACK key group id [id ...], removing entries from
+// the group's PEL and replying with how many were there.
+func xackCommand(c *Client, args []string) resp.Value {
+	ids := make([]streamID, len(args)-3)
+	for i, arg := range args[3:] {
+		var err error
+		if ids[i], err = parseStreamID(arg, 0); err != nil {
+			return errorReply(err)
+		}
+	}
+	_, g, err := c.store.lookupGroup(args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if g == nil {
+		return resp.Integer(0)
+	}
+	var n int64
+	for _, id := range ids {
+		if g.ack(id) {
+			n++
+		}
+	}
+	c.store.dirty += n
+	return resp.Integer(n)
+}
+
+// xpendingCommand implements XPENDING key group [[IDLE min-idle-time] start
+// end count [consumer]]. Without a range it summarizes the PEL: its size,
+// smallest and largest IDs, and how many entries each consumer holds. With
+// one it lists [id, consumer, idle ms, deliveries] for each entry.
+func xpendingCommand(c *Client, args []string) resp.Value {
+	var minIdle int64
+	rangeArgs := args[3:]
+	if len(rangeArgs) > 0 && strings.EqualFold(rangeArgs[0], "IDLE") {
+		if len(rangeArgs) < 2 {
+			return syntaxErrorReply
+		}
+		var ok bool
+		if minIdle, ok = parseInt(rangeArgs[1]); !ok {
+			return notIntegerReply
+		}
+		rangeArgs = rangeArgs[2:]
+		if len(rangeArgs) == 0 {
+			return syntaxErrorReply
+		}
+	}
+	if len(rangeArgs) != 0 && len(rangeArgs) != 3 && len(rangeArgs) != 4 {
+		return syntaxErrorReply
+	}
+	var start, end streamID
+	var count int64
+	if len(rangeArgs) > 0 {
+		var err error
+		if start, err = parseRangeID(rangeArgs[0], false); err != nil {
+			return errorReply(err)
+		}
+		if end, err = parseRangeID(rangeArgs[1], true); err != nil {
+			return errorReply(err)
+		}
+		var ok bool
+		if count, ok = parseInt(rangeArgs[2]); !ok {
+			return notIntegerReply
+		}
+	}
+
+	_, g, err := c.store.lookupGroup(args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if g == nil {
+		return noGroupReply(args[1], args[2])
+	}
+
+	if len(rangeArgs) == 0 {
+		if len(g.pel) == 0 {
+			return resp.Array(resp.Integer(0), resp.NullBulk, resp.NullBulk, resp.NullArray)
+		}
+		pending := sortedPending(g.pel)
+		names := make([]string, 0, len(g.consumers))
+		for name, cons := range g.consumers {
+			if len(cons.pending) > 0 {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		perConsumer := make([]resp.Value, len(names))
+		for i, name := range names {
+			n := strconv.Itoa(len(g.consumers[name].pending))
+			perConsumer[i] = resp.Array(resp.BulkString(name), resp.BulkString(n))
+		}
+		return resp.Array(
+			resp.Integer(int64(len(pending))),
+			resp.BulkString(pending[0].id.String()),
+			resp.BulkString(pending[len(pending)-1].id.String()),
+			resp.Array(perConsumer...),
+		)
+	}
+
+	pel := g.pel
+	if len(rangeArgs) == 4 {
+		cons, ok := g.consumers[rangeArgs[3]]
+		if !ok {
+			return resp.Array()
+		}
+		pel = cons.pending
+	}
+	now := time.Now()
+	replies := []resp.Value{}
+	for _, p := range sortedPending(pel) {
+		if int64(len(replies)) >= count {
+			break
+		}
+		idle := now.Sub(p.deliveredAt).Milliseconds()
+		if p.id.compare(start) < 0 || p.id.compare(end) > 0 || idle < minIdle {
+			continue
+		}
+		replies = append(replies, resp.Array(
+			resp.BulkString(p.id.String()),
+			resp.BulkString(p.consumer.name),
+			resp.Integer(idle),
+			resp.Integer(p.deliveries),
+		))
+	}
+	return resp.Array(replies...)
+}
+
+// parseMinIdle parses the min-idle-time argument of XCLAIM and
+// XAUTOCLAIM, clamping negative values to 0.
+func parseMinIdle(command, arg string) (time.Duration, error) {
+	ms, ok := parseInt(arg)
+	if !ok {
+		return 0, fmt.Errorf("ERR Invalid min-idle-time argument for %s", command)
+	}
+	return time.Duration(max(ms, 0)) * time.Millisecond, nil
+}
+
+// claimReply builds the reply for claimed entries: their IDs alone with
+// JUSTID, or the entries themselves.
+func claimReply(s *streamValue, ids []streamID, justID bool) resp.Value {
+	out := make([]resp.Value, len(ids))
+	for i, id := range ids {
+		if justID {
+			out[i] = resp.BulkString(id.String())
+		} else {
+			e, _ := s.entry(id)
+			out[i] = entryReply(e)
+		}
+	}
+	return resp.Array(out...)
+}
+
+// xclaimCommand implements XCLAIM key group consumer min-idle-time id
+// [id ...] [IDLE ms] [TIME unix-time-ms] [RETRYCOUNT count] [FORCE]
+// [JUSTID] [LASTID id]. It gives consumer the pending entries among the
+// IDs that have been idle for at least min-idle-time, so the entries of a
+// consumer that went away can be processed by another. FORCE also claims
+// entries that are in the stream but not pending; entries since deleted
+// from the stream are dropped from the PEL instead.
+func xclaimCommand(c *Client, args []string) resp.Value {
+	minIdle, err := parseMinIdle("XCLAIM", args[4])
+	if err != nil {
+		return errorReply(err)
+	}
+	var ids []streamID
+	i := 5
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			if i == 5 {
+				return errorReply(err)
+			}
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now()
+	deliveredAt := now
+	retryCount := int64(-1)
+	var force, justID, hasLastID bool
+	var lastID streamID
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			n, ok := parseInt(args[i+1])
+			if !ok {
+				return resp.Error(fmt.Sprintf("ERR Invalid %s option argument for XCLAIM", opt))
+			}
+			switch opt {
+			case "IDLE":
+				deliveredAt = now.Add(-time.Duration(max(n, 0)) * time.Millisecond)
+			case "TIME":
+				if deliveredAt = time.UnixMilli(n); n < 0 || deliveredAt.After(now) {
+					deliveredAt = now
+				}
+			default:
+				retryCount = max(n, 0)
+			}
+			i++
+		case opt == "LASTID" && i+1 < len(args):
+			if lastID, err = parseStreamID(args[i+1], 0); err != nil {
+				return errorReply(err)
+			}
+			hasLastID = true
+			i++
+		default:
+			return resp.Error(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i]))
+		}
+	}
+
+	s, g, err := c.store.lookupGroup(args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if g == nil {
+		return noGroupReply(args[1], args[2])
+	}
+	if hasLastID && lastID.compare(g.lastID) > 0 {
+		g.lastID = lastID
+	}
+	cons, _ := g.consumer(args[3], now)
+	cons.seenAt = now
+
+	var claimed, handled []streamID
+	for _, id := range ids {
+		p, pending := g.pel[id]
+		_, exists := s.entry(id)
+		switch {
+		case pending && !exists:
+			g.ack(id)
+			handled = append(handled, id)
+			continue
+		case !pending && (!force || !exists):
+			continue
+		case pending && now.Sub(p.deliveredAt) < minIdle:
+			continue
+		}
+		p = g.claim(id, cons)
+		p.deliveredAt = deliveredAt
+		switch {
+		case retryCount >= 0:
+			p.deliveries = retryCount
+		case !justID:
+			p.deliveries++
+		}
+		claimed = append(claimed, id)
+		handled = append(handled, id)
+	}
+
+	var opts []string
+	if retryCount >= 0 {
+		opts = append(opts, "RETRYCOUNT", strconv.FormatInt(retryCount, 10))
+	}
+	if force {
+		opts = append(opts, "FORCE")
+	}
+	if justID {
+		opts = append(opts, "JUSTID")
+	}
+	if hasLastID {
+		opts = append(opts, "LASTID", lastID.String())
+	}
+	c.store.dirty++
+	propagateClaim(c, args, handled, deliveredAt, opts)
+	return claimReply(s, claimed, justID)
+}
+
+// propagateClaim logs to the AOF, in place of XCLAIM or XAUTOCLAIM, an
+// XCLAIM of the IDs that were claimed or dropped from the PEL, with the
+// delivery time given, so replaying it does not depend on idle times. The
+// ID 0-0, which no entry has, stands in when there are none, as the
+// consumer is created regardless.
+func propagateClaim(c *Client, args []string, ids []streamID, deliveredAt time.Time, opts []string) {
+	logged := []string{"XCLAIM", args[1], args[2], args[3], "0"}
+	if len(ids) == 0 {
+		logged = append(logged, streamID{}.String())
+	}
+	for _, id := range ids {
+		logged = append(logged, id.String())
+	}
+	logged = append(logged, "TIME", strconv.FormatInt(deliveredAt.UnixMilli(), 10))
+	c.propagateAs(append(logged, opts...))
+}
+
+// xautoclaimCommand implements XAUTOCLAIM key group consumer
+// min-idle-time start [COUNT count] [JUSTID]. It is XCLAIM over the PEL
+// from start, claiming up to count entries, and replies with the cursor to
+// continue from (0-0 once the PEL is exhausted), the claimed entries and
+// the IDs of pending entries found deleted from the stream, which are
+// dropped. At most ten times count PEL entries are looked at per call.
+func xautoclaimCommand(c *Client, args []string) resp.Value {
+	minIdle, err := parseMinIdle("XAUTOCLAIM", args[4])
+	if err != nil {
+		return errorReply(err)
+	}
+	start, err := parseRangeID(args[5], false)
+	if err != nil {
+		return errorReply(err)
+	}
+	count := int64(100)
+	var justID bool
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "JUSTID":
+			justID = true
+		case opt == "COUNT" && i+1 < len(args):
+			var ok bool
+			if count, ok = parseInt(args[i+1]); !ok {
+				return notIntegerReply
+			}
+			if count < 1 || count > math.MaxInt64/10 {
+				return resp.Error("ERR COUNT must be > 0")
+			}
+			i++
+		default:
+			return syntaxErrorReply
+		}
+	}
+
+	s, g, err := c.store.lookupGroup(args[1], args[2])
+	if err != nil {
+		return errorReply(err)
+	}
+	if g == nil {
+		return noGroupReply(args[1], args[2])
+	}
+	now := time.Now()
+	cons, _ := g.consumer(args[3], now)
+	cons.seenAt = now
+
+	attempts := count * 10
+	next := streamID{}
+	var claimed, handled []streamID
+	deleted := []resp.Value{}
+	pending := sortedPending(g.pel)
+	j, _ := slices.BinarySearchFunc(pending, start, func(p *pendingEntry, id streamID) int {
+		return p.id.compare(id)
+	})
+	for ; j < len(pending) && attempts > 0 && int64(len(claimed)) < count; j++ {
+		attempts--
+		p := pending[j]
+		if _, exists := s.entry(p.id); !exists {
+			g.ack(p.id)
+			deleted = append(deleted, resp.BulkString(p.id.String()))
+			handled = append(handled, p.id)
+			continue
+		}
+		if now.Sub(p.deliveredAt) < minIdle {
+			continue
+		}
+		g.claim(p.id, cons)
+		p.deliveredAt = now
+		if !justID {
+			p.deliveries++
+		}
+		claimed = append(claimed, p.id)
+		handled = append(handled, p.id)
+	}
+	if j < len(pending) {
+		next = pending[j].id
+	}
+	var opts []string
+	if justID {
+		opts = append(opts, "JUSTID")
+	}
+	c.store.dirty++
+	propagateClaim(c, args, handled, now, opts)
+	return resp.Array(resp.BulkString(next.String()), claimReply(s, claimed, justID), resp.Array(deleted...))
+}
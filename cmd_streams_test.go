@@ -0,0 +1,462 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func entry(id string, fields ...string) resp.Value {
+	return resp.Array(resp.BulkString(id), resp.BulkStrings(fields))
+}
+
+func TestXaddXlen(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XADD", "s", "NOMKSTREAM", "1-1", "f", "v"}, resp.NullBulk},
+		{[]string{"EXISTS", "s"}, resp.Integer(0)},
+		{[]string{"XADD", "s", "1-1", "f", "v"}, resp.BulkString("1-1")},
+		{[]string{"XADD", "s", "1-*", "f", "v"}, resp.BulkString("1-2")},
+		{[]string{"XADD", "s", "5-*", "f", "v"}, resp.BulkString("5-0")},
+		{[]string{"XADD", "s", "7", "f", "v"}, resp.BulkString("7-0")},
+		{[]string{"XADD", "s", "NOMKSTREAM", "7-5", "f", "v", "g", "w"}, resp.BulkString("7-5")},
+		{[]string{"XLEN", "s"}, resp.Integer(5)},
+		{[]string{"TYPE", "s"}, resp.SimpleString("stream")},
+		{[]string{"XADD", "s", "7-5", "f", "v"}, resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{[]string{"XADD", "s", "6-*", "f", "v"}, resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{[]string{"XADD", "t", "0-0", "f", "v"}, resp.Error("ERR The ID specified in XADD must be greater than 0-0")},
+		{[]string{"XADD", "t", "1-x", "f", "v"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XADD", "t", "*", "f", "v", "g"}, resp.Error("ERR wrong number of arguments for 'xadd' command")},
+		{[]string{"XADD", "t", "NOMKSTREAM", "*", "f"}, resp.Error("ERR wrong number of arguments for 'xadd' command")},
+		{[]string{"XLEN", "missing"}, resp.Integer(0)},
+		{[]string{"SET", "str", "x"}, resp.OK},
+		{[]string{"XADD", "str", "*", "f", "v"}, wrongTypeReply},
+		{[]string{"XLEN", "str"}, wrongTypeReply},
+	})
+
+	// IDs generated from the clock keep growing past explicit ones.
+	do(c, "XADD", "future", "99999999999999-7", "f", "v")
+	if got := do(c, "XADD", "future", "*", "f", "v"); got.Str != "99999999999999-8" {
+		t.Errorf("XADD * after a future ID = %v, want 99999999999999-8", got)
+	}
+	got := do(c, "XADD", "now", "*", "f", "v")
+	if ms, seq, _ := strings.Cut(got.Str, "-"); ms == "" || seq != "0" {
+		t.Errorf("XADD * = %v, want <ms>-0", got)
+	}
+}
+
+func TestXrange(t *testing.T) {
+	c := newTestClient()
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-5"} {
+		do(c, "XADD", "s", id, "id", id)
+	}
+	all := []resp.Value{entry("1-0", "id", "1-0"), entry("1-1", "id", "1-1"), entry("2-0", "id", "2-0"), entry("3-5", "id", "3-5")}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XRANGE", "s", "-", "+"}, resp.Array(all...)},
+		{[]string{"XRANGE", "s", "1", "1"}, resp.Array(all[:2]...)},
+		{[]string{"XRANGE", "s", "1-1", "3-4"}, resp.Array(all[1:3]...)},
+		{[]string{"XRANGE", "s", "(1-1", "+"}, resp.Array(all[2:]...)},
+		{[]string{"XRANGE", "s", "-", "(2-0"}, resp.Array(all[:2]...)},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "3"}, resp.Array(all[:3]...)},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "0"}, resp.NullArray},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "-1"}, resp.NullArray},
+		{[]string{"XRANGE", "s", "3", "1"}, resp.Array()},
+		{[]string{"XRANGE", "missing", "-", "+"}, resp.Array()},
+		{[]string{"XREVRANGE", "s", "+", "-"}, resp.Array(all[3], all[2], all[1], all[0])},
+		{[]string{"XREVRANGE", "s", "2", "1", "COUNT", "2"}, resp.Array(all[2], all[1])},
+		{[]string{"XREVRANGE", "s", "(3-5", "(1-0"}, resp.Array(all[2], all[1])},
+		{[]string{"XRANGE", "s", "(18446744073709551615-18446744073709551615", "+"}, resp.Error("ERR invalid start ID for the interval")},
+		{[]string{"XRANGE", "s", "-", "(0-0"}, resp.Error("ERR invalid end ID for the interval")},
+		{[]string{"XRANGE", "s", "x", "+"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT"}, syntaxErrorReply},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "x"}, notIntegerReply},
+	})
+}
+
+func TestXread(t *testing.T) {
+	c := newTestClient()
+	do(c, "XADD", "a", "1-0", "f", "1")
+	do(c, "XADD", "a", "2-0", "f", "2")
+	do(c, "XADD", "b", "3-0", "f", "3")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XREAD", "STREAMS", "a", "b", "1-0", "0"}, resp.Array(
+			resp.Array(resp.BulkString("a"), resp.Array(entry("2-0", "f", "2"))),
+			resp.Array(resp.BulkString("b"), resp.Array(entry("3-0", "f", "3"))),
+		)},
+		{[]string{"XREAD", "COUNT", "1", "STREAMS", "a", "missing", "0", "0"}, resp.Array(
+			resp.Array(resp.BulkString("a"), resp.Array(entry("1-0", "f", "1"))),
+		)},
+		{[]string{"XREAD", "STREAMS", "a", "b", "$", "3"}, resp.NullArray},
+		{[]string{"XREAD", "STREAMS", "a", "b", "0"}, resp.Error("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")},
+		{[]string{"XREAD", "COUNT", "1", "a", "0"}, syntaxErrorReply},
+		{[]string{"XREAD", "COUNT", "1", "STREAMS"}, syntaxErrorReply},
+		{[]string{"XREAD", "STREAMS", "a", "x"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+	})
+
+	c.proto = 3
+	want := resp.Map(resp.BulkString("b"), resp.Array(entry("3-0", "f", "3")))
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XREAD", "STREAMS", "a", "b", "$", "0"}, want},
+	})
+}
+
+func TestXgroup(t *testing.T) {
+	c := newTestClient()
+	do(c, "XADD", "s", "1-0", "f", "v")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XGROUP", "CREATE", "missing", "g", "$"}, resp.Error("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")},
+		{[]string{"XGROUP", "CREATE", "new", "g", "$", "MKSTREAM"}, resp.OK},
+		{[]string{"XLEN", "new"}, resp.Integer(0)},
+		{[]string{"TYPE", "new"}, resp.SimpleString("stream")},
+		{[]string{"XGROUP", "CREATE", "s", "g", "0"}, resp.OK},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$"}, resp.Error("BUSYGROUP Consumer Group name already exists")},
+		{[]string{"XGROUP", "CREATE", "s", "h", "x"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XGROUP", "CREATE", "s", "h", "0", "FOO"}, syntaxErrorReply},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "alice"}, resp.Integer(1)},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "g", "alice"}, resp.Integer(0)},
+		{[]string{"XGROUP", "CREATECONSUMER", "s", "nope", "alice"}, resp.Error("NOGROUP No such consumer group 'nope' for key name 's'")},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "f", "v"))),
+		)},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, resp.NullArray},
+		{[]string{"XGROUP", "SETID", "s", "g", "0"}, resp.OK},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "f", "v"))),
+		)},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "alice"}, resp.Integer(0)},
+		{[]string{"XGROUP", "DELCONSUMER", "s", "g", "bob"}, resp.Integer(1)},
+		{[]string{"XPENDING", "s", "g"}, resp.Array(resp.Integer(0), resp.NullBulk, resp.NullBulk, resp.NullArray)},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, resp.Integer(1)},
+		{[]string{"XGROUP", "DESTROY", "s", "g"}, resp.Error("NOGROUP No such consumer group 'g' for key name 's'")},
+		{[]string{"XGROUP", "CREATE", "s", "g"}, resp.Error("ERR wrong number of arguments for 'xgroup|create' command")},
+		{[]string{"XGROUP", "FOO"}, resp.Error("ERR unknown subcommand 'FOO'. Try XGROUP HELP.")},
+	})
+	if got := do(c, "XGROUP", "HELP"); len(got.Array) == 0 {
+		t.Errorf("XGROUP HELP = %v, want help lines", got)
+	}
+}
+
+func TestXreadgroupXackXpending(t *testing.T) {
+	c := newTestClient()
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		do(c, "XADD", "s", id, "id", id)
+	}
+	do(c, "XGROUP", "CREATE", "s", "g", "0")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "id", "1-0"), entry("2-0", "id", "2-0"))),
+		)},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("3-0", "id", "3-0"))),
+		)},
+		// History only holds the consumer's own pending entries.
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "id", "1-0"), entry("2-0", "id", "2-0"))),
+		)},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "1-0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("2-0", "id", "2-0"))),
+		)},
+		{[]string{"XPENDING", "s", "g"}, resp.Array(
+			resp.Integer(3), resp.BulkString("1-0"), resp.BulkString("3-0"),
+			resp.Array(
+				resp.Array(resp.BulkString("alice"), resp.BulkString("2")),
+				resp.Array(resp.BulkString("bob"), resp.BulkString("1")),
+			),
+		)},
+		{[]string{"XACK", "s", "g", "1-0", "3-0", "9-0"}, resp.Integer(2)},
+		{[]string{"XACK", "s", "nope", "2-0"}, resp.Integer(0)},
+		{[]string{"XACK", "s", "g", "x"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", "0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array()),
+		)},
+		{[]string{"XPENDING", "s", "g", "-", "+", "10", "bob"}, resp.Array()},
+		{[]string{"XPENDING", "s", "g", "-", "+", "0"}, resp.Array()},
+		{[]string{"XPENDING", "s", "g", "IDLE", "1000000", "-", "+", "10"}, resp.Array()},
+		{[]string{"XPENDING", "s", "nope"}, resp.Error("NOGROUP No such key 's' or consumer group 'nope'")},
+		{[]string{"XPENDING", "s", "g", "-", "+"}, syntaxErrorReply},
+		{[]string{"XREADGROUP", "GROUP", "nope", "alice", "STREAMS", "s", ">"}, resp.Error("NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option")},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "$"}, resp.Error("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")},
+		{[]string{"XREADGROUP", "COUNT", "1", "NOACK", "STREAMS", "s", ">"}, resp.Error("ERR Missing GROUP option for XREADGROUP")},
+		{[]string{"XREAD", "GROUP", "g", "alice", "STREAMS", "s", "0"}, resp.Error("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")},
+	})
+
+	got := do(c, "XPENDING", "s", "g", "-", "+", "10")
+	if len(got.Array) != 1 {
+		t.Fatalf("XPENDING range = %v, want one entry", got)
+	}
+	p := got.Array[0].Array
+	if p[0].Str != "2-0" || p[1].Str != "alice" || p[3].Int != 1 {
+		t.Errorf("XPENDING entry = %v, want 2-0 held by alice delivered once", got.Array[0])
+	}
+
+	// NOACK delivers without tracking the entry.
+	do(c, "XADD", "s", "4-0", "id", "4-0")
+	do(c, "XREADGROUP", "GROUP", "g", "carol", "NOACK", "STREAMS", "s", ">")
+	if got := do(c, "XPENDING", "s", "g"); got.Array[0].Int != 1 {
+		t.Errorf("XPENDING after NOACK = %v, want 1 pending", got)
+	}
+}
+
+func TestStreamCloneCopiesGroups(t *testing.T) {
+	c := newTestClient()
+	do(c, "XADD", "s", "1-0", "f", "v")
+	do(c, "XGROUP", "CREATE", "s", "g", "0")
+	do(c, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	do(c, "COPY", "s", "t")
+	do(c, "XACK", "s", "g", "1-0")
+	if got := do(c, "XPENDING", "t", "g"); got.Array[0].Int != 1 {
+		t.Errorf("XPENDING on the copy = %v, want 1 pending", got)
+	}
+}
+
+func TestXclaim(t *testing.T) {
+	c := newTestClient()
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		do(c, "XADD", "s", id, "id", id)
+	}
+	do(c, "XGROUP", "CREATE", "s", "g", "0")
+	do(c, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		// Nothing has been idle for an hour yet.
+		{[]string{"XCLAIM", "s", "g", "bob", "3600000", "1-0"}, resp.Array()},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "3-0"}, resp.Array(entry("1-0", "id", "1-0"))},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "3-0", "FORCE", "JUSTID"}, resp.BulkStrings([]string{"3-0"})},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "9-0", "FORCE"}, resp.Array()},
+		{[]string{"XPENDING", "s", "g"}, resp.Array(
+			resp.Integer(3), resp.BulkString("1-0"), resp.BulkString("3-0"),
+			resp.Array(
+				resp.Array(resp.BulkString("alice"), resp.BulkString("1")),
+				resp.Array(resp.BulkString("bob"), resp.BulkString("2")),
+			),
+		)},
+		{[]string{"XCLAIM", "s", "g", "carol", "0", "2-0", "IDLE", "5000", "RETRYCOUNT", "7", "JUSTID"}, resp.BulkStrings([]string{"2-0"})},
+		{[]string{"XCLAIM", "s", "g", "dave", "4000", "2-0", "JUSTID"}, resp.BulkStrings([]string{"2-0"})},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "LASTID", "5-0", "JUSTID"}, resp.BulkStrings([]string{"1-0"})},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">"}, resp.NullArray},
+		{[]string{"XCLAIM", "s", "g", "bob", "x", "1-0"}, resp.Error("ERR Invalid min-idle-time argument for XCLAIM")},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "x"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "FOO"}, resp.Error("ERR Unrecognized XCLAIM option 'FOO'")},
+		{[]string{"XCLAIM", "s", "nope", "bob", "0", "1-0"}, resp.Error("NOGROUP No such key 's' or consumer group 'nope'")},
+	})
+
+	got := do(c, "XPENDING", "s", "g", "2-0", "2-0", "1")
+	if p := got.Array[0].Array; p[1].Str != "dave" || p[3].Int != 7 {
+		t.Errorf("XPENDING 2-0 = %v, want dave with 7 deliveries kept by JUSTID", got)
+	}
+}
+
+func TestXautoclaim(t *testing.T) {
+	c := newTestClient()
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0"} {
+		do(c, "XADD", "s", id, "id", id)
+	}
+	do(c, "XGROUP", "CREATE", "s", "g", "0")
+	do(c, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "COUNT", "2"}, resp.Array(
+			resp.BulkString("3-0"),
+			resp.Array(entry("1-0", "id", "1-0"), entry("2-0", "id", "2-0")),
+			resp.Array(),
+		)},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "3-0", "JUSTID"}, resp.Array(
+			resp.BulkString("0-0"), resp.BulkStrings([]string{"3-0", "4-0"}), resp.Array(),
+		)},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "3600000", "-"}, resp.Array(
+			resp.BulkString("0-0"), resp.Array(), resp.Array(),
+		)},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "(3-0", "JUSTID"}, resp.Array(
+			resp.BulkString("0-0"), resp.BulkStrings([]string{"4-0"}), resp.Array(),
+		)},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "0", "COUNT", "0"}, resp.Error("ERR COUNT must be > 0")},
+		{[]string{"XAUTOCLAIM", "s", "g", "carol", "0", "0", "FOO"}, syntaxErrorReply},
+		{[]string{"XAUTOCLAIM", "s", "nope", "carol", "0", "0"}, resp.Error("NOGROUP No such key 's' or consumer group 'nope'")},
+	})
+}
+
+func TestXtrim(t *testing.T) {
+	c := newTestClient()
+	c.srv.cfg.StreamNodeMaxEntries = 3
+	for i := 1; i <= 10; i++ {
+		do(c, "XADD", "s", strconv.Itoa(i), "f", "v")
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		// Approximate trimming removes whole blocks of three entries.
+		{[]string{"XTRIM", "s", "MAXLEN", "~", "6"}, resp.Integer(3)},
+		{[]string{"XLEN", "s"}, resp.Integer(7)},
+		{[]string{"XTRIM", "s", "MAXLEN", "~", "6"}, resp.Integer(0)},
+		{[]string{"XTRIM", "s", "MAXLEN", "6"}, resp.Integer(1)},
+		{[]string{"XRANGE", "s", "-", "-"}, resp.Array()},
+		{[]string{"XTRIM", "s", "MINID", "=", "7"}, resp.Integer(2)},
+		{[]string{"XRANGE", "s", "-", "+", "COUNT", "1"}, resp.Array(entry("7-0", "f", "v"))},
+		{[]string{"XTRIM", "s", "MINID", "~", "100", "LIMIT", "2"}, resp.Integer(0)},
+		{[]string{"XTRIM", "s", "MINID", "~", "100", "LIMIT", "3"}, resp.Integer(3)},
+		{[]string{"XTRIM", "s", "MAXLEN", "0"}, resp.Integer(1)},
+		{[]string{"XLEN", "s"}, resp.Integer(0)},
+		{[]string{"EXISTS", "s"}, resp.Integer(1)},
+		{[]string{"XADD", "s", "10-0", "f", "v"}, resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{[]string{"XTRIM", "missing", "MAXLEN", "0"}, resp.Integer(0)},
+		{[]string{"XTRIM", "s", "MAXLEN", "-1"}, resp.Error("ERR The MAXLEN argument must be >= 0.")},
+		{[]string{"XTRIM", "s", "MAXLEN", "1", "LIMIT", "1"}, resp.Error("ERR syntax error, LIMIT cannot be used without the special ~ option")},
+		{[]string{"XTRIM", "s", "MAXLEN", "~", "1", "LIMIT", "-1"}, resp.Error("ERR The LIMIT argument must be >= 0.")},
+		{[]string{"XTRIM", "s", "MAXLEN", "1", "MINID", "1"}, resp.Error("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")},
+		{[]string{"XTRIM", "s", "MINID", "x"}, resp.Error("ERR Invalid stream ID specified as stream command argument")},
+		{[]string{"XTRIM", "s", "FOO", "1"}, syntaxErrorReply},
+		{[]string{"XTRIM", "s", "MAXLEN", "~"}, syntaxErrorReply},
+	})
+}
+
+func TestXaddTrims(t *testing.T) {
+	c := newTestClient()
+	for i := 1; i <= 5; i++ {
+		do(c, "XADD", "s", "MAXLEN", "3", strconv.Itoa(i), "f", "v")
+	}
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XLEN", "s"}, resp.Integer(3)},
+		{[]string{"XADD", "s", "NOMKSTREAM", "MINID", "5", "6", "f", "v"}, resp.BulkString("6-0")},
+		{[]string{"XRANGE", "s", "-", "+"}, resp.Array(entry("5-0", "f", "v"), entry("6-0", "f", "v"))},
+		{[]string{"XADD", "s", "MAXLEN", "~", "1", "LIMIT", "10", "7", "f", "v"}, resp.BulkString("7-0")},
+		{[]string{"XLEN", "s"}, resp.Integer(3)},
+		{[]string{"XADD", "s", "MAXLEN", "1", "MINID", "1", "*", "f", "v"}, resp.Error("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")},
+		{[]string{"XADD", "s", "MAXLEN", "x", "*", "f", "v"}, notIntegerReply},
+	})
+}
+
+func TestClaimDropsTrimmedEntries(t *testing.T) {
+	c := newTestClient()
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		do(c, "XADD", "s", id, "f", "v")
+	}
+	do(c, "XGROUP", "CREATE", "s", "g", "0")
+	do(c, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+	do(c, "XTRIM", "s", "MAXLEN", "1")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(
+				resp.Array(resp.BulkString("1-0"), resp.NullArray),
+				resp.Array(resp.BulkString("2-0"), resp.NullArray),
+				entry("3-0", "f", "v"),
+			)),
+		)},
+		{[]string{"XCLAIM", "s", "g", "bob", "0", "1-0", "JUSTID"}, resp.Array()},
+		{[]string{"XAUTOCLAIM", "s", "g", "bob", "0", "0", "JUSTID"}, resp.Array(
+			resp.BulkString("0-0"), resp.BulkStrings([]string{"3-0"}), resp.BulkStrings([]string{"2-0"}),
+		)},
+		{[]string{"XPENDING", "s", "g", "-", "+", "10", "alice"}, resp.Array()},
+	})
+}
+
+func TestXreadBlock(t *testing.T) {
+	c := newTestClient()
+	writer := newClient(nil, c.srv)
+	do(c, "XADD", "s", "1-0", "f", "old")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"XREAD", "BLOCK", "0", "STREAMS", "s", "0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "f", "old"))),
+		)},
+		{[]string{"XREAD", "BLOCK", "10", "STREAMS", "s", "$"}, resp.NullArray},
+		{[]string{"XREAD", "BLOCK", "x", "STREAMS", "s", "$"}, resp.Error("ERR timeout is not an integer or out of range")},
+		{[]string{"XREAD", "BLOCK", "-1", "STREAMS", "s", "$"}, resp.Error("ERR timeout is negative")},
+	})
+
+	// "$" keeps meaning the last ID at the time of the call.
+	r := doBlocking(c, "XREAD", "BLOCK", "0", "STREAMS", "missing", "s", "$", "$")
+	do(writer, "XADD", "s", "2-0", "f", "new")
+	expectUnblocked(t, r, resp.Array(
+		resp.Array(resp.BulkString("s"), resp.Array(entry("2-0", "f", "new"))),
+	))
+	r = doBlocking(c, "XREAD", "COUNT", "1", "BLOCK", "0", "STREAMS", "missing", "s", "$", "$")
+	do(writer, "XADD", "missing", "1-0", "f", "a")
+	expectUnblocked(t, r, resp.Array(
+		resp.Array(resp.BulkString("missing"), resp.Array(entry("1-0", "f", "a"))),
+	))
+
+	// Deleting the stream does not end XREAD, which still waits for an ID
+	// past the last one it saw.
+	r = doBlocking(c, "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	do(writer, "DEL", "s")
+	expectBlocked(t, r)
+	do(writer, "XADD", "s", "1-0", "f", "again")
+	expectBlocked(t, r)
+	do(writer, "XADD", "s", "3-0", "f", "again")
+	expectUnblocked(t, r, resp.Array(
+		resp.Array(resp.BulkString("s"), resp.Array(entry("3-0", "f", "again"))),
+	))
+}
+
+func TestXreadgroupBlock(t *testing.T) {
+	c := newTestClient()
+	writer := newClient(nil, c.srv)
+	do(c, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+
+	r := doBlocking(c, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	do(writer, "XADD", "s", "1-0", "f", "v")
+	expectUnblocked(t, r, resp.Array(
+		resp.Array(resp.BulkString("s"), resp.Array(entry("1-0", "f", "v"))),
+	))
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		// Reading the history never blocks.
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", "1-0"}, resp.Array(
+			resp.Array(resp.BulkString("s"), resp.Array()),
+		)},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "BLOCK", "10", "STREAMS", "s", ">"}, resp.NullArray},
+		{[]string{"XPENDING", "s", "g"}, resp.Array(
+			resp.Integer(1), resp.BulkString("1-0"), resp.BulkString("1-0"),
+			resp.Array(resp.Array(resp.BulkString("alice"), resp.BulkString("1"))),
+		)},
+	})
+
+	r = doBlocking(c, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	do(writer, "XGROUP", "DESTROY", "s", "g")
+	expectUnblocked(t, r, resp.Error("UNBLOCKED the consumer group this client was blocked on no longer exists"))
+
+	do(writer, "XGROUP", "CREATE", "s", "g", "$")
+	r = doBlocking(c, "XREADGROUP", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+	do(writer, "DEL", "s")
+	expectUnblocked(t, r, resp.Error("UNBLOCKED the stream key no longer exists"))
+}
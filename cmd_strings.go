@@ -0,0 +1,494 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "set", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: setCommand})
+	RegisterCommand(&Command{Name: "get", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: getCommand})
+	RegisterCommand(&Command{Name: "setnx", Arity: 3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: setnxCommand})
+	RegisterCommand(&Command{Name: "cas", Arity: 4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: casCommand})
+	RegisterCommand(&Command{Name: "getset", Arity: 3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: getsetCommand})
+	RegisterCommand(&Command{Name: "getdel", Arity: 2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: getdelCommand})
+	RegisterCommand(&Command{Name: "getex", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: getexCommand})
+	RegisterCommand(&Command{Name: "mget", Arity: -2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: -1, Step: 1, Handler: mgetCommand})
+	RegisterCommand(&Command{Name: "mset", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 2, Handler: msetCommand})
+	RegisterCommand(&Command{Name: "msetnx", Arity: -3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: -1, Step: 2, Handler: msetnxCommand})
+	RegisterCommand(&Command{Name: "append", Arity: 3, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: appendCommand})
+	RegisterCommand(&Command{Name: "strlen", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: strlenCommand})
+	RegisterCommand(&Command{Name: "getrange", Arity: 4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: getrangeCommand})
+	RegisterCommand(&Command{Name: "setrange", Arity: 4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: setrangeCommand})
+	RegisterCommand(&Command{Name: "incr", Arity: 2, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: incrCommand})
+	RegisterCommand(&Command{Name: "decr", Arity: 2, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: decrCommand})
+	RegisterCommand(&Command{Name: "incrby", Arity: 3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: incrbyCommand})
+	RegisterCommand(&Command{Name: "incrbyfloat", Arity: 3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: incrbyfloatCommand})
+	RegisterCommand(&Command{Name: "decrby", Arity: 3, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: decrbyCommand})
+}
+
+// setnxCommand implements SETNX key value.
+func setnxCommand(c *Client, args []string) resp.Value {
+	if _, ok := c.store.lookup(args[1]); ok {
+		return resp.Integer(0)
+	}
+	c.store.set(args[1], []byte(args[2]))
+	c.store.notify(notifyString, "set", args[1])
+	return resp.Integer(1)
+}
+
+// casCommand implements CAS key expected new, which is not a Redis
+// command: it sets key to new only if it holds the string expected,
+// keeping its TTL, and replies 1 if it did, 0 otherwise. A missing key
+// never matches.
+func casCommand(c *Client, args []string) resp.Value {
+	d, ok, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok || string(d.bytes()) != args[2] {
+		return resp.Integer(0)
+	}
+	d.value = []byte(args[3])
+	c.store.put(args[1], d)
+	c.store.notify(notifyString, "set", args[1])
+	return resp.Integer(1)
+}
+
+// getsetCommand implements GETSET key value. Like SET it discards the TTL.
+func getsetCommand(c *Client, args []string) resp.Value {
+	reply := getCommand(c, args[:2])
+	if reply.IsError() {
+		return reply
+	}
+	c.store.set(args[1], []byte(args[2]))
+	c.store.notify(notifyString, "set", args[1])
+	return reply
+}
+
+// getdelCommand implements GETDEL key.
+func getdelCommand(c *Client, args []string) resp.Value {
+	reply := getCommand(c, args[:2])
+	if reply.IsError() {
+		return reply
+	}
+	c.store.del(args[1])
+	return reply
+}
+
+// getexCommand implements GETEX key [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST].
+func getexCommand(c *Client, args []string) resp.Value {
+	var at time.Time
+	var persist bool
+	switch len(args) {
+	case 2:
+	case 3:
+		if strings.ToUpper(args[2]) != "PERSIST" {
+			return syntaxErrorReply
+		}
+		persist = true
+	case 4:
+		unit := strings.ToUpper(args[2])
+		switch unit {
+		case "EX", "PX", "EXAT", "PXAT":
+		default:
+			return syntaxErrorReply
+		}
+		var err error
+		if at, err = parseExpireArg("getex", unit, args[3]); err != nil {
+			return errorReply(err)
+		}
+	default:
+		return syntaxErrorReply
+	}
+
+	key := args[1]
+	reply := getCommand(c, args[:2])
+	if reply.IsNull() || reply.IsError() {
+		return reply
+	}
+	switch {
+	case persist:
+		c.store.persist(key)
+	case !at.IsZero():
+		c.store.setExpire(key, at)
+		c.propagateExpiry(key, "PEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10))
+	}
+	return reply
+}
+
+// mgetCommand implements MGET key [key ...]. Missing keys and keys holding
+// other types yield nil.
+func mgetCommand(c *Client, args []string) resp.Value {
+	values := make([]resp.Value, 0, len(args)-1)
+	for _, key := range args[1:] {
+		if d, ok, err := c.store.lookupString(key); ok && err == nil {
+			values = append(values, resp.BulkString(string(d.bytes())))
+		} else {
+			values = append(values, resp.NullBulk)
+		}
+	}
+	return resp.Array(values...)
+}
+
+// msetCommand implements MSET key value [key value ...]. All keys are set
+// under a single lock acquisition, so no client sees a partial update.
+func msetCommand(c *Client, args []string) resp.Value {
+	if len(args)%2 == 0 {
+		return wrongArityReply("mset")
+	}
+	for i := 1; i < len(args); i += 2 {
+		c.store.set(args[i], []byte(args[i+1]))
+		c.store.notify(notifyString, "set", args[i])
+	}
+	return resp.OK
+}
+
+// msetnxCommand implements MSETNX key value [key value ...]. Nothing is
+// set if any of the keys already exists.
+func msetnxCommand(c *Client, args []string) resp.Value {
+	if len(args)%2 == 0 {
+		return wrongArityReply("msetnx")
+	}
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := c.store.lookup(args[i]); ok {
+			return resp.Integer(0)
+		}
+	}
+	for i := 1; i < len(args); i += 2 {
+		c.store.set(args[i], []byte(args[i+1]))
+		c.store.notify(notifyString, "set", args[i])
+	}
+	return resp.Integer(1)
+}
+
+// stringTooLongReply is returned when a command would grow a string past
+// proto-max-bulk-len.
+var stringTooLongReply = resp.Error("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// checkStringLength reports whether a string of n bytes is allowed.
+func checkStringLength(c *Client, n int) bool {
+	return n <= c.srv.cfg.ProtoMaxBulkLen
+}
+
+// parseExpireArg converts the argument of an EX, PX, EXAT or PXAT option
+// into an absolute deadline. The error, naming command, is meant to be sent
+// to the client as is.
+func parseExpireArg(command, unit, arg string) (time.Time, error) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return time.Time{}, errNotInteger
+	}
+	invalid := fmt.Errorf("ERR invalid expire time in '%s' command", command)
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+
+	var ms int64
+	switch unit {
+	case "EX", "EXAT":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		ms = n * 1000
+	default:
+		ms = n
+	}
+	if unit == "EX" || unit == "PX" {
+		now := time.Now().UnixMilli()
+		if ms > math.MaxInt64-now {
+			return time.Time{}, invalid
+		}
+		ms += now
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// setCommand implements SET key value [NX | XX] [GET]
+// [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | KEEPTTL].
+func setCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+
+	var nx, xx, get, keepTTL bool
+	var expireUnit string
+	var expireArg int
+	var at time.Time
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expireUnit != "" || i+1 == len(args) {
+				return syntaxErrorReply
+			}
+			var err error
+			if at, err = parseExpireArg("set", opt, args[i+1]); err != nil {
+				return errorReply(err)
+			}
+			expireUnit, expireArg = opt, i
+			i++
+		default:
+			return syntaxErrorReply
+		}
+	}
+	if (nx && xx) || (keepTTL && expireUnit != "") {
+		return syntaxErrorReply
+	}
+
+	old, exists := c.store.lookup(key)
+
+	reply := resp.OK
+	if get {
+		reply = resp.NullBulk
+		if exists {
+			b, isString := old.value.([]byte)
+			if !isString {
+				return wrongTypeReply
+			}
+			reply = resp.BulkString(string(b))
+		}
+	}
+
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			return reply
+		}
+		return resp.NullBulk
+	}
+
+	d := StoreData{value: []byte(args[2]), expiresAt: at}
+	if keepTTL && exists {
+		d.expiresAt = old.expiresAt
+	}
+	if expireUnit != "" {
+		logged := args
+		if expireUnit == "EX" || expireUnit == "PX" {
+			logged = slices.Clone(args)
+			logged[expireArg], logged[expireArg+1] = "PXAT", strconv.FormatInt(at.UnixMilli(), 10)
+		}
+		defer c.propagateExpiry(key, logged...)
+	}
+	if !d.expiresAt.IsZero() && !time.Now().Before(d.expiresAt) && !c.store.replaying() {
+		// An absolute deadline that has already passed leaves no key behind.
+		c.store.remove(key)
+		if exists {
+			c.store.notify(notifyGeneric, "del", key)
+		}
+		return reply
+	}
+	c.store.put(key, d)
+	c.store.notify(notifyString, "set", key)
+	if !at.IsZero() {
+		c.store.notify(notifyGeneric, "expire", key)
+	}
+	return reply
+}
+
+// getCommand implements GET key.
+func getCommand(c *Client, args []string) resp.Value {
+	d, ok, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if !ok {
+		return resp.NullBulk
+	}
+	return resp.BulkString(string(d.bytes()))
+}
+
+// incrCommand implements INCR key.
+func incrCommand(c *Client, args []string) resp.Value {
+	return incrBy(c, args[1], 1)
+}
+
+// decrCommand implements DECR key.
+func decrCommand(c *Client, args []string) resp.Value {
+	return incrBy(c, args[1], -1)
+}
+
+// incrbyCommand implements INCRBY key increment.
+func incrbyCommand(c *Client, args []string) resp.Value {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	return incrBy(c, args[1], delta)
+}
+
+// decrbyCommand implements DECRBY key decrement.
+func decrbyCommand(c *Client, args []string) resp.Value {
+	delta, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	if delta == math.MinInt64 {
+		return resp.Error("ERR decrement would overflow")
+	}
+	return incrBy(c, args[1], -delta)
+}
+
+// incrBy adds delta to the integer stored at key, treating a missing key as
+// 0. The key keeps its TTL.
+func incrBy(c *Client, key string, delta int64) resp.Value {
+	d, ok, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	var n int64
+	if ok {
+		if n, ok = parseInt(string(d.bytes())); !ok {
+			return notIntegerReply
+		}
+	} else {
+		d = StoreData{}
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return resp.Error("ERR increment or decrement would overflow")
+	}
+	n += delta
+
+	d.value = strconv.AppendInt(nil, n, 10)
+	c.store.put(key, d)
+	return resp.Integer(n)
+}
+
+// incrbyfloatCommand implements INCRBYFLOAT key increment. The key keeps
+// its TTL.
+func incrbyfloatCommand(c *Client, args []string) resp.Value {
+	delta, ok := parseFloat(args[2])
+	if !ok {
+		return notFloatReply
+	}
+
+	key := args[1]
+	d, ok, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	var f float64
+	if ok {
+		if f, ok = parseFloat(string(d.bytes())); !ok {
+			return notFloatReply
+		}
+	} else {
+		d = StoreData{}
+	}
+
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return resp.Error("ERR increment would produce NaN or Infinity")
+	}
+
+	s := formatFloat(f)
+	d.value = []byte(s)
+	c.store.put(key, d)
+	return resp.BulkString(s)
+}
+
+// appendCommand implements APPEND key value. A missing key is created; an
+// existing one keeps its TTL.
+func appendCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	d, _, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	if !checkStringLength(c, len(b)+len(args[2])) {
+		return stringTooLongReply
+	}
+
+	b = append(b, args[2]...)
+	d.value = b
+	c.store.put(key, d)
+	return resp.Integer(int64(len(b)))
+}
+
+// strlenCommand implements STRLEN key.
+func strlenCommand(c *Client, args []string) resp.Value {
+	d, _, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(int64(len(d.bytes())))
+}
+
+// getrangeCommand implements GETRANGE key start end. Both offsets are
+// inclusive and may be negative to count from the end of the string.
+func getrangeCommand(c *Client, args []string) resp.Value {
+	start, ok1 := parseInt(args[2])
+	end, ok2 := parseInt(args[3])
+	if !ok1 || !ok2 {
+		return notIntegerReply
+	}
+
+	d, _, err := c.store.lookupString(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	n := int64(len(b))
+	if start < 0 && end < 0 && start > end {
+		return resp.BulkString("")
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	start, end = max(start, 0), max(end, 0)
+	end = min(end, n-1)
+	if start > end || n == 0 {
+		return resp.BulkString("")
+	}
+	return resp.BulkString(string(b[start : end+1]))
+}
+
+// setrangeCommand implements SETRANGE key offset value. The string is
+// zero-padded if offset lies beyond its end; an existing key keeps its TTL.
+func setrangeCommand(c *Client, args []string) resp.Value {
+	offset, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	if offset < 0 {
+		return resp.Error("ERR offset is out of range")
+	}
+
+	key, value := args[1], args[3]
+	d, _, err := c.store.lookupString(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	b := d.bytes()
+	if value == "" {
+		// Nothing to write, and a missing key is not created.
+		return resp.Integer(int64(len(b)))
+	}
+	if offset > int64(c.srv.cfg.ProtoMaxBulkLen) || !checkStringLength(c, int(offset)+len(value)) {
+		return stringTooLongReply
+	}
+	if need := int(offset) + len(value); need > len(b) {
+		b = append(b, make([]byte, need-len(b))...)
+	}
+	copy(b[offset:], value)
+	d.value = b
+	c.store.put(key, d)
+	return resp.Integer(int64(len(b)))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestSetOptions(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "k", "v1", "XX"}, resp.NullBulk},
+		{[]string{"SET", "k", "v1", "NX"}, resp.OK},
+		{[]string{"SET", "k", "v2", "NX"}, resp.NullBulk},
+		{[]string{"SET", "k", "v2", "XX", "GET"}, resp.BulkString("v1")},
+		{[]string{"SET", "k", "v3", "NX", "GET"}, resp.BulkString("v2")},
+		{[]string{"SET", "new", "v", "GET"}, resp.NullBulk},
+		{[]string{"TTL", "k"}, resp.Integer(-1)},
+		{[]string{"SET", "k", "v", "EX", "100"}, resp.OK},
+		{[]string{"TTL", "k"}, resp.Integer(100)},
+		{[]string{"SET", "k", "v", "KEEPTTL"}, resp.OK},
+		{[]string{"TTL", "k"}, resp.Integer(100)},
+		{[]string{"SET", "k", "v"}, resp.OK},
+		{[]string{"TTL", "k"}, resp.Integer(-1)},
+		{[]string{"SET", "k", "v", "PX", "5000"}, resp.OK},
+		{[]string{"TTL", "k"}, resp.Integer(5)},
+		{[]string{"SET", "k", "v", "EX", "0"}, resp.Error("ERR invalid expire time in 'set' command")},
+		{[]string{"SET", "k", "v", "EX", "ten"}, notIntegerReply},
+		{[]string{"SET", "k", "v", "EX"}, syntaxErrorReply},
+		{[]string{"SET", "k", "v", "EX", "1", "PX", "1"}, syntaxErrorReply},
+		{[]string{"SET", "k", "v", "EX", "1", "KEEPTTL"}, syntaxErrorReply},
+		{[]string{"SET", "k", "v", "NX", "XX"}, syntaxErrorReply},
+		{[]string{"SET", "k", "v", "BOGUS"}, syntaxErrorReply},
+	})
+}
+
+func TestSetAbsoluteExpiry(t *testing.T) {
+	c := newTestClient()
+
+	at := time.Now().Add(time.Hour)
+	do(c, "SET", "k", "v", "PXAT", strconv.FormatInt(at.UnixMilli(), 10))
+	if got := c.store.data["k"].expiresAt.UnixMilli(); got != at.UnixMilli() {
+		t.Errorf("expected deadline %d, got %d", at.UnixMilli(), got)
+	}
+
+	do(c, "SET", "k", "v", "EXAT", "1")
+	if _, ok := c.store.data["k"]; ok {
+		t.Error("SET with a past EXAT left the key behind")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "text", "abc")
+	do(c, "SET", "padded", "007")
+	do(c, "SET", "max", "9223372036854775807")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"INCR", "n"}, resp.Integer(1)},
+		{[]string{"INCRBY", "n", "41"}, resp.Integer(42)},
+		{[]string{"DECR", "n"}, resp.Integer(41)},
+		{[]string{"DECRBY", "n", "50"}, resp.Integer(-9)},
+		{[]string{"GET", "n"}, resp.BulkString("-9")},
+		{[]string{"INCR", "text"}, notIntegerReply},
+		{[]string{"INCR", "padded"}, notIntegerReply},
+		{[]string{"INCRBY", "n", "1.5"}, notIntegerReply},
+		{[]string{"INCR", "max"}, resp.Error("ERR increment or decrement would overflow")},
+		{[]string{"DECRBY", "n", "-9223372036854775808"}, resp.Error("ERR decrement would overflow")},
+	})
+}
+
+func TestIncrKeepsTTL(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "n", "1", "EX", "100")
+	do(c, "INCR", "n")
+
+	if got := do(c, "TTL", "n"); got.Int != 100 {
+		t.Errorf("expected INCR to keep the TTL, got %+v", got)
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "f", "10.50")
+	do(c, "SET", "e", "5.0e3")
+	do(c, "SET", "text", "abc")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"INCRBYFLOAT", "f", "0.1"}, resp.BulkString("10.6")},
+		{[]string{"INCRBYFLOAT", "f", "-5"}, resp.BulkString("5.6")},
+		{[]string{"INCRBYFLOAT", "e", "2.0e2"}, resp.BulkString("5200")},
+		{[]string{"INCRBYFLOAT", "new", "3"}, resp.BulkString("3")},
+		{[]string{"INCRBYFLOAT", "new", "1e-5"}, resp.BulkString("3.00001")},
+		{[]string{"INCRBYFLOAT", "text", "1"}, notFloatReply},
+		{[]string{"INCRBYFLOAT", "f", "nan"}, notFloatReply},
+		{[]string{"INCRBYFLOAT", "f", "inf"}, resp.Error("ERR increment would produce NaN or Infinity")},
+		{[]string{"GET", "f"}, resp.BulkString("5.6")},
+	})
+}
+
+func TestAppendAndStrlen(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"STRLEN", "k"}, resp.Integer(0)},
+		{[]string{"APPEND", "k", "Hello"}, resp.Integer(5)},
+		{[]string{"APPEND", "k", " World"}, resp.Integer(11)},
+		{[]string{"GET", "k"}, resp.BulkString("Hello World")},
+		{[]string{"STRLEN", "k"}, resp.Integer(11)},
+		{[]string{"APPEND", "k", "\x00\xff"}, resp.Integer(13)},
+	})
+
+	c.srv.cfg.ProtoMaxBulkLen = 16
+	if got := do(c, "APPEND", "k", "too long now"); got.Str != stringTooLongReply.Str {
+		t.Errorf("expected size limit error, got %+v", got)
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "This is a string")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GETRANGE", "k", "0", "3"}, resp.BulkString("This")},
+		{[]string{"GETRANGE", "k", "-3", "-1"}, resp.BulkString("ing")},
+		{[]string{"GETRANGE", "k", "0", "-1"}, resp.BulkString("This is a string")},
+		{[]string{"GETRANGE", "k", "10", "100"}, resp.BulkString("string")},
+		{[]string{"GETRANGE", "k", "5", "3"}, resp.BulkString("")},
+		{[]string{"GETRANGE", "k", "-1", "-5"}, resp.BulkString("")},
+		{[]string{"GETRANGE", "k", "-100", "3"}, resp.BulkString("This")},
+		{[]string{"GETRANGE", "missing", "0", "-1"}, resp.BulkString("")},
+		{[]string{"GETRANGE", "k", "a", "1"}, notIntegerReply},
+	})
+}
+
+func TestSetRange(t *testing.T) {
+	c := newTestClient()
+	do(c, "SET", "k", "Hello World")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SETRANGE", "k", "6", "Redis"}, resp.Integer(11)},
+		{[]string{"GET", "k"}, resp.BulkString("Hello Redis")},
+		{[]string{"SETRANGE", "pad", "3", "ab"}, resp.Integer(5)},
+		{[]string{"GET", "pad"}, resp.BulkString("\x00\x00\x00ab")},
+		{[]string{"SETRANGE", "missing", "5", ""}, resp.Integer(0)},
+		{[]string{"EXISTS", "missing"}, resp.Integer(0)},
+		{[]string{"SETRANGE", "k", "-1", "x"}, resp.Error("ERR offset is out of range")},
+		{[]string{"SETRANGE", "k", "536870912", "x"}, stringTooLongReply},
+	})
+}
+
+func TestMultiKeyStrings(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MSET", "a", "1", "b", "2"}, resp.OK},
+		{[]string{"MGET", "a", "missing", "b"}, resp.Array(resp.BulkString("1"), resp.NullBulk, resp.BulkString("2"))},
+		{[]string{"MSET", "a", "1", "b"}, resp.Error("ERR wrong number of arguments for 'mset' command")},
+		{[]string{"MSETNX", "b", "3", "c", "3"}, resp.Integer(0)},
+		{[]string{"EXISTS", "c"}, resp.Integer(0)},
+		{[]string{"MSETNX", "c", "3", "d", "4"}, resp.Integer(1)},
+		{[]string{"MGET", "c", "d"}, resp.Array(resp.BulkString("3"), resp.BulkString("4"))},
+	})
+}
+
+func TestConditionalAndCombinedStrings(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SETNX", "lock", "me"}, resp.Integer(1)},
+		{[]string{"SETNX", "lock", "you"}, resp.Integer(0)},
+		{[]string{"GET", "lock"}, resp.BulkString("me")},
+		{[]string{"EXPIRE", "lock", "100"}, resp.Integer(1)},
+		{[]string{"GETSET", "lock", "you"}, resp.BulkString("me")},
+		{[]string{"TTL", "lock"}, resp.Integer(-1)},
+		{[]string{"GETSET", "fresh", "v"}, resp.NullBulk},
+		{[]string{"GETDEL", "fresh"}, resp.BulkString("v")},
+		{[]string{"GETDEL", "fresh"}, resp.NullBulk},
+		{[]string{"GETEX", "lock", "EX", "100"}, resp.BulkString("you")},
+		{[]string{"TTL", "lock"}, resp.Integer(100)},
+		{[]string{"GETEX", "lock"}, resp.BulkString("you")},
+		{[]string{"TTL", "lock"}, resp.Integer(100)},
+		{[]string{"GETEX", "lock", "PERSIST"}, resp.BulkString("you")},
+		{[]string{"TTL", "lock"}, resp.Integer(-1)},
+		{[]string{"GETEX", "missing", "EX", "100"}, resp.NullBulk},
+		{[]string{"GETEX", "lock", "EX"}, syntaxErrorReply},
+		{[]string{"GETEX", "lock", "EX", "0"}, resp.Error("ERR invalid expire time in 'getex' command")},
+		{[]string{"GETEX", "lock", "PXAT", "1"}, resp.BulkString("you")},
+		{[]string{"EXISTS", "lock"}, resp.Integer(0)},
+	})
+}
+
+func TestCas(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"CAS", "k", "", "v"}, resp.Integer(0)},
+		{[]string{"EXISTS", "k"}, resp.Integer(0)},
+		{[]string{"SET", "k", "old", "EX", "100"}, resp.OK},
+		{[]string{"CAS", "k", "other", "new"}, resp.Integer(0)},
+		{[]string{"GET", "k"}, resp.BulkString("old")},
+		{[]string{"CAS", "k", "old", "new"}, resp.Integer(1)},
+		{[]string{"GET", "k"}, resp.BulkString("new")},
+		{[]string{"TTL", "k"}, resp.Integer(100)},
+		{[]string{"LPUSH", "l", "x"}, resp.Integer(1)},
+		{[]string{"CAS", "l", "x", "y"}, wrongTypeReply},
+	})
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+func init() {
+	RegisterCommand(&Command{Name: "zadd", Arity: -4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zaddCommand})
+	RegisterCommand(&Command{Name: "zincrby", Arity: 4, Flags: flagWrite | flagDenyOOM | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zincrbyCommand})
+	RegisterCommand(&Command{Name: "zrem", Arity: -3, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zremCommand})
+	RegisterCommand(&Command{Name: "zscore", Arity: 3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zscoreCommand})
+	RegisterCommand(&Command{Name: "zcard", Arity: 2, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zcardCommand})
+	RegisterCommand(&Command{Name: "zrange", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrangeCommand})
+	RegisterCommand(&Command{Name: "zrevrange", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrevrangeCommand})
+	RegisterCommand(&Command{Name: "zrangebyscore", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrangeByScoreCommand})
+	RegisterCommand(&Command{Name: "zrevrangebyscore", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrangeByScoreCommand})
+	RegisterCommand(&Command{Name: "zrangebylex", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrangeByLexCommand})
+	RegisterCommand(&Command{Name: "zrevrangebylex", Arity: -4, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrangeByLexCommand})
+	RegisterCommand(&Command{Name: "zcount", Arity: 4, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zcountCommand})
+	RegisterCommand(&Command{Name: "zlexcount", Arity: 4, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zlexcountCommand})
+	RegisterCommand(&Command{Name: "zunionstore", Arity: -4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: zsetAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "zinterstore", Arity: -4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: zsetAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "zdiffstore", Arity: -4, Flags: flagWrite | flagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1, Handler: zsetAlgebraStoreCommand})
+	RegisterCommand(&Command{Name: "zpopmin", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zpopCommand})
+	RegisterCommand(&Command{Name: "zpopmax", Arity: -2, Flags: flagWrite | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zpopCommand})
+	RegisterCommand(&Command{Name: "bzpopmin", Arity: -3, Flags: flagWrite | flagFast | flagBlocking, FirstKey: 1, LastKey: -2, Step: 1, Handler: bzpopCommand})
+	RegisterCommand(&Command{Name: "bzpopmax", Arity: -3, Flags: flagWrite | flagFast | flagBlocking, FirstKey: 1, LastKey: -2, Step: 1, Handler: bzpopCommand})
+	RegisterCommand(&Command{Name: "zmpop", Arity: -4, Flags: flagWrite, Handler: zmpopCommand})
+	RegisterCommand(&Command{Name: "zrandmember", Arity: -2, Flags: flagReadonly, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrandmemberCommand})
+	RegisterCommand(&Command{Name: "zrank", Arity: -3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrankCommand})
+	RegisterCommand(&Command{Name: "zrevrank", Arity: -3, Flags: flagReadonly | flagFast, FirstKey: 1, LastKey: 1, Step: 1, Handler: zrankCommand})
+}
+
+// scoredReply builds the reply for members listed with their scores: pairs
+// of [member, score] for RESP3 clients and a flat member, score, ... array
+// for RESP2 ones, where the scores become bulk strings.
+func scoredReply(c *Client, nodes []*skipNode, withScores bool) resp.Value {
+	out := make([]resp.Value, 0, len(nodes))
+	for _, x := range nodes {
+		switch {
+		case !withScores:
+			out = append(out, resp.BulkString(x.member))
+		case c.proto >= 3:
+			out = append(out, resp.Array(resp.BulkString(x.member), resp.Double(x.score)))
+		default:
+			out = append(out, resp.BulkString(x.member), resp.Double(x.score))
+		}
+	}
+	return resp.Array(out...)
+}
+
+// zaddCommand implements ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member
+// [score member ...]. It replies with the number of members added, or
+// added and updated with CH; with INCR it behaves like ZINCRBY and replies
+// with the new score, or nil if a condition prevented the update.
+func zaddCommand(c *Client, args []string) resp.Value {
+	var flags zaddFlags
+	var ch bool
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			flags.incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return syntaxErrorReply
+	}
+	if flags.nx && flags.xx {
+		return resp.Error("ERR XX and NX options at the same time are not compatible")
+	}
+	if (flags.gt && flags.lt) || (flags.nx && (flags.gt || flags.lt)) {
+		return resp.Error("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.incr && len(pairs) > 2 {
+		return resp.Error("ERR INCR option supports a single increment-element pair")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, ok := parseFloat(pairs[2*i])
+		if !ok {
+			return notFloatReply
+		}
+		scores[i] = score
+	}
+
+	z, err := c.store.zsetForWrite(args[1], flags.xx)
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		if flags.incr {
+			return resp.NullBulk
+		}
+		return resp.Integer(0)
+	}
+	if flags.incr {
+		return zincr(c, args[1], z, pairs[1], scores[0], flags)
+	}
+	var n int64
+	changed := false
+	for i, score := range scores {
+		_, res, _ := z.upsert(pairs[2*i+1], score, flags)
+		if res == zaddAdded || (ch && res == zaddUpdated) {
+			n++
+		}
+		changed = changed || res == zaddAdded || res == zaddUpdated
+	}
+	c.store.doneWithZset(args[1], z)
+	if changed {
+		c.store.signalModified(args[1])
+	}
+	return resp.Integer(n)
+}
+
+// zincrbyCommand implements ZINCRBY key increment member, replying with the
+// new score.
+func zincrbyCommand(c *Client, args []string) resp.Value {
+	incr, ok := parseFloat(args[2])
+	if !ok {
+		return notFloatReply
+	}
+	z, err := c.store.zsetForWrite(args[1], false)
+	if err != nil {
+		return errorReply(err)
+	}
+	return zincr(c, args[1], z, args[3], incr, zaddFlags{incr: true})
+}
+
+// zincr applies an increment to member for ZINCRBY and ZADD INCR.
+func zincr(c *Client, key string, z *zsetValue, member string, incr float64, flags zaddFlags) resp.Value {
+	score, res, err := z.upsert(member, incr, flags)
+	c.store.doneWithZset(key, z)
+	if res == zaddAdded || res == zaddUpdated {
+		c.store.signalModified(key)
+	}
+	switch {
+	case err != nil:
+		return errorReply(err)
+	case res == zaddSkipped:
+		return resp.NullBulk
+	}
+	return resp.Double(score)
+}
+
+// zremCommand implements ZREM key member [member ...]. A sorted set left
+// empty is deleted.
+func zremCommand(c *Client, args []string) resp.Value {
+	key := args[1]
+	z, err := c.store.lookupZset(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Integer(0)
+	}
+	var removed int64
+	for _, m := range args[2:] {
+		if z.remove(m) {
+			removed++
+		}
+	}
+	c.store.doneWithZset(key, z)
+	if removed > 0 {
+		c.store.signalModified(key)
+	}
+	return resp.Integer(removed)
+}
+
+// zscoreCommand implements ZSCORE key member.
+func zscoreCommand(c *Client, args []string) resp.Value {
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	score, ok := z.score(args[2])
+	if !ok {
+		return resp.NullBulk
+	}
+	return resp.Double(score)
+}
+
+// zcardCommand implements ZCARD key.
+func zcardCommand(c *Client, args []string) resp.Value {
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	return resp.Integer(int64(z.size()))
+}
+
+// zrangeBy selects how ZRANGE interprets its start and stop arguments.
+type zrangeBy int
+
+const (
+	zrangeByRank zrangeBy = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeSpec is a parsed ZRANGE-family request. Without LIMIT, count is
+// negative and every element in range is returned.
+type zrangeSpec struct {
+	by         zrangeBy
+	rev        bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	count      int64
+}
+
+var (
+	errMinMaxFloat = errors.New("ERR min or max is not a float")
+	errMinMaxLex   = errors.New("ERR min or max not valid string range item")
+)
+
+// parseScoreRange parses the min and max of a score interval, where a
+// leading "(" makes an end exclusive and "-inf" and "+inf" are allowed.
+func parseScoreRange(minArg, maxArg string) (scoreRange, error) {
+	var r scoreRange
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseScoreBound(minArg)
+	r.max, r.maxex, ok2 = parseScoreBound(maxArg)
+	if !ok1 || !ok2 {
+		return r, errMinMaxFloat
+	}
+	return r, nil
+}
+
+func parseScoreBound(arg string) (score float64, exclusive, ok bool) {
+	if rest, found := strings.CutPrefix(arg, "("); found {
+		arg, exclusive = rest, true
+	}
+	score, ok = parseFloat(arg)
+	return score, exclusive, ok
+}
+
+// parseLexRange parses the min and max of a member interval: "[a" and "(a"
+// are inclusive and exclusive bounds, "-" and "+" the infinities.
+func parseLexRange(minArg, maxArg string) (lexRange, error) {
+	var r lexRange
+	var ok1, ok2 bool
+	r.min, ok1 = parseLexBound(minArg)
+	r.max, ok2 = parseLexBound(maxArg)
+	if !ok1 || !ok2 {
+		return r, errMinMaxLex
+	}
+	return r, nil
+}
+
+func parseLexBound(arg string) (lexBound, bool) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, true
+	case arg == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, true
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// parseZrangeOptions parses the options following the key and range of a
+// ZRANGE-family command into spec. BYSCORE, BYLEX and REV are only taken
+// when allowBy is set, as ZRANGE does.
+func parseZrangeOptions(opts []string, spec *zrangeSpec, allowBy bool) error {
+	spec.count = -1
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i]); {
+		case opt == "WITHSCORES":
+			spec.withScores = true
+		case opt == "LIMIT" && i+2 < len(opts):
+			offset, ok1 := parseInt(opts[i+1])
+			count, ok2 := parseInt(opts[i+2])
+			if !ok1 || !ok2 {
+				return errNotInteger
+			}
+			spec.hasLimit, spec.offset, spec.count = true, offset, count
+			i += 2
+		case allowBy && opt == "BYSCORE":
+			spec.by = zrangeByScore
+		case allowBy && opt == "BYLEX":
+			spec.by = zrangeByLex
+		case allowBy && opt == "REV":
+			spec.rev = true
+		default:
+			return errSyntax
+		}
+	}
+	if spec.hasLimit && spec.by == zrangeByRank {
+		return errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if spec.withScores && spec.by == zrangeByLex {
+		return errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// zrangeReply runs a parsed ZRANGE-family request against the sorted set
+// at key. For reversed score and lex ranges the bounds come max first, as
+// the commands take them.
+func zrangeReply(c *Client, spec zrangeSpec, key, startArg, endArg string) resp.Value {
+	var (
+		start, end int64
+		scores     scoreRange
+		lex        lexRange
+		err        error
+	)
+	if spec.rev && spec.by != zrangeByRank {
+		startArg, endArg = endArg, startArg
+	}
+	switch spec.by {
+	case zrangeByRank:
+		var ok1, ok2 bool
+		start, ok1 = parseInt(startArg)
+		end, ok2 = parseInt(endArg)
+		if !ok1 || !ok2 {
+			err = errNotInteger
+		}
+	case zrangeByScore:
+		scores, err = parseScoreRange(startArg, endArg)
+	case zrangeByLex:
+		lex, err = parseLexRange(startArg, endArg)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	z, err := c.store.lookupZset(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Array()
+	}
+
+	if spec.by == zrangeByRank {
+		lo, hi := normalizeRange(start, end, z.size())
+		var x *skipNode
+		if spec.rev {
+			x = z.zsl.byRank(z.size() - 1 - lo)
+		} else {
+			x = z.zsl.byRank(lo)
+		}
+		return scoredReply(c, walkRange(x, spec.rev, nil, 0, int64(hi-lo)), spec.withScores)
+	}
+
+	var x *skipNode
+	var inRange func(*skipNode) bool
+	switch {
+	case spec.by == zrangeByScore && spec.rev:
+		x, inRange = z.zsl.lastInScoreRange(scores), func(x *skipNode) bool { return scores.gteMin(x.score) }
+	case spec.by == zrangeByScore:
+		x, inRange = z.zsl.firstInScoreRange(scores), func(x *skipNode) bool { return scores.lteMax(x.score) }
+	case spec.rev:
+		x, inRange = z.zsl.lastInLexRange(lex), func(x *skipNode) bool { return lex.gteMin(x.member) }
+	default:
+		x, inRange = z.zsl.firstInLexRange(lex), func(x *skipNode) bool { return lex.lteMax(x.member) }
+	}
+	if spec.offset < 0 {
+		return resp.Array()
+	}
+	return scoredReply(c, walkRange(x, spec.rev, inRange, spec.offset, spec.count), spec.withScores)
+}
+
+// walkRange collects up to count elements starting offset steps from x,
+// walking backwards if rev is set and stopping at the first element for
+// which inRange, if given, is false. A negative count means no limit.
+func walkRange(x *skipNode, rev bool, inRange func(*skipNode) bool, offset, count int64) []*skipNode {
+	step := func(x *skipNode) *skipNode {
+		if rev {
+			return x.backward
+		}
+		return x.next()
+	}
+	for ; x != nil && offset > 0; offset-- {
+		x = step(x)
+	}
+	var nodes []*skipNode
+	for ; x != nil && count != 0 && (inRange == nil || inRange(x)); count-- {
+		nodes = append(nodes, x)
+		x = step(x)
+	}
+	return nodes
+}
+
+// zrangeCommand implements ZRANGE key start stop [BYSCORE|BYLEX] [REV]
+// [LIMIT offset count] [WITHSCORES].
+func zrangeCommand(c *Client, args []string) resp.Value {
+	var spec zrangeSpec
+	if err := parseZrangeOptions(args[4:], &spec, true); err != nil {
+		return errorReply(err)
+	}
+	return zrangeReply(c, spec, args[1], args[2], args[3])
+}
+
+// zrevrangeCommand implements ZREVRANGE key start stop [WITHSCORES].
+func zrevrangeCommand(c *Client, args []string) resp.Value {
+	spec := zrangeSpec{rev: true}
+	if err := parseZrangeOptions(args[4:], &spec, false); err != nil {
+		return errorReply(err)
+	}
+	return zrangeReply(c, spec, args[1], args[2], args[3])
+}
+
+// zrangeByScoreCommand implements ZRANGEBYSCORE key min max and
+// ZREVRANGEBYSCORE key max min, both taking [WITHSCORES] [LIMIT offset
+// count].
+func zrangeByScoreCommand(c *Client, args []string) resp.Value {
+	spec := zrangeSpec{by: zrangeByScore, rev: strings.EqualFold(args[0], "zrevrangebyscore")}
+	if err := parseZrangeOptions(args[4:], &spec, false); err != nil {
+		return errorReply(err)
+	}
+	return zrangeReply(c, spec, args[1], args[2], args[3])
+}
+
+// zrangeByLexCommand implements ZRANGEBYLEX key min max and ZREVRANGEBYLEX
+// key max min, both taking [LIMIT offset count].
+func zrangeByLexCommand(c *Client, args []string) resp.Value {
+	spec := zrangeSpec{by: zrangeByLex, rev: strings.EqualFold(args[0], "zrevrangebylex")}
+	if err := parseZrangeOptions(args[4:], &spec, false); err != nil {
+		return errorReply(err)
+	}
+	return zrangeReply(c, spec, args[1], args[2], args[3])
+}
+
+// zcountCommand implements ZCOUNT key min max. The count comes from the
+// ranks of the ends of the range, so it does not walk the elements.
+func zcountCommand(c *Client, args []string) resp.Value {
+	r, err := parseScoreRange(args[2], args[3])
+	if err != nil {
+		return errorReply(err)
+	}
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Integer(0)
+	}
+	return resp.Integer(countRange(z.zsl, z.zsl.firstInScoreRange(r), z.zsl.lastInScoreRange(r)))
+}
+
+// zlexcountCommand implements ZLEXCOUNT key min max.
+func zlexcountCommand(c *Client, args []string) resp.Value {
+	r, err := parseLexRange(args[2], args[3])
+	if err != nil {
+		return errorReply(err)
+	}
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Integer(0)
+	}
+	return resp.Integer(countRange(z.zsl, z.zsl.firstInLexRange(r), z.zsl.lastInLexRange(r)))
+}
+
+// countRange returns the number of elements from first to last inclusive,
+// 0 if the range is empty.
+func countRange(sl *skiplist, first, last *skipNode) int64 {
+	if first == nil || last == nil {
+		return 0
+	}
+	return int64(sl.rank(last.score, last.member) - sl.rank(first.score, first.member) + 1)
+}
+
+// zrankCommand implements ZRANK and ZREVRANK key member [WITHSCORE].
+func zrankCommand(c *Client, args []string) resp.Value {
+	var withScore bool
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[3], "WITHSCORE"):
+		withScore = true
+	case len(args) > 3:
+		return syntaxErrorReply
+	}
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	rank, ok := z.rank(args[2])
+	if !ok {
+		if withScore {
+			return resp.NullArray
+		}
+		return resp.NullBulk
+	}
+	if strings.EqualFold(args[0], "zrevrank") {
+		rank = z.size() - 1 - rank
+	}
+	if !withScore {
+		return resp.Integer(int64(rank))
+	}
+	score, _ := z.score(args[2])
+	return resp.Array(resp.Integer(int64(rank)), resp.Double(score))
+}
+
+// zsetAggregate combines the weighted scores a member has in several inputs.
+type zsetAggregate func(acc, score float64) float64
+
+func aggregateSum(acc, score float64) float64 {
+	// inf + -inf has no meaningful sum; Redis scores it 0.
+	if sum := acc + score; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// zsetAlgebraStoreCommand implements ZUNIONSTORE and ZINTERSTORE
+// destination numkeys key [key ...] [WEIGHTS weight ...]
+// [AGGREGATE SUM|MIN|MAX] and ZDIFFSTORE destination numkeys key [key ...].
+// Plain sets are accepted as inputs with every member scored 1. The
+// destination is overwritten, or deleted if the result is empty, and the
+// reply is the size of the result.
+func zsetAlgebraStoreCommand(c *Client, args []string) resp.Value {
+	name := strings.ToLower(args[0])
+	numkeys, ok := parseInt(args[2])
+	if !ok {
+		return notIntegerReply
+	}
+	if numkeys < 1 {
+		return resp.Error("ERR at least 1 input key is needed for '" + name + "' command")
+	}
+	if numkeys > int64(len(args)-3) {
+		return syntaxErrorReply
+	}
+	keys := args[3 : 3+numkeys]
+	rest := args[3+numkeys:]
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := zsetAggregate(aggregateSum)
+	for i := 0; i < len(rest); i++ {
+		switch opt := strings.ToUpper(rest[i]); {
+		case name == "zdiffstore":
+			return syntaxErrorReply
+		case opt == "WEIGHTS" && i+len(keys) < len(rest):
+			for j := range weights {
+				w, ok := parseFloat(rest[i+1+j])
+				if !ok {
+					return resp.Error("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+			i += len(keys)
+		case opt == "AGGREGATE" && i+1 < len(rest):
+			switch strings.ToUpper(rest[i+1]) {
+			case "SUM":
+				aggregate = aggregateSum
+			case "MIN":
+				aggregate = math.Min
+			case "MAX":
+				aggregate = math.Max
+			default:
+				return syntaxErrorReply
+			}
+			i++
+		default:
+			return syntaxErrorReply
+		}
+	}
+
+	operands := make([]zsetOperand, len(keys))
+	for i, key := range keys {
+		o, err := c.store.lookupZsetOperand(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		operands[i] = o
+	}
+
+	var result map[string]float64
+	switch name {
+	case "zunionstore":
+		result = zunion(operands, weights, aggregate)
+	case "zinterstore":
+		result = zinter(operands, weights, aggregate)
+	default:
+		result = zdiff(operands)
+	}
+
+	dst := args[1]
+	if len(result) == 0 {
+		c.store.remove(dst)
+		return resp.Integer(0)
+	}
+	z := newZset()
+	for m, score := range result {
+		z.add(m, score)
+	}
+	c.store.put(dst, StoreData{value: z})
+	return resp.Integer(int64(z.size()))
+}
+
+// weighted scales a score by a weight, scoring inf * 0 as 0 rather than NaN.
+func weighted(score, weight float64) float64 {
+	if v := score * weight; !math.IsNaN(v) {
+		return v
+	}
+	return 0
+}
+
+func zunion(operands []zsetOperand, weights []float64, aggregate zsetAggregate) map[string]float64 {
+	result := make(map[string]float64)
+	for i, o := range operands {
+		o.each(func(m string, score float64) {
+			score = weighted(score, weights[i])
+			if acc, ok := result[m]; ok {
+				score = aggregate(acc, score)
+			}
+			result[m] = score
+		})
+	}
+	return result
+}
+
+func zinter(operands []zsetOperand, weights []float64, aggregate zsetAggregate) map[string]float64 {
+	result := make(map[string]float64)
+	// Walk the smallest input and probe the others.
+	smallest := 0
+	for i, o := range operands {
+		if o.size() < operands[smallest].size() {
+			smallest = i
+		}
+	}
+	operands[smallest].each(func(m string, _ float64) {
+		var acc float64
+		for i, o := range operands {
+			score, ok := o.score(m)
+			if !ok {
+				return
+			}
+			score = weighted(score, weights[i])
+			if i == 0 {
+				acc = score
+			} else {
+				acc = aggregate(acc, score)
+			}
+		}
+		result[m] = acc
+	})
+	return result
+}
+
+func zdiff(operands []zsetOperand) map[string]float64 {
+	result := make(map[string]float64)
+	operands[0].each(func(m string, score float64) {
+		for _, o := range operands[1:] {
+			if _, ok := o.score(m); ok {
+				return
+			}
+		}
+		result[m] = score
+	})
+	return result
+}
+
+// zpopCommand implements ZPOPMIN and ZPOPMAX key [count]. Without a count
+// the reply is a flat [member, score] pair; with one it lists the popped
+// members like ZRANGE WITHSCORES. A sorted set left empty is deleted.
+func zpopCommand(c *Client, args []string) resp.Value {
+	if len(args) > 3 {
+		return syntaxErrorReply
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		var ok bool
+		if count, ok = parseInt(args[2]); !ok || count < 0 {
+			return errorReply(errNotPositive)
+		}
+	}
+	key := args[1]
+	z, err := c.store.lookupZset(key)
+	if err != nil {
+		return errorReply(err)
+	}
+	if z == nil {
+		return resp.Array()
+	}
+	nodes := z.pop(count, strings.EqualFold(args[0], "zpopmax"))
+	c.store.doneWithZset(key, z)
+	if len(nodes) > 0 {
+		c.store.signalModified(key)
+	}
+	if len(args) == 2 {
+		return resp.Array(resp.BulkString(nodes[0].member), resp.Double(nodes[0].score))
+	}
+	return scoredReply(c, nodes, true)
+}
+
+// bzpopCommand implements BZPOPMIN and BZPOPMAX key [key ...] timeout. It
+// pops the lowest or highest scored member of the first non-empty sorted
+// set and replies with [key, member, score], blocking like BLPOP if they
+// are all empty.
+func bzpopCommand(c *Client, args []string) resp.Value {
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		return errorReply(err)
+	}
+	keys := args[1 : len(args)-1]
+	for _, key := range keys {
+		z, err := c.store.lookupZset(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if z == nil {
+			continue
+		}
+		x := z.pop(1, strings.EqualFold(args[0], "bzpopmax"))[0]
+		c.store.doneWithZset(key, z)
+		c.store.signalModified(key)
+		return resp.Array(resp.BulkString(key), resp.BulkString(x.member), resp.Double(x.score))
+	}
+	return c.block(args, keys, timeout, resp.NullArray)
+}
+
+// parseMpopArgs parses numkeys key [key ...] <where> [COUNT count], as
+// taken by ZMPOP and BLMPOP, where is one of two words naming the side to
+// pop from. It returns the keys, which of the words was given and the
+// count, which defaults to 1.
+func parseMpopArgs(args []string, side1, side2 string) (keys []string, where string, count int64, err error) {
+	numkeys, ok := parseInt(args[0])
+	if !ok {
+		return nil, "", 0, errNotInteger
+	}
+	if numkeys <= 0 {
+		return nil, "", 0, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numkeys > int64(len(args)-2) {
+		return nil, "", 0, errSyntax
+	}
+	keys = args[1 : 1+numkeys]
+	rest := args[1+numkeys:]
+	if where = strings.ToUpper(rest[0]); where != side1 && where != side2 {
+		return nil, "", 0, errSyntax
+	}
+	count = 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.EqualFold(rest[1], "COUNT"):
+		if count, ok = parseInt(rest[2]); !ok || count <= 0 {
+			return nil, "", 0, errors.New("ERR count should be greater than 0")
+		}
+	default:
+		return nil, "", 0, errSyntax
+	}
+	return keys, where, count, nil
+}
+
+// zmpopCommand implements ZMPOP numkeys key [key ...] MIN|MAX [COUNT count].
+// It pops from the first non-empty sorted set and replies with its name and
+// the popped [member, score] pairs, or nil if every sorted set is empty.
+func zmpopCommand(c *Client, args []string) resp.Value {
+	keys, where, count, err := parseMpopArgs(args[1:], "MIN", "MAX")
+	if err != nil {
+		return errorReply(err)
+	}
+	fromMax := where == "MAX"
+	for _, key := range keys {
+		z, err := c.store.lookupZset(key)
+		if err != nil {
+			return errorReply(err)
+		}
+		if z == nil {
+			continue
+		}
+		nodes := z.pop(count, fromMax)
+		c.store.doneWithZset(key, z)
+		c.store.signalModified(key)
+		pairs := make([]resp.Value, len(nodes))
+		for i, x := range nodes {
+			pairs[i] = resp.Array(resp.BulkString(x.member), resp.Double(x.score))
+		}
+		return resp.Array(resp.BulkString(key), resp.Array(pairs...))
+	}
+	return resp.NullArray
+}
+
+// zrandmemberCommand implements ZRANDMEMBER key [count [WITHSCORES]]. A
+// positive count returns distinct members, a negative one allows repeats.
+func zrandmemberCommand(c *Client, args []string) resp.Value {
+	var withScores bool
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[3], "WITHSCORES"):
+		withScores = true
+	case len(args) > 3:
+		return syntaxErrorReply
+	}
+	var count int64
+	if len(args) >= 3 {
+		var err error
+		if count, err = parseSetCount(args[2], true); err != nil {
+			return errorReply(err)
+		}
+	}
+	z, err := c.store.lookupZset(args[1])
+	if err != nil {
+		return errorReply(err)
+	}
+	if len(args) == 2 {
+		if z == nil {
+			return resp.NullBulk
+		}
+		return resp.BulkString(z.zsl.byRank(rand.IntN(z.size())).member)
+	}
+	if z == nil {
+		return resp.Array()
+	}
+	nodes := make([]*skipNode, 0, z.size())
+	for x := z.zsl.first(); x != nil; x = x.next() {
+		nodes = append(nodes, x)
+	}
+	if count >= 0 {
+		rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+		return scoredReply(c, nodes[:min(count, int64(len(nodes)))], withScores)
+	}
+	picked := make([]*skipNode, -count)
+	for i := range picked {
+		picked[i] = nodes[rand.IntN(len(nodes))]
+	}
+	return scoredReply(c, picked, withScores)
+}
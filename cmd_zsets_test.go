@@ -0,0 +1,310 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+// scored builds the RESP2 WITHSCORES reply for alternating members and
+// scores.
+func scored(pairs ...any) resp.Value {
+	out := make([]resp.Value, 0, len(pairs))
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, resp.BulkString(pairs[i].(string)), resp.Double(pairs[i+1].(float64)))
+	}
+	return resp.Array(out...)
+}
+
+func TestSortedSetBasics(t *testing.T) {
+	c := newTestClient()
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZADD", "board", "10", "ada", "20", "bob", "15", "cy"}, resp.Integer(3)},
+		{[]string{"ZADD", "board", "5", "ada", "1", "dee"}, resp.Integer(1)},
+		{[]string{"ZADD", "board", "1", "x", "2"}, syntaxErrorReply},
+		{[]string{"ZADD", "board", "nan", "x"}, notFloatReply},
+		{[]string{"ZADD", "board", "abc", "x"}, notFloatReply},
+		{[]string{"ZCARD", "board"}, resp.Integer(4)},
+		{[]string{"ZCARD", "missing"}, resp.Integer(0)},
+		{[]string{"ZSCORE", "board", "ada"}, resp.Double(5)},
+		{[]string{"ZSCORE", "board", "nope"}, resp.NullBulk},
+		{[]string{"ZSCORE", "missing", "ada"}, resp.NullBulk},
+		{[]string{"ZRANGE", "board", "0", "-1"}, resp.BulkStrings([]string{"dee", "ada", "cy", "bob"})},
+		{[]string{"ZRANGE", "board", "1", "2", "WITHSCORES"}, scored("ada", 5.0, "cy", 15.0)},
+		{[]string{"ZRANGE", "board", "-1", "100"}, resp.BulkStrings([]string{"bob"})},
+		{[]string{"ZRANGE", "board", "3", "1"}, resp.Array()},
+		{[]string{"ZRANGE", "missing", "0", "-1"}, resp.Array()},
+		{[]string{"ZRANGE", "board", "0", "-1", "BOGUS"}, syntaxErrorReply},
+		{[]string{"ZRANK", "board", "cy"}, resp.Integer(2)},
+		{[]string{"ZREVRANK", "board", "cy"}, resp.Integer(1)},
+		{[]string{"ZRANK", "board", "cy", "WITHSCORE"}, resp.Array(resp.Integer(2), resp.Double(15))},
+		{[]string{"ZRANK", "board", "nope"}, resp.NullBulk},
+		{[]string{"ZRANK", "board", "nope", "WITHSCORE"}, resp.NullArray},
+		{[]string{"TYPE", "board"}, resp.SimpleString("zset")},
+		{[]string{"ZREM", "board", "ada", "nope"}, resp.Integer(1)},
+		{[]string{"ZRANGE", "board", "0", "-1"}, resp.BulkStrings([]string{"dee", "cy", "bob"})},
+		{[]string{"ZREM", "board", "dee", "cy", "bob"}, resp.Integer(3)},
+		{[]string{"EXISTS", "board"}, resp.Integer(0)},
+	})
+}
+
+func TestSortedSetTiesAndInfinities(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "1", "b", "1", "a", "+inf", "top", "-inf", "bottom")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZRANGE", "z", "0", "-1"}, resp.BulkStrings([]string{"bottom", "a", "b", "top"})},
+		{[]string{"ZSCORE", "z", "top"}, resp.Double(math.Inf(1))},
+		{[]string{"SET", "s", "v"}, resp.OK},
+		{[]string{"ZADD", "s", "1", "a"}, wrongTypeReply},
+		{[]string{"ZSCORE", "s", "a"}, wrongTypeReply},
+		{[]string{"ZRANGE", "s", "0", "1"}, wrongTypeReply},
+	})
+
+	c.proto = 3
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZRANGE", "z", "0", "0", "WITHSCORES"}, resp.Array(resp.Array(resp.BulkString("bottom"), resp.Double(math.Inf(-1))))},
+	})
+}
+
+func TestSortedSetScoreRanges(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZRANGEBYSCORE", "z", "2", "4"}, resp.BulkStrings([]string{"b", "c", "d"})},
+		{[]string{"ZRANGEBYSCORE", "z", "(2", "(4"}, resp.BulkStrings([]string{"c"})},
+		{[]string{"ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", "1", "2"}, resp.BulkStrings([]string{"b", "c"})},
+		{[]string{"ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", "3", "-1"}, resp.BulkStrings([]string{"d", "e"})},
+		{[]string{"ZRANGEBYSCORE", "z", "-inf", "+inf", "LIMIT", "-1", "2"}, resp.Array()},
+		{[]string{"ZRANGEBYSCORE", "z", "4", "+inf", "WITHSCORES"}, scored("d", 4.0, "e", 5.0)},
+		{[]string{"ZRANGEBYSCORE", "z", "(3", "(3"}, resp.Array()},
+		{[]string{"ZRANGEBYSCORE", "z", "6", "9"}, resp.Array()},
+		{[]string{"ZRANGEBYSCORE", "z", "x", "9"}, resp.Error("ERR min or max is not a float")},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "9", "LIMIT", "1"}, syntaxErrorReply},
+		{[]string{"ZREVRANGEBYSCORE", "z", "4", "(1"}, resp.BulkStrings([]string{"d", "c", "b"})},
+		{[]string{"ZREVRANGEBYSCORE", "z", "+inf", "-inf", "LIMIT", "1", "1"}, resp.BulkStrings([]string{"d"})},
+		{[]string{"ZRANGE", "z", "(1", "3", "BYSCORE"}, resp.BulkStrings([]string{"b", "c"})},
+		{[]string{"ZRANGE", "z", "+inf", "3", "BYSCORE", "REV", "LIMIT", "0", "2"}, resp.BulkStrings([]string{"e", "d"})},
+		{[]string{"ZRANGE", "z", "0", "1", "REV", "WITHSCORES"}, scored("e", 5.0, "d", 4.0)},
+		{[]string{"ZRANGE", "z", "0", "1", "LIMIT", "0", "1"}, resp.Error("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")},
+		{[]string{"ZREVRANGE", "z", "0", "-2"}, resp.BulkStrings([]string{"e", "d", "c", "b"})},
+		{[]string{"ZREVRANGE", "z", "0", "1", "BYSCORE"}, syntaxErrorReply},
+		{[]string{"ZCOUNT", "z", "(1", "4"}, resp.Integer(3)},
+		{[]string{"ZCOUNT", "z", "-inf", "+inf"}, resp.Integer(5)},
+		{[]string{"ZCOUNT", "z", "10", "20"}, resp.Integer(0)},
+		{[]string{"ZCOUNT", "missing", "1", "2"}, resp.Integer(0)},
+	})
+}
+
+func TestSortedSetLexRanges(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZRANGEBYLEX", "z", "-", "+"}, resp.BulkStrings([]string{"a", "b", "c", "d", "e"})},
+		{[]string{"ZRANGEBYLEX", "z", "[b", "(d"}, resp.BulkStrings([]string{"b", "c"})},
+		{[]string{"ZRANGEBYLEX", "z", "(b", "+", "LIMIT", "1", "2"}, resp.BulkStrings([]string{"d", "e"})},
+		{[]string{"ZRANGEBYLEX", "z", "+", "-"}, resp.Array()},
+		{[]string{"ZRANGEBYLEX", "z", "b", "d"}, resp.Error("ERR min or max not valid string range item")},
+		{[]string{"ZREVRANGEBYLEX", "z", "[c", "-"}, resp.BulkStrings([]string{"c", "b", "a"})},
+		{[]string{"ZRANGE", "z", "(d", "[b", "BYLEX", "REV"}, resp.BulkStrings([]string{"c", "b"})},
+		{[]string{"ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES"}, resp.Error("ERR syntax error, WITHSCORES not supported in combination with BYLEX")},
+		{[]string{"ZLEXCOUNT", "z", "[b", "[d"}, resp.Integer(3)},
+		{[]string{"ZLEXCOUNT", "z", "(e", "+"}, resp.Integer(0)},
+	})
+}
+
+func TestSortedSetConditionalAdd(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "10", "a", "20", "b")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZADD", "z", "NX", "1", "a", "30", "c"}, resp.Integer(1)},
+		{[]string{"ZSCORE", "z", "a"}, resp.Double(10)},
+		{[]string{"ZADD", "z", "XX", "11", "a", "40", "d"}, resp.Integer(0)},
+		{[]string{"ZSCORE", "z", "a"}, resp.Double(11)},
+		{[]string{"ZSCORE", "z", "d"}, resp.NullBulk},
+		{[]string{"ZADD", "z", "CH", "12", "a", "20", "b", "50", "e"}, resp.Integer(2)},
+		{[]string{"ZADD", "z", "GT", "CH", "5", "a", "25", "b", "1", "f"}, resp.Integer(2)},
+		{[]string{"ZSCORE", "z", "a"}, resp.Double(12)},
+		{[]string{"ZSCORE", "z", "b"}, resp.Double(25)},
+		{[]string{"ZADD", "z", "LT", "CH", "5", "a", "30", "b"}, resp.Integer(1)},
+		{[]string{"ZSCORE", "z", "a"}, resp.Double(5)},
+		{[]string{"ZADD", "z", "INCR", "2", "a"}, resp.Double(7)},
+		{[]string{"ZADD", "z", "NX", "INCR", "2", "a"}, resp.NullBulk},
+		{[]string{"ZADD", "z", "GT", "INCR", "-1", "a"}, resp.NullBulk},
+		{[]string{"ZADD", "z", "XX", "INCR", "1", "new"}, resp.NullBulk},
+		{[]string{"ZADD", "z", "INCR", "1", "a", "2", "b"}, resp.Error("ERR INCR option supports a single increment-element pair")},
+		{[]string{"ZADD", "z", "NX", "XX", "1", "a"}, resp.Error("ERR XX and NX options at the same time are not compatible")},
+		{[]string{"ZADD", "z", "NX", "GT", "1", "a"}, resp.Error("ERR GT, LT, and/or NX options at the same time are not compatible")},
+		{[]string{"ZADD", "z", "NX", "CH"}, syntaxErrorReply},
+		{[]string{"ZADD", "missing", "XX", "1", "a"}, resp.Integer(0)},
+		{[]string{"EXISTS", "missing"}, resp.Integer(0)},
+		{[]string{"ZINCRBY", "z", "3", "a"}, resp.Double(10)},
+		{[]string{"ZINCRBY", "z", "1.5", "fresh"}, resp.Double(1.5)},
+		{[]string{"ZINCRBY", "z", "x", "a"}, notFloatReply},
+		{[]string{"ZADD", "inf", "+inf", "m"}, resp.Integer(1)},
+		{[]string{"ZINCRBY", "inf", "-inf", "m"}, resp.Error("ERR resulting score is not a number (NaN)")},
+		{[]string{"ZSCORE", "inf", "m"}, resp.Double(math.Inf(1))},
+		{[]string{"ZRANGE", "z", "0", "1"}, resp.BulkStrings([]string{"f", "fresh"})},
+	})
+}
+
+func TestSortedSetAlgebra(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "a", "1", "x", "2", "y", "3", "z")
+	do(c, "ZADD", "b", "10", "y", "20", "z", "30", "w")
+	do(c, "SADD", "s", "x", "w")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZUNIONSTORE", "out", "2", "a", "b"}, resp.Integer(4)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, scored("x", 1.0, "y", 12.0, "z", 23.0, "w", 30.0)},
+		{[]string{"ZUNIONSTORE", "out", "2", "a", "b", "WEIGHTS", "2", "0.5", "AGGREGATE", "MAX"}, resp.Integer(4)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, scored("x", 2.0, "y", 5.0, "z", 10.0, "w", 15.0)},
+		{[]string{"ZINTERSTORE", "out", "2", "a", "b", "AGGREGATE", "MIN"}, resp.Integer(2)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, scored("y", 2.0, "z", 3.0)},
+		{[]string{"ZINTERSTORE", "out", "2", "b", "s"}, resp.Integer(1)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, scored("w", 31.0)},
+		{[]string{"ZDIFFSTORE", "out", "2", "a", "b"}, resp.Integer(1)},
+		{[]string{"ZRANGE", "out", "0", "-1", "WITHSCORES"}, scored("x", 1.0)},
+		{[]string{"ZINTERSTORE", "out", "2", "a", "missing"}, resp.Integer(0)},
+		{[]string{"EXISTS", "out"}, resp.Integer(0)},
+		{[]string{"ZUNIONSTORE", "a", "2", "a", "a"}, resp.Integer(3)},
+		{[]string{"ZSCORE", "a", "z"}, resp.Double(6)},
+		{[]string{"ZUNIONSTORE", "out", "0", "a"}, resp.Error("ERR at least 1 input key is needed for 'zunionstore' command")},
+		{[]string{"ZUNIONSTORE", "out", "3", "a", "b"}, syntaxErrorReply},
+		{[]string{"ZUNIONSTORE", "out", "2", "a", "b", "WEIGHTS", "1"}, syntaxErrorReply},
+		{[]string{"ZUNIONSTORE", "out", "1", "a", "WEIGHTS", "x"}, resp.Error("ERR weight value is not a float")},
+		{[]string{"ZUNIONSTORE", "out", "1", "a", "AGGREGATE", "AVG"}, syntaxErrorReply},
+		{[]string{"ZDIFFSTORE", "out", "1", "a", "WEIGHTS", "1"}, syntaxErrorReply},
+		{[]string{"SET", "str", "v"}, resp.OK},
+		{[]string{"ZUNIONSTORE", "out", "2", "a", "str"}, wrongTypeReply},
+	})
+}
+
+func TestSortedSetPops(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZPOPMIN", "z"}, resp.Array(resp.BulkString("a"), resp.Double(1))},
+		{[]string{"ZPOPMAX", "z", "2"}, scored("d", 4.0, "c", 3.0)},
+		{[]string{"ZPOPMIN", "z", "0"}, resp.Array()},
+		{[]string{"ZPOPMIN", "z", "-1"}, resp.Error("ERR value is out of range, must be positive")},
+		{[]string{"ZPOPMIN", "z", "10"}, scored("b", 2.0)},
+		{[]string{"EXISTS", "z"}, resp.Integer(0)},
+		{[]string{"ZPOPMIN", "z"}, resp.Array()},
+		{[]string{"ZADD", "y", "5", "p", "6", "q", "7", "r"}, resp.Integer(3)},
+		{[]string{"ZMPOP", "2", "z", "y", "MAX", "COUNT", "2"}, resp.Array(resp.BulkString("y"), resp.Array(
+			resp.Array(resp.BulkString("r"), resp.Double(7)),
+			resp.Array(resp.BulkString("q"), resp.Double(6)),
+		))},
+		{[]string{"ZMPOP", "1", "y", "MIN"}, resp.Array(resp.BulkString("y"), resp.Array(
+			resp.Array(resp.BulkString("p"), resp.Double(5)),
+		))},
+		{[]string{"ZMPOP", "1", "y", "MIN"}, resp.NullArray},
+		{[]string{"ZMPOP", "0", "y", "MIN"}, resp.Error("ERR numkeys should be greater than 0")},
+		{[]string{"ZMPOP", "1", "y", "MIDDLE"}, syntaxErrorReply},
+		{[]string{"ZMPOP", "1", "y", "MIN", "COUNT", "0"}, resp.Error("ERR count should be greater than 0")},
+		{[]string{"ZMPOP", "3", "y", "MIN"}, syntaxErrorReply},
+	})
+
+	c.proto = 3
+	do(c, "ZADD", "z", "1", "a", "2", "b")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZPOPMIN", "z", "1"}, resp.Array(resp.Array(resp.BulkString("a"), resp.Double(1)))},
+		{[]string{"ZPOPMIN", "z"}, resp.Array(resp.BulkString("b"), resp.Double(2))},
+	})
+}
+
+func TestSortedSetRandomMember(t *testing.T) {
+	c := newTestClient()
+	do(c, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"ZRANDMEMBER", "missing"}, resp.NullBulk},
+		{[]string{"ZRANDMEMBER", "missing", "3"}, resp.Array()},
+		{[]string{"ZRANDMEMBER", "z", "0"}, resp.Array()},
+		{[]string{"ZRANDMEMBER", "z", "x"}, notIntegerReply},
+		{[]string{"ZRANDMEMBER", "z", "1", "BOGUS"}, syntaxErrorReply},
+	})
+
+	if got := do(c, "ZRANDMEMBER", "z"); got.Type != resp.TypeBulkString {
+		t.Fatalf("ZRANDMEMBER: expected a member, got %v", got)
+	}
+	if got := sortedStrs(do(c, "ZRANDMEMBER", "z", "10")); len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Errorf("ZRANDMEMBER 10: expected every member once, got %v", got)
+	}
+	if got := do(c, "ZRANDMEMBER", "z", "-7"); len(got.Array) != 7 {
+		t.Errorf("ZRANDMEMBER -7: expected 7 members, got %d", len(got.Array))
+	}
+	got := do(c, "ZRANDMEMBER", "z", "-4", "WITHSCORES")
+	if len(got.Array) != 8 {
+		t.Fatalf("ZRANDMEMBER -4 WITHSCORES: expected 8 elements, got %d", len(got.Array))
+	}
+	for i := 0; i < len(got.Array); i += 2 {
+		score := do(c, "ZSCORE", "z", got.Array[i].Str)
+		if !reflect.DeepEqual(got.Array[i+1], score) {
+			t.Errorf("ZRANDMEMBER WITHSCORES: %s paired with %v, want %v", got.Array[i].Str, got.Array[i+1], score)
+		}
+	}
+}
+
+func TestBzpop(t *testing.T) {
+	c := newTestClient()
+	c.proto = 3
+	do(c, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	do(c, "SET", "str", "x")
+
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BZPOPMIN", "missing", "z", "0"}, resp.Array(resp.BulkString("z"), resp.BulkString("a"), resp.Double(1))},
+		{[]string{"BZPOPMAX", "z", "0"}, resp.Array(resp.BulkString("z"), resp.BulkString("c"), resp.Double(3))},
+		{[]string{"BZPOPMAX", "z", "0"}, resp.Array(resp.BulkString("z"), resp.BulkString("b"), resp.Double(2))},
+		{[]string{"EXISTS", "z"}, resp.Integer(0)},
+		{[]string{"BZPOPMIN", "z", "0.01"}, resp.NullArray},
+		{[]string{"BZPOPMIN", "str", "0"}, wrongTypeReply},
+		{[]string{"BZPOPMIN", "z", "-1"}, resp.Error("ERR timeout is negative")},
+	})
+
+	// The lowest score among those added by the write that woke it wins.
+	r := doBlocking(c, "BZPOPMIN", "z", "0")
+	do(newClient(nil, c.srv), "ZADD", "z", "5", "x", "4", "y")
+	expectUnblocked(t, r, resp.Array(resp.BulkString("z"), resp.BulkString("y"), resp.Double(4)))
+}
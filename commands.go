@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// commandFlags describe how a command behaves. The names reported for them
+// match the flags Redis uses in its command table.
+type commandFlags uint32
+
+const (
+	flagWrite commandFlags = 1 << iota
+	flagReadonly
+	flagDenyOOM
+	flagAdmin
+	flagPubSub
+	flagNoScript
+	flagBlocking
+	flagLoading
+	flagStale
+	flagFast
+	flagNoMulti
+)
+
+var flagNames = []struct {
+	flag commandFlags
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadonly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagAdmin, "admin"},
+	{flagPubSub, "pubsub"},
+	{flagNoScript, "noscript"},
+	{flagBlocking, "blocking"},
+	{flagLoading, "loading"},
+	{flagStale, "stale"},
+	{flagFast, "fast"},
+	{flagNoMulti, "no_multi"},
+}
+
+// names returns the flag names set in f.
+func (f commandFlags) names() []string {
+	var names []string
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// CommandHandler implements a command. args holds the full request,
+// including the command name in args[0]. Handlers run with the store lock
+// already held: exclusively for write commands, shared for readonly ones
+// and not at all for commands that are neither.
+type CommandHandler func(c *Client, args []string) resp.Value
+
+// Command describes a command the server understands.
+//
+// Arity follows the Redis convention and counts the command name itself: a
+// positive arity requires exactly that many arguments, a negative arity
+// requires at least -Arity. FirstKey, LastKey and Step give the positions of
+// key arguments (LastKey -1 meaning the last argument); all zero means the
+// command takes no keys.
+type Command struct {
+	Name     string
+	Arity    int
+	Flags    commandFlags
+	FirstKey int
+	LastKey  int
+	Step     int
+	Handler  CommandHandler
+	// handler is Handler wrapped in the middleware chain.
+	handler CommandHandler
+}
+
+// commandTable holds every known command keyed by lower-case name.
+var commandTable = map[string]*Command{}
+
+// RegisterCommand adds cmd to the command table. Commands are usually
+// registered from an init function in the file implementing them. It panics
+// if a command with the same name already exists.
+func RegisterCommand(cmd *Command) {
+	name := strings.ToLower(cmd.Name)
+	if _, ok := commandTable[name]; ok {
+		panic("command already registered: " + name)
+	}
+	if cmd.Handler == nil {
+		panic("command has no handler: " + name)
+	}
+	cmd.Name = name
+	cmd.wrap()
+	commandTable[name] = cmd
+}
+
+// Middleware wraps a command handler, to layer behaviour such as auth
+// checks, audit logging, metrics or rate limiting around every command. It
+// may run code before and after calling next, or reply without calling it
+// at all. The wrapped handler runs where the handler would, with the store
+// locked as the command's flags require, and args[0] names the command as
+// the client sent it.
+type Middleware func(next CommandHandler) CommandHandler
+
+// middlewares is the chain added with Use, outermost first.
+var middlewares []Middleware
+
+// Use adds mw to the middleware chain, inside the middleware added before
+// it. It applies to every command, registered before or after, whether
+// run directly, from a transaction or from a script. A command that blocks
+// returns no reply to the middleware, which does not run again when the
+// command is retried and completes. Like RegisterCommand it must be called
+// before the server starts, usually from an init function.
+func Use(mw Middleware) {
+	middlewares = append(middlewares, mw)
+	for _, cmd := range commandTable {
+		cmd.wrap()
+	}
+}
+
+// wrap builds the command's handler from Handler and the middleware chain.
+func (cmd *Command) wrap() {
+	h := cmd.Handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	cmd.handler = h
+}
+
+// lookupCommand finds the command named name, ignoring case.
+func lookupCommand(name string) *Command {
+	return commandTable[strings.ToLower(name)]
+}
+
+// checkArity reports whether argc arguments (including the command name)
+// satisfy the command's arity.
+func (cmd *Command) checkArity(argc int) bool {
+	if cmd.Arity < 0 {
+		return argc >= -cmd.Arity
+	}
+	return argc == cmd.Arity
+}
+
+// call runs cmd's handler, locking the store as its flags require.
+// Blocking commands take the write lock too, as they may register the
+// client as waiting. After a write it serves the clients blocked on the
+// keys it made ready.
+func (c *Client) call(cmd *Command, args []string) resp.Value {
+	switch {
+	case cmd.Flags&(flagWrite|flagBlocking) != 0:
+		c.store.mu.Lock()
+		defer c.store.mu.Unlock()
+		defer c.store.actFor(c)()
+		defer c.store.serveBlocked()
+	case cmd.Flags&flagReadonly != 0:
+		c.store.mu.RLock()
+		defer c.store.mu.RUnlock()
+	}
+	var dirty int64
+	if cmd.Flags&flagWrite != 0 {
+		c.expireKeys(cmd, args)
+		dirty = c.store.dirty
+	}
+	start := time.Now()
+	reply := cmd.handler(c, args)
+	d := time.Since(start)
+	if cmd.Flags&flagWrite != 0 {
+		c.propagate(cmd, args, dirty)
+		c.flushEffects()
+	}
+	c.srv.slowlog.record(c, args, d)
+	if cmd.Flags&flagFast != 0 {
+		c.srv.latency.add(latencyFastCommandEvent, d)
+	} else {
+		c.srv.latency.add(latencyCommandEvent, d)
+	}
+	c.trackKeys(cmd, args)
+	c.srv.monitors.feed(c, cmd, args, false)
+	return reply
+}
+
+// keys returns the key arguments of a call to cmd according to FirstKey,
+// LastKey and Step. Commands whose keys depend on other arguments, such as
+// a key count, leave FirstKey zero and get none.
+func (cmd *Command) keys(args []string) []string {
+	if cmd.FirstKey == 0 || cmd.FirstKey >= len(args) {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	last = min(last, len(args)-1)
+	step := max(cmd.Step, 1)
+	var keys []string
+	for i := cmd.FirstKey; i <= last; i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// Error replies shared by many commands.
+var (
+	// wrongTypeReply is returned when a command is used against a key
+	// holding a value of the wrong type.
+	wrongTypeReply   = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	notIntegerReply  = resp.Error("ERR value is not an integer or out of range")
+	notFloatReply    = resp.Error("ERR value is not a valid float")
+	syntaxErrorReply = resp.Error("ERR syntax error")
+)
+
+// parseFloat parses a command argument or stored value as a float. NaN is
+// rejected; infinities are accepted and left for callers to judge.
+func parseFloat(arg string) (float64, bool) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return f, !math.IsNaN(f)
+}
+
+// formatFloat renders a float result the way INCRBYFLOAT does: the shortest
+// decimal that round-trips, with no exponent and no trailing zeros.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// errNotInteger and errSyntax are the error forms of notIntegerReply and
+// syntaxErrorReply, for parsing helpers that return errors.
+var (
+	errNotInteger = errors.New(notIntegerReply.Str)
+	errSyntax     = errors.New(syntaxErrorReply.Str)
+)
+
+// errNotPositive is returned for counts that must not be negative. Redis
+// uses it for arguments that are not integers at all as well.
+var errNotPositive = errors.New("ERR value is out of range, must be positive")
+
+// errorReply turns an error produced by a parsing helper into an error
+// reply. Such errors carry the full Redis error text, code included.
+func errorReply(err error) resp.Value {
+	return resp.Error(err.Error())
+}
+
+// parseInt parses a command argument or stored value as a 64-bit integer.
+// Like Redis it only accepts the canonical form: no sign other than a
+// leading '-', no leading zeros and no surrounding whitespace.
+func parseInt(arg string) (int64, bool) {
+	digits := strings.TrimPrefix(arg, "-")
+	if digits == "" || digits[0] == '+' || (digits[0] == '0' && len(arg) > 1) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	return n, err == nil
+}
+
+// unknownCommandReply builds the error Redis sends for unknown commands,
+// echoing the first few arguments to help spot client bugs.
+func unknownCommandReply(args []string) resp.Value {
+	var b strings.Builder
+	for i, arg := range args[1:] {
+		if i == 16 || b.Len() >= 128 {
+			break
+		}
+		fmt.Fprintf(&b, "'%s' ", arg)
+	}
+	return resp.Error(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", args[0], b.String()))
+}
+
+// wrongArityReply builds the error for a call with the wrong argument count.
+func wrongArityReply(name string) resp.Value {
+	return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
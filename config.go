@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go-http-practice/resp"
+)
+
+// Config holds the server settings. Option names follow redis.conf.
+type Config struct {
+	// Addr is the TCP address the server listens on.
+	Addr string
+	// InlineMaxSize caps the length of a single inline request line.
+	InlineMaxSize int
+	// ProtoMaxBulkLen caps the length of a single bulk string argument.
+	ProtoMaxBulkLen int
+	// ProtoMaxMultibulkLen caps the number of arguments in one request.
+	ProtoMaxMultibulkLen int
+	// HllSparseMaxBytes caps the size of a sparse HyperLogLog; larger ones
+	// are converted to the dense encoding.
+	HllSparseMaxBytes int
+	// StreamNodeMaxEntries is the number of stream entries treated as one
+	// node by approximate (~) trimming, which only removes whole nodes.
+	StreamNodeMaxEntries int
+	// ClientOutputBufferLimitPubSub caps the bytes of messages waiting to
+	// be written to a subscriber; a client falling further behind is
+	// disconnected. 0 means no limit.
+	ClientOutputBufferLimitPubSub int
+	// SlowlogLogSlowerThan is the run time, in microseconds, from which a
+	// command is recorded in the slow log; negative disables it.
+	// SlowlogMaxLen caps the number of entries it keeps.
+	SlowlogLogSlowerThan int
+	SlowlogMaxLen        int
+	// LatencyMonitorThreshold is the latency, in milliseconds, from which
+	// events are recorded by the latency monitor; 0 disables it.
+	LatencyMonitorThreshold int
+	// LuaTimeLimit is how long, in milliseconds, a script may run before
+	// it is aborted; 0 means no limit. See callLua.
+	LuaTimeLimit int
+	// Dir is the directory the dump and append-only files are kept in,
+	// created at startup if missing.
+	Dir string
+	// DBFilename is the file in Dir SAVE writes snapshots to. RDBCompression has
+	// their long strings compressed, and RDBChecksum has them end with a
+	// checksum, verified when they are loaded.
+	DBFilename     string
+	RDBCompression bool
+	RDBChecksum    bool
+	// SaveRules are the conditions in which a BGSAVE starts on its own.
+	SaveRules []saveRule
+	// AppendOnly enables logging writes to AppendFilename, in Dir, which then
+	// replaces the snapshot as the data loaded at startup. AppendFsync is
+	// when those writes are flushed to disk. AOFLoadTruncated lets the
+	// server start from a file whose last command was cut short, dropping
+	// it, rather than refuse to. AOFUseRDBPreamble has rewrites start the
+	// file with a snapshot rather than commands.
+	AppendOnly        bool
+	AppendFilename    string
+	AppendFsync       appendFsync
+	AOFLoadTruncated  bool
+	AOFUseRDBPreamble bool
+	// AOFTimestampEnabled has writes to the AOF annotated with the time,
+	// and RecoverToTimestamp, a Unix time in seconds, has the AOF loaded
+	// at startup replayed only up to it, when it is not 0. See aof.go.
+	AOFTimestampEnabled bool
+	RecoverToTimestamp  int64
+	// ReplPingReplicaPeriod is how often, in seconds, a master sends a
+	// PING to its replicas, and ReplTimeout how long, in seconds, a
+	// replica waits for data from its master before dropping the link.
+	ReplPingReplicaPeriod int
+	ReplTimeout           int
+	// NotifyKeyspaceEvents selects the keyspace events published to pub/sub
+	// clients. None are by default.
+	NotifyKeyspaceEvents notifyClass
+}
+
+// defaultConfig returns the settings used when no flags are given.
+func defaultConfig() *Config {
+	return &Config{
+		Addr:                          ":8000",
+		InlineMaxSize:                 64 * 1024,
+		ProtoMaxBulkLen:               512 * 1024 * 1024,
+		ProtoMaxMultibulkLen:          1024 * 1024,
+		HllSparseMaxBytes:             3000,
+		StreamNodeMaxEntries:          100,
+		ClientOutputBufferLimitPubSub: 32 * 1024 * 1024,
+		SlowlogLogSlowerThan:          10000,
+		SlowlogMaxLen:                 128,
+		LuaTimeLimit:                  5000,
+		Dir:                           ".",
+		DBFilename:                    "dump.rdb",
+		RDBCompression:                true,
+		RDBChecksum:                   true,
+		SaveRules:                     defaultSaveRules,
+		AppendFilename:                "appendonly.aof",
+		AppendFsync:                   fsyncEverysec,
+		AOFLoadTruncated:              true,
+		AOFUseRDBPreamble:             true,
+		ReplPingReplicaPeriod:         10,
+		ReplTimeout:                   60,
+	}
+}
+
+// requestLimits returns the protocol limits applied to client requests.
+func (cfg *Config) requestLimits() resp.Limits {
+	return resp.Limits{
+		InlineMaxSize: cfg.InlineMaxSize,
+		MaxBulkLen:    cfg.ProtoMaxBulkLen,
+		MaxArgs:       cfg.ProtoMaxMultibulkLen,
+	}
+}
+
+// dbPath returns the path of the dump file.
+func (cfg *Config) dbPath() string {
+	return filepath.Join(cfg.Dir, cfg.DBFilename)
+}
+
+// appendPath returns the path of the append-only file.
+func (cfg *Config) appendPath() string {
+	return filepath.Join(cfg.Dir, cfg.AppendFilename)
+}
+
+// parseFlags builds a Config from the command line.
+func parseFlags() *Config {
+	cfg := defaultConfig()
+	flag.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	flag.IntVar(&cfg.InlineMaxSize, "inline-max-size", cfg.InlineMaxSize, "maximum length in bytes of an inline request")
+	flag.IntVar(&cfg.ProtoMaxBulkLen, "proto-max-bulk-len", cfg.ProtoMaxBulkLen, "maximum length in bytes of a single request argument")
+	flag.IntVar(&cfg.ProtoMaxMultibulkLen, "proto-max-multibulk-len", cfg.ProtoMaxMultibulkLen, "maximum number of arguments in a single request")
+	flag.IntVar(&cfg.HllSparseMaxBytes, "hll-sparse-max-bytes", cfg.HllSparseMaxBytes, "maximum size in bytes of a sparse HyperLogLog")
+	flag.IntVar(&cfg.StreamNodeMaxEntries, "stream-node-max-entries", cfg.StreamNodeMaxEntries, "number of stream entries approximate trimming removes at a time")
+	flag.IntVar(&cfg.ClientOutputBufferLimitPubSub, "client-output-buffer-limit-pubsub", cfg.ClientOutputBufferLimitPubSub, "maximum bytes of messages waiting to be sent to a subscriber before it is disconnected (0 for no limit)")
+	flag.IntVar(&cfg.SlowlogLogSlowerThan, "slowlog-log-slower-than", cfg.SlowlogLogSlowerThan, "run time in microseconds from which commands are recorded in the slow log (negative disables it)")
+	flag.IntVar(&cfg.SlowlogMaxLen, "slowlog-max-len", cfg.SlowlogMaxLen, "maximum number of entries in the slow log")
+	flag.IntVar(&cfg.LatencyMonitorThreshold, "latency-monitor-threshold", cfg.LatencyMonitorThreshold, "latency in milliseconds from which events are recorded by the latency monitor (0 disables it)")
+	flag.IntVar(&cfg.LuaTimeLimit, "lua-time-limit", cfg.LuaTimeLimit, "milliseconds a script may run before it is aborted (0 for no limit)")
+	flag.StringVar(&cfg.Dir, "dir", cfg.Dir, "directory holding the dump and append-only files, created if missing")
+	flag.Func("dbfilename", "name of the dump file in dir (default \"dump.rdb\")", func(s string) error {
+		cfg.DBFilename = s
+		return checkFilename("dbfilename", s)
+	})
+	flag.Func("save", "snapshot in the background after the given seconds if at least the given number of keys changed, as pairs of both such as \"900 1 300 10\", or \"\" never (default \"3600 1 300 100 60 10000\")", func(s string) error {
+		rules, err := parseSaveRules(s)
+		cfg.SaveRules = rules
+		return err
+	})
+	flag.Func("rdbcompression", "compress long strings in snapshots with LZF: yes or no (default yes)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.RDBCompression = on
+		return err
+	})
+	flag.Func("rdbchecksum", "end snapshots with a CRC-64 checksum of their content: yes or no (default yes)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.RDBChecksum = on
+		return err
+	})
+	flag.Func("appendonly", "log writes to the appendfilename and load it at startup instead of the dump file: yes or no (default no)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.AppendOnly = on
+		return err
+	})
+	flag.Func("appendfilename", "name of the append-only file in dir (default \"appendonly.aof\")", func(s string) error {
+		cfg.AppendFilename = s
+		return checkFilename("appendfilename", s)
+	})
+	flag.Func("appendfsync", "when to flush the append-only file to disk: always, everysec or no (default everysec)", func(s string) error {
+		policy, err := parseAppendFsync(s)
+		cfg.AppendFsync = policy
+		return err
+	})
+	flag.Func("aof-load-truncated", "load an append-only file whose last command is incomplete, truncating it, rather than refuse to start: yes or no (default yes)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.AOFLoadTruncated = on
+		return err
+	})
+	flag.Func("aof-use-rdb-preamble", "start rewritten append-only files with a snapshot of the data rather than commands, for faster loading: yes or no (default yes)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.AOFUseRDBPreamble = on
+		return err
+	})
+	flag.Func("aof-timestamp-enabled", "annotate writes to the append-only file with the time, once a second, for recover-to-timestamp: yes or no (default no)", func(s string) error {
+		on, err := parseYesNo(s)
+		cfg.AOFTimestampEnabled = on
+		return err
+	})
+	flag.Int64Var(&cfg.RecoverToTimestamp, "recover-to-timestamp", cfg.RecoverToTimestamp, "replay the append-only file at startup only up to this Unix time, dropping the later writes from it for good (0 replays it all)")
+	flag.IntVar(&cfg.ReplPingReplicaPeriod, "repl-ping-replica-period", cfg.ReplPingReplicaPeriod, "seconds between the PINGs a master sends its replicas")
+	flag.IntVar(&cfg.ReplTimeout, "repl-timeout", cfg.ReplTimeout, "seconds a replica waits for data from its master before reconnecting")
+	flag.Func("notify-keyspace-events", "keyspace event classes to publish, such as KEA (default none)", func(s string) error {
+		flags, err := parseNotifyKeyspaceEvents(s)
+		cfg.NotifyKeyspaceEvents = flags
+		return err
+	})
+	flag.Parse()
+	return cfg
+}
+
+// parseYesNo parses a boolean setting written yes or no, as in redis.conf.
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// checkFilename fails unless name, the value of the setting called option,
+// is a plain file name, as files go in dir.
+func checkFilename(option, name string) error {
+	if name == "" || name != filepath.Base(name) {
+		return fmt.Errorf("%s can't be a path, just a filename", option)
+	}
+	return nil
+}
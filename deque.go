@@ -0,0 +1,169 @@
+package main
+
+// deque is a double-ended queue of strings backed by a ring buffer. Pushes
+// and pops at either end are amortised O(1), index access is O(1) and
+// inserts or removals in the middle move the shorter side.
+type deque struct {
+	buf  []string
+	head int // index in buf of element 0
+	n    int
+}
+
+// Len returns the number of elements.
+func (q *deque) Len() int {
+	return q.n
+}
+
+// pos maps an element index to a buf index.
+func (q *deque) pos(i int) int {
+	return (q.head + i) & (len(q.buf) - 1)
+}
+
+// grow makes room for at least one more element. len(buf) is always zero
+// or a power of two, so pos can mask instead of dividing.
+func (q *deque) grow() {
+	if q.n < len(q.buf) {
+		return
+	}
+	size := max(2*len(q.buf), 8)
+	buf := make([]string, size)
+	q.copyTo(buf)
+	q.buf, q.head = buf, 0
+}
+
+// shrink halves buf when it is mostly empty, so a list that was once huge
+// does not hold on to its memory.
+func (q *deque) shrink() {
+	if len(q.buf) <= 8 || q.n > len(q.buf)/4 {
+		return
+	}
+	buf := make([]string, len(q.buf)/2)
+	q.copyTo(buf)
+	q.buf, q.head = buf, 0
+}
+
+// copyTo copies the elements in order to the start of dst.
+func (q *deque) copyTo(dst []string) {
+	if q.n == 0 {
+		return
+	}
+	end := q.head + q.n
+	if end <= len(q.buf) {
+		copy(dst, q.buf[q.head:end])
+		return
+	}
+	k := copy(dst, q.buf[q.head:])
+	copy(dst[k:], q.buf[:end-len(q.buf)])
+}
+
+// PushFront adds s before the first element.
+func (q *deque) PushFront(s string) {
+	q.grow()
+	q.head = (q.head - 1) & (len(q.buf) - 1)
+	q.buf[q.head] = s
+	q.n++
+}
+
+// PushBack adds s after the last element.
+func (q *deque) PushBack(s string) {
+	q.grow()
+	q.buf[q.pos(q.n)] = s
+	q.n++
+}
+
+// PopFront removes and returns the first element. The deque must not be
+// empty.
+func (q *deque) PopFront() string {
+	s := q.buf[q.head]
+	q.buf[q.head] = ""
+	q.head = q.pos(1)
+	q.n--
+	q.shrink()
+	return s
+}
+
+// PopBack removes and returns the last element. The deque must not be
+// empty.
+func (q *deque) PopBack() string {
+	i := q.pos(q.n - 1)
+	s := q.buf[i]
+	q.buf[i] = ""
+	q.n--
+	q.shrink()
+	return s
+}
+
+// At returns element i, which must be in [0, Len()).
+func (q *deque) At(i int) string {
+	return q.buf[q.pos(i)]
+}
+
+// Set replaces element i, which must be in [0, Len()).
+func (q *deque) Set(i int, s string) {
+	q.buf[q.pos(i)] = s
+}
+
+// Range returns a copy of elements [start, end).
+func (q *deque) Range(start, end int) []string {
+	out := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		out = append(out, q.At(i))
+	}
+	return out
+}
+
+// Insert adds s so that it becomes element i, for i in [0, Len()].
+func (q *deque) Insert(i int, s string) {
+	if i < q.n/2 {
+		q.PushFront(s)
+		for j := 0; j < i; j++ {
+			q.Set(j, q.At(j+1))
+		}
+	} else {
+		q.PushBack(s)
+		for j := q.n - 1; j > i; j-- {
+			q.Set(j, q.At(j-1))
+		}
+	}
+	q.Set(i, s)
+}
+
+// Remove deletes element i, which must be in [0, Len()).
+func (q *deque) Remove(i int) {
+	if i < q.n/2 {
+		for j := i; j > 0; j-- {
+			q.Set(j, q.At(j-1))
+		}
+		q.PopFront()
+	} else {
+		for j := i; j < q.n-1; j++ {
+			q.Set(j, q.At(j+1))
+		}
+		q.PopBack()
+	}
+}
+
+// Filter keeps the elements for which keep returns true, preserving their
+// order, and returns how many were dropped.
+func (q *deque) Filter(keep func(i int, s string) bool) int {
+	w := 0
+	for r := 0; r < q.n; r++ {
+		s := q.At(r)
+		if keep(r, s) {
+			q.Set(w, s)
+			w++
+		}
+	}
+	dropped := q.n - w
+	for q.n > w {
+		q.PopBack()
+	}
+	return dropped
+}
+
+// Clone returns an independent copy of q.
+func (q *deque) Clone() *deque {
+	c := &deque{buf: make([]string, len(q.buf)), n: q.n}
+	q.copyTo(c.buf)
+	return c
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func dequeItems(q *deque) []string {
+	return q.Range(0, q.Len())
+}
+
+func TestDequePushPopBothEnds(t *testing.T) {
+	var q deque
+	for i := 0; i < 20; i++ {
+		q.PushBack(strconv.Itoa(i))
+		q.PushFront(strconv.Itoa(-i - 1))
+	}
+	if q.Len() != 40 {
+		t.Fatalf("Len = %d, want 40", q.Len())
+	}
+	if q.At(0) != "-20" || q.At(39) != "19" {
+		t.Fatalf("ends = %q, %q", q.At(0), q.At(39))
+	}
+	for i := 19; i >= 0; i-- {
+		if got := q.PopBack(); got != strconv.Itoa(i) {
+			t.Fatalf("PopBack = %q, want %d", got, i)
+		}
+	}
+	for i := 20; i >= 1; i-- {
+		if got := q.PopFront(); got != strconv.Itoa(-i) {
+			t.Fatalf("PopFront = %q, want %d", got, -i)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("Len = %d after popping everything", q.Len())
+	}
+}
+
+func TestDequeInsertRemoveShiftShorterSide(t *testing.T) {
+	var q deque
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		q.PushBack(s)
+	}
+	// Rotate the ring so the elements wrap around the end of buf.
+	for i := 0; i < 6; i++ {
+		q.PushBack(q.PopFront())
+	}
+
+	q.Insert(1, "x")
+	q.Insert(5, "y")
+	q.Insert(0, "first")
+	q.Insert(q.Len(), "last")
+	want := []string{"first", "b", "x", "c", "d", "e", "y", "a", "last"}
+	if got := dequeItems(&q); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after Insert = %q, want %q", got, want)
+	}
+
+	q.Remove(1)
+	q.Remove(6)
+	want = []string{"first", "x", "c", "d", "e", "y", "last"}
+	if got := dequeItems(&q); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after Remove = %q, want %q", got, want)
+	}
+
+	dropped := q.Filter(func(i int, s string) bool { return len(s) == 1 })
+	if dropped != 2 {
+		t.Errorf("Filter dropped %d, want 2", dropped)
+	}
+	want = []string{"x", "c", "d", "e", "y"}
+	if got := dequeItems(&q); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after Filter = %q, want %q", got, want)
+	}
+
+	c := q.Clone()
+	c.Set(0, "changed")
+	if q.At(0) != "x" {
+		t.Error("Clone shares storage with the original")
+	}
+}
+
+func TestDequeShrinks(t *testing.T) {
+	var q deque
+	for i := 0; i < 1000; i++ {
+		q.PushBack("x")
+	}
+	for q.Len() > 1 {
+		q.PopFront()
+	}
+	if len(q.buf) > 16 {
+		t.Errorf("buf still holds %d slots for one element", len(q.buf))
+	}
+}
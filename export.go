@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+// This file converts the dataset to and from JSON, for the export and
+// import subcommands of the binary: a readable form of a snapshot, to
+// inspect or diff datasets and to seed test fixtures. Keys are listed in
+// order, each with its type, its value and its expiry time. Strings that
+// are not valid UTF-8, which JSON cannot hold, are written as objects
+// {"base64": "..."}; times are Unix times in milliseconds, 0 for none.
+
+// jsonDataset is the document written by export.
+type jsonDataset struct {
+	Functions []string  `json:"functions,omitempty"`
+	Keys      []jsonKey `json:"keys"`
+}
+
+// jsonKey is a key with the value it holds. Value is a jsonString for a
+// string or a value of a registered type, which is saved with its Save
+// function; a list of them for a list or set; and a list of jsonField,
+// jsonMember or a jsonStream for a hash, sorted set or stream.
+type jsonKey struct {
+	Key      jsonString      `json:"key"`
+	Type     string          `json:"type"`
+	ExpireAt int64           `json:"expireat,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+type jsonField struct {
+	Field    jsonString `json:"field"`
+	Value    jsonString `json:"value"`
+	ExpireAt int64      `json:"expireat,omitempty"`
+}
+
+type jsonMember struct {
+	Member jsonString `json:"member"`
+	Score  jsonScore  `json:"score"`
+}
+
+type jsonStream struct {
+	Entries []jsonEntry `json:"entries"`
+	LastID  string      `json:"lastid"`
+	Groups  []jsonGroup `json:"groups,omitempty"`
+}
+
+type jsonEntry struct {
+	ID     string       `json:"id"`
+	Fields []jsonString `json:"fields"`
+}
+
+type jsonGroup struct {
+	Name      jsonString     `json:"name"`
+	LastID    string         `json:"lastid"`
+	Consumers []jsonConsumer `json:"consumers"`
+	Pending   []jsonPending  `json:"pending"`
+}
+
+type jsonConsumer struct {
+	Name     jsonString `json:"name"`
+	SeenTime int64      `json:"seentime"`
+}
+
+type jsonPending struct {
+	ID           string     `json:"id"`
+	Consumer     jsonString `json:"consumer"`
+	DeliveryTime int64      `json:"deliverytime"`
+	Deliveries   int64      `json:"deliveries"`
+}
+
+// jsonString is a binary-safe string: a JSON string if it is valid UTF-8,
+// and an object holding it in base64 otherwise.
+type jsonString string
+
+func (s jsonString) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(s)) {
+		return marshalJSON(string(s))
+	}
+	return marshalJSON(map[string]string{"base64": base64.StdEncoding.EncodeToString([]byte(s))})
+}
+
+func (s *jsonString) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = jsonString(str)
+		return nil
+	}
+	var obj struct {
+		Base64 *string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil || obj.Base64 == nil {
+		return fmt.Errorf("%s is not a string", data)
+	}
+	b, err := base64.StdEncoding.DecodeString(*obj.Base64)
+	*s = jsonString(b)
+	return err
+}
+
+// jsonScore is a sorted set score, written as a number, or as the string
+// "inf" or "-inf" for the infinities JSON has no number for.
+type jsonScore float64
+
+func (f jsonScore) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(f), 1):
+		return []byte(`"inf"`), nil
+	case math.IsInf(float64(f), -1):
+		return []byte(`"-inf"`), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (f *jsonScore) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		score, ok := parseFloat(s)
+		if !ok {
+			return fmt.Errorf("%s is not a score", data)
+		}
+		*f = jsonScore(score)
+		return nil
+	}
+	return json.Unmarshal(data, (*float64)(f))
+}
+
+// marshalJSON is json.Marshal without the escaping of <, > and &, which
+// only makes the output harder to read.
+func marshalJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+func jsonMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func jsonTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func jsonStrings(s []string) []jsonString {
+	out := make([]jsonString, len(s))
+	for i, x := range s {
+		out[i] = jsonString(x)
+	}
+	return out
+}
+
+// writeJSON encodes the snapshot to out as a jsonDataset, with its keys
+// and the members of its sets sorted so that exports of the same data are
+// the same.
+func (snap *snapshot) writeJSON(out io.Writer) error {
+	doc := jsonDataset{Functions: snap.libraries, Keys: []jsonKey{}}
+	for _, key := range slices.Sorted(maps.Keys(snap.data)) {
+		d := snap.data[key]
+		var v any
+		switch val := d.value.(type) {
+		case []byte:
+			v = jsonString(val)
+		case *listValue:
+			items := make([]jsonString, val.Len())
+			for i := range items {
+				items[i] = jsonString(val.At(i))
+			}
+			v = items
+		case *setValue:
+			v = jsonStrings(slices.Sorted(maps.Keys(val.members)))
+		case *hashValue:
+			fields := []jsonField{}
+			for _, f := range slices.Sorted(maps.Keys(val.fields)) {
+				fields = append(fields, jsonField{jsonString(f), jsonString(val.fields[f]), jsonMillis(val.expires[f])})
+			}
+			v = fields
+		case *zsetValue:
+			members := []jsonMember{}
+			for x := val.zsl.first(); x != nil; x = x.next() {
+				members = append(members, jsonMember{jsonString(x.member), jsonScore(x.score)})
+			}
+			v = members
+		case *streamValue:
+			v = streamJSON(val)
+		case *moduleValue:
+			if val.typ.Save == nil {
+				return fmt.Errorf("value of type %s at key %q cannot be saved", val.typ.Name, key)
+			}
+			v = jsonString(val.typ.Save(val.v))
+		}
+		raw, err := marshalJSON(v)
+		if err != nil {
+			return err
+		}
+		doc.Keys = append(doc.Keys, jsonKey{jsonString(key), d.typeName(), jsonMillis(d.expiresAt), raw})
+	}
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func streamJSON(st *streamValue) jsonStream {
+	js := jsonStream{Entries: []jsonEntry{}, LastID: st.lastID.String()}
+	for _, e := range st.entries {
+		js.Entries = append(js.Entries, jsonEntry{e.id.String(), jsonStrings(e.fields)})
+	}
+	for _, name := range slices.Sorted(maps.Keys(st.groups)) {
+		g := st.groups[name]
+		jg := jsonGroup{Name: jsonString(name), LastID: g.lastID.String(), Consumers: []jsonConsumer{}, Pending: []jsonPending{}}
+		for _, cname := range slices.Sorted(maps.Keys(g.consumers)) {
+			jg.Consumers = append(jg.Consumers, jsonConsumer{jsonString(cname), jsonMillis(g.consumers[cname].seenAt)})
+		}
+		for _, p := range sortedPending(g.pel) {
+			jg.Pending = append(jg.Pending, jsonPending{p.id.String(), jsonString(p.consumer.name), jsonMillis(p.deliveredAt), p.deliveries})
+		}
+		js.Groups = append(js.Groups, jg)
+	}
+	return js
+}
+
+// readJSON loads a jsonDataset into the store, adding its keys and
+// function libraries to those already there, except for the keys that
+// have expired, as readSnapshot does. The caller must hold mu for writing.
+func (s *Store) readJSON(in io.Reader) error {
+	var doc jsonDataset
+	dec := json.NewDecoder(in)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	for _, code := range doc.Functions {
+		if _, err := s.functions.load(code, true); err != nil {
+			return fmt.Errorf("loading function library: %w", err)
+		}
+	}
+	now := time.Now()
+	for _, k := range doc.Keys {
+		v, err := jsonValue(k.Type, k.Value)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", k.Key, err)
+		}
+		d := StoreData{value: v, expiresAt: jsonTime(k.ExpireAt)}
+		if h, ok := v.(*hashValue); ok {
+			h.purge(now)
+			if len(h.fields) == 0 {
+				continue
+			}
+		}
+		if !d.expired(now) {
+			s.put(string(k.Key), d)
+		}
+	}
+	return nil
+}
+
+// jsonValue decodes the value of a key of type typ.
+func jsonValue(typ string, raw json.RawMessage) (any, error) {
+	unmarshal := func(v any) error {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	}
+	switch typ {
+	case "string":
+		var s jsonString
+		err := unmarshal(&s)
+		return []byte(s), err
+	case "list":
+		var items []jsonString
+		if err := unmarshal(&items); err != nil {
+			return nil, err
+		}
+		l := &listValue{}
+		for _, item := range items {
+			l.PushBack(string(item))
+		}
+		return l, nil
+	case "set":
+		var members []jsonString
+		if err := unmarshal(&members); err != nil {
+			return nil, err
+		}
+		set := newSet()
+		for _, m := range members {
+			set.members[string(m)] = struct{}{}
+		}
+		return set, nil
+	case "hash":
+		var fields []jsonField
+		if err := unmarshal(&fields); err != nil {
+			return nil, err
+		}
+		h := newHash()
+		for _, f := range fields {
+			h.fields[string(f.Field)] = string(f.Value)
+			if f.ExpireAt != 0 {
+				h.setFieldExpire(string(f.Field), jsonTime(f.ExpireAt))
+			}
+		}
+		return h, nil
+	case "zset":
+		var members []jsonMember
+		if err := unmarshal(&members); err != nil {
+			return nil, err
+		}
+		z := newZset()
+		for _, m := range members {
+			z.add(string(m.Member), float64(m.Score))
+		}
+		return z, nil
+	case "stream":
+		var js jsonStream
+		if err := unmarshal(&js); err != nil {
+			return nil, err
+		}
+		return js.value()
+	}
+	t := typeTable[typ]
+	if t == nil || t.Load == nil {
+		return nil, fmt.Errorf("can't load value of unknown type %s", typ)
+	}
+	var data jsonString
+	if err := unmarshal(&data); err != nil {
+		return nil, err
+	}
+	v, err := t.Load([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("loading value of type %s: %w", typ, err)
+	}
+	return &moduleValue{typ: t, v: v}, nil
+}
+
+// value builds the stream js describes.
+func (js *jsonStream) value() (*streamValue, error) {
+	var errs []error
+	id := func(s string) streamID {
+		id, err := parseStreamID(s, 0)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bad stream ID %q", s))
+		}
+		return id
+	}
+	st := newStream()
+	for _, e := range js.Entries {
+		st.entries = append(st.entries, streamEntry{id: id(e.ID), fields: plainStrings(e.Fields)})
+	}
+	st.lastID = id(js.LastID)
+	for _, jg := range js.Groups {
+		if st.groups == nil {
+			st.groups = make(map[string]*streamGroup)
+		}
+		g := newStreamGroup(id(jg.LastID))
+		for _, jc := range jg.Consumers {
+			cons, _ := g.consumer(string(jc.Name), time.Time{})
+			cons.seenAt = jsonTime(jc.SeenTime)
+		}
+		for _, jp := range jg.Pending {
+			cons := g.consumers[string(jp.Consumer)]
+			if cons == nil {
+				return nil, fmt.Errorf("pending entry %s of unknown consumer %q", jp.ID, jp.Consumer)
+			}
+			p := &pendingEntry{id: id(jp.ID), consumer: cons, deliveredAt: jsonTime(jp.DeliveryTime), deliveries: jp.Deliveries}
+			g.pel[p.id] = p
+			cons.pending[p.id] = p
+		}
+		st.groups[string(jg.Name)] = g
+	}
+	return st, errors.Join(errs...)
+}
+
+func plainStrings(s []jsonString) []string {
+	out := make([]string, len(s))
+	for i, x := range s {
+		out[i] = string(x)
+	}
+	return out
+}
+
+// runTool runs the subcommand of the binary given after the flags, in
+// place of the server:
+//
+//	export FILE  load the data as the server would at startup and write
+//	             it to FILE as JSON
+//	import FILE  read the data from the JSON in FILE and save it as the
+//	             dataset, replacing the dbfilename and, with appendonly,
+//	             the appendfilename
+//
+// FILE "-" stands for the standard output or input.
+func (srv *Server) runTool(args []string) error {
+	if len(args) != 2 || args[0] != "export" && args[0] != "import" {
+		return errors.New("usage: [flags] export|import FILE")
+	}
+	name := args[1]
+	if args[0] == "export" {
+		if err := srv.loadDataFromDisk(); err != nil {
+			return err
+		}
+		snap := srv.store.snapshot(false)
+		if name == "-" {
+			return snap.writeJSON(os.Stdout)
+		}
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := snap.writeJSON(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	in := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	srv.store.mu.Lock()
+	defer srv.store.mu.Unlock()
+	if err := srv.store.readJSON(in); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	snap := srv.store.snapshot(false)
+	if err := srv.save(snap); err != nil {
+		return err
+	}
+	if srv.cfg.AppendOnly {
+		f, err := srv.writeRewrite(snap)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Rename(f.Name(), srv.cfg.appendPath())
+	}
+	return nil
+}
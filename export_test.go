@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+func TestExportAndImportJSON(t *testing.T) {
+	RegisterType(blobType)
+	defer delete(typeTable, blobType.Name)
+
+	c := newClient(nil, newSnapshotServer(t))
+	for _, args := range [][]string{
+		{"SET", "str", "<hello>"},
+		{"SET", "bin", "\xff\x00"},
+		{"SET", "ttl", "x", "PX", "100000"},
+		{"RPUSH", "list", "b", "a", "\xfe"},
+		{"SADD", "set", "x", "y"},
+		{"HSET", "hash", "f", "1", "g", "2"},
+		{"HPEXPIRE", "hash", "100000", "FIELDS", "1", "g"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b", "inf", "c"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XADD", "stream", "2-1", "f", "w"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "COUNT", "1", "STREAMS", "stream", ">"},
+		{"XGROUP", "CREATECONSUMER", "stream", "grp", "bob"},
+		{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('hi', function() return 'hi' end)"},
+	} {
+		if reply := do(c, args...); reply.Type == resp.TypeError {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	c.SetValue("blob", blobType, "opaque")
+
+	var out bytes.Buffer
+	if err := c.store.snapshot(false).writeJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+	for _, want := range []string{`"value": "<hello>"`, `"base64": "/wA="`, `"score": "-inf"`, `"type": "test.blob"`, `"expireat": `} {
+		if !strings.Contains(exported, want) {
+			t.Errorf("export lacks %s:\n%s", want, exported)
+		}
+	}
+
+	loaded := newClient(nil, NewServer(defaultConfig()))
+	if err := loaded.store.readJSON(strings.NewReader(exported)); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"GET", "str"},
+		{"GET", "bin"},
+		{"PEXPIRETIME", "ttl"},
+		{"LRANGE", "list", "0", "-1"},
+		{"SMISMEMBER", "set", "x", "y"},
+		{"HMGET", "hash", "f", "g"},
+		{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		{"XRANGE", "stream", "-", "+"},
+		{"FCALL", "hi", "0"},
+		{"TYPE", "blob"},
+		{"DBSIZE"},
+	} {
+		if got, want := do(loaded, args...), do(c, args...); !reflect.DeepEqual(got, want) {
+			t.Errorf("after import, %q = %+v, want %+v", args, got, want)
+		}
+	}
+	expectSameInternals(t, c.store, loaded.store, "hash", "stream")
+	// Exports of the same data are the same, delivery times included.
+	out.Reset()
+	if err := loaded.store.snapshot(false).writeJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != exported {
+		t.Errorf("export after import differs:\n%s\nwant:\n%s", out.String(), exported)
+	}
+
+	for _, doc := range []string{
+		`{"keys": [{"key": "k", "type": "nope", "value": "x"}]}`,
+		`{"keys": [{"key": "k", "type": "list", "value": "x"}]}`,
+		`{"keys": [{"key": "k", "type": "string", "value": {"base64": "!"}}]}`,
+		`{"keys": [{"key": "k", "type": "zset", "value": [{"member": "m", "score": "nan"}]}]}`,
+		`{"keys": [{"key": "k", "type": "stream", "value": {"entries": [{"id": "x", "fields": []}], "lastid": "0-0"}}]}`,
+		`{"keys": [{"key": "k", "type": "string", "value": "x", "ttl": 1}]}`,
+		`{"keys": [`,
+	} {
+		if err := NewStore().readJSON(strings.NewReader(doc)); err == nil {
+			t.Errorf("importing %s succeeded", doc)
+		}
+	}
+}
+
+func TestExportAndImportTool(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	do(c, "SET", "k", "v")
+	do(c, "SAVE")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+
+	cfg := *c.srv.cfg
+	if err := NewServer(&cfg).runTool([]string{"export", path}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = bytes.Replace(doc, []byte(`"value": "v"`), []byte(`"value": "imported"`), 1)
+	if err := os.WriteFile(path, doc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Importing replaces the dump file and, with appendonly, the AOF.
+	cfg.AppendOnly = true
+	if err := NewServer(&cfg).runTool([]string{"import", path}); err != nil {
+		t.Fatal(err)
+	}
+	for _, appendOnly := range []bool{false, true} {
+		cfg.AppendOnly = appendOnly
+		srv := NewServer(&cfg)
+		if err := srv.loadDataFromDisk(); err != nil {
+			t.Fatal(err)
+		}
+		if got := do(newClient(nil, srv), "GET", "k"); !reflect.DeepEqual(got, resp.BulkString("imported")) {
+			t.Errorf("with appendonly %v, GET k after import = %+v", appendOnly, got)
+		}
+	}
+
+	if err := NewServer(&cfg).runTool([]string{"convert", path}); err == nil {
+		t.Error("an unknown subcommand succeeded")
+	}
+}
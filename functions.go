@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// library is a function library loaded with FUNCTION LOAD. Its functions
+// are closures living in the library's own Lua state, which stays open
+// until the library is deleted or replaced.
+type library struct {
+	name      string
+	code      string
+	state     *lua.LState
+	env       *scriptEnv
+	functions map[string]*function
+}
+
+// function is a function registered by a library.
+type function struct {
+	name        string
+	lib         *library
+	callback    *lua.LFunction
+	description string
+	flags       []string
+}
+
+// noWrites reports whether the function was registered with the no-writes
+// flag, which lets FCALL_RO call it and keeps it from running writes.
+func (f *function) noWrites() bool {
+	return slices.Contains(f.flags, "no-writes")
+}
+
+// functionFlags are the flags register_function accepts.
+var functionFlags = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+// functionRegistry holds the loaded libraries and their functions, which
+// share one namespace across libraries. It lives in the Store and is
+// guarded by its lock: FUNCTION is a write command and FCALL runs under
+// the lock.
+type functionRegistry struct {
+	libraries map[string]*library
+	functions map[string]*function
+}
+
+// validName reports whether name is a valid library or function name:
+// letters, digits and underscores, at least one character.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLibraryHeader parses the first line of a library, such as
+// "#!lua name=mylib", and returns the library name.
+func parseLibraryHeader(code string) (string, error) {
+	line, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return "", errors.New("ERR Missing library metadata")
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	var name string
+	for _, f := range fields[1:] {
+		v, ok := strings.CutPrefix(f, "name=")
+		if !ok {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", f)
+		}
+		name = v
+	}
+	if name == "" {
+		return "", errors.New("ERR Library name was not given")
+	}
+	if !validName(name) {
+		return "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// load compiles and runs a library's code, which registers its functions,
+// and adds the library. With replace an existing library of the same name
+// is replaced; otherwise that is an error. A function name already taken
+// by another library is always an error.
+func (r *functionRegistry) load(code string, replace bool) (*library, error) {
+	name, err := parseLibraryHeader(code)
+	if err != nil {
+		return nil, err
+	}
+	old := r.libraries[name]
+	if old != nil && !replace {
+		return nil, fmt.Errorf("ERR Library '%s' already exists", name)
+	}
+
+	// Blank out the header rather than dropping it, so line numbers in
+	// errors match the code as loaded.
+	body := code[strings.IndexByte(code+"\n", '\n'):]
+	chunk, err := parse.Parse(strings.NewReader(body), "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", oneLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %s", oneLine(err.Error()))
+	}
+
+	lib := &library{name: name, code: code, env: &scriptEnv{}, functions: make(map[string]*function)}
+	lib.env.register = func(L *lua.LState) int {
+		f, err := registerFunction(L, lib)
+		if err != nil {
+			L.RaiseError("%s", err)
+		}
+		lib.functions[f.name] = f
+		return 0
+	}
+	lib.state = newScriptState(lib.env)
+	protectGlobals(lib.state)
+	lib.state.Push(lib.state.NewFunctionFromProto(proto))
+	err = lib.state.PCall(0, 0, nil)
+	lib.env.register = nil
+	if err == nil && len(lib.functions) == 0 {
+		err = errors.New("No functions registered")
+	}
+	if err == nil {
+		for fname := range lib.functions {
+			if f, ok := r.functions[fname]; ok && f.lib != old {
+				err = fmt.Errorf("Function %s already exists", fname)
+				break
+			}
+		}
+	}
+	if err != nil {
+		lib.state.Close()
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			return nil, fmt.Errorf("ERR Error registering functions: %s", oneLine(apiErr.Object.String()))
+		}
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+
+	if old != nil {
+		r.delete(name)
+	}
+	if r.libraries == nil {
+		r.libraries = make(map[string]*library)
+		r.functions = make(map[string]*function)
+	}
+	r.libraries[name] = lib
+	for fname, f := range lib.functions {
+		r.functions[fname] = f
+	}
+	return lib, nil
+}
+
+// registerFunction parses the arguments of redis.register_function: either
+// a name and a callback, or a table with function_name, callback and
+// optionally description and flags.
+func registerFunction(L *lua.LState, lib *library) (*function, error) {
+	f := &function{lib: lib}
+	switch arg := L.Get(1).(type) {
+	case lua.LString:
+		f.name = string(arg)
+		f.callback, _ = L.Get(2).(*lua.LFunction)
+		if f.callback == nil {
+			return nil, errors.New("callback argument given to redis.register_function must be a function")
+		}
+	case *lua.LTable:
+		var err error
+		arg.ForEach(func(k, v lua.LValue) {
+			switch k.String() {
+			case "function_name":
+				f.name = v.String()
+			case "callback":
+				f.callback, _ = v.(*lua.LFunction)
+			case "description":
+				f.description = v.String()
+			case "flags":
+				flags, ok := v.(*lua.LTable)
+				if !ok {
+					err = errors.New("flags argument to redis.register_function must be a table representing function flags")
+					return
+				}
+				flags.ForEach(func(_, flag lua.LValue) {
+					if !slices.Contains(functionFlags, flag.String()) {
+						err = errors.New("unknown flag given")
+						return
+					}
+					f.flags = append(f.flags, flag.String())
+				})
+			default:
+				err = errors.New("unknown argument given to redis.register_function")
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if f.callback == nil {
+			return nil, errors.New("redis.register_function must get a callback argument")
+		}
+	default:
+		return nil, errors.New("wrong number of arguments to redis.register_function")
+	}
+	if !validName(f.name) {
+		return nil, errors.New("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if _, ok := lib.functions[f.name]; ok {
+		return nil, errors.New("Function already exists in the library")
+	}
+	return f, nil
+}
+
+// delete removes the library called name and its functions, and reports
+// whether there was one.
+func (r *functionRegistry) delete(name string) bool {
+	lib, ok := r.libraries[name]
+	if !ok {
+		return false
+	}
+	for fname := range lib.functions {
+		delete(r.functions, fname)
+	}
+	delete(r.libraries, name)
+	lib.state.Close()
+	return true
+}
+
+// flush removes every library.
+func (r *functionRegistry) flush() {
+	for name := range r.libraries {
+		r.delete(name)
+	}
+}
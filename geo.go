@@ -0,0 +1,193 @@
+package main
+
+import "math"
+
+// Geo members are sorted set members whose score is a 52-bit geohash, the
+// encoding Redis uses: latitude and longitude are each quantized to 26
+// bits and their bits interleaved, longitude first. Nearby points then
+// tend to have nearby scores, and every geohash cell is a contiguous range
+// of scores, which is what searches query.
+
+const (
+	geoStep    = 26 // bits per coordinate
+	geoLonMin  = -180.0
+	geoLonMax  = 180.0
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoMercMax = 20037726.37 // half the circumference in Web Mercator metres
+
+	// earthRadius is the radius Redis uses for distances, in metres.
+	earthRadius = 6372797.560856
+)
+
+func validLonLat(lon, lat float64) bool {
+	return lon >= geoLonMin && lon <= geoLonMax && lat >= geoLatMin && lat <= geoLatMax
+}
+
+// interleave spreads the low 32 bits of x over the even bits of the result
+// and those of y over the odd bits.
+func interleave(x, y uint32) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func deinterleave(v uint64) (x, y uint32) {
+	return squash(v), squash(v >> 1)
+}
+
+func spread(x uint32) uint64 {
+	v := uint64(x)
+	v = (v | v<<16) & 0x0000ffff0000ffff
+	v = (v | v<<8) & 0x00ff00ff00ff00ff
+	v = (v | v<<4) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+func squash(v uint64) uint32 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0f0f0f0f0f0f0f0f
+	v = (v | v>>4) & 0x00ff00ff00ff00ff
+	v = (v | v>>8) & 0x0000ffff0000ffff
+	v = (v | v>>16) & 0x00000000ffffffff
+	return uint32(v)
+}
+
+// geoCell returns the indexes of the cell holding a point on a grid of
+// 2^step by 2^step cells.
+func geoCell(lon, lat float64, step uint) (x, y uint32) {
+	n := float64(uint64(1) << step)
+	x = uint32(min((lon-geoLonMin)/(geoLonMax-geoLonMin)*n, n-1))
+	y = uint32(min((lat-geoLatMin)/(geoLatMax-geoLatMin)*n, n-1))
+	return x, y
+}
+
+// geoEncode returns the 52-bit geohash of a point, used as its score.
+func geoEncode(lon, lat float64) uint64 {
+	x, y := geoCell(lon, lat, geoStep)
+	return interleave(y, x)
+}
+
+// geoDecode returns the centre of the cell a geohash names, which is the
+// position GEOPOS reports.
+func geoDecode(hash uint64) (lon, lat float64) {
+	y, x := deinterleave(hash)
+	n := float64(uint64(1) << geoStep)
+	lon = geoLonMin + (float64(x)+0.5)/n*(geoLonMax-geoLonMin)
+	lat = geoLatMin + (float64(y)+0.5)/n*(geoLatMax-geoLatMin)
+	return min(max(lon, geoLonMin), geoLonMax), min(max(lat, geoLatMin), geoLatMax)
+}
+
+func degRad(d float64) float64 { return d * math.Pi / 180 }
+func radDeg(r float64) float64 { return r * 180 / math.Pi }
+
+// geoDistance returns the great-circle distance in metres between two
+// points, using the haversine formula.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degRad(lon2-lon1) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geoShape is the area searched by GEOSEARCH: a circle of radius metres,
+// or a box of width by height metres, around a centre.
+type geoShape struct {
+	lon, lat      float64
+	box           bool
+	radius        float64
+	width, height float64
+}
+
+// contains reports whether the point lies in the shape, and its distance
+// from the centre.
+func (s geoShape) contains(lon, lat float64) (float64, bool) {
+	if !s.box {
+		d := geoDistance(s.lon, s.lat, lon, lat)
+		return d, d <= s.radius
+	}
+	// The north-south distance is cheaper, so check it first. The
+	// east-west one is measured along the point's own parallel.
+	if earthRadius*math.Abs(degRad(lat)-degRad(s.lat)) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(s.lon, lat, lon, lat) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.lon, s.lat, lon, lat), true
+}
+
+// bounds returns the extent of the shape in degrees. The longitudes may
+// fall outside [-180, 180] when the shape crosses the antimeridian.
+func (s geoShape) bounds() (minLon, minLat, maxLon, maxLat float64) {
+	halfH, halfW := s.radius, s.radius
+	if s.box {
+		halfH, halfW = s.height/2, s.width/2
+	}
+	latDelta := radDeg(halfH / earthRadius)
+	minLat, maxLat = max(s.lat-latDelta, geoLatMin), min(s.lat+latDelta, geoLatMax)
+	// A parallel is shortest at the edge nearest a pole, so that is where
+	// a given width spans the most longitude.
+	edge := max(math.Abs(minLat), math.Abs(maxLat))
+	lonDelta := 180.0
+	if c := math.Cos(degRad(edge)); c > 0 {
+		lonDelta = min(radDeg(halfW/earthRadius/c), 180)
+	}
+	return s.lon - lonDelta, minLat, s.lon + lonDelta, maxLat
+}
+
+// geoSearchStep picks a grid resolution whose cells are about as large as
+// the search area, as Redis does, so that only a handful of cells need to
+// be scanned.
+func geoSearchStep(meters, lat float64) uint {
+	if meters == 0 {
+		return geoStep
+	}
+	step := 1
+	for meters < geoMercMax {
+		meters *= 2
+		step++
+	}
+	step -= 2
+	// Cells get narrower towards the poles.
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStep))
+}
+
+// scoreRanges returns the score ranges of the grid cells that cover the
+// shape. Each range is half-open: [lo, hi).
+func (s geoShape) scoreRanges() [][2]float64 {
+	size := s.radius
+	if s.box {
+		size = max(s.width, s.height) / 2
+	}
+	step := geoSearchStep(size, s.lat)
+	minLon, minLat, maxLon, maxLat := s.bounds()
+
+	n := int64(1) << step
+	cellW := (geoLonMax - geoLonMin) / float64(n)
+	x0 := int64(math.Floor((minLon - geoLonMin) / cellW))
+	x1 := int64(math.Floor((maxLon - geoLonMin) / cellW))
+	if x1-x0+1 >= n {
+		x0, x1 = 0, n-1
+	}
+	_, y0 := geoCell(geoLonMin, minLat, step)
+	_, y1 := geoCell(geoLonMin, maxLat, step)
+
+	shift := 2 * (geoStep - step)
+	var ranges [][2]float64
+	for y := int64(y0); y <= int64(y1); y++ {
+		for x := x0; x <= x1; x++ {
+			// Wrap around the antimeridian.
+			cell := interleave(uint32(y), uint32((x%n+n)%n)) << shift
+			ranges = append(ranges, [2]float64{float64(cell), float64(cell + 1<<shift)})
+		}
+	}
+	return ranges
+}
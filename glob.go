@@ -0,0 +1,131 @@
+package main
+
+// matchPattern reports whether s matches the Redis glob-style pattern:
+//
+//   - any sequence of characters, including none
+//     ?        any single character
+//     [abc]    one of the listed characters; [^abc] negates, [a-z] is a range
+//     \x       the literal character x
+//
+// It follows Redis' stringmatchlen, so KEYS, SCAN MATCH and PSUBSCRIBE
+// agree with a real server on odd patterns such as an unterminated "[".
+// When nocase is set, letters are compared case-insensitively.
+func matchPattern(pattern, s string, nocase bool) bool {
+	skipLonger := false
+	return globMatch(pattern, s, nocase, &skipLonger, 0)
+}
+
+// maxGlobNesting bounds the recursion used for '*', cutting off patterns
+// such as "*a*a*a*...b" that would otherwise take exponential time.
+const maxGlobNesting = 1000
+
+func globMatch(p, s string, nocase bool, skipLonger *bool, nesting int) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+	for len(p) > 0 && len(s) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for len(s) > 0 {
+				if globMatch(p[1:], s, nocase, skipLonger, nesting+1) {
+					return true
+				}
+				if *skipLonger {
+					// The rest of the pattern already failed against a
+					// suffix of s; trying longer ones cannot help.
+					return false
+				}
+				s = s[1:]
+			}
+			*skipLonger = true
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for {
+				if len(p) == 0 {
+					break
+				}
+				if p[0] == '\\' && len(p) >= 2 {
+					p = p[1:]
+					if p[0] == s[0] {
+						match = true
+					}
+				} else if p[0] == ']' {
+					break
+				} else if len(p) >= 3 && p[1] == '-' {
+					start, end := p[0], p[2]
+					if start > end {
+						start, end = end, start
+					}
+					c := s[0]
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					p = p[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalFold(p[0], s[0], nocase) {
+					match = true
+				}
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				// An unterminated class behaves as if it was closed at the
+				// end of the pattern, leaving nothing after it to match.
+				p = "]"
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if !equalFold(p[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
+	}
+	if len(s) == 0 {
+		for len(p) > 0 && p[0] == '*' {
+			p = p[1:]
+		}
+	}
+	return len(p) == 0 && len(s) == 0
+}
+
+func equalFold(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		nocase     bool
+		want       bool
+	}{
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "hllo", false, true},
+		{"h*llo", "heeeello", false, true},
+		{"h**llo", "heello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hbllo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{`h\*llo`, "h*llo", false, true},
+		{`h\*llo`, "hello", false, false},
+		{`h[\]]llo`, "h]llo", false, true},
+		{"user:*:name", "user:42:name", false, true},
+		{"user:*:name", "user:42:email", false, false},
+		{"HELLO", "hello", false, false},
+		{"HELLO", "hello", true, true},
+		{"h[A-Z]llo", "hello", true, true},
+		{"a*", "", false, false},
+		{"abc[", "abc", false, false},
+		{"ab[c", "abc", false, true},
+		{`abc\`, `abc\`, false, true},
+		{"*a*a*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false, false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s, tt.nocase); got != tt.want {
+			t.Errorf("matchPattern(%q, %q, %v) = %v, want %v", tt.pattern, tt.s, tt.nocase, got, tt.want)
+		}
+	}
+}
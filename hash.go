@@ -0,0 +1,184 @@
+package main
+
+import (
+	"maps"
+	"time"
+)
+
+// hashValue is the value of a hash key: a map of fields to values.
+//
+// Fields may have their own deadlines, set with HEXPIRE and friends. An
+// expired field is invisible to every accessor below even before it is
+// purged, which happens on the next write to the hash or when the janitor
+// gets to it.
+type hashValue struct {
+	fields map[string]string
+	// expires holds the deadlines of the fields that have one. It is nil
+	// until a field gets a TTL.
+	expires map[string]time.Time
+}
+
+func newHash() *hashValue {
+	return &hashValue{fields: make(map[string]string)}
+}
+
+func (h *hashValue) clone() *hashValue {
+	return &hashValue{fields: maps.Clone(h.fields), expires: maps.Clone(h.expires)}
+}
+
+// release drops the fields so the collector can reclaim them.
+func (h *hashValue) release() {
+	clear(h.fields)
+	h.expires = nil
+}
+
+// fieldExpired reports whether field has a deadline that has passed.
+func (h *hashValue) fieldExpired(field string, now time.Time) bool {
+	at, ok := h.expires[field]
+	return ok && !now.Before(at)
+}
+
+// get returns the value of a live field. It is safe to call on a nil hash,
+// which has no fields.
+func (h *hashValue) get(field string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	v, ok := h.fields[field]
+	if !ok || h.fieldExpired(field, time.Now()) {
+		return "", false
+	}
+	return v, true
+}
+
+// set stores v in field and clears any TTL the field had, as HSET does.
+func (h *hashValue) set(field, v string) {
+	h.fields[field] = v
+	delete(h.expires, field)
+}
+
+// update stores v in field, keeping its TTL unless it has already expired,
+// as HINCRBY does.
+func (h *hashValue) update(field, v string) {
+	if h.fieldExpired(field, time.Now()) {
+		delete(h.expires, field)
+	}
+	h.fields[field] = v
+}
+
+// del removes field and reports whether it was live.
+func (h *hashValue) del(field string) bool {
+	_, ok := h.get(field)
+	delete(h.fields, field)
+	delete(h.expires, field)
+	return ok
+}
+
+// length returns the number of live fields.
+func (h *hashValue) length() int {
+	if h == nil {
+		return 0
+	}
+	n := len(h.fields)
+	now := time.Now()
+	for field := range h.expires {
+		if h.fieldExpired(field, now) {
+			n--
+		}
+	}
+	return n
+}
+
+// each calls fn for every live field.
+func (h *hashValue) each(fn func(field, v string)) {
+	if h == nil {
+		return
+	}
+	now := time.Now()
+	for field, v := range h.fields {
+		if !h.fieldExpired(field, now) {
+			fn(field, v)
+		}
+	}
+}
+
+// purge deletes the fields that have expired by now.
+func (h *hashValue) purge(now time.Time) {
+	for field := range h.expires {
+		if h.fieldExpired(field, now) {
+			delete(h.fields, field)
+			delete(h.expires, field)
+		}
+	}
+}
+
+// setFieldExpire sets the deadline of field, which must exist.
+func (h *hashValue) setFieldExpire(field string, at time.Time) {
+	if h.expires == nil {
+		h.expires = make(map[string]time.Time)
+	}
+	h.expires[field] = at
+}
+
+// lookupHash returns the hash at key, or nil if there is none. The caller
+// must hold mu for reading or writing.
+func (s *Store) lookupHash(key string) (*hashValue, error) {
+	return lookupTyped[*hashValue](s, key)
+}
+
+// hashForWrite returns the hash at key, creating an empty one if there is
+// none. The caller must hold mu for writing and must call doneWithHash
+// afterwards, which deletes the key if the hash was left empty.
+func (s *Store) hashForWrite(key string) (*hashValue, error) {
+	h, err := s.lookupHash(key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		h = newHash()
+		s.put(key, StoreData{value: h})
+	}
+	h.purge(time.Now())
+	return h, nil
+}
+
+// doneWithHash finishes a write to the hash at key: it deletes the key if
+// no fields are left and keeps the field expiry index up to date. The
+// caller must hold mu for writing.
+func (s *Store) doneWithHash(key string, h *hashValue) {
+	h.purge(time.Now())
+	if len(h.fields) == 0 {
+		s.remove(key)
+		return
+	}
+	s.indexFieldExpires(key, h)
+}
+
+// indexFieldExpires adds key to the index of hashes with field TTLs that
+// the janitor walks, or drops it from there once no field has a TTL. The
+// caller must hold mu for writing.
+func (s *Store) indexFieldExpires(key string, h *hashValue) {
+	if len(h.expires) == 0 {
+		delete(s.fieldExpires, key)
+		return
+	}
+	if s.fieldExpires == nil {
+		s.fieldExpires = make(map[string]struct{})
+	}
+	s.fieldExpires[key] = struct{}{}
+}
+
+// cleanupFields purges expired hash fields, deleting hashes that are left
+// empty. The caller must hold mu for writing.
+func (s *Store) cleanupFields(now time.Time) {
+	for key := range s.fieldExpires {
+		h, ok := s.data[key].value.(*hashValue)
+		if !ok {
+			delete(s.fieldExpires, key)
+			continue
+		}
+		s.preserve(key)
+		h.purge(now)
+		s.doneWithHash(key, h)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are stored as strings in the format Redis uses, so PFADD on
+// a value written by Redis (or read back with GET and SET elsewhere) just
+// works. A value is a 16-byte header followed by the registers:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// E is the encoding, dense or sparse, and the last 8 bytes cache the
+// cardinality in little-endian order, with the top bit set when the cache
+// is stale.
+//
+// The dense encoding packs 16384 6-bit registers, least significant bit
+// first. The sparse encoding run-length encodes them with three opcodes:
+//
+//	00xxxxxx          ZERO:  1 to 64 registers set to 0
+//	01xxxxxx yyyyyyyy XZERO: 1 to 16384 registers set to 0
+//	1vvvvvxx          VAL:   1 to 4 registers set to 1 to 32
+//
+// New HyperLogLogs start sparse, which takes a few bytes for small sets,
+// and are converted to dense once a register exceeds 32 or the sparse form
+// grows past hll-sparse-max-bytes.
+
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllPMask       = hllRegisters - 1
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHdrSize     = 16
+	hllDenseSize   = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMax   = 32
+	hllSparseValLen   = 4
+	hllSparseZeroLen  = 64
+	hllSparseXZeroMax = 16384
+
+	// hllAlphaInf is 0.5/ln(2), the bias correction of the estimator as
+	// the number of registers goes to infinity.
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var (
+	errHLLInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errHLLCorrupt = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// hllRegs holds the registers of a HyperLogLog decoded from either
+// encoding.
+type hllRegs [hllRegisters]uint8
+
+// newHLL returns an empty HyperLogLog: a sparse one whose registers are
+// all covered by a single XZERO, with a valid cached cardinality of 0.
+func newHLL() []byte {
+	b := hllHeader(hllSparse)
+	return append(b, 0x40|(hllSparseXZeroMax-1)>>8, (hllSparseXZeroMax-1)&0xff)
+}
+
+func hllHeader(encoding byte) []byte {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, "HYLL")
+	b[4] = encoding
+	return b
+}
+
+// isHLL reports whether b has a valid HyperLogLog header. A sparse body
+// is only checked when it is decoded.
+func isHLL(b []byte) bool {
+	if len(b) < hllHdrSize || string(b[:4]) != "HYLL" || b[4] > hllSparse {
+		return false
+	}
+	return b[4] != hllDense || len(b) == hllDenseSize
+}
+
+// hllCachedCount returns the cached cardinality, if it is valid.
+func hllCachedCount(b []byte) (uint64, bool) {
+	if b[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCachedCount(b []byte, n uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], n)
+}
+
+func hllInvalidateCache(b []byte) {
+	b[15] |= 0x80
+}
+
+// murmurHash64A is MurmurHash2, 64-bit version, by Austin Appleby, reading
+// blocks in little-endian order as Redis does on every platform.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+	for ; len(key) >= 8; key = key[8:] {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen hashes an element to the register it belongs to and the
+// length of the 000..1 pattern that follows, which is what the register
+// records the maximum of.
+func hllPatLen(element string) (index int, count uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index = int(hash & hllPMask)
+	// Setting bit Q bounds the count at Q+1 for a hash of all zeros.
+	hash = hash>>hllP | 1<<hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// hllDenseGet and hllDenseSet access register i of the dense registers in
+// regs, the bytes after the header.
+func hllDenseGet(regs []byte, i int) uint8 {
+	byteIdx, fb := i*hllBits/8, uint(i*hllBits&7)
+	v := regs[byteIdx] >> fb
+	if byteIdx+1 < len(regs) {
+		v |= regs[byteIdx+1] << (8 - fb)
+	}
+	return v & hllRegisterMax
+}
+
+func hllDenseSet(regs []byte, i int, v uint8) {
+	byteIdx, fb := i*hllBits/8, uint(i*hllBits&7)
+	regs[byteIdx] &^= hllRegisterMax << fb
+	regs[byteIdx] |= v << fb
+	if byteIdx+1 < len(regs) {
+		regs[byteIdx+1] &^= hllRegisterMax >> (8 - fb)
+		regs[byteIdx+1] |= v >> (8 - fb)
+	}
+}
+
+// mergeInto raises every register in regs to at least its value in the
+// HyperLogLog b, which must have a valid header. Decoding into zeroed
+// registers is a merge too.
+func (regs *hllRegs) mergeInto(b []byte) error {
+	body := b[hllHdrSize:]
+	if b[4] == hllDense {
+		for i := range regs {
+			regs[i] = max(regs[i], hllDenseGet(body, i))
+		}
+		return nil
+	}
+
+	idx := 0
+	for i := 0; i < len(body); i++ {
+		op := body[i]
+		switch op & 0xc0 {
+		case 0x00:
+			idx += int(op&0x3f) + 1
+		case 0x40:
+			if i+1 == len(body) {
+				return errHLLCorrupt
+			}
+			i++
+			idx += int(op&0x3f)<<8 | int(body[i]) + 1
+		default:
+			v, n := (op>>2)&0x1f+1, int(op&0x3)+1
+			if idx+n > hllRegisters {
+				return errHLLCorrupt
+			}
+			for j := idx; j < idx+n; j++ {
+				regs[j] = max(regs[j], v)
+			}
+			idx += n
+		}
+		if idx > hllRegisters {
+			return errHLLCorrupt
+		}
+	}
+	if idx != hllRegisters {
+		return errHLLCorrupt
+	}
+	return nil
+}
+
+// sparse encodes the registers sparsely. ok is false if that is impossible
+// because a register is too large, or would take more than maxBytes.
+func (regs *hllRegs) sparse(maxBytes int) (b []byte, ok bool) {
+	b = hllHeader(hllSparse)
+	for i := 0; i < hllRegisters; {
+		v := regs[i]
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == v {
+			run++
+		}
+		i += run
+		switch {
+		case v > hllSparseValMax:
+			return nil, false
+		case v == 0:
+			for run > hllSparseZeroLen {
+				n := min(run, hllSparseXZeroMax)
+				b = append(b, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			}
+			if run > 0 {
+				b = append(b, byte(run-1))
+			}
+		default:
+			for ; run > 0; run -= hllSparseValLen {
+				n := min(run, hllSparseValLen)
+				b = append(b, 0x80|(v-1)<<2|byte(n-1))
+			}
+		}
+		if len(b) > maxBytes {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// dense encodes the registers densely.
+func (regs *hllRegs) dense() []byte {
+	b := make([]byte, hllDenseSize)
+	copy(b, hllHeader(hllDense))
+	for i, v := range regs {
+		if v != 0 {
+			hllDenseSet(b[hllHdrSize:], i, v)
+		}
+	}
+	return b
+}
+
+// count estimates the cardinality with the estimator from Otmar Ertl's
+// "New cardinality estimation algorithms for HyperLogLog sketches", as
+// Redis does since 5.0.
+func (regs *hllRegs) count() uint64 {
+	var histo [hllRegisterMax + 1]int
+	for _, v := range regs {
+		histo[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if z == prev {
+			return z / 3
+		}
+	}
+}
+
+// lookupHLL returns the HyperLogLog at key, failing if the key holds
+// something else, including a string that is not a HyperLogLog. ok is
+// false when there is no such key. The caller must hold mu for reading or
+// writing.
+func (s *Store) lookupHLL(key string) (d StoreData, ok bool, err error) {
+	d, ok, err = s.lookupString(key)
+	if err != nil || !ok {
+		return d, ok, err
+	}
+	if !isHLL(d.bytes()) {
+		return d, false, errHLLInvalid
+	}
+	return d, true, nil
+}
+
+// hllAdd adds elements to the HyperLogLog b and reports whether any
+// register changed. Dense registers are updated in place; a sparse value
+// is decoded, updated and re-encoded, and converted to dense if it no
+// longer fits in maxBytes. The returned slice replaces b.
+func hllAdd(b []byte, elements []string, maxBytes int) ([]byte, bool, error) {
+	var updated bool
+	if b[4] == hllDense {
+		body := b[hllHdrSize:]
+		for _, e := range elements {
+			i, count := hllPatLen(e)
+			if hllDenseGet(body, i) < count {
+				hllDenseSet(body, i, count)
+				updated = true
+			}
+		}
+		return b, updated, nil
+	}
+
+	var regs hllRegs
+	if err := regs.mergeInto(b); err != nil {
+		return nil, false, err
+	}
+	for _, e := range elements {
+		i, count := hllPatLen(e)
+		if regs[i] < count {
+			regs[i] = count
+			updated = true
+		}
+	}
+	if !updated {
+		return b, false, nil
+	}
+	if s, ok := regs.sparse(maxBytes); ok {
+		return s, true, nil
+	}
+	return regs.dense(), true, nil
+}
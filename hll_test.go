@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+func TestHLLDenseRegisters(t *testing.T) {
+	body := make([]byte, hllDenseSize-hllHdrSize)
+	for i := 0; i < hllRegisters; i++ {
+		hllDenseSet(body, i, uint8(i%64))
+	}
+	for i := 0; i < hllRegisters; i++ {
+		if got := hllDenseGet(body, i); got != uint8(i%64) {
+			t.Fatalf("register %d: got %d, want %d", i, got, i%64)
+		}
+	}
+}
+
+func TestHLLSparseRoundTrip(t *testing.T) {
+	var regs hllRegs
+	for i := 0; i < 500; i++ {
+		regs[rand.IntN(hllRegisters)] = uint8(rand.IntN(hllSparseValMax) + 1)
+	}
+	b, ok := regs.sparse(1 << 20)
+	if !ok {
+		t.Fatal("sparse encoding failed")
+	}
+	var got hllRegs
+	if err := got.mergeInto(b); err != nil {
+		t.Fatal(err)
+	}
+	if got != regs {
+		t.Fatal("sparse round trip changed the registers")
+	}
+
+	regs[0] = hllSparseValMax + 1
+	if _, ok := regs.sparse(1 << 20); ok {
+		t.Error("a register above 32 must not be sparse-encoded")
+	}
+}
+
+func TestHLLRejectsCorruptSparse(t *testing.T) {
+	b := newHLL()
+	b = append(b, 0x00) // one register too many
+	var regs hllRegs
+	if err := regs.mergeInto(b); err != errHLLCorrupt {
+		t.Errorf("expected errHLLCorrupt, got %v", err)
+	}
+}
+
+func TestHLLAccuracy(t *testing.T) {
+	b := newHLL()
+	var elements []string
+	const n = 100000
+	for i := 0; i < n; i++ {
+		elements = append(elements, "element:"+strconv.Itoa(i))
+	}
+	var err error
+	for i := 0; i < n; i += 1000 {
+		if b, _, err = hllAdd(b, elements[i:i+1000], 3000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b[4] != hllDense {
+		t.Error("expected the HyperLogLog to have been converted to dense")
+	}
+	var regs hllRegs
+	if err := regs.mergeInto(b); err != nil {
+		t.Fatal(err)
+	}
+	// The standard error is 0.81%; allow five of them.
+	if got := float64(regs.count()); got < n*0.96 || got > n*1.04 {
+		t.Errorf("estimated %v distinct elements, want about %d", got, n)
+	}
+}
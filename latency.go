@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHistoryLen is the number of samples kept for each event, as in
+// Redis: one per second at most, so a few minutes' worth of spikes.
+const latencyHistoryLen = 160
+
+// Latency event classes.
+const (
+	// latencyCommandEvent and latencyFastCommandEvent time commands, the
+	// latter those flagged fast.
+	latencyCommandEvent     = "command"
+	latencyFastCommandEvent = "fast-command"
+	// latencyExpireCycleEvent times a pass of the janitor reclaiming expired
+	// keys and fields.
+	latencyExpireCycleEvent = "expire-cycle"
+)
+
+// latencySample is the worst latency of an event in a second.
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyEvent is the history of an event class.
+type latencyEvent struct {
+	// samples holds the latest samples, oldest first.
+	samples []latencySample
+	// max is the worst latency ever recorded for the event.
+	max time.Duration
+}
+
+// latencyMonitor records, per event class, the latencies that reached the
+// latency-monitor-threshold, for the LATENCY command. A zero threshold
+// disables it.
+type latencyMonitor struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	events    map[string]*latencyEvent
+	// now tells the time samples are taken at; tests replace it.
+	now func() time.Time
+}
+
+func newLatencyMonitor(cfg *Config) *latencyMonitor {
+	return &latencyMonitor{
+		threshold: time.Duration(cfg.LatencyMonitorThreshold) * time.Millisecond,
+		events:    make(map[string]*latencyEvent),
+		now:       time.Now,
+	}
+}
+
+// add records that event took d, if that reaches the threshold. Samples in
+// the same second are merged, keeping the worst.
+func (m *latencyMonitor) add(event string, d time.Duration) {
+	if m.threshold <= 0 || d < m.threshold {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, d)
+	now := m.now().Truncate(time.Second)
+	if n := len(e.samples); n > 0 && e.samples[n-1].at.Equal(now) {
+		e.samples[n-1].latency = max(e.samples[n-1].latency, d)
+		return
+	}
+	e.samples = append(e.samples, latencySample{at: now, latency: d})
+	if over := len(e.samples) - latencyHistoryLen; over > 0 {
+		e.samples = append(e.samples[:0], e.samples[over:]...)
+	}
+}
+
+// names returns the events with samples, sorted.
+func (m *latencyMonitor) names() []string {
+	var names []string
+	for name := range m.events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// latest returns, for each event with samples, its latest sample and worst
+// latency.
+func (m *latencyMonitor) latest() (names []string, latest []latencySample, worst []time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names = m.names()
+	for _, name := range names {
+		e := m.events[name]
+		latest = append(latest, e.samples[len(e.samples)-1])
+		worst = append(worst, e.max)
+	}
+	return names, latest, worst
+}
+
+// history returns the samples of event, oldest first.
+func (m *latencyMonitor) history(event string) []latencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.events[event]; e != nil {
+		return slices.Clone(e.samples)
+	}
+	return nil
+}
+
+// reset drops the history of the given events, or of every event if none
+// is given, and returns how many had one.
+func (m *latencyMonitor) reset(events []string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		clear(m.events)
+		return n
+	}
+	var n int
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// doctor returns a human readable report on the latency spikes observed,
+// in the manner of Redis' LATENCY DOCTOR.
+func (m *latencyMonitor) doctor() string {
+	if m.threshold <= 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may start the server with -latency-monitor-threshold <milliseconds> to enable it.\n"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := m.names()
+	if len(names) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. " +
+			"I honestly think you ought to sleep tonight.\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	advice := map[string]bool{}
+	for i, name := range names {
+		e := m.events[name]
+		var sum time.Duration
+		for _, s := range e.samples {
+			sum += s.latency
+		}
+		avg := sum / time.Duration(len(e.samples))
+		var dev time.Duration
+		for _, s := range e.samples {
+			dev += (s.latency - avg).Abs()
+		}
+		dev /= time.Duration(len(e.samples))
+		period := e.samples[len(e.samples)-1].at.Sub(e.samples[0].at) / time.Duration(len(e.samples))
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n",
+			i+1, name, len(e.samples), avg.Milliseconds(), dev.Milliseconds(), period.Seconds(), e.max.Milliseconds())
+		switch name {
+		case latencyCommandEvent, latencyFastCommandEvent:
+			advice["slowlog"] = true
+		case latencyExpireCycleEvent:
+			advice["expire"] = true
+		}
+	}
+
+	b.WriteString("\nI have a few advices for you:\n\n")
+	if advice["slowlog"] {
+		b.WriteString("- Check your SLOWLOG for commands that are slow to run, and consider replacing O(N) commands " +
+			"on big values, such as KEYS, SMEMBERS or LRANGE over long lists, with incremental alternatives like SCAN.\n")
+	}
+	if advice["expire"] {
+		b.WriteString("- Many keys or fields expire at the same time. Consider adding some jitter to the TTLs you set, " +
+			"so the janitor reclaims them in smaller batches.\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import "sync"
+
+// lazyfree releases deleted values on a background goroutine, the way
+// Redis' lazyfree thread does, so that dropping a huge value never stalls
+// the command that deleted it. Go's collector frees the memory either way;
+// what the reclaimer takes off the command path is the work of tearing a
+// large container apart while the store lock is held.
+var lazyfree reclaimer
+
+type reclaimer struct {
+	once sync.Once
+	ch   chan any
+	wg   sync.WaitGroup
+}
+
+// lazyfreeQueue bounds how many values can wait for the reclaimer. When it
+// is full, values are left to the garbage collector instead of blocking.
+const lazyfreeQueue = 1024
+
+// free hands v, a StoreData or a whole map of them, to the reclaimer.
+func (r *reclaimer) free(v any) {
+	r.once.Do(func() {
+		r.ch = make(chan any, lazyfreeQueue)
+		go r.run()
+	})
+	r.wg.Add(1)
+	select {
+	case r.ch <- v:
+	default:
+		r.wg.Done()
+	}
+}
+
+func (r *reclaimer) run() {
+	for v := range r.ch {
+		release(v)
+		r.wg.Done()
+	}
+}
+
+// wait blocks until every value handed to free so far has been released.
+func (r *reclaimer) wait() {
+	r.wg.Wait()
+}
+
+// release drops the references held by v so the collector can reclaim it
+// piecemeal.
+func release(v any) {
+	switch v := v.(type) {
+	case map[string]StoreData:
+		for _, d := range v {
+			release(d)
+		}
+		clear(v)
+	case StoreData:
+		if r, ok := v.value.(interface{ release() }); ok {
+			r.release()
+		}
+	}
+}
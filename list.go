@@ -0,0 +1,41 @@
+package main
+
+// listValue is the value of a list key.
+type listValue struct {
+	deque
+}
+
+func (l *listValue) clone() *listValue {
+	return &listValue{*l.deque.Clone()}
+}
+
+// release drops the elements so the collector can reclaim them.
+func (l *listValue) release() {
+	l.deque = deque{}
+}
+
+// lookupList returns the list at key, or nil if there is none. The caller
+// must hold mu for reading or writing.
+func (s *Store) lookupList(key string) (*listValue, error) {
+	return lookupTyped[*listValue](s, key)
+}
+
+// normalizeRange converts the inclusive, possibly negative start and end
+// offsets taken by LRANGE and friends into a half-open range [lo, hi)
+// clamped to a sequence of n elements. It returns an empty range when
+// nothing is selected.
+func normalizeRange(start, end int64, n int) (lo, hi int) {
+	size := int64(n)
+	if start < 0 {
+		start += size
+	}
+	if end < 0 {
+		end += size
+	}
+	start = max(start, 0)
+	end = min(end, size-1)
+	if start > end || start >= size {
+		return 0, 0
+	}
+	return int(start), int(end + 1)
+}
@@ -1,59 +1,44 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
 	"net"
-	"strings"
-	"sync"
-	"time"
+	"os"
 )
 
-func handleConnection(conn net.Conn, store *Store) {
-	defer conn.Close()
-
-	reader := bufio.NewReader(conn)
-	
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
+func main() {
+	cfg := parseFlags()
+	// The directory may hold a dump of the data, so it is kept from other
+	// users.
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		log.Fatalf("Can't create the working directory %s: %v", cfg.Dir, err)
+	}
+	srv := NewServer(cfg)
+	if flag.NArg() > 0 {
+		if err := srv.runTool(flag.Args()); err != nil {
+			log.Fatal(err)
 		}
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		return
+	}
+	if err := srv.loadDataFromDisk(); err != nil {
+		log.Fatalf("Fatal error loading the DB: %v. Exiting.", err)
+	}
+	if cfg.AppendOnly {
+		if err := srv.startAppendOnly(); err != nil {
+			log.Fatalf("Can't open the append-only file: %v", err)
 		}
-	
-		parts := strings.Fields(line)
-		cmd := strings.ToUpper(parts[0])
-		args := parts[1:]
-	
-		resp := store.Execute(cmd, args)
-		fmt.Fprintln(conn, resp)
 	}
-	
-}
 
-func main() {
-	ln, err := net.Listen("tcp", ":8000")
+	ln, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer ln.Close()
 
-	store := &Store{
-		mu: sync.RWMutex{},
-		data: make(map[string]StoreData),
-	}
-
-	store.StartJanitor(time.Duration(time.Second * 3))
-
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Fatal(err)
-		}
-		go handleConnection(conn, store)
+	if err := srv.Serve(ln); !errors.Is(err, errShutdown) {
+		log.Fatal(err)
 	}
-}
\ No newline at end of file
+	log.Print("Ready to exit, bye bye...")
+}
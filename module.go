@@ -0,0 +1,97 @@
+package main
+
+import "strings"
+
+// This file is the extension API for embedders who compile their own
+// commands and value types into the server, in the spirit of Redis
+// modules. Commands are added with RegisterCommand; value types with
+// RegisterType, and command handlers reach keys of those types through
+// the Client methods below. Like the built-in commands, extensions
+// register themselves from an init function.
+
+// DataType describes a value type added with RegisterType. Values of the
+// type are opaque to the server, which only calls the hooks below.
+type DataType struct {
+	// Name is what TYPE reports for keys holding the type and what SCAN
+	// TYPE matches. It must not be the name of a built-in type.
+	Name string
+	// Copy returns a deep copy of a value, for COPY. If it is nil values
+	// are taken to be immutable and the copy shares them.
+	Copy func(v any) any
+	// Save encodes a value for persistence and Load decodes it back. A
+	// type without them cannot be persisted.
+	Save func(v any) []byte
+	Load func(data []byte) (any, error)
+	// Rewrite returns the commands that store a value at key, for
+	// BGREWRITEAOF. A type without it cannot be in a rewritten AOF.
+	Rewrite func(key string, v any) [][]string
+}
+
+// moduleValue is a value of a registered type, as held in the store.
+type moduleValue struct {
+	typ *DataType
+	v   any
+}
+
+// typeTable holds every registered value type keyed by name.
+var typeTable = map[string]*DataType{}
+
+// builtinTypes are the type names TYPE reports for the built-in types.
+var builtinTypes = []string{"string", "list", "hash", "set", "zset", "stream", "none"}
+
+// RegisterType adds t to the value types the server can store. It panics
+// if the name is empty or already taken, by a built-in type or another
+// registered one.
+func RegisterType(t *DataType) {
+	name := strings.ToLower(t.Name)
+	if name == "" {
+		panic("type has no name")
+	}
+	for _, builtin := range builtinTypes {
+		if name == builtin {
+			panic("type name is reserved: " + name)
+		}
+	}
+	if _, ok := typeTable[name]; ok {
+		panic("type already registered: " + name)
+	}
+	t.Name = name
+	typeTable[name] = t
+}
+
+// Value returns the live value of type t at key, or nil if there is none.
+// It fails with a WRONGTYPE error if the key holds another type. Handlers
+// must be registered with flagReadonly or flagWrite, so the store is
+// locked.
+func (c *Client) Value(key string, t *DataType) (any, error) {
+	d, ok := c.store.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	mv, isT := d.value.(*moduleValue)
+	if !isT || mv.typ != t {
+		return nil, errWrongType
+	}
+	return mv.v, nil
+}
+
+// SetValue stores v, of type t, at key, replacing any previous value and
+// TTL. Handlers must be registered with flagWrite.
+func (c *Client) SetValue(key string, t *DataType, v any) {
+	c.store.put(key, StoreData{value: &moduleValue{typ: t, v: v}})
+}
+
+// Modified records that the value at key was modified in place, so
+// WATCH and CLIENT TRACKING see the change, and publishes event as a
+// keyspace notification of the generic class. Handlers must be registered
+// with flagWrite.
+func (c *Client) Modified(key, event string) {
+	c.store.signalModified(key)
+	c.store.notify(notifyGeneric, event, key)
+}
+
+// DeleteKey deletes key, whatever its type, and reports whether it held a
+// live value. Handlers must be registered with flagWrite.
+func (c *Client) DeleteKey(key string) bool {
+	return c.store.del(key)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+// counterType is a module type holding an *int64, incremented in place by
+// the mod.incr test command.
+var counterType = &DataType{
+	Name: "mod.counter",
+	Copy: func(v any) any {
+		n := *v.(*int64)
+		return &n
+	},
+}
+
+func modIncrCommand(c *Client, args []string) resp.Value {
+	v, err := c.Value(args[1], counterType)
+	if err != nil {
+		return errorReply(err)
+	}
+	n, _ := v.(*int64)
+	if n == nil {
+		n = new(int64)
+		c.SetValue(args[1], counterType, n)
+	} else {
+		c.Modified(args[1], "mod.incr")
+	}
+	*n++
+	return resp.Integer(*n)
+}
+
+func TestModuleType(t *testing.T) {
+	RegisterType(counterType)
+	defer delete(typeTable, counterType.Name)
+	RegisterCommand(&Command{Name: "mod.incr", Arity: 2, Flags: flagWrite, FirstKey: 1, LastKey: 1, Step: 1, Handler: modIncrCommand})
+	defer delete(commandTable, "mod.incr")
+
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"MOD.INCR", "n"}, resp.Integer(1)},
+		{[]string{"MOD.INCR", "n"}, resp.Integer(2)},
+		{[]string{"TYPE", "n"}, resp.SimpleString("mod.counter")},
+		{[]string{"GET", "n"}, wrongTypeReply},
+		{[]string{"SET", "s", "x"}, resp.OK},
+		{[]string{"MOD.INCR", "s"}, wrongTypeReply},
+		{[]string{"COPY", "n", "m"}, resp.Integer(1)},
+		{[]string{"MOD.INCR", "m"}, resp.Integer(3)},
+		{[]string{"MOD.INCR", "n"}, resp.Integer(3)},
+	})
+
+	other := newClient(nil, c.srv)
+	do(other, "WATCH", "n")
+	do(c, "MOD.INCR", "n")
+	do(other, "MULTI")
+	if got := do(other, "EXEC"); !got.IsNull() {
+		t.Errorf("EXEC = %+v, want nil after the watched value was modified in place", got)
+	}
+}
+
+func TestRegisterTypeReservedName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a type named hash to panic")
+		}
+	}()
+	RegisterType(&DataType{Name: "Hash"})
+}
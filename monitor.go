@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// monitors is the registry of clients in MONITOR mode, which are sent a
+// line describing every command the server runs. Like Redis it leaves out
+// admin commands, which may carry secrets, and feeds a command once it has
+// run, so the commands of a transaction come before its EXEC.
+type monitors struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+func newMonitors() *monitors {
+	return &monitors{clients: make(map[*Client]struct{})}
+}
+
+// add puts c in MONITOR mode and replies OK, under the lock feeding
+// takes, so the reply comes before the first line fed.
+func (m *monitors) add(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[c] = struct{}{}
+	c.out.write(resp.OK, c.proto)
+}
+
+// remove takes c out of MONITOR mode, if it was in it.
+func (m *monitors) remove(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, c)
+}
+
+// feed sends the monitors a line for args, run by c, or by a script on
+// c's behalf if fromScript is set, in the format of Redis:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "k" "v"
+func (m *monitors) feed(c *Client, cmd *Command, args []string, fromScript bool) {
+	if cmd.Flags&flagAdmin != 0 {
+		return
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.clients) == 0 {
+		return
+	}
+	now := time.Now()
+	from := "lua"
+	if !fromScript {
+		from = c.addr()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, from)
+	for _, arg := range args {
+		b.WriteByte(' ')
+		b.WriteString(quoteArg(arg))
+	}
+	line := resp.SimpleString(b.String())
+	for mon := range m.clients {
+		mon.out.push(line)
+	}
+}
+
+// quoteArg quotes s the way Redis shows arguments in MONITOR output:
+// between double quotes, with quotes, backslashes and control characters
+// escaped and other non-printable bytes written in hex.
+func quoteArg(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if ch < ' ' || ch > '~' {
+				fmt.Fprintf(&b, `\x%02x`, ch)
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
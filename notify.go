@@ -0,0 +1,74 @@
+package main
+
+import "errors"
+
+// notifyClass is a set of keyspace event classes, as selected by the
+// notify-keyspace-events setting. K and E pick the channels events are
+// published on, the other flags which events are; with neither K nor E,
+// or no event class, nothing is published.
+type notifyClass uint16
+
+const (
+	notifyKeyspace notifyClass = 1 << iota // K: __keyspace@0__:<key>, message is the event
+	notifyKeyevent                         // E: __keyevent@0__:<event>, message is the key
+	notifyGeneric                          // g: del, expire, persist, ...
+	notifyString                           // $: set, ...
+	notifyList                             // l
+	notifySet                              // s
+	notifyHash                             // h
+	notifyZset                             // z
+	notifyExpired                          // x: keys removed once their TTL passed
+	notifyEvicted                          // e
+	notifyStream                           // t
+	notifyKeyMiss                          // m
+	notifyNew                              // n
+
+	// notifyAll is what A stands for. It leaves out m and n, like Redis.
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset | notifyExpired | notifyEvicted | notifyStream
+)
+
+var notifyFlagChars = map[byte]notifyClass{
+	'K': notifyKeyspace,
+	'E': notifyKeyevent,
+	'g': notifyGeneric,
+	'$': notifyString,
+	'l': notifyList,
+	's': notifySet,
+	'h': notifyHash,
+	'z': notifyZset,
+	'x': notifyExpired,
+	'e': notifyEvicted,
+	't': notifyStream,
+	'm': notifyKeyMiss,
+	'n': notifyNew,
+	'A': notifyAll,
+}
+
+// parseNotifyKeyspaceEvents parses a notify-keyspace-events value such as
+// "KEA" or "Kx". The empty string disables notifications.
+func parseNotifyKeyspaceEvents(s string) (notifyClass, error) {
+	var flags notifyClass
+	for i := 0; i < len(s); i++ {
+		f, ok := notifyFlagChars[s[i]]
+		if !ok {
+			return 0, errors.New("invalid event class character; use 'g$lshzxetmnKEA'")
+		}
+		flags |= f
+	}
+	return flags, nil
+}
+
+// notify publishes a keyspace event for key if the notify-keyspace-events
+// setting asks for events of class. The caller must hold mu for writing.
+func (s *Store) notify(class notifyClass, event, key string) {
+	flags := s.notifyFlags
+	if flags&class == 0 || s.publish == nil {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		s.publish("__keyspace@0__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.publish("__keyevent@0__:"+event, key)
+	}
+}
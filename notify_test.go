@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+func TestParseNotifyKeyspaceEvents(t *testing.T) {
+	tests := []struct {
+		in   string
+		want notifyClass
+	}{
+		{"", 0},
+		{"KEA", notifyKeyspace | notifyKeyevent | notifyAll},
+		{"Ex", notifyKeyevent | notifyExpired},
+		{"Kg$", notifyKeyspace | notifyGeneric | notifyString},
+		{"AKEmn", notifyKeyspace | notifyKeyevent | notifyAll | notifyKeyMiss | notifyNew},
+	}
+	for _, tc := range tests {
+		got, err := parseNotifyKeyspaceEvents(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseNotifyKeyspaceEvents(%q) = %b, %v; want %b", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := parseNotifyKeyspaceEvents("KQ"); err == nil {
+		t.Error("parseNotifyKeyspaceEvents(\"KQ\") succeeded, want an error")
+	}
+}
+
+// keyEvent builds a message on a keyspace or keyevent channel as a pattern
+// subscriber reads it.
+func keyEvent(pattern, channel, message string) resp.Value {
+	return pushed(resp.BulkString("pmessage"), resp.BulkString(pattern), resp.BulkString(channel), resp.BulkString(message))
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.NotifyKeyspaceEvents, _ = parseNotifyKeyspaceEvents("KEA")
+	c := newClient(nil, NewServer(cfg))
+	sub := newClient(nil, c.srv)
+	do(sub, "PSUBSCRIBE", "__keyevent@0__:*")
+	written(t, sub)
+
+	for _, args := range [][]string{
+		{"SET", "a", "1"},
+		{"SET", "b", "2", "EX", "100"},
+		{"MSET", "c", "3"},
+		{"EXPIRE", "a", "100"},
+		{"PERSIST", "a"},
+		{"DEL", "a"},
+		{"DEL", "a"},
+		{"UNLINK", "b"},
+		{"GETDEL", "c"},
+		{"GET", "c"},
+	} {
+		do(c, args...)
+	}
+	p := "__keyevent@0__:*"
+	expectWritten(t, sub,
+		keyEvent(p, "__keyevent@0__:set", "a"),
+		keyEvent(p, "__keyevent@0__:set", "b"),
+		keyEvent(p, "__keyevent@0__:expire", "b"),
+		keyEvent(p, "__keyevent@0__:set", "c"),
+		keyEvent(p, "__keyevent@0__:expire", "a"),
+		keyEvent(p, "__keyevent@0__:persist", "a"),
+		keyEvent(p, "__keyevent@0__:del", "a"),
+		keyEvent(p, "__keyevent@0__:del", "b"),
+		keyEvent(p, "__keyevent@0__:del", "c"),
+	)
+
+	// Keys removed by the janitor once their TTL passes are reported as
+	// expired, on the keyspace channel too.
+	do(sub, "PSUBSCRIBE", "__keyspace@0__:*")
+	written(t, sub)
+	do(c, "SET", "k", "v", "PX", "1")
+	written(t, sub)
+	time.Sleep(5 * time.Millisecond)
+	c.store.cleanup()
+	expectWritten(t, sub,
+		keyEvent("__keyspace@0__:*", "__keyspace@0__:k", "expired"),
+		keyEvent(p, "__keyevent@0__:expired", "k"),
+	)
+}
+
+func TestKeyspaceNotificationClasses(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.NotifyKeyspaceEvents, _ = parseNotifyKeyspaceEvents("Kg")
+	c := newClient(nil, NewServer(cfg))
+	sub := newClient(nil, c.srv)
+	do(sub, "PSUBSCRIBE", "__key*")
+	written(t, sub)
+
+	// Only generic events, and only on keyspace channels.
+	do(c, "SET", "a", "1")
+	do(c, "DEL", "a")
+	expectWritten(t, sub, keyEvent("__key*", "__keyspace@0__:a", "del"))
+
+	// Notifications are off by default.
+	c = newTestClient()
+	sub = newClient(nil, c.srv)
+	do(sub, "PSUBSCRIBE", "*")
+	written(t, sub)
+	do(c, "SET", "a", "1")
+	expectWritten(t, sub)
+}
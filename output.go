@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"go-http-practice/resp"
+)
+
+// output is the outgoing side of a connection. Replies written by the
+// connection's own goroutine and messages pushed to it by other clients,
+// such as pub/sub messages, share one buffer, so the client sees them in
+// the order they were produced. A writer goroutine started with run drains
+// the buffer onto the connection; until then, as for clients without a
+// connection, it only grows.
+type output struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  []byte
+	// proto is the protocol version of the last reply, used for pushes.
+	proto int
+	// limit caps the bytes pushes may leave waiting to be written; 0 means
+	// no limit.
+	limit int
+	// flushing asks the writer to write out the buffer.
+	flushing bool
+	closed   bool
+	// err is set once writing has failed or the limit was exceeded. Nothing
+	// more is buffered after that.
+	err  error
+	done chan struct{}
+}
+
+var errOutputLimit = errors.New("output buffer limit exceeded")
+
+func newOutput(limit int) *output {
+	o := &output{proto: 2, limit: limit, done: make(chan struct{})}
+	o.cond.L = &o.mu
+	return o
+}
+
+// write buffers v as a reply in protocol version proto. It is only sent
+// once flush is called.
+func (o *output) write(v resp.Value, proto int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.proto = proto
+	if o.err == nil && !o.closed {
+		o.buf = resp.AppendValue(o.buf, v, proto)
+	}
+}
+
+// flush asks the writer to send everything buffered so far. It returns the
+// error that stopped the writer, if any.
+func (o *output) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flushing = true
+	o.cond.Signal()
+	return o.err
+}
+
+// push buffers v in the protocol version of the client's replies and has it
+// sent right away. Past the limit the connection is given up on instead:
+// push reports false and the writer closes it.
+func (o *output) push(v resp.Value) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil || o.closed {
+		return false
+	}
+	o.buf = resp.AppendValue(o.buf, v, o.proto)
+	if o.limit > 0 && len(o.buf) > o.limit {
+		o.buf, o.err = nil, errOutputLimit
+	}
+	o.flushing = true
+	o.cond.Signal()
+	return o.err == nil
+}
+
+// send buffers data, already encoded, and has it sent right away. Unlike
+// push, it is not subject to the limit: it is how a master streams the
+// dataset and the writes to a replica, which must not miss any.
+func (o *output) send(data []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil || o.closed {
+		return false
+	}
+	o.buf = append(o.buf, data...)
+	o.flushing = true
+	o.cond.Signal()
+	return true
+}
+
+// protocol returns the protocol version of the client's replies, which
+// pushes are sent in.
+func (o *output) protocol() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.proto
+}
+
+// run writes the buffer to w whenever it is flushed, until close is called
+// and everything left has been written. If writing fails or the limit is
+// exceeded it closes w and returns early.
+func (o *output) run(w io.WriteCloser) {
+	defer close(o.done)
+	var spare []byte
+	for {
+		o.mu.Lock()
+		for !o.flushing && !o.closed && o.err == nil {
+			o.cond.Wait()
+		}
+		if o.err != nil || (o.closed && len(o.buf) == 0) {
+			failed := o.err != nil
+			o.mu.Unlock()
+			if failed {
+				w.Close()
+			}
+			return
+		}
+		data := o.buf
+		o.buf, spare = spare[:0], nil
+		o.flushing = false
+		o.mu.Unlock()
+
+		if _, err := w.Write(data); err != nil {
+			o.mu.Lock()
+			o.err = err
+			o.mu.Unlock()
+			w.Close()
+			return
+		}
+		spare = data
+	}
+}
+
+// close stops buffering and waits for the writer started with run to write
+// what is left.
+func (o *output) close() {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Signal()
+	o.mu.Unlock()
+	<-o.done
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"go-http-practice/resp"
+)
+
+// pubsub is the registry of pub/sub subscriptions. Publishing pushes a
+// message onto the output of every client subscribed to the channel or to
+// a glob-style pattern matching it, from the publisher's goroutine; the
+// subscribers' writers send it on. Subscribing confirms each subscription
+// on the client's output under the same lock publishing takes, so a
+// subscriber never sees a message before the confirmation of its
+// subscription.
+//
+// Shard channels, used by cluster-aware clients, live in a namespace of
+// their own: SPUBLISH only reaches SSUBSCRIBE subscribers, and the other
+// way round. With a single node every shard channel is served here.
+type pubsub struct {
+	mu sync.RWMutex
+	// subs holds the subscribers of each channel or pattern, by kind.
+	subs [numSubKinds]map[string]map[*Client]struct{}
+}
+
+// subKind is a family of subscriptions.
+type subKind int
+
+const (
+	channelSub subKind = iota
+	patternSub
+	shardSub
+	numSubKinds
+)
+
+// subscribeNames and unsubscribeNames name the confirmations of each kind
+// of subscription.
+var (
+	subscribeNames   = [numSubKinds]string{"subscribe", "psubscribe", "ssubscribe"}
+	unsubscribeNames = [numSubKinds]string{"unsubscribe", "punsubscribe", "sunsubscribe"}
+)
+
+func newPubsub() *pubsub {
+	ps := &pubsub{}
+	for i := range ps.subs {
+		ps.subs[i] = make(map[string]map[*Client]struct{})
+	}
+	return ps
+}
+
+// allowedWhileSubscribed lists the commands a RESP2 client can run while
+// it has subscriptions.
+var allowedWhileSubscribed = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// subscriptions returns the number of subscriptions c holds, of any kind.
+func (c *Client) subscriptions() int {
+	var n int
+	for _, mine := range c.subscribed {
+		n += len(mine)
+	}
+	return n
+}
+
+// subscriptionCount returns the count reported when confirming a
+// subscription of kind: the channel and pattern subscriptions of c
+// together, or its shard channel subscriptions.
+func (c *Client) subscriptionCount(kind subKind) int {
+	if kind == shardSub {
+		return len(c.subscribed[shardSub])
+	}
+	return len(c.subscribed[channelSub]) + len(c.subscribed[patternSub])
+}
+
+// subscribe subscribes c to names, channels or patterns depending on kind,
+// writing a confirmation with the new subscription count for each.
+func (ps *pubsub) subscribe(c *Client, names []string, kind subKind) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	subs, mine := ps.subs[kind], c.subscribed[kind]
+	for _, name := range names {
+		if _, ok := mine[name]; !ok {
+			if mine == nil {
+				mine = make(map[string]struct{})
+				c.subscribed[kind] = mine
+			}
+			mine[name] = struct{}{}
+			if subs[name] == nil {
+				subs[name] = make(map[*Client]struct{})
+			}
+			subs[name][c] = struct{}{}
+		}
+		c.out.write(pubsubReply(subscribeNames[kind], name, c.subscriptionCount(kind)), c.proto)
+	}
+}
+
+// unsubscribe removes the subscriptions of c of kind to names, or all of
+// them if there are no names, writing a confirmation for each. When there
+// is nothing to unsubscribe from a single confirmation with a nil name is
+// written.
+func (ps *pubsub) unsubscribe(c *Client, names []string, kind subKind) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	subs, mine := ps.subs[kind], c.subscribed[kind]
+	if len(names) == 0 {
+		if len(mine) == 0 {
+			c.out.write(resp.Push(resp.BulkString(unsubscribeNames[kind]), resp.NullBulk, resp.Integer(int64(c.subscriptionCount(kind)))), c.proto)
+			return
+		}
+		for name := range mine {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		removeSubscription(subs, mine, c, name)
+		c.out.write(pubsubReply(unsubscribeNames[kind], name, c.subscriptionCount(kind)), c.proto)
+	}
+}
+
+// removeSubscription drops the subscription of c to name from subs and
+// mine, if it has one.
+func removeSubscription(subs map[string]map[*Client]struct{}, mine map[string]struct{}, c *Client, name string) {
+	if _, ok := mine[name]; !ok {
+		return
+	}
+	delete(mine, name)
+	delete(subs[name], c)
+	if len(subs[name]) == 0 {
+		delete(subs, name)
+	}
+}
+
+// removeClient drops every subscription of c, without confirming them, for
+// a client that has gone away or was RESET.
+func (ps *pubsub) removeClient(c *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for kind, mine := range c.subscribed {
+		for name := range mine {
+			removeSubscription(ps.subs[kind], mine, c, name)
+		}
+	}
+}
+
+// publish sends message to the subscribers of channel and of the patterns
+// matching it, and returns how many messages were delivered: a client
+// subscribed several ways receives, and counts, one for each.
+func (ps *pubsub) publish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	msg := resp.Push(resp.BulkString("message"), resp.BulkString(channel), resp.BulkString(message))
+	n := pushAll(ps.subs[channelSub][channel], msg)
+	for pattern, subs := range ps.subs[patternSub] {
+		if matchPattern(pattern, channel, false) {
+			msg := resp.Push(resp.BulkString("pmessage"), resp.BulkString(pattern), resp.BulkString(channel), resp.BulkString(message))
+			n += pushAll(subs, msg)
+		}
+	}
+	return n
+}
+
+// spublish sends message to the subscribers of the shard channel and
+// returns how many received it.
+func (ps *pubsub) spublish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	msg := resp.Push(resp.BulkString("smessage"), resp.BulkString(channel), resp.BulkString(message))
+	return pushAll(ps.subs[shardSub][channel], msg)
+}
+
+// pushAll pushes msg to the clients in subs and returns how many took it.
+func pushAll(subs map[*Client]struct{}, msg resp.Value) int {
+	var n int
+	for c := range subs {
+		if c.out.push(msg) {
+			n++
+		}
+	}
+	return n
+}
+
+// activeChannels returns, sorted, the channels, or shard channels, with
+// subscribers whose names match pattern.
+func (ps *pubsub) activeChannels(pattern string, kind subKind) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	var names []string
+	for name := range ps.subs[kind] {
+		if matchPattern(pattern, name, false) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// numSub returns the number of subscribers of channel, or of the shard
+// channel, not counting those subscribed through patterns.
+func (ps *pubsub) numSub(channel string, kind subKind) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.subs[kind][channel])
+}
+
+// isSubscriber reports whether c holds any subscription. Unlike
+// subscriptions it takes the lock, for use from other clients' goroutines.
+func (ps *pubsub) isSubscriber(c *Client) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return c.subscriptions() > 0
+}
+
+// numPat returns the number of patterns clients are subscribed to.
+func (ps *pubsub) numPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.subs[patternSub])
+}
+
+// pubsubReply builds the confirmation of a (un)subscription.
+func pubsubReply(kind, name string, count int) resp.Value {
+	return resp.Push(resp.BulkString(kind), resp.BulkString(name), resp.Integer(int64(count)))
+}
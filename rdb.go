@@ -0,0 +1,1125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file reads and writes snapshots of the store, in the layout of
+// Redis' RDB files: a magic string and version, auxiliary fields, the
+// function libraries, then every key with its absolute expiry time, type
+// and value, and finally an EOF marker and a CRC-64 checksum of everything
+// before it, zero when it was not computed. Strings, lists,
+// sets, hashes and sorted sets use the Redis encodings, so the files read
+// like the ones Redis writes; streams, hashes with field TTLs and values
+// of registered types have encodings of their own, under type codes Redis
+// does not use. The compact encodings Redis writes for small values are
+// read as well, so a dump.rdb from Redis can be loaded; they are decoded in
+// rdb_encodings.go.
+
+// rdbVersion is the RDB format version written after the magic string.
+const rdbVersion = 11
+
+// rdbMaxVersion is the newest RDB format version read, that of Redis 7.4.
+// What it adds to version 11 is read as far as it is supported.
+const rdbMaxVersion = 12
+
+// Opcodes marking the records of a snapshot that are not keys.
+const (
+	rdbOpSlotInfo     = 244
+	rdbOpFunction     = 245
+	rdbOpModuleAux    = 247
+	rdbOpIdle         = 248
+	rdbOpFreq         = 249
+	rdbOpAux          = 250
+	rdbOpResizeDB     = 251
+	rdbOpExpireTimeMS = 252
+	rdbOpExpireTime   = 253
+	rdbOpSelectDB     = 254
+	rdbOpEOF          = 255
+)
+
+// Value type codes: the Redis ones, then those private to this server.
+const (
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeHash   = 4
+	rdbTypeZset2  = 5
+
+	rdbTypeStream       = 200
+	rdbTypeHashFieldTTL = 201
+	rdbTypeModule       = 202
+)
+
+// Length encodings: the two top bits of the first byte tell how many bytes
+// the length takes, or that a string is stored in a special encoding.
+const (
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdbEncVal   = 3
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+)
+
+var errBadSnapshot = errors.New("bad snapshot file")
+
+// rdbWriter encodes a snapshot. Writes are buffered; the first error is
+// kept and returned by flush, so the encoders need not check each one.
+// With compress, strings longer than 20 bytes are written LZF-compressed
+// when that saves space. crc is the checksum of what was written.
+type rdbWriter struct {
+	w        *bufio.Writer
+	err      error
+	compress bool
+	crc      uint64
+}
+
+func (w *rdbWriter) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+		w.crc = crc64Jones(w.crc, p)
+	}
+}
+
+func (w *rdbWriter) byte(b byte) {
+	w.write([]byte{b})
+}
+
+// length writes n in the smallest length encoding that holds it.
+func (w *rdbWriter) length(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.byte(byte(n))
+	case n < 1<<14:
+		w.write([]byte{byte(n>>8) | rdb14BitLen<<6, byte(n)})
+	case n <= math.MaxUint32:
+		w.byte(rdb32BitLen)
+		w.write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		w.byte(rdb64BitLen)
+		w.write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func (w *rdbWriter) string(s string) {
+	w.bytes([]byte(s))
+}
+
+func (w *rdbWriter) bytes(b []byte) {
+	if w.compress && len(b) > 20 {
+		// As in Redis, compression must save at least 4 bytes.
+		if c := lzfCompress(b, len(b)-4); c != nil {
+			w.byte(rdbEncVal<<6 | rdbEncLZF)
+			w.length(uint64(len(c)))
+			w.length(uint64(len(b)))
+			w.write(c)
+			return
+		}
+	}
+	w.length(uint64(len(b)))
+	w.write(b)
+}
+
+// float writes f as a little-endian IEEE 754 double, as zset2 does.
+func (w *rdbWriter) float(f float64) {
+	w.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+// millis writes t as a length-encoded Unix time in milliseconds, 0 for
+// the zero time.
+func (w *rdbWriter) millis(t time.Time) {
+	if t.IsZero() {
+		w.length(0)
+		return
+	}
+	w.length(uint64(t.UnixMilli()))
+}
+
+func (w *rdbWriter) streamID(id streamID) {
+	w.length(id.ms)
+	w.length(id.seq)
+}
+
+func (w *rdbWriter) aux(key, value string) {
+	w.byte(rdbOpAux)
+	w.string(key)
+	w.string(value)
+}
+
+// snapshot is what a snapshot file holds: every key and the code of every
+// function library.
+type snapshot struct {
+	data      map[string]StoreData
+	libraries []string
+	// view, when set, holds the keys in place of data. See view.
+	view *view
+	// keys and expires count the keys, and those with a TTL.
+	keys, expires int
+	// dirty is the store's count of changes when the snapshot was taken.
+	dirty int64
+	// compress and checksum have the snapshot written with compressed
+	// strings and a checksum, as set by rdbcompression and rdbchecksum.
+	compress, checksum bool
+	// processed counts the keys written so far, for INFO.
+	processed atomic.Int64
+}
+
+// snapshot returns the content of the store. With background it is a view
+// that stays consistent once the lock is released and writes resume, until
+// it is closed; otherwise the values are shared and the caller must keep
+// holding the lock while the snapshot is in use. The caller must hold mu
+// for reading at least.
+func (s *Store) snapshot(background bool) *snapshot {
+	snap := &snapshot{data: s.data, keys: len(s.data), expires: len(s.expires), dirty: s.dirty}
+	if background {
+		snap.data, snap.view = nil, s.newView()
+	}
+	for _, lib := range s.functions.libraries {
+		snap.libraries = append(snap.libraries, lib.code)
+	}
+	return snap
+}
+
+// each calls fn with every key of the snapshot and its value, stopping at
+// the first error.
+func (snap *snapshot) each(fn func(key string, d StoreData) error) error {
+	if snap.view != nil {
+		for _, key := range snap.view.keys {
+			if err := fn(key, snap.view.get(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for key, d := range snap.data {
+		if err := fn(key, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close stops keeping a background snapshot consistent, once it has been
+// written or abandoned.
+func (snap *snapshot) close() {
+	if snap.view != nil {
+		snap.view.close()
+	}
+}
+
+// view is a point-in-time view of a store that stays consistent while
+// writes go on, so a background save does not have to copy the dataset
+// upfront nor hold the lock while it writes. Instead, the first time a key
+// that has not been written yet is looked up, stored or removed, its value
+// is copied aside. Lookups count as well as writes because command
+// handlers change values in place after looking them up, and nothing tells
+// a lookup made to write apart from one made to read. Each value is copied
+// at most once, and writes only wait for the copy of the key they touch.
+type view struct {
+	store *Store
+	// keys lists the keys when the view was taken, in the order they are
+	// written.
+	keys []string
+
+	mu sync.Mutex
+	// pending holds the keys that were neither written nor copied yet, so
+	// their value in the store is still the one they had.
+	pending map[string]struct{}
+	// saved holds the copies made of the others, until they are written.
+	saved map[string]StoreData
+}
+
+// newView takes a view of the store and has put, remove, flush and lookup
+// keep it consistent until it is closed. The caller must hold mu for
+// reading at least.
+func (s *Store) newView() *view {
+	v := &view{
+		store:   s,
+		keys:    make([]string, 0, len(s.data)),
+		pending: make(map[string]struct{}, len(s.data)),
+		saved:   make(map[string]StoreData),
+	}
+	for key := range s.data {
+		v.keys = append(v.keys, key)
+		v.pending[key] = struct{}{}
+	}
+	s.viewsMu.Lock()
+	defer s.viewsMu.Unlock()
+	views := []*view{v}
+	if old := s.views.Load(); old != nil {
+		views = append(views, *old...)
+	}
+	s.views.Store(&views)
+	return v
+}
+
+// close unregisters the view from its store.
+func (v *view) close() {
+	s := v.store
+	s.viewsMu.Lock()
+	defer s.viewsMu.Unlock()
+	old := *s.views.Load()
+	views := slices.DeleteFunc(slices.Clone(old), func(o *view) bool { return o == v })
+	if len(views) == 0 {
+		s.views.Store(nil)
+		return
+	}
+	s.views.Store(&views)
+}
+
+// preserve copies the value at key aside for every open view that has not
+// written it yet, before it is changed or handed to a command. The caller
+// must hold mu for reading at least.
+func (s *Store) preserve(key string) {
+	views := s.views.Load()
+	if views == nil {
+		return
+	}
+	for _, v := range *views {
+		v.mu.Lock()
+		if _, ok := v.pending[key]; ok {
+			delete(v.pending, key)
+			v.saved[key] = s.data[key].clone()
+		}
+		v.mu.Unlock()
+	}
+}
+
+// preserveAll is preserve for every key, before the store is flushed. The
+// caller must hold mu for writing.
+func (s *Store) preserveAll() {
+	views := s.views.Load()
+	if views == nil {
+		return
+	}
+	for _, v := range *views {
+		v.mu.Lock()
+		for key := range v.pending {
+			v.saved[key] = s.data[key].clone()
+		}
+		clear(v.pending)
+		v.mu.Unlock()
+	}
+}
+
+// get returns a copy of the value key had when the view was taken, to be
+// written. It takes the store's mu for reading, in case the value is still
+// in the store, and is called once per key.
+func (v *view) get(key string) StoreData {
+	v.store.mu.RLock()
+	defer v.store.mu.RUnlock()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if d, ok := v.saved[key]; ok {
+		delete(v.saved, key)
+		return d
+	}
+	delete(v.pending, key)
+	return v.store.data[key].clone()
+}
+
+// write encodes the snapshot to out.
+func (snap *snapshot) write(out io.Writer) error {
+	w := &rdbWriter{w: bufio.NewWriter(out), compress: snap.compress}
+	w.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+	w.aux("redis-ver", serverVersion)
+	w.aux("redis-bits", strconv.Itoa(strconv.IntSize))
+	w.aux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	for _, code := range snap.libraries {
+		w.byte(rdbOpFunction)
+		w.string(code)
+	}
+
+	w.byte(rdbOpSelectDB)
+	w.length(0)
+	w.byte(rdbOpResizeDB)
+	w.length(uint64(snap.keys))
+	w.length(uint64(snap.expires))
+	err := snap.each(func(key string, d StoreData) error {
+		if mv, ok := d.value.(*moduleValue); ok && mv.typ.Save == nil {
+			return fmt.Errorf("value of type %s at key %q cannot be saved", mv.typ.Name, key)
+		}
+		if !d.expiresAt.IsZero() {
+			w.byte(rdbOpExpireTimeMS)
+			w.write(binary.LittleEndian.AppendUint64(nil, uint64(d.expiresAt.UnixMilli())))
+		}
+		w.byte(valueType(d.value))
+		w.string(key)
+		w.value(d.value)
+		snap.processed.Add(1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.byte(rdbOpEOF)
+	// A checksum left zero tells readers not to verify it.
+	var sum uint64
+	if snap.checksum {
+		sum = w.crc
+	}
+	w.write(binary.LittleEndian.AppendUint64(nil, sum))
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// valueType returns the type code v is encoded under.
+func valueType(v any) byte {
+	switch v := v.(type) {
+	case *listValue:
+		return rdbTypeList
+	case *setValue:
+		return rdbTypeSet
+	case *hashValue:
+		if len(v.expires) > 0 {
+			return rdbTypeHashFieldTTL
+		}
+		return rdbTypeHash
+	case *zsetValue:
+		return rdbTypeZset2
+	case *streamValue:
+		return rdbTypeStream
+	case *moduleValue:
+		return rdbTypeModule
+	}
+	return rdbTypeString
+}
+
+// value writes the encoding of v, under the type code valueType gives.
+func (w *rdbWriter) value(v any) {
+	switch v := v.(type) {
+	case []byte:
+		w.bytes(v)
+	case *listValue:
+		w.length(uint64(v.Len()))
+		for i := range v.Len() {
+			w.string(v.At(i))
+		}
+	case *setValue:
+		w.length(uint64(v.size()))
+		for m := range v.members {
+			w.string(m)
+		}
+	case *hashValue:
+		// With field TTLs, each field is preceded by its deadline, 0 if it
+		// has none.
+		w.length(uint64(len(v.fields)))
+		for field, val := range v.fields {
+			if len(v.expires) > 0 {
+				w.millis(v.expires[field])
+			}
+			w.string(field)
+			w.string(val)
+		}
+	case *zsetValue:
+		w.length(uint64(v.size()))
+		for x := v.zsl.first(); x != nil; x = x.next() {
+			w.string(x.member)
+			w.float(x.score)
+		}
+	case *streamValue:
+		w.stream(v)
+	case *moduleValue:
+		w.string(v.typ.Name)
+		w.bytes(v.typ.Save(v.v))
+	}
+}
+
+// stream writes the entries of st, its last ID and its consumer groups,
+// each with its PEL and consumers. A consumer's pending entries are
+// written as IDs into the group's PEL.
+func (w *rdbWriter) stream(st *streamValue) {
+	w.length(uint64(len(st.entries)))
+	for _, e := range st.entries {
+		w.streamID(e.id)
+		w.length(uint64(len(e.fields)))
+		for _, f := range e.fields {
+			w.string(f)
+		}
+	}
+	w.streamID(st.lastID)
+
+	w.length(uint64(len(st.groups)))
+	for name, g := range st.groups {
+		w.string(name)
+		w.streamID(g.lastID)
+		w.length(uint64(len(g.pel)))
+		for id, p := range g.pel {
+			w.streamID(id)
+			w.millis(p.deliveredAt)
+			w.length(uint64(p.deliveries))
+		}
+		w.length(uint64(len(g.consumers)))
+		for cname, cons := range g.consumers {
+			w.string(cname)
+			w.millis(cons.seenAt)
+			w.length(uint64(len(cons.pending)))
+			for id := range cons.pending {
+				w.streamID(id)
+			}
+		}
+	}
+}
+
+// rdbReader decodes a snapshot. The first error is kept and every read
+// after it returns zero values, so the decoders check err once per record.
+// crc is the checksum of what was read.
+type rdbReader struct {
+	r   *bufio.Reader
+	err error
+	crc uint64
+}
+
+func (r *rdbReader) fail(err error) {
+	if r.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+	}
+}
+
+func (r *rdbReader) read(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	// Grow the buffer as the data comes in, so a corrupt length cannot
+	// make it allocate more than the file holds.
+	buf := make([]byte, 0, min(n, 1<<16))
+	for uint64(len(buf)) < n {
+		chunk := min(n-uint64(len(buf)), 1<<16)
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if _, err := io.ReadFull(r.r, buf[start:]); err != nil {
+			r.fail(err)
+			return nil
+		}
+		r.crc = crc64Jones(r.crc, buf[start:])
+	}
+	return buf
+}
+
+func (r *rdbReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.fail(err)
+	}
+	r.crc = crc64Jones(r.crc, []byte{b})
+	return b
+}
+
+// lengthOrEncoding reads a length or, with encoded set, the code of the
+// special encoding of the string that follows.
+func (r *rdbReader) lengthOrEncoding() (n uint64, encoded bool) {
+	b := r.byte()
+	switch {
+	case b>>6 == rdb6BitLen:
+		return uint64(b & 0x3f), false
+	case b>>6 == rdb14BitLen:
+		return uint64(b&0x3f)<<8 | uint64(r.byte()), false
+	case b>>6 == rdbEncVal:
+		return uint64(b & 0x3f), true
+	case b == rdb32BitLen:
+		if p := r.read(4); p != nil {
+			return uint64(binary.BigEndian.Uint32(p)), false
+		}
+	case b == rdb64BitLen:
+		if p := r.read(8); p != nil {
+			return binary.BigEndian.Uint64(p), false
+		}
+	default:
+		r.fail(errBadSnapshot)
+	}
+	return 0, false
+}
+
+func (r *rdbReader) length() uint64 {
+	n, encoded := r.lengthOrEncoding()
+	if encoded {
+		r.fail(errBadSnapshot)
+	}
+	return n
+}
+
+func (r *rdbReader) bytes() []byte {
+	n, encoded := r.lengthOrEncoding()
+	if encoded {
+		return r.encodedString(n)
+	}
+	return r.read(n)
+}
+
+func (r *rdbReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *rdbReader) float() float64 {
+	if p := r.read(8); p != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (r *rdbReader) millis() time.Time {
+	if ms := r.length(); ms != 0 {
+		return time.UnixMilli(int64(ms))
+	}
+	return time.Time{}
+}
+
+func (r *rdbReader) streamID() streamID {
+	return streamID{ms: r.length(), seq: r.length()}
+}
+
+// readSnapshot loads a snapshot written by snapshot.write or by Redis into
+// the store, adding its keys and function libraries to those already
+// there, except for the keys that have expired, unless replaying (see
+// Store.replaying), and those of databases other than 0, as there is just
+// the one here. A snapshot whose checksum does not match is an error,
+// though its keys are loaded by then. Given a *bufio.Reader, it reads no
+// further than the snapshot's end, so what follows can be read from it.
+// The caller must hold mu for writing.
+func (s *Store) readSnapshot(in io.Reader) error {
+	r := &rdbReader{r: bufio.NewReader(in)}
+	magic := r.read(9)
+	if r.err != nil || string(magic[:5]) != "REDIS" {
+		return errBadSnapshot
+	}
+	if v, err := strconv.Atoi(string(magic[5:])); err != nil || v > rdbMaxVersion {
+		return fmt.Errorf("can't handle RDB format version %s", magic[5:])
+	}
+
+	var (
+		db        uint64
+		skipped   int
+		expiresAt time.Time
+		idle      time.Duration = -1
+	)
+	for {
+		op := r.byte()
+		if r.err != nil {
+			return r.err
+		}
+		switch op {
+		case rdbOpEOF:
+			want := r.crc
+			if p := r.read(8); p != nil {
+				if sum := binary.LittleEndian.Uint64(p); sum != 0 && sum != want {
+					return fmt.Errorf("wrong RDB checksum %016x, expected %016x", sum, want)
+				}
+			}
+			if skipped > 0 && r.err == nil {
+				log.Printf("Skipped %d keys of databases other than 0", skipped)
+			}
+			return r.err
+		case rdbOpAux:
+			r.string()
+			r.string()
+		case rdbOpFunction:
+			if _, err := s.functions.load(r.string(), true); r.err == nil && err != nil {
+				return fmt.Errorf("loading function library: %w", err)
+			}
+		case rdbOpModuleAux:
+			return errors.New("can't load module data")
+		case rdbOpSelectDB:
+			db = r.length()
+		case rdbOpResizeDB:
+			r.length()
+			r.length()
+		case rdbOpSlotInfo:
+			r.length()
+			r.length()
+			r.length()
+		case rdbOpExpireTimeMS:
+			if p := r.read(8); p != nil {
+				expiresAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(p)))
+			}
+		case rdbOpExpireTime:
+			if p := r.read(4); p != nil {
+				expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(p)), 0)
+			}
+		case rdbOpIdle:
+			idle = time.Duration(r.length()) * time.Second
+		case rdbOpFreq:
+			// There are no access frequencies to restore.
+			r.byte()
+		default:
+			key := r.string()
+			v, err := r.value(op)
+			if err == nil {
+				err = r.err
+			}
+			if err != nil {
+				return err
+			}
+			d, keyIdle := StoreData{value: v, expiresAt: expiresAt}, idle
+			expiresAt, idle = time.Time{}, -1
+			if db != 0 {
+				skipped++
+				continue
+			}
+			// Keys and hash fields whose deadline passed while the server
+			// was down are dropped rather than loaded, except for keys
+			// when replaying, as the writes that follow expect them.
+			now := time.Now()
+			if h, ok := v.(*hashValue); ok {
+				h.purge(now)
+				if len(h.fields) == 0 {
+					continue
+				}
+			}
+			if !d.expired(now) || s.loading {
+				s.put(key, d)
+				if keyIdle >= 0 {
+					// put stamps the access time; the idle time saved
+					// with the key overrides it.
+					d = s.data[key]
+					d.accessedAt = now.Add(-keyIdle)
+					s.data[key] = d
+				}
+			}
+		}
+	}
+}
+
+// value reads a value of type code typ.
+func (r *rdbReader) value(typ byte) (any, error) {
+	switch typ {
+	case rdbTypeString:
+		return r.bytes(), nil
+	case rdbTypeList:
+		l := &listValue{}
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			l.PushBack(r.string())
+		}
+		return l, nil
+	case rdbTypeSet:
+		set := newSet()
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			set.members[r.string()] = struct{}{}
+		}
+		return set, nil
+	case rdbTypeHash, rdbTypeHashFieldTTL:
+		h := newHash()
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			var at time.Time
+			if typ == rdbTypeHashFieldTTL {
+				at = r.millis()
+			}
+			field := r.string()
+			h.fields[field] = r.string()
+			if !at.IsZero() {
+				h.setFieldExpire(field, at)
+			}
+		}
+		return h, nil
+	case rdbTypeZset2:
+		z := newZset()
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			member := r.string()
+			z.add(member, r.float())
+		}
+		return z, nil
+	case rdbTypeStream:
+		return r.stream(), nil
+	case rdbTypeModule:
+		name := r.string()
+		data := r.bytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		t := typeTable[name]
+		if t == nil || t.Load == nil {
+			return nil, fmt.Errorf("can't load value of unknown type %s", name)
+		}
+		v, err := t.Load(data)
+		if err != nil {
+			return nil, fmt.Errorf("loading value of type %s: %w", name, err)
+		}
+		return &moduleValue{typ: t, v: v}, nil
+	}
+	return r.redisValue(typ)
+}
+
+// stream reads a stream written by rdbWriter.stream.
+func (r *rdbReader) stream() *streamValue {
+	st := newStream()
+	for n := r.length(); n > 0 && r.err == nil; n-- {
+		id := r.streamID()
+		var fields []string
+		for m := r.length(); m > 0 && r.err == nil; m-- {
+			fields = append(fields, r.string())
+		}
+		st.entries = append(st.entries, streamEntry{id: id, fields: fields})
+	}
+	st.lastID = r.streamID()
+
+	for n := r.length(); n > 0 && r.err == nil; n-- {
+		if st.groups == nil {
+			st.groups = make(map[string]*streamGroup)
+		}
+		name := r.string()
+		g := newStreamGroup(r.streamID())
+		for m := r.length(); m > 0 && r.err == nil; m-- {
+			id := r.streamID()
+			g.pel[id] = &pendingEntry{id: id, deliveredAt: r.millis(), deliveries: int64(r.length())}
+		}
+		for m := r.length(); m > 0 && r.err == nil; m-- {
+			cons, _ := g.consumer(r.string(), time.Time{})
+			cons.seenAt = r.millis()
+			for k := r.length(); k > 0 && r.err == nil; k-- {
+				p := g.pel[r.streamID()]
+				if p == nil {
+					r.fail(errBadSnapshot)
+					break
+				}
+				p.consumer = cons
+				cons.pending[p.id] = p
+			}
+		}
+		st.groups[name] = g
+	}
+	return st
+}
+
+// crcTable is for CRC-64/Jones, the checksum Redis uses in DUMP payloads,
+// in its reflected form.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// crc64Jones adds p to the checksum crc. Go's crc64 complements the sum
+// before and after each update, which CRC-64/Jones does not, hence the
+// complements here.
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+var (
+	errBadDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+	errBadDataFormat  = errors.New("ERR Bad data format")
+)
+
+// dumpValue serializes v for DUMP, as Redis does: its type code and
+// encoding from a snapshot, then the RDB version in two bytes and a
+// checksum of the lot in eight, both little-endian.
+func dumpValue(v any) ([]byte, error) {
+	if mv, ok := v.(*moduleValue); ok && mv.typ.Save == nil {
+		return nil, fmt.Errorf("ERR value of type %s cannot be dumped", mv.typ.Name)
+	}
+	var buf bytes.Buffer
+	w := &rdbWriter{w: bufio.NewWriter(&buf)}
+	w.byte(valueType(v))
+	w.value(v)
+	w.write(binary.LittleEndian.AppendUint16(nil, rdbVersion))
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+	payload := buf.Bytes()
+	return binary.LittleEndian.AppendUint64(payload, crc64Jones(0, payload)), nil
+}
+
+// restoreValue decodes a payload written by dumpValue or by Redis' DUMP,
+// for RESTORE. It fails with errBadDumpPayload if the payload's version is
+// newer than rdbMaxVersion or its checksum does not match, and errBadDataFormat if
+// it does not decode to a value.
+func restoreValue(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errBadDumpPayload
+	}
+	body, footer := payload[:len(payload)-8], payload[len(payload)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbMaxVersion || binary.LittleEndian.Uint64(footer[2:]) != crc64Jones(0, body) {
+		return nil, errBadDumpPayload
+	}
+	in := bytes.NewReader(body[:len(body)-2])
+	r := &rdbReader{r: bufio.NewReader(in)}
+	v, err := r.value(r.byte())
+	if err != nil || r.err != nil || r.r.Buffered() > 0 || in.Len() > 0 {
+		return nil, errBadDataFormat
+	}
+	return v, nil
+}
+
+// save writes snap to the dbfilename, through a temporary file renamed
+// over it once complete, so a crash while saving leaves the previous
+// snapshot intact.
+func (srv *Server) save(snap *snapshot) error {
+	path := srv.cfg.dbPath()
+	f, err := os.CreateTemp(srv.cfg.Dir, "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	snap.compress, snap.checksum = srv.cfg.RDBCompression, srv.cfg.RDBChecksum
+	if err := snap.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+var errBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+// rdbState tracks the snapshots the server takes, for INFO.
+type rdbState struct {
+	mu sync.Mutex
+	// bgsave is the snapshot being written by BGSAVE, nil if none is,
+	// and bgsaveStart when it started.
+	bgsave      *snapshot
+	bgsaveStart time.Time
+	// lastBgsaveErr and lastBgsaveTime are the outcome and duration of
+	// the last BGSAVE, -1 before the first, and lastBgsaveTry when it
+	// started.
+	lastBgsaveErr  error
+	lastBgsaveTime time.Duration
+	lastBgsaveTry  time.Time
+	// lastSave is when the last successful save, by SAVE or BGSAVE, was
+	// started, or when the server started before the first, and
+	// savedDirty the store's count of changes it held. saves counts them.
+	lastSave   time.Time
+	savedDirty int64
+	saves      int64
+	// done is released when the running BGSAVE finishes.
+	done sync.WaitGroup
+}
+
+func newRDBState() *rdbState {
+	return &rdbState{lastBgsaveTime: -1, lastSave: time.Now()}
+}
+
+// saved records the successful save of snap, started at start. The caller
+// must hold st.mu.
+func (st *rdbState) saved(snap *snapshot, start time.Time) {
+	st.lastSave, st.savedDirty = start, snap.dirty
+	st.saves++
+}
+
+// saveSync writes a snapshot of the store in the foreground, for SAVE. It
+// fails while a BGSAVE runs. The caller must hold the store's mu for
+// reading at least.
+func (srv *Server) saveSync() error {
+	if srv.rdb.running() {
+		return errBgsaveInProgress
+	}
+	start, snap := time.Now(), srv.store.snapshot(false)
+	if err := srv.save(snap); err != nil {
+		return err
+	}
+	srv.rdb.mu.Lock()
+	defer srv.rdb.mu.Unlock()
+	srv.rdb.saved(snap, start)
+	return nil
+}
+
+// bgsave writes a view of the store to disk in the background, while
+// writes go on. It fails while another BGSAVE runs. The caller must hold
+// the store's mu for reading at least.
+func (srv *Server) bgsave() error {
+	st := srv.rdb
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.bgsave != nil {
+		return errBgsaveInProgress
+	}
+	snap := srv.store.snapshot(true)
+	st.bgsave, st.bgsaveStart = snap, time.Now()
+	st.lastBgsaveTry = st.bgsaveStart
+	st.done.Add(1)
+	go func() {
+		defer st.done.Done()
+		err := srv.save(snap)
+		snap.close()
+		if err != nil {
+			log.Printf("Background saving error: %v", err)
+		} else {
+			log.Print("Background saving terminated with success")
+		}
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		st.bgsave = nil
+		st.lastBgsaveErr = err
+		st.lastBgsaveTime = time.Since(st.bgsaveStart)
+		if err == nil {
+			st.saved(snap, st.bgsaveStart)
+		}
+	}()
+	return nil
+}
+
+// saveRule has a BGSAVE start on its own once at least changes changes
+// were made since the last save and it is older than after.
+type saveRule struct {
+	after   time.Duration
+	changes int64
+}
+
+// defaultSaveRules are those of Redis without a config file: after an
+// hour if anything changed, 5 minutes for 100 changes and a minute for
+// 10000.
+var defaultSaveRules = []saveRule{{time.Hour, 1}, {5 * time.Minute, 100}, {time.Minute, 10000}}
+
+// parseSaveRules parses save rules in the form of redis.conf: pairs of
+// seconds and changes, such as "3600 1 300 100", or "" for none.
+func parseSaveRules(s string) ([]saveRule, error) {
+	args := strings.Fields(s)
+	if len(args)%2 != 0 {
+		return nil, errors.New("invalid save parameters")
+	}
+	rules := []saveRule{}
+	for i := 0; i < len(args); i += 2 {
+		seconds, err1 := strconv.ParseInt(args[i], 10, 64)
+		changes, err2 := strconv.ParseInt(args[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, errors.New("invalid save parameters")
+		}
+		rules = append(rules, saveRule{time.Duration(seconds) * time.Second, changes})
+	}
+	return rules, nil
+}
+
+// bgsaveRetryDelay is how long after a failed BGSAVE a save rule waits
+// before starting another.
+const bgsaveRetryDelay = 5 * time.Second
+
+// startSaveScheduler checks the save rules every second, in the
+// background, for the life of the process.
+func (srv *Server) startSaveScheduler() {
+	if len(srv.cfg.SaveRules) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			srv.checkSaveRules(now)
+		}
+	}()
+}
+
+// checkSaveRules starts a BGSAVE if one of the save rules is met at now,
+// and reports whether it did. None is started while a BGSAVE runs, or
+// within bgsaveRetryDelay of one that failed.
+func (srv *Server) checkSaveRules(now time.Time) bool {
+	srv.store.mu.RLock()
+	defer srv.store.mu.RUnlock()
+	st := srv.rdb
+	st.mu.Lock()
+	changes, elapsed := srv.store.dirty-st.savedDirty, now.Sub(st.lastSave)
+	wait := st.bgsave != nil || st.lastBgsaveErr != nil && now.Sub(st.lastBgsaveTry) < bgsaveRetryDelay
+	st.mu.Unlock()
+	if wait {
+		return false
+	}
+	for _, rule := range srv.cfg.SaveRules {
+		if changes >= rule.changes && elapsed > rule.after {
+			log.Printf("%d changes in %d seconds. Saving...", rule.changes, int64(rule.after.Seconds()))
+			if err := srv.bgsave(); err != nil {
+				log.Printf("Can't save in background: %v", err)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// running reports whether a BGSAVE is in progress.
+func (st *rdbState) running() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.bgsave != nil
+}
+
+// info writes the snapshot fields of the persistence section of INFO,
+// for a store whose count of changes is dirty.
+func (st *rdbState) info(w io.Writer, dirty int64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	status := "ok"
+	if st.lastBgsaveErr != nil {
+		status = "err"
+	}
+	last := int64(-1)
+	if st.lastBgsaveTime >= 0 {
+		last = int64(st.lastBgsaveTime.Seconds())
+	}
+	inProgress, current, processed, total := 0, int64(-1), int64(0), 0
+	if st.bgsave != nil {
+		inProgress, current = 1, int64(time.Since(st.bgsaveStart).Seconds())
+		processed, total = st.bgsave.processed.Load(), st.bgsave.keys
+	}
+	fmt.Fprint(w, "loading:0\r\nasync_loading:0\r\n")
+	fmt.Fprintf(w, "current_save_keys_processed:%d\r\n", processed)
+	fmt.Fprintf(w, "current_save_keys_total:%d\r\n", total)
+	fmt.Fprintf(w, "rdb_changes_since_last_save:%d\r\n", dirty-st.savedDirty)
+	fmt.Fprintf(w, "rdb_bgsave_in_progress:%d\r\n", inProgress)
+	fmt.Fprintf(w, "rdb_last_save_time:%d\r\n", st.lastSave.Unix())
+	fmt.Fprintf(w, "rdb_last_bgsave_status:%s\r\n", status)
+	fmt.Fprintf(w, "rdb_last_bgsave_time_sec:%d\r\n", last)
+	fmt.Fprintf(w, "rdb_current_bgsave_time_sec:%d\r\n", current)
+	fmt.Fprintf(w, "rdb_saves:%d\r\n", st.saves)
+}
+
+// loadDataFromDisk restores the store at startup, before the server
+// accepts connections, from the dbfilename or, with appendonly enabled
+// and an appendfilename present, from the latter.
+func (srv *Server) loadDataFromDisk() error {
+	start := time.Now()
+	path, load, from := srv.cfg.dbPath(), srv.loadSnapshot, "disk"
+	if _, err := os.Stat(srv.cfg.appendPath()); srv.cfg.AppendOnly && !errors.Is(err, fs.ErrNotExist) {
+		// The AOF holds the whole dataset, so the dump file is not read.
+		// Without one, as when appendonly was just turned on, the dump
+		// file is loaded and the AOF is created from it.
+		path, load, from = srv.cfg.appendPath(), srv.loadAppendOnly, "append only file"
+	}
+	err := load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", path, err)
+	}
+	log.Printf("DB loaded from %s: %.3f seconds, %d keys", from, time.Since(start).Seconds(), len(srv.store.data))
+	// What was just loaded is on disk already.
+	srv.rdb.savedDirty = srv.store.dirty
+	return nil
+}
+
+// loadSnapshot loads the snapshot at path into the store, taking its lock.
+func (srv *Server) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	srv.store.mu.Lock()
+	defer srv.store.mu.Unlock()
+	return srv.store.readSnapshot(f)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// This file decodes the compact encodings Redis uses in RDB files and DUMP
+// payloads: strings stored as integers or compressed with LZF, and small
+// lists, sets, hashes and sorted sets packed into ziplists, listpacks,
+// intsets or zipmaps, as are the nodes of big lists. Of these, this server
+// only writes LZF-compressed strings, with rdbcompression. Redis' stream and module encodings, and those of
+// hashes with field TTLs, are not read.
+
+// Type codes of the Redis encodings read but not written.
+const (
+	rdbTypeZset           = 3
+	rdbTypeHashZipmap     = 9
+	rdbTypeListZiplist    = 10
+	rdbTypeSetIntset      = 11
+	rdbTypeZsetZiplist    = 12
+	rdbTypeHashZiplist    = 13
+	rdbTypeListQuicklist  = 14
+	rdbTypeHashListpack   = 16
+	rdbTypeZsetListpack   = 17
+	rdbTypeListQuicklist2 = 18
+	rdbTypeSetListpack    = 20
+)
+
+// Special string encodings, flagged by rdbEncVal in the top bits of a
+// length.
+const (
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// Containers of the nodes of a quicklist2: a single element stored as is,
+// or a listpack of them.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// encodedString reads a string in special encoding enc.
+func (r *rdbReader) encodedString(enc uint64) []byte {
+	switch enc {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		p := r.read(1 << enc)
+		if p == nil {
+			return nil
+		}
+		return strconv.AppendInt(nil, littleEndianInt(p), 10)
+	case rdbEncLZF:
+		clen, n := r.length(), r.length()
+		p := r.read(clen)
+		if p == nil {
+			return nil
+		}
+		s, err := lzfDecompress(p, n)
+		if err != nil {
+			r.fail(err)
+		}
+		return s
+	}
+	r.fail(errBadSnapshot)
+	return nil
+}
+
+// lzfDecompress decompresses in, which must expand to n bytes.
+func lzfDecompress(in []byte, n uint64) ([]byte, error) {
+	out := make([]byte, 0, min(n, 1<<16))
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// A run of ctrl+1 literal bytes.
+			end := i + ctrl + 1
+			if end > len(in) {
+				return nil, errBadSnapshot
+			}
+			out = append(out, in[i:end]...)
+			i = end
+		} else {
+			// A copy of bytes already output: the three top bits hold
+			// the length less 2, or 7 for a longer one whose rest is in
+			// the next byte, and the others the high bits of the offset.
+			length := ctrl >> 5
+			if length == 7 && i < len(in) {
+				length += int(in[i])
+				i++
+			}
+			if i == len(in) {
+				return nil, errBadSnapshot
+			}
+			ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+			i++
+			if ref < 0 {
+				return nil, errBadSnapshot
+			}
+			// The copy may overlap what it appends, so go byte by byte.
+			for k := range length + 2 {
+				out = append(out, out[ref+k])
+			}
+		}
+		if uint64(len(out)) > n {
+			return nil, errBadSnapshot
+		}
+	}
+	if uint64(len(out)) != n {
+		return nil, errBadSnapshot
+	}
+	return out, nil
+}
+
+// lzfCompress compresses in with LZF, returning nil unless the result
+// takes at most limit bytes. Repeats are found through a table of where
+// each 3-byte sequence was last seen, as in liblzf.
+func lzfCompress(in []byte, limit int) []byte {
+	const (
+		hashBits  = 14
+		maxOffset = 1 << 13
+		maxLit    = 1 << 5
+		maxRef    = 7 + 255 + 2
+	)
+	var last [1 << hashBits]int
+	out := make([]byte, 0, limit)
+	lit := 0
+	// flush writes the literal bytes before i, in runs of up to maxLit.
+	flush := func(i int) {
+		for lit < i {
+			n := min(i-lit, maxLit)
+			out = append(out, byte(n-1))
+			out = append(out, in[lit:lit+n]...)
+			lit += n
+		}
+	}
+	for i := 0; i+2 < len(in) && len(out) <= limit; {
+		seq := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+		h := seq * 2654435761 >> (32 - hashBits)
+		ref := last[h] - 1
+		last[h] = i + 1
+		if ref < 0 || i-ref > maxOffset || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			i++
+			continue
+		}
+		n := 3
+		for n < min(len(in)-i, maxRef) && in[ref+n] == in[i+n] {
+			n++
+		}
+		flush(i)
+		off := i - ref - 1
+		if n-2 < 7 {
+			out = append(out, byte((n-2)<<5|off>>8), byte(off))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(n-2-7), byte(off))
+		}
+		i += n
+		lit = i
+	}
+	flush(len(in))
+	if len(out) > limit {
+		return nil
+	}
+	return out
+}
+
+// redisValue reads a value in one of the Redis encodings of type code typ.
+func (r *rdbReader) redisValue(typ byte) (any, error) {
+	switch typ {
+	case rdbTypeZset:
+		z := newZset()
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			member := r.string()
+			z.add(member, r.stringFloat())
+		}
+		return z, nil
+	case rdbTypeListZiplist:
+		l := &listValue{}
+		for _, e := range r.packed(ziplistEntries) {
+			l.PushBack(e)
+		}
+		return l, nil
+	case rdbTypeListQuicklist, rdbTypeListQuicklist2:
+		l := &listValue{}
+		for n := r.length(); n > 0 && r.err == nil; n-- {
+			container := uint64(quicklistNodePacked)
+			decode := listpackEntries
+			if typ == rdbTypeListQuicklist {
+				decode = ziplistEntries
+			} else {
+				container = r.length()
+			}
+			switch container {
+			case quicklistNodePlain:
+				l.PushBack(r.string())
+			case quicklistNodePacked:
+				for _, e := range r.packed(decode) {
+					l.PushBack(e)
+				}
+			default:
+				r.fail(errBadSnapshot)
+			}
+		}
+		return l, nil
+	case rdbTypeSetIntset, rdbTypeSetListpack:
+		decode := intsetMembers
+		if typ == rdbTypeSetListpack {
+			decode = listpackEntries
+		}
+		set := newSet()
+		for _, m := range r.packed(decode) {
+			set.members[m] = struct{}{}
+		}
+		return set, nil
+	case rdbTypeHashZipmap, rdbTypeHashZiplist, rdbTypeHashListpack:
+		decode := ziplistEntries
+		switch typ {
+		case rdbTypeHashZipmap:
+			decode = zipmapEntries
+		case rdbTypeHashListpack:
+			decode = listpackEntries
+		}
+		pairs := r.pairs(decode)
+		h := newHash()
+		for i := 0; i < len(pairs); i += 2 {
+			h.fields[pairs[i]] = pairs[i+1]
+		}
+		return h, nil
+	case rdbTypeZsetZiplist, rdbTypeZsetListpack:
+		decode := ziplistEntries
+		if typ == rdbTypeZsetListpack {
+			decode = listpackEntries
+		}
+		pairs := r.pairs(decode)
+		z := newZset()
+		for i := 0; i < len(pairs); i += 2 {
+			score, err := strconv.ParseFloat(pairs[i+1], 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, errBadSnapshot
+			}
+			z.add(pairs[i], score)
+		}
+		return z, nil
+	}
+	return nil, fmt.Errorf("unknown value type %d in snapshot", typ)
+}
+
+// stringFloat reads a sorted set score stored as a string, as the zset
+// type does: a length byte, whose three top values stand for NaN and the
+// infinities, then the digits.
+func (r *rdbReader) stringFloat() float64 {
+	switch n := r.byte(); n {
+	case 253:
+		r.fail(errBadSnapshot)
+	case 254:
+		return math.Inf(1)
+	case 255:
+		return math.Inf(-1)
+	default:
+		f, err := strconv.ParseFloat(string(r.read(uint64(n))), 64)
+		if err != nil {
+			r.fail(errBadSnapshot)
+		}
+		return f
+	}
+	return 0
+}
+
+// packed reads a string and returns the elements decode finds in it.
+func (r *rdbReader) packed(decode func([]byte) ([]string, error)) []string {
+	p := r.bytes()
+	if r.err != nil {
+		return nil
+	}
+	elems, err := decode(p)
+	if err != nil {
+		r.fail(err)
+	}
+	return elems
+}
+
+// pairs is packed for the elements of a hash or sorted set, which come
+// in pairs of a field and its value or a member and its score.
+func (r *rdbReader) pairs(decode func([]byte) ([]string, error)) []string {
+	elems := r.packed(decode)
+	if len(elems)%2 != 0 {
+		r.fail(errBadSnapshot)
+		return nil
+	}
+	return elems
+}
+
+// packedReader walks the bytes of a ziplist, listpack, intset or zipmap.
+// Like rdbReader, it keeps the first error and returns zero values after
+// it.
+type packedReader struct {
+	p   []byte
+	err error
+}
+
+func (b *packedReader) next(n int) []byte {
+	if b.err != nil || n < 0 || n > len(b.p) {
+		b.err = errBadSnapshot
+		return nil
+	}
+	p := b.p[:n]
+	b.p = b.p[n:]
+	return p
+}
+
+func (b *packedReader) byte() byte {
+	if p := b.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+// string reads n bytes as a string.
+func (b *packedReader) string(n int) string {
+	return string(b.next(n))
+}
+
+// int reads a little-endian signed integer of n bytes, as a string.
+func (b *packedReader) int(n int) string {
+	return strconv.FormatInt(littleEndianInt(b.next(n)), 10)
+}
+
+// uint reads a little-endian unsigned integer of n bytes.
+func (b *packedReader) uint(n int) uint64 {
+	var v uint64
+	p := b.next(n)
+	for i := len(p) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[i])
+	}
+	return v
+}
+
+// littleEndianInt decodes p as a little-endian two's complement integer
+// of up to eight bytes.
+func littleEndianInt(p []byte) int64 {
+	var v uint64
+	for i := len(p) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[i])
+	}
+	shift := 64 - 8*len(p)
+	return int64(v<<shift) >> shift
+}
+
+// ziplistEntries decodes a ziplist: a header of its size in bytes, the
+// offset of its last entry and its count of entries, then the entries,
+// each with the length of the one before it, and a 0xff terminator.
+func ziplistEntries(zl []byte) ([]string, error) {
+	b := &packedReader{p: zl}
+	b.next(10)
+	var entries []string
+	for {
+		prevlen := b.byte()
+		if b.err != nil {
+			return nil, b.err
+		}
+		if prevlen == 0xff {
+			return entries, nil
+		}
+		if prevlen == 0xfe {
+			b.next(4)
+		}
+		var e string
+		switch enc := b.byte(); {
+		case enc>>6 == 0:
+			e = b.string(int(enc & 0x3f))
+		case enc>>6 == 1:
+			e = b.string(int(enc&0x3f)<<8 | int(b.byte()))
+		case enc == 0x80:
+			if p := b.next(4); p != nil {
+				e = b.string(int(binary.BigEndian.Uint32(p)))
+			}
+		case enc == 0xc0:
+			e = b.int(2)
+		case enc == 0xd0:
+			e = b.int(4)
+		case enc == 0xe0:
+			e = b.int(8)
+		case enc == 0xf0:
+			e = b.int(3)
+		case enc == 0xfe:
+			e = b.int(1)
+		case enc > 0xf0 && enc < 0xfe:
+			// An integer from 0 to 12 held in the encoding itself.
+			e = strconv.Itoa(int(enc&0x0f) - 1)
+		default:
+			return nil, errBadSnapshot
+		}
+		entries = append(entries, e)
+	}
+}
+
+// listpackEntries decodes a listpack: a header of its size in bytes and
+// its count of elements, then the elements, each followed by the length
+// of its encoding so it can be walked backwards, and a 0xff terminator.
+func listpackEntries(lp []byte) ([]string, error) {
+	b := &packedReader{p: lp}
+	b.next(6)
+	var entries []string
+	for {
+		enc := b.byte()
+		if b.err != nil {
+			return nil, b.err
+		}
+		if enc == 0xff {
+			return entries, nil
+		}
+		var e string
+		size := 1
+		switch {
+		case enc < 0x80:
+			e = strconv.Itoa(int(enc))
+		case enc>>6 == 2:
+			n := int(enc & 0x3f)
+			e, size = b.string(n), 1+n
+		case enc>>5 == 6:
+			// A 13-bit two's complement integer.
+			v := int(enc&0x1f)<<8 | int(b.byte())
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			e, size = strconv.Itoa(v), 2
+		case enc>>4 == 0xe:
+			n := int(enc&0x0f)<<8 | int(b.byte())
+			e, size = b.string(n), 2+n
+		case enc == 0xf0:
+			n := int(b.uint(4))
+			e, size = b.string(n), 5+n
+		case enc >= 0xf1 && enc <= 0xf4:
+			n := []int{2, 3, 4, 8}[enc-0xf1]
+			e, size = b.int(n), 1+n
+		default:
+			return nil, errBadSnapshot
+		}
+		b.next(backlenSize(size))
+		entries = append(entries, e)
+	}
+}
+
+// backlenSize returns how many bytes a listpack element takes to store
+// the size of its encoding, at 7 bits per byte.
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// intsetMembers decodes an intset: the size of its integers, 2, 4 or 8
+// bytes, and their count, then the integers, all little-endian.
+func intsetMembers(is []byte) ([]string, error) {
+	b := &packedReader{p: is}
+	size, n := b.uint(4), b.uint(4)
+	if b.err != nil || size != 2 && size != 4 && size != 8 || uint64(len(b.p)) != n*size {
+		return nil, errBadSnapshot
+	}
+	members := make([]string, 0, n)
+	for range n {
+		members = append(members, b.int(int(size)))
+	}
+	return members, nil
+}
+
+// zipmapEntries decodes a zipmap: a count byte, then each field and value
+// with their lengths, the value's followed by a count of unused bytes
+// after it, and a 0xff terminator. Lengths take a byte, or 254 and then
+// four.
+func zipmapEntries(zm []byte) ([]string, error) {
+	b := &packedReader{p: zm}
+	b.byte()
+	length := func(first byte) int {
+		if first == 254 {
+			return int(b.uint(4))
+		}
+		return int(first)
+	}
+	var entries []string
+	for {
+		first := b.byte()
+		if b.err != nil {
+			return nil, b.err
+		}
+		if first == 0xff {
+			return entries, nil
+		}
+		field := b.string(length(first))
+		n := length(b.byte())
+		free := int(b.byte())
+		entries = append(entries, field, b.string(n))
+		b.next(free)
+	}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// blobType is a module type holding a string, persisted as its bytes.
+var blobType = &DataType{
+	Name: "test.blob",
+	Save: func(v any) []byte { return []byte(v.(string)) },
+	Load: func(data []byte) (any, error) { return string(data), nil },
+}
+
+// newSnapshotServer returns a server saving to a file in a temporary
+// directory.
+func newSnapshotServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Dir = t.TempDir()
+	return NewServer(cfg)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	RegisterType(blobType)
+	defer delete(typeTable, blobType.Name)
+
+	c := newClient(nil, newSnapshotServer(t))
+	for _, args := range [][]string{
+		{"SET", "str", "hello\x00world"},
+		{"SET", "int", "12345"},
+		{"SET", "ttl", "x", "PX", "100000"},
+		{"RPUSH", "list", "a", "b", "c"},
+		{"SADD", "set", "x", "y"},
+		{"HSET", "hash", "f", "1", "g", "2"},
+		{"HSET", "fieldttl", "f", "1", "g", "2"},
+		{"HPEXPIRE", "fieldttl", "100000", "FIELDS", "1", "g"},
+		{"ZADD", "zset", "1.5", "a", "-inf", "b", "3", "c"},
+		{"XADD", "stream", "1-1", "f", "v"},
+		{"XADD", "stream", "2-1", "f", "w", "g", "x"},
+		{"XGROUP", "CREATE", "stream", "grp", "0"},
+		{"XREADGROUP", "GROUP", "grp", "alice", "COUNT", "1", "STREAMS", "stream", ">"},
+		{"XGROUP", "CREATECONSUMER", "stream", "grp", "bob"},
+		{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('hi', function() return 'hi' end)"},
+	} {
+		if reply := do(c, args...); reply.Type == resp.TypeError {
+			t.Fatalf("%q = %+v", args, reply)
+		}
+	}
+	c.SetValue("blob", blobType, "opaque")
+	if got := do(c, "SAVE"); !reflect.DeepEqual(got, resp.OK) {
+		t.Fatalf("SAVE = %+v", got)
+	}
+
+	reads := [][]string{
+		{"GET", "str"},
+		{"GET", "int"},
+		{"LRANGE", "list", "0", "-1"},
+		{"SMISMEMBER", "set", "x", "y", "z"},
+		{"HLEN", "hash"},
+		{"HMGET", "hash", "f", "g"},
+		{"HMGET", "fieldttl", "f", "g"},
+		{"ZRANGE", "zset", "0", "-1", "WITHSCORES"},
+		{"XRANGE", "stream", "-", "+"},
+		{"XPENDING", "stream", "grp", "-", "+", "10"},
+		{"FCALL", "hi", "0"},
+		{"TYPE", "blob"},
+		{"DBSIZE"},
+		{"PEXPIRETIME", "ttl"},
+		{"PEXPIRETIME", "str"},
+	}
+	want := make([]resp.Value, len(reads))
+	for i, args := range reads {
+		want[i] = do(c, args...)
+	}
+
+	loaded := newClient(nil, NewServer(defaultConfig()))
+	if err := loaded.srv.loadSnapshot(c.srv.cfg.dbPath()); err != nil {
+		t.Fatalf("loadSnapshot: %v", err)
+	}
+	for i, args := range reads {
+		got := do(loaded, args...)
+		// Idle times keep growing, so leave them out.
+		if args[0] == "XPENDING" {
+			got, want[i] = pendingIDs(got), pendingIDs(want[i])
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("after loading, %q = %+v, want %+v", args, got, want[i])
+		}
+	}
+	expectSameInternals(t, c.store, loaded.store, "fieldttl", "stream")
+	if v, _ := loaded.Value("blob", blobType); v != "opaque" {
+		t.Errorf("after loading, blob = %v, want opaque", v)
+	}
+}
+
+// internals describes what no read command reports exactly about a
+// value: the deadlines of a hash's fields, and the groups of a stream with
+// their consumers and pending entries. Delivery and seen times are left
+// out, as an AOF does not keep them all.
+func internals(d StoreData) string {
+	var b strings.Builder
+	switch v := d.value.(type) {
+	case *hashValue:
+		fields := slices.Sorted(maps.Keys(v.expires))
+		for _, f := range fields {
+			fmt.Fprintf(&b, "%s@%d ", f, v.expires[f].UnixMilli())
+		}
+	case *streamValue:
+		fmt.Fprintf(&b, "last %v;", v.lastID)
+		for _, name := range slices.Sorted(maps.Keys(v.groups)) {
+			g := v.groups[name]
+			fmt.Fprintf(&b, " group %s last %v consumers %v pending", name, g.lastID, slices.Sorted(maps.Keys(g.consumers)))
+			for _, p := range sortedPending(g.pel) {
+				fmt.Fprintf(&b, " %v:%s:%d", p.id, p.consumer.name, p.deliveries)
+			}
+			b.WriteString(";")
+		}
+	}
+	return b.String()
+}
+
+// expectSameInternals compares the internals of keys in two stores.
+func expectSameInternals(t *testing.T, want, got *Store, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if w, g := internals(want.data[key]), internals(got.data[key]); g != w {
+			t.Errorf("after loading, %s has %q, want %q", key, g, w)
+		}
+	}
+}
+
+// pendingIDs keeps the IDs, consumers and delivery counts from an extended
+// XPENDING reply.
+func pendingIDs(v resp.Value) resp.Value {
+	var ids []resp.Value
+	for _, p := range v.Array {
+		ids = append(ids, p.Array[0], p.Array[1], p.Array[3])
+	}
+	return resp.Array(ids...)
+}
+
+func TestSaveErrors(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+
+	// A value whose type cannot be saved makes SAVE fail.
+	unsaved := &DataType{Name: "test.unsaved"}
+	RegisterType(unsaved)
+	defer delete(typeTable, unsaved.Name)
+	c.SetValue("k", unsaved, 1)
+	if got := do(c, "SAVE"); got.Type != resp.TypeError {
+		t.Errorf("SAVE with an unsaved type = %+v, want an error", got)
+	}
+
+	if err := c.srv.loadSnapshot(filepath.Join(t.TempDir(), "missing.rdb")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+	// A snapshot cut short is rejected.
+	var buf []byte
+	buf = append(buf, "REDIS0011"...)
+	buf = append(buf, rdbOpSelectDB, 0, rdbTypeString, 1, 'k', 5, 'v')
+	srv := NewServer(defaultConfig())
+	if err := srv.store.readSnapshot(bytes.NewReader(buf)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading a truncated snapshot = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	buf = binary.LittleEndian.AppendUint64(append(buf[:0], "REDIS0099"...), 0)
+	if err := srv.store.readSnapshot(bytes.NewReader(buf)); err == nil {
+		t.Error("reading a snapshot of a future version succeeded")
+	}
+}
+
+func TestBgsave(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	do(c, "SET", "k", "v")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"BGSAVE"}, resp.SimpleString("Background saving started")},
+		{[]string{"BGSAVE", "NOW"}, syntaxErrorReply},
+	})
+	c.srv.rdb.done.Wait()
+	// Writes made after BGSAVE started are not in the snapshot.
+	do(c, "SET", "later", "v")
+
+	loaded := NewServer(defaultConfig())
+	if err := loaded.loadSnapshot(c.srv.cfg.dbPath()); err != nil {
+		t.Fatalf("loadSnapshot: %v", err)
+	}
+	if got := len(loaded.store.data); got != 1 {
+		t.Errorf("loaded %d keys, want 1", got)
+	}
+	info := do(c, "INFO", "persistence").Str
+	for _, line := range []string{"rdb_bgsave_in_progress:0", "rdb_last_bgsave_status:ok", "rdb_last_bgsave_time_sec:0"} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("INFO persistence lacks %q:\n%s", line, info)
+		}
+	}
+
+	// While a BGSAVE runs, neither SAVE nor another BGSAVE can start.
+	c.srv.rdb.bgsave = c.store.snapshot(true)
+	c.srv.rdb.bgsaveStart = time.Now()
+	for _, cmd := range []string{"SAVE", "BGSAVE"} {
+		if got := do(c, cmd); !reflect.DeepEqual(got, resp.Error("ERR Background save already in progress")) {
+			t.Errorf("%s during BGSAVE = %+v", cmd, got)
+		}
+	}
+	info = do(c, "INFO", "persistence").Str
+	for _, line := range []string{"rdb_bgsave_in_progress:1", "current_save_keys_processed:0", "current_save_keys_total:2"} {
+		if !strings.Contains(info, line+"\r\n") {
+			t.Errorf("INFO persistence during BGSAVE lacks %q:\n%s", line, info)
+		}
+	}
+	c.srv.rdb.bgsave.close()
+	c.srv.rdb.bgsave = nil
+
+	// A failed BGSAVE is reported in INFO.
+	c.srv.cfg.Dir = filepath.Join(t.TempDir(), "missing")
+	do(c, "BGSAVE")
+	c.srv.rdb.done.Wait()
+	if info := do(c, "INFO", "persistence").Str; !strings.Contains(info, "rdb_last_bgsave_status:err\r\n") {
+		t.Errorf("INFO persistence after a failed BGSAVE:\n%s", info)
+	}
+}
+
+func TestSnapshotView(t *testing.T) {
+	c := newTestClient()
+	for _, args := range [][]string{
+		{"SET", "str", "v"},
+		{"SET", "gone", "v"},
+		{"RPUSH", "list", "a", "b"},
+		{"HSET", "hash", "f", "1"},
+		{"SADD", "set", "x"},
+	} {
+		do(c, args...)
+	}
+	c.store.mu.RLock()
+	first := c.store.snapshot(true)
+	c.store.mu.RUnlock()
+
+	// Writes made once a view is taken do not show in it, whether they
+	// replace a value or change it in place, and neither does a flush.
+	for _, args := range [][]string{
+		{"SET", "str", "changed"},
+		{"DEL", "gone"},
+		{"RPUSH", "list", "c"},
+		{"HSET", "hash", "f", "2"},
+		{"SET", "new", "v"},
+	} {
+		do(c, args...)
+	}
+	c.store.mu.RLock()
+	second := c.store.snapshot(true)
+	c.store.mu.RUnlock()
+	do(c, "SADD", "set", "y")
+	do(c, "FLUSHALL")
+
+	for _, tc := range []struct {
+		snap *snapshot
+		want []resp.Value
+	}{
+		{first, []resp.Value{
+			resp.BulkString("v"),
+			resp.BulkString("v"),
+			resp.Array(resp.BulkString("a"), resp.BulkString("b")),
+			resp.BulkString("1"),
+			resp.Integer(1),
+			resp.Integer(0),
+		}},
+		{second, []resp.Value{
+			resp.BulkString("changed"),
+			resp.NullBulk,
+			resp.Array(resp.BulkString("a"), resp.BulkString("b"), resp.BulkString("c")),
+			resp.BulkString("2"),
+			resp.Integer(1),
+			resp.Integer(1),
+		}},
+	} {
+		var out bytes.Buffer
+		if err := tc.snap.write(&out); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		tc.snap.close()
+		loaded := newTestClient()
+		if err := loaded.store.readSnapshot(&out); err != nil {
+			t.Fatalf("readSnapshot: %v", err)
+		}
+		for i, args := range [][]string{
+			{"GET", "str"},
+			{"GET", "gone"},
+			{"LRANGE", "list", "0", "-1"},
+			{"HGET", "hash", "f"},
+			{"SCARD", "set"},
+			{"EXISTS", "new"},
+		} {
+			if got := do(loaded, args...); !reflect.DeepEqual(got, tc.want[i]) {
+				t.Errorf("%q = %+v, want %+v", args, got, tc.want[i])
+			}
+		}
+	}
+	if c.store.views.Load() != nil {
+		t.Error("views left open")
+	}
+}
+
+func TestLoadDataFromDisk(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	if err := c.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk without a dump file: %v", err)
+	}
+
+	past := time.Now().Add(-time.Second)
+	do(c, "SET", "live", "v", "EX", "100")
+	do(c, "HSET", "h", "f", "1", "g", "2")
+	do(c, "HSET", "gone", "f", "1")
+	c.store.mu.Lock()
+	c.store.put("dead", StoreData{value: []byte("v"), expiresAt: past})
+	h, _ := c.store.lookupHash("h")
+	h.setFieldExpire("g", past)
+	h, _ = c.store.lookupHash("gone")
+	h.setFieldExpire("f", past)
+	c.store.mu.Unlock()
+	do(c, "SAVE")
+
+	// Keys and fields that expired before the restart are not loaded.
+	restarted := newClient(nil, NewServer(c.srv.cfg))
+	if err := restarted.srv.loadDataFromDisk(); err != nil {
+		t.Fatalf("loadDataFromDisk: %v", err)
+	}
+	for _, key := range []string{"dead", "gone"} {
+		if _, ok := restarted.store.data[key]; ok {
+			t.Errorf("expired key %q was loaded", key)
+		}
+	}
+	expectReply(t, restarted, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "live"}, resp.BulkString("v")},
+		{[]string{"HGETALL", "h"}, resp.Map(resp.BulkString("f"), resp.BulkString("1"))},
+	})
+	if h, _ := restarted.store.lookupHash("h"); len(h.fields) != 1 {
+		t.Errorf("loaded hash has %d fields, want 1", len(h.fields))
+	}
+
+	os.WriteFile(c.srv.cfg.dbPath(), []byte("REDIS0011\xfe"), 0o644)
+	if err := NewServer(c.srv.cfg).loadDataFromDisk(); err == nil {
+		t.Error("loadDataFromDisk with a truncated dump file succeeded")
+	}
+}
+
+func TestLastsave(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	started := do(c, "LASTSAVE").Int
+	if now := time.Now().Unix(); started < now-1 || started > now {
+		t.Errorf("LASTSAVE before any save = %d, want the start time", started)
+	}
+	expectInfo := func(lines ...string) {
+		t.Helper()
+		info := do(c, "INFO", "persistence").Str
+		for _, line := range lines {
+			if !strings.Contains(info, "\r\n"+line+"\r\n") {
+				t.Errorf("INFO persistence lacks %q:\n%s", line, info)
+			}
+		}
+	}
+	expectInfo("rdb_changes_since_last_save:0", "rdb_saves:0")
+	do(c, "SET", "a", "1")
+	do(c, "SET", "b", "1")
+	do(c, "GET", "a")
+	expectInfo("rdb_changes_since_last_save:2")
+
+	do(c, "SAVE")
+	expectInfo("rdb_changes_since_last_save:0", "rdb_saves:1")
+	do(c, "SET", "a", "2")
+	do(c, "BGSAVE")
+	c.srv.rdb.done.Wait()
+	expectInfo("rdb_changes_since_last_save:0", "rdb_saves:2")
+	if got := do(c, "LASTSAVE").Int; got < started {
+		t.Errorf("LASTSAVE after saving = %d, want at least %d", got, started)
+	}
+
+	// Loading the data leaves nothing to save.
+	restarted := newClient(nil, NewServer(c.srv.cfg))
+	restarted.srv.loadDataFromDisk()
+	if info := do(restarted, "INFO", "persistence").Str; !strings.Contains(info, "\r\nrdb_changes_since_last_save:0\r\n") {
+		t.Errorf("INFO persistence after loading:\n%s", info)
+	}
+}
+
+func TestLoadRedisSnapshot(t *testing.T) {
+	// str length-encodes s, as Redis writes strings and packed values.
+	str := func(s string) string {
+		if len(s) < 1<<6 {
+			return string(rune(len(s))) + s
+		}
+		return string([]byte{0x40 | byte(len(s)>>8), byte(len(s))}) + s
+	}
+	in50Years := string(binary.LittleEndian.AppendUint32(nil, uint32(time.Now().AddDate(50, 0, 0).Unix())))
+	long := strings.Repeat("b", 64)
+	dump := "REDIS0012" +
+		"\xfa" + str("redis-ver") + str("7.4.1") +
+		"\xfa" + str("redis-bits") + "\xc0\x40" +
+		"\xfe\x00\xfb\x0e\x01" +
+		// Strings as integers and compressed with LZF, with an idle time,
+		// a frequency, and expiry times in seconds.
+		"\xf8\x40\x64\x00" + str("int") + "\xc1\x39\x30" +
+		"\xf9\x05\x00" + str("neg") + "\xc2\xff\xff\xff\xff" +
+		"\xfd" + in50Years + "\x00" + str("lzf") + "\xc3\x05\x14\x00a\xe0\x0a\x00" +
+		"\xfd\xe8\x03\x00\x00\x00" + str("gone") + "\x01v" +
+		// A quicklist of a listpack and a plain node, and a ziplist.
+		"\x12" + str("list") + "\x02" +
+		"\x02" + str("\x13\x00\x00\x00\x04\x00"+"\x81a\x02"+"\x07\x01"+"\xdf\xfe\x02"+"\xf1\xe8\x03\x03"+"\xff") +
+		"\x01" + str("plain") +
+		"\x0a" + str("zl") + str("\x1b\x00\x00\x00\x17\x00\x00\x00\x04\x00"+"\x00\x05hello"+"\x07\xfd"+"\x02\xc0\xff\xff"+"\x04\xfe\x85"+"\xff") +
+		"\x0e" + str("qzl") + "\x01" + str("\x0d\x00\x00\x00\x0a\x00\x00\x00\x01\x00"+"\x00\x01q"+"\xff") +
+		// An intset and a listpack set.
+		"\x0b" + str("ints") + str("\x02\x00\x00\x00\x03\x00\x00\x00"+"\x01\x00\x02\x00\xfd\xff") +
+		"\x14" + str("lpset") + str("\x0c\x00\x00\x00\x02\x00"+"\x81x\x02"+"\x05\x01"+"\xff") +
+		// Hashes as a listpack, a ziplist and a zipmap.
+		"\x10" + str("hash") + str("\x55\x00\x00\x00\x04\x00"+"\x82f1\x03"+"\x82v1\x03"+"\x81n\x02"+"\xe0\x40"+long+"\x42"+"\xff") +
+		"\x0d" + str("zlhash") + str("\x10\x00\x00\x00\x0d\x00\x00\x00\x02\x00"+"\x00\x01a"+"\x03\xf2"+"\xff") +
+		"\x09" + str("zm") + str("\x02"+"\x03foo\x03\x00bar"+"\x01k\x02\x01vvX"+"\xff") +
+		// Sorted sets as a listpack, a ziplist and with string scores.
+		"\x11" + str("zset") + str("\x16\x00\x00\x00\x04\x00"+"\x82m1\x03"+"\x831.5\x04"+"\x82m2\x03"+"\x03\x01"+"\xff") +
+		"\x0c" + str("zlzset") + str("\x0f\x00\x00\x00\x0c\x00\x00\x00\x02\x00"+"\x00\x01m"+"\x03\xf4"+"\xff") +
+		"\x03" + str("oldzset") + "\x02" + str("a") + "\x032.5" + str("b") + "\xfe" +
+		// Another database, which there is no room for.
+		"\xfe\x01" + "\x00" + str("other") + str("v") +
+		"\xff" + strings.Repeat("\x00", 8)
+
+	srv := NewServer(defaultConfig())
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.loadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(nil, srv)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"DBSIZE"}, resp.Integer(14)},
+		{[]string{"GET", "int"}, resp.BulkString("12345")},
+		{[]string{"GET", "neg"}, resp.BulkString("-1")},
+		{[]string{"GET", "lzf"}, resp.BulkString(strings.Repeat("a", 20))},
+		{[]string{"EXISTS", "gone", "other"}, resp.Integer(0)},
+		{[]string{"LRANGE", "list", "0", "-1"}, resp.Array(resp.BulkString("a"), resp.BulkString("7"), resp.BulkString("-2"), resp.BulkString("1000"), resp.BulkString("plain"))},
+		{[]string{"LRANGE", "zl", "0", "-1"}, resp.Array(resp.BulkString("hello"), resp.BulkString("12"), resp.BulkString("-1"), resp.BulkString("-123"))},
+		{[]string{"LRANGE", "qzl", "0", "-1"}, resp.Array(resp.BulkString("q"))},
+		{[]string{"SMISMEMBER", "ints", "1", "2", "-3", "3"}, resp.Array(resp.Integer(1), resp.Integer(1), resp.Integer(1), resp.Integer(0))},
+		{[]string{"SMISMEMBER", "lpset", "x", "5"}, resp.Array(resp.Integer(1), resp.Integer(1))},
+		{[]string{"HMGET", "hash", "f1", "n"}, resp.Array(resp.BulkString("v1"), resp.BulkString(long))},
+		{[]string{"HGET", "zlhash", "a"}, resp.BulkString("1")},
+		{[]string{"HMGET", "zm", "foo", "k"}, resp.Array(resp.BulkString("bar"), resp.BulkString("vv"))},
+		{[]string{"ZRANGE", "zset", "0", "-1", "WITHSCORES"}, resp.Array(resp.BulkString("m1"), resp.Double(1.5), resp.BulkString("m2"), resp.Double(3))},
+		{[]string{"ZSCORE", "zlzset", "m"}, resp.Double(3)},
+		{[]string{"ZRANGE", "oldzset", "0", "-1", "WITHSCORES"}, resp.Array(resp.BulkString("a"), resp.Double(2.5), resp.BulkString("b"), resp.Double(math.Inf(1)))},
+	})
+	if ttl := do(c, "TTL", "lzf").Int; ttl < 49*365*24*3600 {
+		t.Errorf("TTL of a key expiring in 50 years = %d", ttl)
+	}
+	if idle := time.Since(srv.store.data["int"].accessedAt); idle < 100*time.Second || idle > 110*time.Second {
+		t.Errorf("key saved idle for 100s idle for %v", idle)
+	}
+
+	// DUMP payloads of Redis 6 and 7, from its documentation, restore too.
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"RESTORE", "v9", "0", "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"}, resp.OK},
+		{[]string{"RESTORE", "v10", "0", "\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"}, resp.OK},
+		{[]string{"MGET", "v9", "v10"}, resp.Array(resp.BulkString("10"), resp.BulkString("10"))},
+	})
+
+	// Packed values cut short or overrunning are rejected.
+	for _, value := range []string{
+		"\x10\x02\x81a",
+		"\x10\x09\x00\x00\x00\x00\x00\x00\x85ab\x02\xff",
+		"\x0b\x0a\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00",
+		"\x0a\x0c\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05",
+		"\x00\xc3\x02\x05\x01a",
+	} {
+		dump := "REDIS0012" + value[:1] + str("k") + value[1:] + "\xff" + strings.Repeat("\x00", 8)
+		if err := NewStore().readSnapshot(strings.NewReader(dump)); err == nil {
+			t.Errorf("reading %q succeeded", value)
+		}
+	}
+}
+
+func TestLZF(t *testing.T) {
+	random := make([]byte, 1000)
+	for i, x := 0, uint32(1); i < len(random); i++ {
+		x = x*1664525 + 1013904223
+		random[i] = byte(x >> 24)
+	}
+	for _, in := range []string{
+		strings.Repeat("a", 21),
+		strings.Repeat("abc", 1000),
+		"hello hello hello hello, world world world",
+		strings.Repeat("x", 100) + string(random) + strings.Repeat("x", 10000) + string(random[:300]),
+		string(random[:10]) + strings.Repeat(string(random[:40]), 50),
+	} {
+		c := lzfCompress([]byte(in), len(in)-4)
+		if c == nil {
+			t.Errorf("%.20q... did not compress", in)
+			continue
+		}
+		if out, err := lzfDecompress(c, uint64(len(in))); err != nil || string(out) != in {
+			t.Errorf("%.20q... compressed and decompressed to %.20q..., %v", in, out, err)
+		}
+	}
+	if c := lzfCompress(random, len(random)-4); c != nil {
+		t.Errorf("random bytes compressed to %d bytes", len(c))
+	}
+}
+
+func TestSnapshotCompressionAndChecksum(t *testing.T) {
+	c := newClient(nil, newSnapshotServer(t))
+	value := strings.Repeat("abc", 1000)
+	do(c, "SET", "k", value)
+	do(c, "RPUSH", "l", value, "short")
+	do(c, "SAVE")
+	path := c.srv.cfg.dbPath()
+	dump, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump) > 1000 {
+		t.Errorf("snapshot of compressible values takes %d bytes", len(dump))
+	}
+	srv := NewServer(defaultConfig())
+	if err := srv.loadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	expectReply(t, newClient(nil, srv), []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "k"}, resp.BulkString(value)},
+		{[]string{"LRANGE", "l", "0", "-1"}, resp.Array(resp.BulkString(value), resp.BulkString("short"))},
+	})
+
+	// A corrupt byte is caught by the checksum.
+	corrupt := slices.Clone(dump)
+	corrupt[bytes.Index(corrupt, []byte("short"))] = 'S'
+	if err := NewStore().readSnapshot(bytes.NewReader(corrupt)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("reading a corrupt snapshot = %v, want a checksum error", err)
+	}
+
+	// Without either, values are written as is and the checksum is zero,
+	// which is not verified.
+	c.srv.cfg.RDBCompression, c.srv.cfg.RDBChecksum = false, false
+	do(c, "SAVE")
+	if dump, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(dump, []byte(value)) || !bytes.HasSuffix(dump, make([]byte, 8)) {
+		t.Error("snapshot compressed or checksummed with rdbcompression and rdbchecksum off")
+	}
+	dump[bytes.Index(dump, []byte("short"))] = 'S'
+	if err := NewStore().readSnapshot(bytes.NewReader(dump)); err != nil {
+		t.Errorf("reading a snapshot without a checksum = %v", err)
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	for _, tc := range []struct {
+		arg  string
+		want []saveRule
+	}{
+		{"3600 1 300 100", []saveRule{{time.Hour, 1}, {5 * time.Minute, 100}}},
+		{"", []saveRule{}},
+		{"60", nil},
+		{"a 1", nil},
+		{"0 1", nil},
+		{"60 -1", nil},
+	} {
+		got, err := parseSaveRules(tc.arg)
+		if !reflect.DeepEqual(got, tc.want) || (err == nil) != (tc.want != nil) {
+			t.Errorf("parseSaveRules(%q) = %v, %v, want %v", tc.arg, got, err, tc.want)
+		}
+	}
+
+	srv := newSnapshotServer(t)
+	srv.cfg.SaveRules = []saveRule{{time.Minute, 2}, {time.Hour, 1}}
+	c := newClient(nil, srv)
+	start := time.Now()
+	do(c, "SET", "a", "1")
+	if srv.checkSaveRules(start.Add(2 * time.Minute)) {
+		t.Error("saved after one change before an hour")
+	}
+	do(c, "SET", "b", "2")
+	if srv.checkSaveRules(start) {
+		t.Error("saved two changes before a minute")
+	}
+	if !srv.checkSaveRules(start.Add(2 * time.Minute)) {
+		t.Fatal("did not save two changes after a minute")
+	}
+	srv.rdb.done.Wait()
+	if _, err := os.Stat(srv.cfg.dbPath()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.checkSaveRules(start.Add(2 * time.Hour)) {
+		t.Error("saved again without changes")
+	}
+
+	// After a failure, saving is only tried again after a delay.
+	srv.cfg.Dir = filepath.Join(t.TempDir(), "missing")
+	do(c, "SET", "c", "3")
+	if !srv.checkSaveRules(start.Add(2 * time.Hour)) {
+		t.Fatal("did not save one change after an hour")
+	}
+	srv.rdb.done.Wait()
+	tried := srv.rdb.lastBgsaveTry
+	if srv.checkSaveRules(tried.Add(time.Second)) {
+		t.Error("saved again right after a failure")
+	}
+	if !srv.checkSaveRules(tried.Add(bgsaveRetryDelay + time.Hour)) {
+		t.Error("did not try again after the delay")
+	}
+	srv.rdb.done.Wait()
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// This file implements master-replica replication, in the protocol of
+// Redis, so either side can be a Redis server. REPLICAOF makes the server
+// a replica: it connects to the master, asks for the dataset with PSYNC,
+// and the master replies with a snapshot of it in the RDB format, written
+// from a view of the store while writes go on, then streams the writes
+// made since, as they are logged to the AOF. The replica loads the
+// snapshot in place of its own data and runs the writes it receives,
+// refusing those of other clients.
+//
+// Only full resynchronisations are supported: a replica whose connection
+// drops reconnects and loads the whole dataset again. A replica hides the
+// keys whose TTL passed from its clients, but keeps them for the writes
+// of its master until the master's DEL for them, as the AOF does.
+
+func init() {
+	// These take the store lock themselves, like SAVE.
+	RegisterCommand(&Command{Name: "replicaof", Arity: 3, Flags: flagAdmin | flagNoScript | flagNoMulti | flagStale, Handler: replicaofCommand})
+	RegisterCommand(&Command{Name: "slaveof", Arity: 3, Flags: flagAdmin | flagNoScript | flagNoMulti | flagStale, Handler: replicaofCommand})
+	RegisterCommand(&Command{Name: "replconf", Arity: -1, Flags: flagAdmin | flagNoScript | flagLoading | flagStale, Handler: replconfCommand})
+	RegisterCommand(&Command{Name: "psync", Arity: -3, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: syncCommand})
+	RegisterCommand(&Command{Name: "sync", Arity: 1, Flags: flagAdmin | flagNoScript | flagNoMulti, Handler: syncCommand})
+}
+
+// replRetryDelay is how long a replica waits before connecting again after
+// the link failed.
+const replRetryDelay = time.Second
+
+// replication is the replication state of a server: its replicas, and its
+// master when it is a replica itself.
+type replication struct {
+	mu sync.Mutex
+	// id names the history of the dataset, and offset counts the bytes of
+	// writes sent to replicas in it, as Redis reports them; on a replica,
+	// those received from its master.
+	id     string
+	offset int64
+	// replicas holds the connected replicas, and buf is reused to encode
+	// the writes fed to them.
+	replicas map[*Client]*replica
+	buf      []byte
+	// master is the link to the master, nil unless the server is a
+	// replica. isReplica mirrors it, so checking the role of the server
+	// on every write does not take mu.
+	master    *masterLink
+	isReplica atomic.Bool
+	// ackWaiters lists the clients blocked in WAIT.
+	ackWaiters []*ackWaiter
+}
+
+// ackWaiter is a client blocked in WAIT until numreplicas replicas have
+// acknowledged offset.
+type ackWaiter struct {
+	w           *waiter
+	offset      int64
+	numreplicas int
+}
+
+// replica is the master's side of a replica's connection.
+type replica struct {
+	// pending collects the writes fed while the snapshot is being sent,
+	// until synced is set; from then on, they are sent as they come.
+	pending []byte
+	synced  bool
+	// ack is the last offset the replica acknowledged with REPLCONF ACK.
+	ack int64
+}
+
+func newReplication() *replication {
+	return &replication{id: newReplID(), replicas: make(map[*Client]*replica)}
+}
+
+// newReplID returns a random replication ID, 40 hexadecimal digits as in
+// Redis.
+func newReplID() string {
+	return fmt.Sprintf("%016x%016x%08x", rand.Uint64(), rand.Uint64(), rand.Uint32())
+}
+
+// readOnly reports whether c may not write, since the server is a replica
+// and c is not its master.
+func (r *replication) readOnly(c *Client) bool {
+	return r.isReplica.Load() && !c.master
+}
+
+// hasReplicas reports whether writes should be fed to replicas.
+func (r *replication) hasReplicas() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.replicas) > 0
+}
+
+// feed sends cmds to every replica, or keeps them for those that are
+// still receiving the snapshot, and returns the offset after them. The
+// caller must hold the store's mu for writing, so writes are sent in the
+// order they happened.
+func (r *replication) feed(cmds [][]string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.replicas) == 0 {
+		return r.offset
+	}
+	r.buf = r.buf[:0]
+	for _, args := range cmds {
+		r.buf = resp.AppendCommand(r.buf, args...)
+	}
+	if !r.isReplica.Load() {
+		r.offset += int64(len(r.buf))
+	}
+	for c, rep := range r.replicas {
+		if !rep.synced {
+			rep.pending = append(rep.pending, r.buf...)
+			continue
+		}
+		c.out.send(r.buf)
+	}
+	return r.offset
+}
+
+// acked returns how many replicas have acknowledged offset. The caller
+// must hold mu.
+func (r *replication) acked(offset int64) int {
+	n := 0
+	for _, rep := range r.replicas {
+		if rep.ack >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForAcks implements WAIT for c: it returns how many replicas have
+// acknowledged the last write of c, if at least numreplicas have or c
+// cannot block; otherwise it blocks c until they have or timeout passes,
+// and asks the replicas for their offset with REPLCONF GETACK. The caller
+// must hold the store's mu for writing.
+func (r *replication) waitForAcks(c *Client, args []string, numreplicas int, timeout time.Duration) resp.Value {
+	offset := c.woff
+	r.mu.Lock()
+	n := r.acked(offset)
+	r.mu.Unlock()
+	if n >= numreplicas || c.execing {
+		return resp.Integer(int64(n))
+	}
+	reply := c.block(args, nil, timeout, resp.Integer(0))
+	aw := &ackWaiter{w: c.blocked, offset: offset, numreplicas: numreplicas}
+	aw.w.onTimeout = func() resp.Value {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ackWaiters = slices.DeleteFunc(r.ackWaiters, func(other *ackWaiter) bool { return other == aw })
+		return resp.Integer(int64(r.acked(offset)))
+	}
+	r.mu.Lock()
+	r.ackWaiters = append(r.ackWaiters, aw)
+	r.mu.Unlock()
+	r.feed([][]string{{"REPLCONF", "GETACK", "*"}})
+	return reply
+}
+
+// ack records that the replica c has processed the writes up to offset,
+// and wakes the clients in WAIT that it was the last one missing for.
+func (r *replication) ack(c *Client, offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.replicas[c]
+	if !ok {
+		return
+	}
+	rep.ack = offset
+	r.ackWaiters = slices.DeleteFunc(r.ackWaiters, func(aw *ackWaiter) bool {
+		n := r.acked(aw.offset)
+		if n < aw.numreplicas {
+			return false
+		}
+		aw.w.reply <- resp.Integer(int64(n))
+		return true
+	})
+}
+
+// addReplica registers c as a replica, from the current offset, which it
+// returns with the replication ID. The caller must hold the store's mu
+// for reading at least, so no write is missed between taking the snapshot
+// sent to c and registering it.
+func (r *replication) addReplica(c *Client) (id string, offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replicas[c] = &replica{}
+	return r.id, r.offset
+}
+
+// removeReplica forgets c once it has disconnected.
+func (r *replication) removeReplica(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.replicas, c)
+}
+
+// dropReplicas disconnects every replica, when the dataset they follow
+// was replaced, so they reconnect and load the new one.
+func (r *replication) dropReplicas() {
+	r.mu.Lock()
+	replicas := r.replicas
+	r.replicas = make(map[*Client]*replica)
+	r.mu.Unlock()
+	for c := range replicas {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}
+}
+
+// syncCommand implements SYNC and PSYNC replid offset, with which a
+// replica asks for the dataset. A partial resynchronisation is never
+// possible, so PSYNC replies +FULLRESYNC with the replication ID and
+// offset, then, like SYNC, the snapshot follows as a bulk string without
+// the final CRLF, and the writes made since. The connection stays a
+// replica's from then on.
+func syncCommand(c *Client, args []string) resp.Value {
+	r := c.srv.repl
+	if r.isReplica.Load() && !r.linkUp() {
+		return resp.Error("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	r.mu.Lock()
+	_, already := r.replicas[c]
+	r.mu.Unlock()
+	if already {
+		return noReply
+	}
+
+	c.store.mu.RLock()
+	snap := c.store.snapshot(true)
+	id, offset := r.addReplica(c)
+	c.store.mu.RUnlock()
+	log.Printf("Replica %s asks for synchronization", c.addr())
+	if strings.EqualFold(args[0], "PSYNC") {
+		c.out.write(resp.SimpleString(fmt.Sprintf("FULLRESYNC %s %d", id, offset)), c.proto)
+	}
+	go c.srv.sendSnapshot(c, snap)
+	return noReply
+}
+
+// sendSnapshot sends snap to the replica c, then the writes kept for it
+// meanwhile, after which writes are sent to it as they are fed. While the
+// snapshot is written, a newline is sent every second, so the replica
+// does not time out.
+func (srv *Server) sendSnapshot(c *Client, snap *snapshot) {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.out.send([]byte("\n"))
+			}
+		}
+	}()
+	var buf bytes.Buffer
+	snap.compress, snap.checksum = srv.cfg.RDBCompression, srv.cfg.RDBChecksum
+	err := snap.write(&buf)
+	snap.close()
+	close(stop)
+	<-stopped
+	if err != nil {
+		log.Printf("Can't send the dataset to replica %s: %v", c.addr(), err)
+		srv.repl.removeReplica(c)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		return
+	}
+	c.out.send(append(fmt.Appendf(nil, "$%d\r\n", buf.Len()), buf.Bytes()...))
+
+	r := srv.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.replicas[c]
+	if !ok {
+		return
+	}
+	c.out.send(rep.pending)
+	rep.pending, rep.synced = nil, true
+	log.Printf("Synchronization with replica %s succeeded", c.addr())
+}
+
+// replconfCommand implements REPLCONF option value [option value ...],
+// with which a replica tells about itself during the handshake. ACK, the
+// offset a replica has processed, gets no reply.
+func replconfCommand(c *Client, args []string) resp.Value {
+	if len(args)%2 == 0 {
+		return syntaxErrorReply
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			c.replicaPort = args[i+1]
+		case "ack":
+			if offset, ok := parseInt(args[i+1]); ok {
+				c.srv.repl.ack(c, offset)
+			}
+			return noReply
+		case "capa", "ip-address", "getack", "rdb-only", "rdb-filter-only":
+		default:
+			return resp.Errorf("ERR Unrecognized REPLCONF option: %s", args[i])
+		}
+	}
+	return resp.OK
+}
+
+// replicaofCommand implements REPLICAOF host port, which makes the server
+// a replica of the given master, dropping its data for the master's once
+// connected, and REPLICAOF NO ONE, which makes it a master again, keeping
+// its data. SLAVEOF is the same.
+func replicaofCommand(c *Client, args []string) resp.Value {
+	if strings.EqualFold(args[1], "NO") && strings.EqualFold(args[2], "ONE") {
+		if c.srv.stopReplication() {
+			log.Printf("MASTER MODE enabled (user request from '%s')", c.addr())
+		}
+		return resp.OK
+	}
+	if port, ok := parseInt(args[2]); !ok || port < 0 || port > 65535 {
+		return notIntegerReply
+	}
+	if !c.srv.replicaOf(args[1], args[2]) {
+		return resp.SimpleString("OK Already connected to specified master")
+	}
+	log.Printf("REPLICAOF %s:%s enabled (user request from '%s')", args[1], args[2], c.addr())
+	return resp.OK
+}
+
+// masterLink is a replica's link to its master, which connects to it
+// again whenever the connection fails, until it is closed.
+type masterLink struct {
+	host, port string
+	// stop is closed by close, and done once the link has returned.
+	stop, done chan struct{}
+
+	mu sync.Mutex
+	// conn is the connection to the master, nil while there is none, and
+	// closed is set once the link is closed.
+	conn   net.Conn
+	closed bool
+	// up is set once the dataset is loaded.
+	up bool
+}
+
+// replicaOf makes the server a replica of the master at host and port,
+// replacing the link to its current master, and reports whether it did;
+// it does not if that is its master already. Its replicas are dropped,
+// as the data they have is not the new master's.
+func (srv *Server) replicaOf(host, port string) bool {
+	r := srv.repl
+	r.mu.Lock()
+	old := r.master
+	if old != nil && old.host == host && old.port == port {
+		r.mu.Unlock()
+		return false
+	}
+	l := &masterLink{host: host, port: port, stop: make(chan struct{}), done: make(chan struct{})}
+	r.master = l
+	r.isReplica.Store(true)
+	r.mu.Unlock()
+	srv.updateStoreRole()
+	if old != nil {
+		old.close()
+	}
+	r.dropReplicas()
+	go srv.followMaster(l)
+	return true
+}
+
+// stopReplication makes the server a master again and reports whether it
+// was a replica. As its data may diverge from its old master's from now
+// on, it gets a new replication ID.
+func (srv *Server) stopReplication() bool {
+	r := srv.repl
+	r.mu.Lock()
+	l := r.master
+	r.master = nil
+	r.isReplica.Store(false)
+	if l != nil {
+		r.id = newReplID()
+	}
+	r.mu.Unlock()
+	if l == nil {
+		return false
+	}
+	srv.updateStoreRole()
+	l.close()
+	return true
+}
+
+// updateStoreRole tells the store whether the server is a replica, whose
+// keys expire when the master's DELs say so rather than by the janitor.
+// It takes the store's lock, so it must be called without the lock of
+// the replication state.
+func (srv *Server) updateStoreRole() {
+	srv.store.mu.Lock()
+	defer srv.store.mu.Unlock()
+	srv.store.replica = srv.repl.isReplica.Load()
+}
+
+// linkUp reports whether the server is a replica that has loaded the
+// dataset of its master and follows its writes.
+func (r *replication) linkUp() bool {
+	r.mu.Lock()
+	l := r.master
+	r.mu.Unlock()
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.up
+}
+
+// close stops the link and waits for it to return.
+func (l *masterLink) close() {
+	l.mu.Lock()
+	l.closed = true
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.mu.Unlock()
+	close(l.stop)
+	<-l.done
+}
+
+// attach records conn as the connection to the master, unless the link
+// was closed meanwhile.
+func (l *masterLink) attach(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+// detach forgets the connection, once it failed, and reports whether the
+// link is closed.
+func (l *masterLink) detach() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conn, l.up = nil, false
+	return l.closed
+}
+
+// followMaster keeps the server in sync with the master of l, connecting
+// to it again replRetryDelay after each failure, until l is closed.
+func (srv *Server) followMaster(l *masterLink) {
+	defer close(l.done)
+	for {
+		log.Printf("Connecting to MASTER %s:%s", l.host, l.port)
+		err := srv.syncWithMaster(l)
+		if l.detach() {
+			return
+		}
+		log.Printf("Connection with master lost: %v", err)
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(replRetryDelay):
+		}
+	}
+}
+
+// errLinkClosed is returned by syncWithMaster once its link is closed.
+var errLinkClosed = errors.New("link closed")
+
+// syncWithMaster connects to the master of l, loads its dataset in place
+// of the store's, then runs the writes it streams, until the connection
+// fails or no data comes from the master for repl-timeout, which sends
+// PINGs to prevent that.
+func (srv *Server) syncWithMaster(l *masterLink) error {
+	timeout := time.Duration(srv.cfg.ReplTimeout) * time.Second
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(l.host, l.port), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !l.attach(conn) {
+		return errLinkClosed
+	}
+	in := &countingReader{r: deadlineReader{conn, timeout}}
+	br := bufio.NewReader(in)
+
+	// request sends a command of the handshake and returns the reply, a
+	// single line.
+	request := func(args ...string) (string, error) {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(resp.AppendCommand(nil, args...)); err != nil {
+			return "", err
+		}
+		return readReplLine(br)
+	}
+	reply, err := request("PING")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(reply, "-") {
+		return fmt.Errorf("error reply to PING from master: %s", reply)
+	}
+	_, port, _ := net.SplitHostPort(srv.cfg.Addr)
+	for _, args := range [][]string{{"REPLCONF", "listening-port", port}, {"REPLCONF", "capa", "psync2"}} {
+		// Errors are ignored, as Redis does, for masters that do not know
+		// an option.
+		if _, err := request(args...); err != nil {
+			return err
+		}
+	}
+	reply, err = request("PSYNC", "?", "-1")
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC from master: %s", reply)
+	}
+	id := fields[1]
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected reply to PSYNC from master: %s", reply)
+	}
+	log.Printf("Full resync from master: %s:%d", id, offset)
+
+	// The master may send newlines to keep the connection alive while it
+	// prepares the snapshot.
+	var line string
+	for line == "" {
+		if line, err = readReplLine(br); err != nil {
+			return err
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(line, "$"), 10, 64)
+	if line[0] != '$' || err != nil || size < 0 {
+		return fmt.Errorf("bad protocol from MASTER, the first byte is not '$': %s", line)
+	}
+	dump := make([]byte, size)
+	if _, err := io.ReadFull(br, dump); err != nil {
+		return fmt.Errorf("reading the dataset from master: %w", err)
+	}
+	if err := srv.loadFromMaster(dump); err != nil {
+		return fmt.Errorf("loading the dataset from master: %w", err)
+	}
+
+	r := srv.repl
+	r.mu.Lock()
+	r.id, r.offset = id, offset
+	r.mu.Unlock()
+	start := in.n - int64(br.Buffered())
+	l.mu.Lock()
+	l.up = true
+	l.mu.Unlock()
+	log.Print("MASTER <-> REPLICA sync: Finished with success")
+
+	c := newClient(nil, srv)
+	c.master = true
+	defer srv.removeClient(c)
+	cr := resp.NewReader(br)
+	for {
+		args, err := cr.ReadCommand()
+		if err != nil {
+			return err
+		}
+		// cr reads from br itself, so what it has not consumed yet is in
+		// br's buffer.
+		processed := offset + in.n - int64(br.Buffered()) - start
+		switch {
+		case len(args) > 1 && strings.EqualFold(args[0], "REPLCONF") && strings.EqualFold(args[1], "GETACK"):
+			// The acknowledged offset is that of the commands before.
+			r.mu.Lock()
+			acked := r.offset
+			r.mu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := conn.Write(resp.AppendCommand(nil, "REPLCONF", "ACK", strconv.FormatInt(acked, 10))); err != nil {
+				return err
+			}
+		case len(args) > 0:
+			// Like the commands of the AOF, they must not block, as they
+			// were served on the master already.
+			c.execing = true
+			c.execute(args)
+		}
+		r.mu.Lock()
+		r.offset = processed
+		r.mu.Unlock()
+	}
+}
+
+// deadlineReader reads from conn, failing once it has waited timeout for
+// data, so a master gone without closing the connection is noticed.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
+
+// startReplicaPings sends a PING to the replicas every
+// repl-ping-replica-period, so they can tell a master with nothing to send
+// from one that went away.
+func (srv *Server) startReplicaPings() {
+	if srv.cfg.ReplPingReplicaPeriod <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(srv.cfg.ReplPingReplicaPeriod) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !srv.repl.hasReplicas() {
+				continue
+			}
+			srv.store.mu.Lock()
+			srv.repl.feed([][]string{{"PING"}})
+			srv.store.mu.Unlock()
+		}
+	}()
+}
+
+// readReplLine reads a line of the replication protocol, without its
+// terminator.
+func readReplLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// loadFromMaster replaces the store's data and functions with the
+// snapshot dump sent by the master. The server's replicas are dropped to
+// load it too, and the AOF, if on, is rewritten from it.
+func (srv *Server) loadFromMaster(dump []byte) error {
+	srv.store.mu.Lock()
+	defer srv.store.mu.Unlock()
+	lazyfree.free(srv.store.flush())
+	srv.store.functions.flush()
+	srv.store.loading = true
+	err := srv.store.readSnapshot(bytes.NewReader(dump))
+	srv.store.loading = false
+	if err != nil {
+		return err
+	}
+	log.Printf("MASTER <-> REPLICA sync: Loaded %d keys", len(srv.store.data))
+	srv.repl.dropReplicas()
+	if srv.aof != nil {
+		if err := srv.bgrewriteaof(); err != nil {
+			log.Printf("Can't rewrite the append only file after loading the dataset from master: %v", err)
+		}
+	}
+	return nil
+}
+
+// info writes the replication section of INFO.
+func (r *replication) info(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if l := r.master; l != nil {
+		l.mu.Lock()
+		status := "down"
+		if l.up {
+			status = "up"
+		}
+		l.mu.Unlock()
+		fmt.Fprint(w, "role:slave\r\n")
+		fmt.Fprintf(w, "master_host:%s\r\n", l.host)
+		fmt.Fprintf(w, "master_port:%s\r\n", l.port)
+		fmt.Fprintf(w, "master_link_status:%s\r\n", status)
+		fmt.Fprintf(w, "slave_repl_offset:%d\r\n", r.offset)
+	} else {
+		fmt.Fprint(w, "role:master\r\n")
+	}
+	fmt.Fprintf(w, "connected_slaves:%d\r\n", len(r.replicas))
+	i := 0
+	for c, rep := range r.replicas {
+		ip, _, _ := net.SplitHostPort(c.addr())
+		state := "wait_bgsave"
+		if rep.synced {
+			state = "online"
+		}
+		fmt.Fprintf(w, "slave%d:ip=%s,port=%s,state=%s,offset=%d,lag=0\r\n", i, ip, c.replicaPort, state, rep.ack)
+		i++
+	}
+	fmt.Fprintf(w, "master_replid:%s\r\n", r.id)
+	fmt.Fprintf(w, "master_repl_offset:%d\r\n", r.offset)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// infoField returns the value of field in the reply to INFO info.
+func infoField(info, field string) string {
+	for _, line := range strings.Split(info, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestReplication(t *testing.T) {
+	master := newSnapshotServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go master.Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	mc := newClient(nil, master)
+	for _, args := range [][]string{
+		{"SET", "str", "v"},
+		{"RPUSH", "list", "a", "b"},
+		{"HSET", "hash", "f", "1"},
+		{"FUNCTION", "LOAD", "#!lua name=lib\nredis.register_function('hi', function() return 'hi' end)"},
+	} {
+		do(mc, args...)
+	}
+
+	replica := newSnapshotServer(t)
+	rc := newClient(nil, replica)
+	do(rc, "SET", "stale", "x")
+	expectReply(t, rc, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"REPLICAOF", host, "port"}, notIntegerReply},
+		{[]string{"REPLICAOF", host, port}, resp.OK},
+		{[]string{"REPLICAOF", host, port}, resp.SimpleString("OK Already connected to specified master")},
+	})
+	info := func(c *Client) string { return do(c, "INFO", "replication").Str }
+	waitFor(t, func() bool {
+		return strings.Contains(info(rc), "master_link_status:up\r\n")
+	})
+
+	// The replica has the master's data in place of its own, and gets its
+	// writes from then on, transactions and TTLs included.
+	for _, args := range [][]string{
+		{"SET", "later", "1"},
+		{"INCR", "later"},
+		{"MULTI"},
+		{"RPUSH", "list", "c"},
+		{"DEL", "hash"},
+		{"EXEC"},
+		{"SET", "ttl", "v", "EX", "100"},
+		{"SET", "done", "1"},
+	} {
+		do(mc, args...)
+	}
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "EXISTS", "done"), resp.Integer(1))
+	})
+	for _, args := range [][]string{
+		{"GET", "str"},
+		{"GET", "later"},
+		{"GET", "stale"},
+		{"LRANGE", "list", "0", "-1"},
+		{"EXISTS", "hash"},
+		{"PEXPIRETIME", "ttl"},
+		{"FCALL", "hi", "0"},
+	} {
+		if got, want := do(rc, args...), do(mc, args...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q on the replica = %+v, on the master %+v", args, got, want)
+		}
+	}
+	waitFor(t, func() bool {
+		got := infoField(info(rc), "slave_repl_offset")
+		return got != "0" && got == infoField(info(mc), "master_repl_offset")
+	})
+	// WAIT counts the replica once it acknowledges the writes, and
+	// replies with the count so far when it times out.
+	expectReply(t, mc, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"WAIT", "1", "5000"}, resp.Integer(1)},
+		{[]string{"WAIT", "0", "0"}, resp.Integer(1)},
+		{[]string{"WAIT", "2", "100"}, resp.Integer(1)},
+	})
+	if got := info(mc); !strings.Contains(got, "role:master\r\nconnected_slaves:1\r\nslave0:ip=127.0.0.1,") || !strings.Contains(got, ",state=online,") {
+		t.Errorf("INFO replication on the master:\n%s", got)
+	}
+	if got := info(rc); !strings.HasPrefix(got, "txt:# Replication\r\nrole:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:"+port+"\r\n") {
+		t.Errorf("INFO replication on the replica:\n%s", got)
+	}
+
+	// Its clients cannot write, even from scripts.
+	expectReply(t, rc, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "str", "w"}, errReadOnlyReplica},
+		{[]string{"EVAL", "return redis.pcall('SET', 'str', 'w')", "0"}, errReadOnlyReplica},
+		{[]string{"WAIT", "0", "0"}, resp.Error("ERR WAIT cannot be used with replica instances.")},
+		{[]string{"GET", "str"}, resp.BulkString("v")},
+	})
+
+	// Once promoted, it keeps the data and stops following the master.
+	expectReply(t, rc, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"REPLICAOF", "no", "one"}, resp.OK},
+		{[]string{"SET", "str", "w"}, resp.OK},
+		{[]string{"GET", "later"}, resp.BulkString("2")},
+	})
+	waitFor(t, func() bool {
+		return strings.Contains(info(mc), "connected_slaves:0\r\n")
+	})
+	do(mc, "SET", "after", "1")
+	if got := do(rc, "EXISTS", "after"); !reflect.DeepEqual(got, resp.Integer(0)) {
+		t.Errorf("a write after REPLICAOF NO ONE reached the old replica")
+	}
+	if !strings.HasPrefix(info(rc), "txt:# Replication\r\nrole:master\r\n") {
+		t.Errorf("INFO replication after REPLICAOF NO ONE:\n%s", info(rc))
+	}
+}
+
+func TestReplicationMigrate(t *testing.T) {
+	master := newSnapshotServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go master.Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	target := NewServer(defaultConfig())
+	tln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tln.Close()
+	go target.Serve(tln)
+	thost, tport, _ := net.SplitHostPort(tln.Addr().String())
+
+	mc := newClient(nil, master)
+	rc := newClient(nil, newSnapshotServer(t))
+	do(rc, "REPLICAOF", host, port)
+	defer do(rc, "REPLICAOF", "NO", "ONE")
+	do(mc, "MSET", "mk1", "1", "mk2", "2", "mk3", "3")
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "EXISTS", "mk1", "mk2", "mk3"), resp.Integer(3))
+	})
+
+	// The keys moved by a MIGRATE of several are deleted on the replica.
+	if got := do(mc, "MIGRATE", thost, tport, "", "0", "1000", "KEYS", "mk1", "mk2"); !reflect.DeepEqual(got, resp.OK) {
+		t.Fatalf("MIGRATE = %+v", got)
+	}
+	do(mc, "SET", "done", "1")
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "EXISTS", "done"), resp.Integer(1))
+	})
+	if got := do(rc, "EXISTS", "mk1", "mk2", "mk3"); !reflect.DeepEqual(got, resp.Integer(1)) {
+		t.Errorf("EXISTS of the keys on the replica = %+v, want 1", got)
+	}
+}
+
+func TestReplicationReconnects(t *testing.T) {
+	master := newSnapshotServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go master.Serve(ln)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	mc := newClient(nil, master)
+	do(mc, "SET", "k", "1")
+
+	rc := newClient(nil, newSnapshotServer(t))
+	do(rc, "REPLICAOF", host, port)
+	defer do(rc, "REPLICAOF", "NO", "ONE")
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "GET", "k"), resp.BulkString("1"))
+	})
+
+	// When the connection drops, the replica syncs again, after a pause.
+	master.repl.dropReplicas()
+	do(mc, "SET", "k", "2")
+	time.Sleep(replRetryDelay)
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "GET", "k"), resp.BulkString("2"))
+	})
+}
+
+// fakeMaster accepts the connection of a replica on ln and answers its
+// handshake with an empty dataset, returning the connection and a reader
+// of what the replica sends.
+func fakeMaster(t *testing.T, ln net.Listener) (net.Conn, *resp.Reader) {
+	t.Helper()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	r := resp.NewReader(conn)
+	for _, reply := range []string{"+PONG", "+OK", "+OK", "+FULLRESYNC 0123456789012345678901234567890123456789 0"} {
+		if _, err := r.ReadCommand(); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "%s\r\n", reply)
+	}
+	var rdb bytes.Buffer
+	if err := NewStore().snapshot(false).write(&rdb); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "$%d\r\n%s", rdb.Len(), rdb.Bytes())
+	return conn, r
+}
+
+func TestReplicationOffset(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	rc := newClient(nil, newSnapshotServer(t))
+	do(rc, "REPLICAOF", host, port)
+	defer do(rc, "REPLICAOF", "NO", "ONE")
+	conn, r := fakeMaster(t, ln)
+
+	// A transaction and the GETACK after it arrive in one packet; the
+	// acknowledgement counts every command before the GETACK.
+	var batch []byte
+	for _, args := range [][]string{{"MULTI"}, {"SET", "a", "1"}, {"INCR", "a"}, {"EXEC"}} {
+		batch = resp.AppendCommand(batch, args...)
+	}
+	getack := resp.AppendCommand(nil, "REPLCONF", "GETACK", "*")
+	if _, err := conn.Write(append(slices.Clone(batch), getack...)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	ack, err := r.ReadCommand()
+	if want := []string{"REPLCONF", "ACK", strconv.Itoa(len(batch))}; err != nil || !reflect.DeepEqual(ack, want) {
+		t.Fatalf("replica sent %q, %v, want %q", ack, err, want)
+	}
+	if got := do(rc, "GET", "a"); !reflect.DeepEqual(got, resp.BulkString("2")) {
+		t.Errorf("GET a = %+v after the acknowledgement, want 2", got)
+	}
+	waitFor(t, func() bool {
+		return infoField(do(rc, "INFO", "replication").Str, "slave_repl_offset") == strconv.Itoa(len(batch)+len(getack))
+	})
+}
+
+func TestReplicationTimeout(t *testing.T) {
+	master := newSnapshotServer(t)
+	master.cfg.ReplPingReplicaPeriod = 1
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go master.Serve(ln)
+	mc := newClient(nil, master)
+
+	// The replica connects through a proxy, whose connections can be
+	// silenced as if the master had gone without closing them.
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	silenced := make(chan *atomic.Bool, 2)
+	var mu sync.Mutex
+	var conns []net.Conn
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			up, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				conn.Close()
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn, up)
+			mu.Unlock()
+			var silent atomic.Bool
+			pipe := func(dst, src net.Conn) {
+				buf := make([]byte, 4096)
+				for {
+					n, err := src.Read(buf)
+					if err != nil {
+						return
+					}
+					if !silent.Load() {
+						dst.Write(buf[:n])
+					}
+				}
+			}
+			go pipe(up, conn)
+			go pipe(conn, up)
+			silenced <- &silent
+		}
+	}()
+
+	replica := newSnapshotServer(t)
+	replica.cfg.ReplTimeout = 2
+	rc := newClient(nil, replica)
+	host, port, _ := net.SplitHostPort(proxy.Addr().String())
+	do(rc, "REPLICAOF", host, port)
+	defer do(rc, "REPLICAOF", "NO", "ONE")
+	info := func(c *Client, field string) string { return infoField(do(c, "INFO", "replication").Str, field) }
+	waitFor(t, func() bool { return info(rc, "master_link_status") == "up" })
+	first := <-silenced
+
+	// With nothing to replicate, the master's PINGs keep the link up.
+	offset := info(mc, "master_repl_offset")
+	time.Sleep(2500 * time.Millisecond)
+	if got := info(rc, "master_link_status"); got != "up" {
+		t.Fatalf("master_link_status = %s on an idle link", got)
+	}
+	if got := info(mc, "master_repl_offset"); got == offset {
+		t.Errorf("master_repl_offset stayed at %s without PINGs", got)
+	}
+
+	// Once the master falls silent, the replica drops the link and syncs
+	// again.
+	first.Store(true)
+	time.Sleep(time.Duration(replica.cfg.ReplTimeout) * time.Second)
+	waitFor(t, func() bool { return info(rc, "master_link_status") == "down" })
+	do(mc, "SET", "k", "v")
+	time.Sleep(replRetryDelay)
+	waitFor(t, func() bool {
+		return reflect.DeepEqual(do(rc, "GET", "k"), resp.BulkString("v"))
+	})
+}
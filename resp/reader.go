@@ -0,0 +1,430 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ProtocolError is returned when the peer sent something that is not valid
+// RESP. The stream cannot be resynchronised after it.
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// IsProtocolError reports whether err was caused by malformed input.
+func IsProtocolError(err error) bool {
+	var perr ProtocolError
+	return errors.As(err, &perr)
+}
+
+// Limits bound the size of a request so a misbehaving client cannot make
+// the reader buffer unbounded amounts of memory. A zero field disables that
+// check.
+type Limits struct {
+	// InlineMaxSize caps inline request lines and multi-bulk header lines.
+	InlineMaxSize int
+	// MaxBulkLen caps the length of each bulk string argument.
+	MaxBulkLen int
+	// MaxArgs caps the number of arguments in a multi-bulk request.
+	MaxArgs int
+}
+
+// bulkPreallocLimit is the largest bulk string that is allocated in one go
+// from its declared length alone. Longer ones grow as their data arrives.
+const bulkPreallocLimit = 64 * 1024
+
+// Reader reads RESP values and requests from a stream.
+type Reader struct {
+	rd  *bufio.Reader
+	lim Limits
+}
+
+// NewReader returns a Reader reading from rd without any limits.
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(rd)}
+}
+
+// NewReaderSize is like NewReader but uses a read buffer of size bytes.
+func NewReaderSize(rd io.Reader, size int) *Reader {
+	return &Reader{rd: bufio.NewReaderSize(rd, size)}
+}
+
+// SetLimits sets the limits applied by ReadCommand.
+func (r *Reader) SetLimits(lim Limits) {
+	r.lim = lim
+}
+
+// Buffered returns the number of bytes already read from the stream but
+// not yet consumed. Servers use it to detect the end of a pipelined batch.
+func (r *Reader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// Wait blocks until there is input to read or reading fails, and returns
+// the error if it did. The input is left buffered. Servers use it to notice
+// a client closing the connection while its request is parked.
+func (r *Reader) Wait() error {
+	_, err := r.rd.Peek(1)
+	return err
+}
+
+// readLine reads a line terminated by "\r\n" (or a bare "\n") and returns it
+// without the terminator. Once the line grows beyond max bytes it fails with
+// a protocol error, so the peer cannot make the reader buffer an unbounded
+// line. A max of 0 disables the check.
+func (r *Reader) readLine(max int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		line = append(line, chunk...)
+		if max > 0 && len(line) > max {
+			return "", ProtocolError("too big inline request")
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return string(line), nil
+}
+
+// readBulk reads a bulk payload of size bytes plus its CRLF.
+func (r *Reader) readBulk(size int) (string, error) {
+	var buf bytes.Buffer
+	if size <= bulkPreallocLimit {
+		buf.Grow(size + 2)
+	}
+	if _, err := io.CopyN(&buf, r.rd, int64(size)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	data := buf.Bytes()
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "", ProtocolError("bulk string not terminated by CRLF")
+	}
+	return string(data[:size]), nil
+}
+
+// ReadValue reads one RESP2 or RESP3 value of any type. RESP3 streamed
+// strings and aggregates are reassembled into a single value, and attributes
+// preceding a value are skipped.
+func (r *Reader) ReadValue() (Value, error) {
+	for {
+		v, err := r.readValue()
+		if err == errStreamEnd {
+			return Value{}, ProtocolError("unexpected end of streamed aggregate")
+		}
+		if err != nil || v.Type != TypeAttribute {
+			return v, err
+		}
+	}
+}
+
+// errStreamEnd is returned by readValue for the "." terminator of a
+// streamed aggregate.
+var errStreamEnd = errors.New("end of streamed aggregate")
+
+func (r *Reader) readValue() (Value, error) {
+	line, err := r.readLine(0)
+	if err != nil {
+		return Value{}, err
+	}
+	if line == "" {
+		return Value{}, ProtocolError("empty type line")
+	}
+
+	typ, payload := Type(line[0]), line[1:]
+	switch typ {
+	case TypeSimpleString, TypeError, TypeBigNumber:
+		return Value{Type: typ, Str: payload}, nil
+	case TypeInteger:
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return Value{}, ProtocolError("invalid integer")
+		}
+		return Value{Type: typ, Int: n}, nil
+	case TypeNull:
+		return Value{Type: typ}, nil
+	case TypeBoolean:
+		switch payload {
+		case "t":
+			return Value{Type: typ, Int: 1}, nil
+		case "f":
+			return Value{Type: typ, Int: 0}, nil
+		}
+		return Value{}, ProtocolError("invalid boolean")
+	case TypeDouble:
+		f, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return Value{}, ProtocolError("invalid double")
+		}
+		return Value{Type: typ, Float: f}, nil
+	case TypeBulkString, TypeBulkError, TypeVerbatim:
+		if payload == "?" {
+			return r.readStreamedString(typ)
+		}
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 {
+			return Value{}, ProtocolError("invalid bulk length")
+		}
+		if n == -1 {
+			return Value{Type: typ, Null: true}, nil
+		}
+		str, err := r.readBulk(n)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Type: typ, Str: str}, nil
+	case TypeArray, TypeSet, TypePush, TypeMap, TypeAttribute:
+		if payload == "?" {
+			return r.readStreamedAggregate(typ)
+		}
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 {
+			return Value{}, ProtocolError("invalid multibulk length")
+		}
+		if n == -1 {
+			return Value{Type: typ, Null: true}, nil
+		}
+		if typ == TypeMap || typ == TypeAttribute {
+			n *= 2
+		}
+		elems := make([]Value, 0, min(n, 1024))
+		for range n {
+			elem, err := r.ReadValue()
+			if err != nil {
+				return Value{}, err
+			}
+			elems = append(elems, elem)
+		}
+		return Value{Type: typ, Array: elems}, nil
+	case '.':
+		return Value{}, errStreamEnd
+	default:
+		return Value{}, ProtocolError(fmt.Sprintf("unknown type byte '%c'", typ))
+	}
+}
+
+// readStreamedString reads the ";<len>" chunks of a RESP3 streamed string
+// up to the terminating ";0".
+func (r *Reader) readStreamedString(typ Type) (Value, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := r.readLine(0)
+		if err != nil {
+			return Value{}, err
+		}
+		if line == "" || line[0] != ';' {
+			return Value{}, ProtocolError("invalid streamed string chunk")
+		}
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return Value{}, ProtocolError("invalid streamed string chunk")
+		}
+		if n == 0 {
+			return Value{Type: typ, Str: buf.String()}, nil
+		}
+		chunk, err := r.readBulk(n)
+		if err != nil {
+			return Value{}, err
+		}
+		buf.WriteString(chunk)
+	}
+}
+
+// readStreamedAggregate reads the elements of a RESP3 streamed aggregate
+// up to the terminating ".".
+func (r *Reader) readStreamedAggregate(typ Type) (Value, error) {
+	elems := []Value{}
+	for {
+		elem, err := r.readValue()
+		if err == errStreamEnd {
+			if typ == TypeMap && len(elems)%2 != 0 {
+				return Value{}, ProtocolError("streamed map with odd number of elements")
+			}
+			return Value{Type: typ, Array: elems}, nil
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		if elem.Type == TypeAttribute {
+			continue
+		}
+		elems = append(elems, elem)
+	}
+}
+
+// ReadCommand reads the next client request. A request starting with '*' is
+// parsed as a multi-bulk array of bulk strings; anything else is treated as
+// an inline command, terminated by "\r\n" or "\n", and split with SplitArgs.
+// The framing is detected per request, so a client may mix both styles. A
+// request exceeding the reader's limits fails with a protocol error. An
+// empty request is returned as a nil slice.
+func (r *Reader) ReadCommand() ([]string, error) {
+	b, err := r.rd.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if Type(b[0]) != TypeArray {
+		line, err := r.readLine(r.lim.InlineMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		return SplitArgs(line)
+	}
+
+	line, err := r.readLine(r.lim.InlineMaxSize)
+	if IsProtocolError(err) {
+		return nil, ProtocolError("too big mbulk count string")
+	} else if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || (r.lim.MaxArgs > 0 && n > r.lim.MaxArgs) {
+		return nil, ProtocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([]string, 0, min(n, 1024))
+	for range n {
+		line, err := r.readLine(r.lim.InlineMaxSize)
+		if IsProtocolError(err) {
+			return nil, ProtocolError("too big bulk count string")
+		} else if err == io.EOF {
+			// The stream ended between two arguments of the request.
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if line == "" || Type(line[0]) != TypeBulkString {
+			return nil, ProtocolError(fmt.Sprintf("expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || (r.lim.MaxBulkLen > 0 && size > r.lim.MaxBulkLen) {
+			return nil, ProtocolError("invalid bulk length")
+		}
+
+		arg, err := r.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// SplitArgs splits an inline request into arguments. Arguments are separated
+// by whitespace and may be quoted: double quoted arguments understand the
+// escapes \n, \r, \t, \b, \a, \\, \" and \xHH, single quoted arguments only
+// \'. This matches the rules redis-cli uses, so values containing spaces or
+// binary bytes can be sent without RESP framing.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		switch line[i] {
+		case '"':
+			i++
+			for {
+				if i == len(line) {
+					return nil, ProtocolError("unbalanced quotes in request")
+				}
+				ch := line[i]
+				if ch == '"' {
+					i++
+					break
+				}
+				if ch == '\\' && i+1 < len(line) {
+					if line[i+1] == 'x' && i+3 < len(line) && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+						b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+						arg = append(arg, byte(b))
+						i += 4
+						continue
+					}
+					i++
+					switch line[i] {
+					case 'n':
+						ch = '\n'
+					case 'r':
+						ch = '\r'
+					case 't':
+						ch = '\t'
+					case 'b':
+						ch = '\b'
+					case 'a':
+						ch = '\a'
+					default:
+						ch = line[i]
+					}
+				}
+				arg = append(arg, ch)
+				i++
+			}
+		case '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, ProtocolError("unbalanced quotes in request")
+				}
+				ch := line[i]
+				if ch == '\'' {
+					i++
+					break
+				}
+				if ch == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					ch = '\''
+				}
+				arg = append(arg, ch)
+				i++
+			}
+		default:
+			for i < len(line) && !isSpace(line[i]) {
+				arg = append(arg, line[i])
+				i++
+			}
+			args = append(args, string(arg))
+			continue
+		}
+
+		// A closing quote must be followed by whitespace or the end of line.
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, ProtocolError("unbalanced quotes in request")
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\v' || ch == '\f'
+}
+
+func isHexDigit(ch byte) bool {
+	return ('0' <= ch && ch <= '9') || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
@@ -0,0 +1,165 @@
+package resp
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"PING\r\n", []string{"PING"}},
+		{"SET foo bar\n", []string{"SET", "foo", "bar"}},
+		{"*1\r\n$4\r\nPING\r\n", []string{"PING"}},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$6\r\nb a\r\nr\r\n", []string{"SET", "foo", "b a\r\nr"}},
+		{"*0\r\n", nil},
+	}
+
+	for _, tc := range tests {
+		got, err := NewReader(strings.NewReader(tc.input)).ReadCommand()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %q, got %q", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestReadCommandMixedFraming(t *testing.T) {
+	r := NewReader(strings.NewReader("PING\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\nGET k\r\n"))
+	want := [][]string{{"PING"}, {"ECHO", "hi"}, {"GET", "k"}}
+
+	for _, w := range want {
+		got, err := r.ReadCommand()
+		if err != nil || !reflect.DeepEqual(got, w) {
+			t.Errorf("expected %q, got %q, %v", w, got, err)
+		}
+	}
+	if _, err := r.ReadCommand(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadCommandProtocolError(t *testing.T) {
+	inputs := []string{
+		"*x\r\n",
+		"*1\r\n:1\r\n",
+		"*1\r\n$3\r\nfoobar\r\n",
+	}
+
+	for _, input := range inputs {
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		if !IsProtocolError(err) {
+			t.Errorf("%q: expected protocol error, got %v", input, err)
+		}
+	}
+}
+
+func TestReadCommandInlineMaxSize(t *testing.T) {
+	r := NewReaderSize(strings.NewReader("SET k "+strings.Repeat("v", 100)+"\r\nPING\r\n"), 16)
+	r.SetLimits(Limits{InlineMaxSize: 64})
+
+	if _, err := r.ReadCommand(); !IsProtocolError(err) {
+		t.Errorf("expected too big inline request error, got %v", err)
+	}
+
+	r = NewReaderSize(strings.NewReader("SET k "+strings.Repeat("v", 40)+"\r\n"), 16)
+	r.SetLimits(Limits{InlineMaxSize: 64})
+	args, err := r.ReadCommand()
+	if err != nil || len(args) != 3 {
+		t.Errorf("expected request under the limit to be accepted, got %q, %v", args, err)
+	}
+}
+
+func TestReadCommandLimits(t *testing.T) {
+	lim := Limits{InlineMaxSize: 64, MaxBulkLen: 8, MaxArgs: 2}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"*3\r\n$3\r\nGET\r\n$1\r\na\r\n$1\r\nb\r\n", "Protocol error: invalid multibulk length"},
+		{"*2\r\n$3\r\nGET\r\n$9\r\n123456789\r\n", "Protocol error: invalid bulk length"},
+		{"*2\r\n$3\r\nGET\r\n$-5\r\n", "Protocol error: invalid bulk length"},
+		{"*1\r\n:1\r\n", "Protocol error: expected '$', got ':'"},
+		{"*" + strings.Repeat("1", 100) + "\r\n", "Protocol error: too big mbulk count string"},
+	}
+
+	for _, tc := range tests {
+		r := NewReader(strings.NewReader(tc.input))
+		r.SetLimits(lim)
+		_, err := r.ReadCommand()
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: expected %q, got %v", tc.input, tc.want, err)
+		}
+	}
+
+	// A huge declared length must not be trusted before the data arrives.
+	_, err := NewReader(strings.NewReader("*1\r\n$100000000\r\nabc")).ReadCommand()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF for truncated bulk, got %v", err)
+	}
+	_, err = NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n")).ReadCommand()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF for a missing argument, got %v", err)
+	}
+}
+
+func TestReadValueStreamed(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"$?\r\n;4\r\nHell\r\n;2\r\no!\r\n;0\r\n", BulkString("Hello!")},
+		{"*?\r\n:1\r\n:2\r\n.\r\n", Array(Integer(1), Integer(2))},
+		{"%?\r\n+a\r\n:1\r\n.\r\n", Map(SimpleString("a"), Integer(1))},
+		{"|1\r\n+ttl\r\n:3600\r\n:42\r\n", Integer(42)},
+	}
+
+	for _, tc := range tests {
+		got, err := NewReader(strings.NewReader(tc.input)).ReadValue()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.input, tc.want, got)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{`SET key value`, []string{"SET", "key", "value"}},
+		{`  SET   key  "hello world" `, []string{"SET", "key", "hello world"}},
+		{`SET key "a\nb\x00\xff"`, []string{"SET", "key", "a\nb\x00\xff"}},
+		{`SET key 'it\'s' ""`, []string{"SET", "key", "it's", ""}},
+		{`SET key 'no \n escapes'`, []string{"SET", "key", `no \n escapes`}},
+	}
+
+	for _, tc := range tests {
+		got, err := SplitArgs(tc.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %q, got %q", tc.input, tc.want, got)
+		}
+	}
+
+	for _, input := range []string{`SET key "open`, `SET key 'open`, `SET key "a"b`} {
+		if _, err := SplitArgs(input); !IsProtocolError(err) {
+			t.Errorf("%q: expected unbalanced quotes error, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package resp implements the Redis serialization protocol, RESP2 and RESP3.
+//
+// Reader parses values and client requests from a stream, Writer serializes
+// values for a connection speaking either protocol version. Both are shared
+// by the server and by anything that needs to talk to it.
+package resp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Type is the prefix byte identifying a RESP value's type.
+type Type byte
+
+// RESP2 types.
+const (
+	TypeSimpleString Type = '+'
+	TypeError        Type = '-'
+	TypeInteger      Type = ':'
+	TypeBulkString   Type = '$'
+	TypeArray        Type = '*'
+)
+
+// RESP3 types. When writing for a RESP2 connection these are downgraded to
+// the closest RESP2 type.
+const (
+	TypeNull      Type = '_'
+	TypeBoolean   Type = '#'
+	TypeDouble    Type = ','
+	TypeBigNumber Type = '('
+	TypeBulkError Type = '!'
+	TypeVerbatim  Type = '='
+	TypeMap       Type = '%'
+	TypeSet       Type = '~'
+	TypePush      Type = '>'
+	TypeAttribute Type = '|'
+)
+
+// Value is a single RESP value. Str holds the payload of simple strings,
+// errors, bulk strings, big numbers and verbatim strings, Int the payload of
+// integers and booleans, Float the payload of doubles and Array the elements
+// of arrays, sets and pushes. Maps store their keys and values interleaved
+// in Array. Null marks the RESP2 null bulk string and null array.
+type Value struct {
+	Type  Type
+	Str   string
+	Int   int64
+	Float float64
+	Array []Value
+	Null  bool
+}
+
+// IsError reports whether v is an error reply.
+func (v Value) IsError() bool {
+	return v.Type == TypeError || v.Type == TypeBulkError
+}
+
+// IsNull reports whether v is a null of any kind.
+func (v Value) IsNull() bool {
+	return v.Null || v.Type == TypeNull
+}
+
+// String renders v for humans, roughly the way redis-cli does.
+func (v Value) String() string {
+	switch {
+	case v.IsNull():
+		return "(nil)"
+	}
+	switch v.Type {
+	case TypeError, TypeBulkError:
+		return "(error) " + v.Str
+	case TypeInteger:
+		return "(integer) " + strconv.FormatInt(v.Int, 10)
+	case TypeBoolean:
+		if v.Int != 0 {
+			return "(true)"
+		}
+		return "(false)"
+	case TypeDouble:
+		return "(double) " + FormatDouble(v.Float)
+	case TypeBulkString, TypeVerbatim, TypeBigNumber:
+		return strconv.Quote(v.Str)
+	case TypeArray, TypeSet, TypePush, TypeMap:
+		return fmt.Sprint(v.Array)
+	}
+	return v.Str
+}
+
+// SimpleString returns a simple string reply such as "OK".
+func SimpleString(s string) Value {
+	return Value{Type: TypeSimpleString, Str: s}
+}
+
+// Error returns an error reply. msg should start with an upper-case error
+// code such as "ERR" or "WRONGTYPE" so clients can tell error kinds apart.
+func Error(msg string) Value {
+	return Value{Type: TypeError, Str: msg}
+}
+
+// Errorf is like Error but formats its message.
+func Errorf(format string, args ...any) Value {
+	return Error(fmt.Sprintf(format, args...))
+}
+
+// Integer returns an integer reply.
+func Integer(n int64) Value {
+	return Value{Type: TypeInteger, Int: n}
+}
+
+// BulkString returns a bulk string reply.
+func BulkString(s string) Value {
+	return Value{Type: TypeBulkString, Str: s}
+}
+
+// Array returns an array reply holding elems.
+func Array(elems ...Value) Value {
+	if elems == nil {
+		elems = []Value{}
+	}
+	return Value{Type: TypeArray, Array: elems}
+}
+
+// Map returns a map reply. kvs holds keys and values interleaved.
+func Map(kvs ...Value) Value {
+	if kvs == nil {
+		kvs = []Value{}
+	}
+	return Value{Type: TypeMap, Array: kvs}
+}
+
+// Set returns a set reply holding elems.
+func Set(elems ...Value) Value {
+	if elems == nil {
+		elems = []Value{}
+	}
+	return Value{Type: TypeSet, Array: elems}
+}
+
+// Push returns an out-of-band push message holding elems.
+func Push(elems ...Value) Value {
+	return Value{Type: TypePush, Array: elems}
+}
+
+// Double returns a double reply.
+func Double(f float64) Value {
+	return Value{Type: TypeDouble, Float: f}
+}
+
+// Boolean returns a boolean reply.
+func Boolean(b bool) Value {
+	if b {
+		return Value{Type: TypeBoolean, Int: 1}
+	}
+	return Value{Type: TypeBoolean}
+}
+
+// BulkStrings returns an array of bulk strings.
+func BulkStrings(strs []string) Value {
+	elems := make([]Value, len(strs))
+	for i, s := range strs {
+		elems[i] = BulkString(s)
+	}
+	return Array(elems...)
+}
+
+var (
+	// OK is the "+OK" status reply.
+	OK = SimpleString("OK")
+	// NullBulk is the null bulk string, "$-1" in RESP2.
+	NullBulk = Value{Type: TypeBulkString, Null: true}
+	// NullArray is the null array, "*-1" in RESP2.
+	NullArray = Value{Type: TypeArray, Null: true}
+)
+
+// FormatDouble renders f the way RESP3 expects, spelling out infinities as
+// "inf" and "-inf".
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,142 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// Writer serializes RESP values onto a buffered stream. Nothing reaches the
+// underlying writer until Flush is called or the buffer fills up.
+type Writer struct {
+	wr    *bufio.Writer
+	buf   []byte
+	proto int
+}
+
+// NewWriter returns a Writer for a RESP2 connection.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(w), proto: 2}
+}
+
+// SetProtocol selects the protocol version (2 or 3) values are written in.
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Protocol returns the protocol version values are written in.
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// WriteValue serializes v into the buffer.
+func (w *Writer) WriteValue(v Value) error {
+	w.buf = AppendValue(w.buf[:0], v, w.proto)
+	_, err := w.wr.Write(w.buf)
+	return err
+}
+
+// WriteCommand serializes args as a multi-bulk request, the form servers
+// expect commands in.
+func (w *Writer) WriteCommand(args ...string) error {
+	w.buf = AppendCommand(w.buf[:0], args...)
+	_, err := w.wr.Write(w.buf)
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
+
+// AppendCommand serializes args as a multi-bulk request onto b.
+func AppendCommand(b []byte, args ...string) []byte {
+	b = append(b, byte(TypeArray))
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, arg := range args {
+		b = append(b, byte(TypeBulkString))
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+// AppendValue serializes v onto b using protocol version proto (2 or 3).
+// RESP3-only types are downgraded for RESP2 the same way Redis does: maps
+// and sets become flat arrays, doubles and big numbers bulk strings and
+// booleans integers.
+func AppendValue(b []byte, v Value, proto int) []byte {
+	if proto < 3 {
+		v = Downgrade(v)
+	} else if v.Null {
+		return append(b, "_\r\n"...)
+	}
+
+	b = append(b, byte(v.Type))
+	switch v.Type {
+	case TypeSimpleString, TypeError, TypeBigNumber:
+		b = append(b, v.Str...)
+	case TypeInteger:
+		b = strconv.AppendInt(b, v.Int, 10)
+	case TypeBoolean:
+		if v.Int != 0 {
+			b = append(b, 't')
+		} else {
+			b = append(b, 'f')
+		}
+	case TypeDouble:
+		b = append(b, FormatDouble(v.Float)...)
+	case TypeBulkString, TypeBulkError, TypeVerbatim:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		b = strconv.AppendInt(b, int64(len(v.Str)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, v.Str...)
+	case TypeArray, TypeSet, TypePush, TypeMap, TypeAttribute:
+		if v.Null {
+			return append(b, "-1\r\n"...)
+		}
+		n := len(v.Array)
+		if v.Type == TypeMap || v.Type == TypeAttribute {
+			n /= 2
+		}
+		b = strconv.AppendInt(b, int64(n), 10)
+		b = append(b, "\r\n"...)
+		for _, elem := range v.Array {
+			b = AppendValue(b, elem, proto)
+		}
+		return b
+	}
+	return append(b, "\r\n"...)
+}
+
+// Downgrade maps a RESP3-only type onto its RESP2 equivalent. Nested values
+// are downgraded by AppendValue as it recurses.
+func Downgrade(v Value) Value {
+	switch v.Type {
+	case TypeNull:
+		return NullBulk
+	case TypeBoolean:
+		return Integer(v.Int)
+	case TypeDouble:
+		return BulkString(FormatDouble(v.Float))
+	case TypeBigNumber:
+		return BulkString(v.Str)
+	case TypeBulkError:
+		return Error(v.Str)
+	case TypeVerbatim:
+		// Verbatim strings carry a three letter format prefix, e.g. "txt:".
+		str := v.Str
+		if len(str) >= 4 && str[3] == ':' {
+			str = str[4:]
+		}
+		return BulkString(str)
+	case TypeMap, TypeSet, TypePush, TypeAttribute:
+		return Value{Type: TypeArray, Array: v.Array, Null: v.Null}
+	}
+	return v
+}
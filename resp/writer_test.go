@@ -0,0 +1,97 @@
+package resp
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRESP2RoundTrip(t *testing.T) {
+	values := []Value{
+		SimpleString("OK"),
+		Error("ERR boom"),
+		Integer(-42),
+		BulkString("hello\r\nworld"),
+		NullBulk,
+		NullArray,
+		Array(Integer(1), BulkString("two"), Array()),
+	}
+
+	for _, v := range values {
+		encoded := string(AppendValue(nil, v, 2))
+		got, err := NewReader(strings.NewReader(encoded)).ReadValue()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", encoded, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("%q: expected %+v, got %+v", encoded, v, got)
+		}
+	}
+}
+
+func TestRESP3RoundTrip(t *testing.T) {
+	values := []Value{
+		{Type: TypeNull},
+		Boolean(true),
+		Double(3.5),
+		{Type: TypeBigNumber, Str: "12345678901234567890"},
+		{Type: TypeVerbatim, Str: "txt:hello"},
+		Map(BulkString("a"), Integer(1)),
+		Set(BulkString("x")),
+		Push(BulkString("message")),
+	}
+
+	for _, v := range values {
+		encoded := string(AppendValue(nil, v, 3))
+		got, err := NewReader(strings.NewReader(encoded)).ReadValue()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", encoded, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("%q: expected %+v, got %+v", encoded, v, got)
+		}
+	}
+}
+
+func TestRESP2Downgrade(t *testing.T) {
+	tests := []struct {
+		value Value
+		want  string
+	}{
+		{Value{Type: TypeNull}, "$-1\r\n"},
+		{NullBulk, "$-1\r\n"},
+		{Boolean(true), ":1\r\n"},
+		{Double(1.5), "$3\r\n1.5\r\n"},
+		{Value{Type: TypeVerbatim, Str: "txt:hi"}, "$2\r\nhi\r\n"},
+		{Map(BulkString("k"), Double(2)), "*2\r\n$1\r\nk\r\n$1\r\n2\r\n"},
+	}
+
+	for _, tc := range tests {
+		if got := string(AppendValue(nil, tc.value, 2)); got != tc.want {
+			t.Errorf("%+v: expected %q, got %q", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	w.WriteCommand("SET", "k", "v")
+	w.SetProtocol(3)
+	w.WriteValue(Map(BulkString("k"), Boolean(false)))
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing written before Flush, got %q", buf.String())
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n%1\r\n$1\r\nk\r\n#f\r\n"
+	if buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+}
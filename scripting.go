@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"go-http-practice/resp"
+)
+
+// scriptCache holds the scripts run with EVAL or loaded with SCRIPT LOAD,
+// compiled and keyed by the lower-case SHA1 hex digest of their body, for
+// EVALSHA.
+type scriptCache struct {
+	mu      sync.Mutex
+	scripts map[string]*lua.FunctionProto
+}
+
+// sha1hex returns the lower-case SHA1 hex digest of s.
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// load compiles body, unless a script with the same digest is cached
+// already, and returns its digest and compiled form.
+func (sc *scriptCache) load(body string) (string, *lua.FunctionProto, error) {
+	sha := sha1hex(body)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if proto, ok := sc.scripts[sha]; ok {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(body), "@user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", oneLine(err.Error()))
+	}
+	if sc.scripts == nil {
+		sc.scripts = make(map[string]*lua.FunctionProto)
+	}
+	sc.scripts[sha] = proto
+	return sha, proto, nil
+}
+
+// get returns the cached script with digest sha, or nil.
+func (sc *scriptCache) get(sha string) *lua.FunctionProto {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.scripts[strings.ToLower(sha)]
+}
+
+// flush empties the cache.
+func (sc *scriptCache) flush() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.scripts = nil
+}
+
+// scriptEnv is what the redis library of a Lua state acts on.
+type scriptEnv struct {
+	// c is the client the running script acts for. It is nil while a
+	// function library is being loaded, when no command may run.
+	c *Client
+	// readOnly rejects write commands, for FCALL_RO and functions flagged
+	// no-writes.
+	readOnly bool
+	// register implements redis.register_function during FUNCTION LOAD,
+	// and is nil otherwise.
+	register lua.LGFunction
+}
+
+// runScript runs a compiled script on behalf of c with the given KEYS and
+// ARGV and converts its return value into a reply.
+func runScript(c *Client, sha string, proto *lua.FunctionProto, keys, argv []string) resp.Value {
+	return runAtomically(c, func() resp.Value {
+		L := newScriptState(&scriptEnv{c: c})
+		defer L.Close()
+		L.SetGlobal("KEYS", stringsTable(L, keys))
+		L.SetGlobal("ARGV", stringsTable(L, argv))
+		protectGlobals(L)
+		return callLua(c, L, L.NewFunctionFromProto(proto), "script (call to f_"+sha+")")
+	})
+}
+
+// runAtomically runs fn, which runs a script for c, under the store's
+// write lock, like EXEC, so the script is atomic with respect to other
+// clients; blocking commands it calls do not wait. Commands see the client
+// as a RESP2 one, as Redis scripts do.
+func runAtomically(c *Client, fn func() resp.Value) resp.Value {
+	if !c.execing {
+		c.store.mu.Lock()
+		defer c.store.mu.Unlock()
+		defer c.store.actFor(c)()
+		defer c.store.serveBlocked()
+		defer c.flushEffects()
+		c.execing = true
+		defer func() { c.execing = false }()
+	}
+	saved := c.proto
+	c.proto = 2
+	defer func() { c.proto = saved }()
+	return fn()
+}
+
+// callLua calls fn with args for c and converts its return value into a
+// reply. An error raised with an {err = ...} table, as redis.call does,
+// becomes that error reply; other errors are reported as failures of what,
+// the script or function. A call still running after lua-time-limit is
+// aborted.
+func callLua(c *Client, L *lua.LState, fn *lua.LFunction, what string, args ...lua.LValue) resp.Value {
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	lift := limitScript(c, L)
+	err := L.PCall(len(args), 1, nil)
+	if lift() && err != nil {
+		return resp.Errorf("ERR Error running %s: aborted after running for longer than lua-time-limit (%d ms)", what, c.srv.cfg.LuaTimeLimit)
+	}
+	if err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return resp.Error(string(msg))
+				}
+			}
+			return resp.Errorf("ERR Error running %s: %s", what, oneLine(apiErr.Object.String()))
+		}
+		return resp.Errorf("ERR Error running %s: %s", what, oneLine(err.Error()))
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return luaToReply(ret)
+}
+
+// limitScript has the Lua code L runs for c aborted once it has run for
+// lua-time-limit, and other clients answered with errBusyScript from then
+// until it returns. The function it returns lifts the limit, once L is
+// done, and reports whether it was reached.
+func limitScript(c *Client, L *lua.LState) func() bool {
+	limit := time.Duration(c.srv.cfg.LuaTimeLimit) * time.Millisecond
+	if limit <= 0 {
+		return func() bool { return false }
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	L.SetContext(ctx)
+	fired := make(chan struct{})
+	timer := time.AfterFunc(limit, func() {
+		c.srv.scriptBusy.Store(true)
+		cancel()
+		close(fired)
+	})
+	return func() bool {
+		reached := !timer.Stop()
+		if reached {
+			<-fired
+			c.srv.scriptBusy.Store(false)
+		}
+		cancel()
+		L.RemoveContext()
+		return reached
+	}
+}
+
+// oneLine collapses the whitespace in a Lua error message, which may span
+// lines, so it fits in an error reply.
+func oneLine(msg string) string {
+	return strings.Join(strings.Fields(msg), " ")
+}
+
+// newScriptState returns a Lua state with the base, table, string and math
+// libraries and the redis library bound to env. Functions that reach the
+// file system are left out.
+func newScriptState(env *scriptEnv) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return scriptCall(L, env, true) },
+		"pcall": func(L *lua.LState) int { return scriptCall(L, env, false) },
+		"register_function": func(L *lua.LState) int {
+			if env.register == nil {
+				L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+			}
+			return env.register(L)
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1hex(L.CheckString(1))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			L.CheckInt(1)
+			log.Printf("script: %s", L.CheckString(2))
+			return 0
+		},
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		redis.RawSetString(level, lua.LNumber(i))
+	}
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// protectGlobals makes reading an undefined global or defining a new one
+// an error, so scripts cannot leak state through globals or silently use
+// misspelled names.
+func protectGlobals(L *lua.LState) {
+	mt := L.NewTable()
+	mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.CheckString(2))
+		return 0
+	}))
+	mt.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.CheckString(2))
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, mt)
+}
+
+// scriptCall implements redis.call and redis.pcall, running a command with
+// the script's arguments and converting its reply to Lua. An error reply is
+// raised as a Lua error by redis.call and returned by redis.pcall.
+func scriptCall(L *lua.LState, env *scriptEnv, raise bool) int {
+	if env.c == nil {
+		L.RaiseError("Commands can not be called while loading a library")
+	}
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, n)
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString, lua.LNumber:
+			args[i] = v.String()
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	var reply resp.Value
+	cmd := lookupCommand(args[0])
+	switch {
+	case cmd == nil:
+		reply = resp.Error("ERR Unknown Redis command called from script")
+	case cmd.Flags&flagNoScript != 0:
+		reply = resp.Error("ERR This Redis command is not allowed from script")
+	case !cmd.checkArity(len(args)):
+		reply = resp.Error("ERR Wrong number of args calling Redis command from script")
+	case env.readOnly && cmd.Flags&flagWrite != 0:
+		reply = resp.Error("ERR Write commands are not allowed from read-only scripts.")
+	case cmd.Flags&flagWrite != 0 && env.c.srv.repl.readOnly(env.c):
+		reply = errReadOnlyReplica
+	default:
+		if cmd.Flags&flagWrite != 0 {
+			env.c.expireKeys(cmd, args)
+		}
+		dirty := env.c.store.dirty
+		reply = cmd.handler(env.c, args)
+		env.c.propagate(cmd, args, dirty)
+		env.c.trackKeys(cmd, args)
+		env.c.srv.monitors.feed(env.c, cmd, args, true)
+	}
+	if raise && reply.IsError() {
+		L.Error(replyTable(L, "err", reply.Str), 1)
+	}
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+// replyTable returns the single-field table Redis uses for status and
+// error replies in Lua, {ok = msg} or {err = msg}.
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+// stringsTable returns a Lua array holding strs.
+func stringsTable(L *lua.LState, strs []string) *lua.LTable {
+	t := L.CreateTable(len(strs), 0)
+	for _, s := range strs {
+		t.Append(lua.LString(s))
+	}
+	return t
+}
+
+// replyToLua converts a command reply to Lua the way Redis does for RESP2:
+// integers become numbers, bulk strings strings, arrays tables, nulls
+// false, and status and error replies {ok = ...} and {err = ...} tables.
+func replyToLua(L *lua.LState, v resp.Value) lua.LValue {
+	v = resp.Downgrade(v)
+	if v.IsNull() {
+		return lua.LFalse
+	}
+	switch v.Type {
+	case resp.TypeInteger:
+		return lua.LNumber(v.Int)
+	case resp.TypeSimpleString:
+		return replyTable(L, "ok", v.Str)
+	case resp.TypeError:
+		return replyTable(L, "err", v.Str)
+	case resp.TypeArray:
+		t := L.CreateTable(len(v.Array), 0)
+		for _, elem := range v.Array {
+			t.Append(replyToLua(L, elem))
+		}
+		return t
+	}
+	return lua.LString(v.Str)
+}
+
+// luaToReply converts a script's return value to a reply: numbers become
+// integers, truncated, strings bulk strings, true 1 and false and nil a
+// null. Tables with an ok or err field become status or error replies and
+// other tables arrays, up to their first nil.
+func luaToReply(lv lua.LValue) resp.Value {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return resp.Integer(int64(v))
+	case lua.LString:
+		return resp.BulkString(string(v))
+	case lua.LBool:
+		if v {
+			return resp.Integer(1)
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.Error(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return resp.SimpleString(string(msg))
+		}
+		var elems []resp.Value
+		for i := 1; ; i++ {
+			elem := v.RawGetInt(i)
+			if elem == lua.LNil {
+				break
+			}
+			elems = append(elems, luaToReply(elem))
+		}
+		return resp.Array(elems...)
+	}
+	return resp.NullBulk
+}
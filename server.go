@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-http-practice/resp"
+)
+
+// Server owns the shared Store and accepts client connections.
+type Server struct {
+	cfg      *Config
+	store    *Store
+	pubsub   *pubsub
+	scripts  *scriptCache
+	tracking *tracking
+	monitors *monitors
+	slowlog  *slowlog
+	latency  *latencyMonitor
+	rdb      *rdbState
+	// aof is the append-only file, nil unless appendonly is enabled. See
+	// aof.go.
+	aof        *appendOnly
+	aofRewrite *aofRewriteState
+	// repl is the replication state. See replication.go.
+	repl *replication
+	// scriptBusy is set while a script that ran past lua-time-limit is
+	// being aborted, when other clients get errBusyScript. See
+	// limitScript.
+	scriptBusy atomic.Bool
+	// started is when the server was created, for INFO.
+	started time.Time
+	// clients holds the connected clients by ID.
+	clientsMu sync.Mutex
+	clients   map[int64]*Client
+	// shutdown is closed by SHUTDOWN, once, to have Serve return.
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+// errShutdown is returned by Serve once SHUTDOWN has run.
+var errShutdown = errors.New("server shut down")
+
+func NewServer(cfg *Config) *Server {
+	s := &Server{
+		cfg:        cfg,
+		store:      NewStore(),
+		pubsub:     newPubsub(),
+		scripts:    &scriptCache{},
+		clients:    make(map[int64]*Client),
+		monitors:   newMonitors(),
+		slowlog:    newSlowlog(cfg),
+		latency:    newLatencyMonitor(cfg),
+		rdb:        newRDBState(),
+		aofRewrite: newAOFRewriteState(),
+		repl:       newReplication(),
+		started:    time.Now(),
+		shutdown:   make(chan struct{}),
+	}
+	s.tracking = newTracking(s)
+	s.store.tracking = s.tracking
+	s.store.latency = s.latency
+	s.store.notifyFlags = cfg.NotifyKeyspaceEvents
+	s.store.publish = func(channel, message string) { s.pubsub.publish(channel, message) }
+	s.store.expired = func(key string) {
+		if s.propagating() {
+			s.feed([][]string{{"DEL", key}})
+		}
+	}
+	return s
+}
+
+// Serve accepts connections on ln until it fails, handling each client in
+// its own goroutine. After SHUTDOWN, it closes ln and returns errShutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	s.store.StartJanitor(time.Duration(time.Second * 3))
+	s.startSaveScheduler()
+	s.startReplicaPings()
+	go func() {
+		<-s.shutdown
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				return errShutdown
+			default:
+			}
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	c := newClient(conn, s)
+	go c.out.run(conn)
+	defer c.out.close()
+	defer s.pubsub.removeClient(c)
+	defer c.unwatchAll()
+	defer s.tracking.disable(c)
+	defer s.removeClient(c)
+	defer s.repl.removeReplica(c)
+	defer s.monitors.remove(c)
+	reader := resp.NewReader(conn)
+	reader.SetLimits(s.cfg.requestLimits())
+
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if resp.IsProtocolError(err) {
+				c.out.write(resp.Error("ERR "+err.Error()), c.proto)
+			}
+			break
+		}
+
+		if len(args) > 0 {
+			reply, ok := c.safeExecute(args)
+			if c.blocked != nil {
+				// Answer the requests before this one while waiting.
+				if err := c.out.flush(); err != nil {
+					break
+				}
+				reply = c.waitUnblocked(reader)
+			}
+			if reply.Type != 0 {
+				c.out.write(reply, c.proto)
+			}
+			if !ok {
+				break
+			}
+		}
+
+		// Pipelined requests arrive together, so only flush once every
+		// buffered request has been answered.
+		if reader.Buffered() == 0 {
+			if err := c.out.flush(); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// addClient registers c as connected.
+func (s *Server) addClient(c *Client) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	s.clients[c.id] = c
+}
+
+// removeClient forgets c once it has disconnected.
+func (s *Server) removeClient(c *Client) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	delete(s.clients, c.id)
+}
+
+// clientByID returns the connected client with the given ID, or nil.
+func (s *Server) clientByID(id int64) *Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.clients[id]
+}
+
+// clientList returns the connected clients, in no particular order.
+func (s *Server) clientList() []*Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	list := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// safeExecute runs args like execute but turns a panic in a command handler
+// into an error reply instead of taking the whole server down. ok is false
+// after a panic, and the caller should drop the connection since the
+// client's state can no longer be trusted.
+func (c *Client) safeExecute(args []string) (reply resp.Value, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic while executing %q for client %d: %v\n%s", args[0], c.id, r, debug.Stack())
+			reply, ok = resp.Error("ERR internal error"), false
+		}
+	}()
+	return c.execute(args), true
+}
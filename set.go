@@ -0,0 +1,56 @@
+package main
+
+import "maps"
+
+// setValue is the value of a set key.
+type setValue struct {
+	members map[string]struct{}
+}
+
+func newSet() *setValue {
+	return &setValue{members: make(map[string]struct{})}
+}
+
+func (s *setValue) clone() *setValue {
+	return &setValue{members: maps.Clone(s.members)}
+}
+
+// release drops the members so the collector can reclaim them.
+func (s *setValue) release() {
+	clear(s.members)
+}
+
+// has reports whether m is a member. It is safe to call on a nil set, which
+// has no members.
+func (s *setValue) has(m string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.members[m]
+	return ok
+}
+
+// size returns the number of members, 0 for a nil set.
+func (s *setValue) size() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.members)
+}
+
+// list returns the members in no particular order.
+func (s *setValue) list() []string {
+	out := make([]string, 0, s.size())
+	if s != nil {
+		for m := range s.members {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// lookupSet returns the set at key, or nil if there is none. The caller
+// must hold mu for reading or writing.
+func (s *Store) lookupSet(key string) (*setValue, error) {
+	return lookupTyped[*setValue](s, key)
+}
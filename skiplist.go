@@ -0,0 +1,344 @@
+package main
+
+import "math/rand/v2"
+
+// skiplist orders sorted set members by (score, member), the same structure
+// Redis uses. Every forward link records its span, the number of elements
+// it skips, so ranks can be computed while walking down the levels.
+// Insertion, deletion and lookup by rank or score are O(log n) on average.
+type skiplist struct {
+	head   *skipNode
+	tail   *skipNode
+	length int
+	level  int
+}
+
+type skipNode struct {
+	member   string
+	score    float64
+	backward *skipNode
+	levels   []skipLevel
+}
+
+type skipLevel struct {
+	forward *skipNode
+	span    int
+}
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skipNode{levels: make([]skipLevel, skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// before reports whether n sorts before (score, member).
+func (n *skipNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds member with score. The member must not be present already.
+func (sl *skiplist) insert(score float64, member string) *skipNode {
+	var update [skiplistMaxLevel]*skipNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].levels[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipNode{member: member, score: score, levels: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+	return x
+}
+
+// unlink removes x given the nodes that precede it on every level.
+func (sl *skiplist) unlink(x *skipNode, update *[skiplistMaxLevel]*skipNode) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.levels[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+}
+
+// remove deletes the element with the given score and member and reports
+// whether it was there.
+func (sl *skiplist) remove(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skipNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.before(score, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	sl.unlink(x, &update)
+	return true
+}
+
+// updateScore moves member from curScore to newScore. The member must be
+// present with curScore.
+func (sl *skiplist) updateScore(curScore float64, member string, newScore float64) {
+	var update [skiplistMaxLevel]*skipNode
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.before(curScore, member) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+
+	// If the node stays between its neighbours it can be updated in place.
+	if (x.backward == nil || x.backward.before(newScore, member)) &&
+		(x.next() == nil || !x.next().before(newScore, member)) {
+		x.score = newScore
+		return
+	}
+	sl.unlink(x, &update)
+	sl.insert(newScore, member)
+}
+
+// rank returns the 0-based rank of the element, which must be present.
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil &&
+			(x.levels[i].forward.before(score, member) || (x.levels[i].forward.score == score && x.levels[i].forward.member == member)) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if x != sl.head && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the element at 0-based rank, or nil if out of range.
+func (sl *skiplist) byRank(rank int) *skipNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// first returns the first element, or nil if the list is empty.
+func (sl *skiplist) first() *skipNode {
+	return sl.head.levels[0].forward
+}
+
+// next returns the element after n, or nil.
+func (n *skipNode) next() *skipNode {
+	return n.levels[0].forward
+}
+
+// scoreRange is a score interval as given to ZRANGEBYSCORE, with each end
+// either inclusive or exclusive.
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r scoreRange) gteMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r scoreRange) lteMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+func (r scoreRange) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minex || r.maxex))
+}
+
+// lexBound is one end of a lexicographic interval: "[a" and "(a" are
+// inclusive and exclusive bounds, "-" and "+" are the infinities.
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for "-", +1 for "+", 0 for a string bound
+}
+
+// lexRange is a member interval as given to ZRANGEBYLEX. It is only
+// meaningful on sorted sets whose members all share the same score.
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r lexRange) gteMin(member string) bool {
+	switch {
+	case r.min.inf != 0:
+		return r.min.inf < 0
+	case r.min.exclusive:
+		return member > r.min.value
+	default:
+		return member >= r.min.value
+	}
+}
+
+func (r lexRange) lteMax(member string) bool {
+	switch {
+	case r.max.inf != 0:
+		return r.max.inf > 0
+	case r.max.exclusive:
+		return member < r.max.value
+	default:
+		return member <= r.max.value
+	}
+}
+
+func (r lexRange) empty() bool {
+	switch {
+	case r.min.inf > 0 || r.max.inf < 0:
+		return true
+	case r.min.inf < 0 || r.max.inf > 0:
+		return false
+	}
+	return r.min.value > r.max.value ||
+		(r.min.value == r.max.value && (r.min.exclusive || r.max.exclusive))
+}
+
+// firstInRange returns the first element of the range described by gteMin
+// and lteMax, which test a node against the lower and upper end, or nil if
+// the range holds no elements.
+func (sl *skiplist) firstInRange(gteMin, lteMax func(*skipNode) bool) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !gteMin(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+	x = x.next()
+	if x == nil || !lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last element of a range, or nil if there is none.
+func (sl *skiplist) lastInRange(gteMin, lteMax func(*skipNode) bool) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && lteMax(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+	if x == sl.head || !gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+// firstInScoreRange and lastInScoreRange return the ends of the elements
+// whose scores fall in r, or nil if there are none.
+func (sl *skiplist) firstInScoreRange(r scoreRange) *skipNode {
+	if r.empty() {
+		return nil
+	}
+	return sl.firstInRange(func(x *skipNode) bool { return r.gteMin(x.score) }, func(x *skipNode) bool { return r.lteMax(x.score) })
+}
+
+func (sl *skiplist) lastInScoreRange(r scoreRange) *skipNode {
+	if r.empty() {
+		return nil
+	}
+	return sl.lastInRange(func(x *skipNode) bool { return r.gteMin(x.score) }, func(x *skipNode) bool { return r.lteMax(x.score) })
+}
+
+// firstInLexRange and lastInLexRange do the same for member ranges.
+func (sl *skiplist) firstInLexRange(r lexRange) *skipNode {
+	if r.empty() {
+		return nil
+	}
+	return sl.firstInRange(func(x *skipNode) bool { return r.gteMin(x.member) }, func(x *skipNode) bool { return r.lteMax(x.member) })
+}
+
+func (sl *skiplist) lastInLexRange(r lexRange) *skipNode {
+	if r.empty() {
+		return nil
+	}
+	return sl.lastInRange(func(x *skipNode) bool { return r.gteMin(x.member) }, func(x *skipNode) bool { return r.lteMax(x.member) })
+}
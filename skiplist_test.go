@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// checkSkiplist verifies ordering, back links, spans and ranks against a
+// sorted copy of want.
+func checkSkiplist(t *testing.T, sl *skiplist, want map[string]float64) {
+	t.Helper()
+	type elem struct {
+		member string
+		score  float64
+	}
+	var sorted []elem
+	for m, s := range want {
+		sorted = append(sorted, elem{m, s})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].score != sorted[j].score {
+			return sorted[i].score < sorted[j].score
+		}
+		return sorted[i].member < sorted[j].member
+	})
+
+	if sl.length != len(sorted) {
+		t.Fatalf("length = %d, want %d", sl.length, len(sorted))
+	}
+	var prev *skipNode
+	i := 0
+	for x := sl.first(); x != nil; x = x.next() {
+		if x.member != sorted[i].member || x.score != sorted[i].score {
+			t.Fatalf("element %d = (%v, %q), want (%v, %q)", i, x.score, x.member, sorted[i].score, sorted[i].member)
+		}
+		if x.backward != prev {
+			t.Fatalf("element %d has a bad backward link", i)
+		}
+		if r := sl.rank(x.score, x.member); r != i {
+			t.Fatalf("rank(%q) = %d, want %d", x.member, r, i)
+		}
+		if n := sl.byRank(i); n != x {
+			t.Fatalf("byRank(%d) = %v, want %q", i, n, x.member)
+		}
+		prev = x
+		i++
+	}
+	if sl.tail != prev {
+		t.Fatal("tail does not point at the last element")
+	}
+	if sl.byRank(len(sorted)) != nil || sl.byRank(-1) != nil {
+		t.Fatal("byRank out of range returned an element")
+	}
+}
+
+func TestSkiplistRandomOperations(t *testing.T) {
+	sl := newSkiplist()
+	want := map[string]float64{}
+	for op := 0; op < 3000; op++ {
+		member := strconv.Itoa(rand.IntN(300))
+		score := float64(rand.IntN(50))
+		cur, exists := want[member]
+		switch {
+		case !exists:
+			sl.insert(score, member)
+			want[member] = score
+		case rand.IntN(2) == 0:
+			if !sl.remove(cur, member) {
+				t.Fatalf("remove(%v, %q) found nothing", cur, member)
+			}
+			delete(want, member)
+		default:
+			sl.updateScore(cur, member, score)
+			want[member] = score
+		}
+		if op%100 == 0 {
+			checkSkiplist(t, sl, want)
+		}
+	}
+	checkSkiplist(t, sl, want)
+
+	if sl.remove(1000, "absent") {
+		t.Error("remove of an absent element reported success")
+	}
+}
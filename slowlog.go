@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits on what a slow log entry keeps of a command, as in Redis: at most
+// slowlogMaxArgs arguments, the last standing for the ones left out, each
+// cut to slowlogMaxArgLen bytes.
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is a command that took longer than the threshold.
+type slowlogEntry struct {
+	id       int64
+	at       time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the latest commands that ran for longer than the
+// slowlog-log-slower-than threshold, up to slowlog-max-len of them. Only
+// the time spent running a command is measured, not waiting for the store
+// lock or sending the reply.
+type slowlog struct {
+	mu sync.Mutex
+	// threshold is the duration a command must reach to be logged; a
+	// negative one disables the log. maxLen caps the entries kept.
+	threshold time.Duration
+	maxLen    int
+	// entries holds the logged commands, oldest first.
+	entries []slowlogEntry
+	nextID  int64
+}
+
+func newSlowlog(cfg *Config) *slowlog {
+	return &slowlog{
+		threshold: time.Duration(cfg.SlowlogLogSlowerThan) * time.Microsecond,
+		maxLen:    cfg.SlowlogMaxLen,
+	}
+}
+
+// record logs args, run by c, if it took d or longer than the threshold.
+func (sl *slowlog) record(c *Client, args []string, d time.Duration) {
+	if sl.threshold < 0 || d < sl.threshold {
+		return
+	}
+	n := min(len(args), slowlogMaxArgs)
+	kept := make([]string, n)
+	for i := range kept {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			kept[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgs+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		kept[i] = arg
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = append(sl.entries, slowlogEntry{
+		id:       sl.nextID,
+		at:       time.Now(),
+		duration: d,
+		args:     kept,
+		addr:     c.addr(),
+		name:     c.name,
+	})
+	sl.nextID++
+	if over := len(sl.entries) - sl.maxLen; over > 0 {
+		sl.entries = append(sl.entries[:0], sl.entries[over:]...)
+	}
+}
+
+// latest returns up to n entries, newest first, or all of them if n is
+// negative.
+func (sl *slowlog) latest(n int) []slowlogEntry {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if n < 0 || n > len(sl.entries) {
+		n = len(sl.entries)
+	}
+	out := make([]slowlogEntry, n)
+	for i := range out {
+		out[i] = sl.entries[len(sl.entries)-1-i]
+	}
+	return out
+}
+
+// len returns the number of entries.
+func (sl *slowlog) len() int {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return len(sl.entries)
+}
+
+// reset drops every entry. IDs keep counting up.
+func (sl *slowlog) reset() {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.entries = nil
+}
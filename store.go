@@ -1,113 +1,541 @@
 package main
 
 import (
-	"strconv"
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// StoreData is a single stored value. The value is one of:
+//
+//	[]byte        a string, kept as raw bytes so it can hold binary data
+//	*listValue    a list
+//	*hashValue    a hash
+//	*setValue     a set
+//	*zsetValue    a sorted set
+//	*streamValue  a stream
+//
+// Aggregate values are modified in place and are never empty: the command
+// that removes the last element deletes the key. Streams are the
+// exception, as they keep their last ID when emptied.
 type StoreData struct {
-	value string
+	value     any
 	expiresAt time.Time
+	// accessedAt is when the value was last written or touched by TOUCH.
+	accessedAt time.Time
 }
 
+// expired reports whether the value's deadline has passed at now.
+func (d StoreData) expired(now time.Time) bool {
+	return !d.expiresAt.IsZero() && !now.Before(d.expiresAt)
+}
+
+// clone returns a deep copy of d, for commands such as COPY whose result
+// must not share memory with the original: values are modified in place.
+func (d StoreData) clone() StoreData {
+	switch v := d.value.(type) {
+	case []byte:
+		d.value = bytes.Clone(v)
+	case *listValue:
+		d.value = v.clone()
+	case *hashValue:
+		d.value = v.clone()
+	case *setValue:
+		d.value = v.clone()
+	case *zsetValue:
+		d.value = v.clone()
+	case *streamValue:
+		d.value = v.clone()
+	case *moduleValue:
+		if v.typ.Copy != nil {
+			d.value = &moduleValue{typ: v.typ, v: v.typ.Copy(v.v)}
+		}
+	}
+	return d
+}
+
+// typeName returns the name TYPE and SCAN TYPE use for the value: one of
+// string, list, hash, set, zset or stream, or the name of a type added
+// with RegisterType.
+func (d StoreData) typeName() string {
+	switch v := d.value.(type) {
+	case *listValue:
+		return "list"
+	case *hashValue:
+		return "hash"
+	case *setValue:
+		return "set"
+	case *zsetValue:
+		return "zset"
+	case *streamValue:
+		return "stream"
+	case *moduleValue:
+		return v.typ.Name
+	default:
+		return "string"
+	}
+}
 
+// bytes returns the value as a string, or nil if it is not one.
+func (d StoreData) bytes() []byte {
+	b, _ := d.value.([]byte)
+	return b
+}
+
+// errWrongType is the error form of wrongTypeReply.
+var errWrongType = errors.New(wrongTypeReply.Str)
+
+// Store is the keyspace shared by all clients.
+//
+// The exported methods take mu themselves. The lower-case helpers such as
+// lookup and set expect the caller to hold it already; command handlers use
+// those, since the dispatcher locks the store around each command.
 type Store struct {
-	mu sync.RWMutex
+	mu   sync.RWMutex
 	data map[string]StoreData
+	// expires indexes the keys that have a TTL, so the janitor only has to
+	// look at those. It is kept in sync by put and remove.
+	expires map[string]struct{}
+	// slots spreads the keys over a fixed number of buckets by hash, which
+	// gives SCAN a stable cursor: a key never moves between slots, so a
+	// cursor that walked past a slot has seen every key that stayed in it.
+	// It is kept in sync by put and remove.
+	slots []map[string]struct{}
+	// keys lists every key densely, with keyPos giving each key's position,
+	// so RANDOMKEY can pick one uniformly in constant time. They are kept in
+	// sync by put and remove.
+	keys   []string
+	keyPos map[string]int
+	// fieldExpires indexes the hashes that have fields with a TTL, for the
+	// janitor. It is kept in sync by put, remove and the hash commands.
+	fieldExpires map[string]struct{}
+	// waiters lists the clients blocked on each key, in the order they
+	// blocked, and readyKeys the keys written since they were last
+	// served, deduplicated by ready. See blocking.go.
+	waiters   map[string][]*waiter
+	ready     map[string]struct{}
+	readyKeys []string
+	// watchers lists the clients WATCHing each key. See cmd_multi.go.
+	watchers map[string]map[*Client]struct{}
+	// tracking is told about modified keys, for CLIENT TRACKING, and
+	// current is the client whose command is running under the write lock,
+	// nil for the server's own writes such as expiry. See tracking.go.
+	tracking *tracking
+	current  *Client
+	// views lists the views taken for background snapshots that are
+	// still being written, for preserve. It is read without a lock and
+	// replaced under viewsMu. See rdb.go.
+	views   atomic.Pointer[[]*view]
+	viewsMu sync.Mutex
+	// dirty counts the changes made to the dataset, so the writes that
+	// changed something can be told apart. See aof.go.
+	dirty int64
+	// latency records how long the janitor takes, if set. See latency.go.
+	latency *latencyMonitor
+	// functions holds the libraries loaded with FUNCTION LOAD. See
+	// functions.go.
+	functions functionRegistry
+	// notifyFlags selects the keyspace events published through publish.
+	// See notify.go.
+	notifyFlags notifyClass
+	publish     func(channel, message string)
+	// expired, if set, is told of each key the janitor deletes as its TTL
+	// passed, so the deletion reaches the AOF and replicas. replica is set
+	// while the server follows a master, whose deletions then replace the
+	// janitor's. loading is set while the AOF is replayed. See replaying.
+	expired func(key string)
+	replica bool
+	loading bool
 }
 
-func (s *Store) Set(key string, value string) (string) {
-	s.mu.Lock()
-	s.data[key] = StoreData{
-		value: value,
+// numSlots is the number of SCAN slots. It must be a power of two.
+const numSlots = 1024
+
+// slotOf returns the SCAN slot holding key.
+func slotOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() & (numSlots - 1))
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		data:    make(map[string]StoreData),
+		expires: make(map[string]struct{}),
 	}
-	s.mu.Unlock()
-	s.Expire(key, 5)
-	return "OK"
 }
 
-func (s *Store) Get(key string) (string) {
-	s.mu.RLock()
-	
-	storeData, ok := s.data[key]
-	
-	s.mu.RUnlock()
+// put stores d at key, keeping the expires index up to date. Every write to
+// data goes through put or remove, apart from the access time stamped by
+// touch. The caller must hold mu for writing.
+func (s *Store) put(key string, d StoreData) {
+	s.preserve(key)
+	if _, ok := s.data[key]; !ok {
+		s.index(key)
+	}
+	d.accessedAt = time.Now()
+	if h, ok := d.value.(*hashValue); ok {
+		s.indexFieldExpires(key, h)
+	} else {
+		delete(s.fieldExpires, key)
+	}
+	s.data[key] = d
+	s.signalReady(key)
+	s.signalModified(key)
+	if d.expiresAt.IsZero() {
+		delete(s.expires, key)
+		return
+	}
+	if s.expires == nil {
+		s.expires = make(map[string]struct{})
+	}
+	s.expires[key] = struct{}{}
+}
 
-	if !ok {
-		return "ERR data doesn't exist"
+// remove deletes key. The caller must hold mu for writing.
+func (s *Store) remove(key string) {
+	s.preserve(key)
+	if _, ok := s.data[key]; ok {
+		s.unindex(key)
 	}
+	delete(s.data, key)
+	delete(s.expires, key)
+	delete(s.fieldExpires, key)
+	s.signalReady(key)
+	s.signalModified(key)
+}
 
-	expired := s.TTL(key)
+// index adds a new key to slots and keys.
+func (s *Store) index(key string) {
+	if s.slots == nil {
+		s.slots = make([]map[string]struct{}, numSlots)
+		s.keyPos = make(map[string]int)
+	}
+	slot := slotOf(key)
+	if s.slots[slot] == nil {
+		s.slots[slot] = make(map[string]struct{})
+	}
+	s.slots[slot][key] = struct{}{}
+	s.keyPos[key] = len(s.keys)
+	s.keys = append(s.keys, key)
+}
 
-	if expired == "-1" {
-		return "ERR data expired"
+// unindex drops key from slots and keys, moving the last key into the hole
+// it leaves.
+func (s *Store) unindex(key string) {
+	pos, ok := s.keyPos[key]
+	if !ok {
+		return
 	}
+	delete(s.slots[slotOf(key)], key)
+	last := len(s.keys) - 1
+	s.keys[pos] = s.keys[last]
+	s.keyPos[s.keys[pos]] = pos
+	s.keys = s.keys[:last]
+	delete(s.keyPos, key)
+}
 
-	return storeData.value
+// randomKey returns a key chosen uniformly among the live keys, or false
+// if there are none. The caller must hold mu for reading or writing.
+func (s *Store) randomKey() (string, bool) {
+	const tries = 100
+	now := time.Now()
+	for i := 0; i < tries && len(s.keys) > 0; i++ {
+		key := s.keys[rand.IntN(len(s.keys))]
+		if !s.data[key].expired(now) {
+			return key, true
+		}
+	}
+	// Mostly expired keys that the janitor has not reached yet: fall back
+	// to reservoir sampling over the live ones.
+	var key string
+	n := 0
+	for _, k := range s.keys {
+		if s.data[k].expired(now) {
+			continue
+		}
+		n++
+		if rand.IntN(n) == 0 {
+			key = k
+		}
+	}
+	return key, n > 0
 }
 
-func (s *Store) Del(key string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.data, key)
+// scan visits the live keys of whole slots starting at cursor until at least
+// count keys have been seen, and returns them with the cursor to resume
+// from, which is 0 once every slot has been visited. Keys that exist for the
+// whole iteration are returned at least once. The caller must hold mu for
+// reading or writing.
+func (s *Store) scan(cursor uint64, count int) (keys []string, next uint64) {
+	if s.slots == nil || cursor >= numSlots {
+		return nil, 0
+	}
+	now := time.Now()
+	slot := int(cursor)
+	for ; slot < numSlots && len(keys) < count; slot++ {
+		for key := range s.slots[slot] {
+			if !s.data[key].expired(now) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if slot == numSlots {
+		return keys, 0
+	}
+	return keys, uint64(slot)
 }
 
-func (s *Store) Exists(key string) (bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, exists := s.data[key]
-	return exists
+// replaying reports whether the write running was made elsewhere first:
+// it is replayed from the AOF or sent by the master. Keys are then live
+// until they are deleted, whatever their TTL, as they were when the write
+// was first made; the deletion of those that expired follows as a DEL.
+// The caller must hold mu for reading or writing.
+func (s *Store) replaying() bool {
+	return s.loading || s.current != nil && s.current.master
 }
 
-func (s *Store) Expire(key string, seconds int) (string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// lookup returns the live value stored at key, treating expired values as
+// missing unless replaying. The caller must hold mu for reading or
+// writing.
+func (s *Store) lookup(key string) (StoreData, bool) {
+	s.preserve(key)
+	d, ok := s.data[key]
+	if !ok || d.expired(time.Now()) && !s.replaying() {
+		return StoreData{}, false
+	}
+	return d, true
+}
 
-	value, ok := s.data[key]
+// lookupString returns the live value at key, failing with errWrongType if
+// it is not a string. ok is false when there is no live value. The caller
+// must hold mu for reading or writing.
+func (s *Store) lookupString(key string) (d StoreData, ok bool, err error) {
+	d, ok = s.lookup(key)
+	if !ok {
+		return d, false, nil
+	}
+	if _, isString := d.value.([]byte); !isString {
+		return StoreData{}, false, errWrongType
+	}
+	return d, true, nil
+}
 
+// lookupTyped returns the live value at key as a T, or the zero T if there
+// is none. It fails with errWrongType if the key holds another type. As
+// aggregates are never empty, the zero T unambiguously means "no key". The
+// caller must hold mu for reading or writing.
+func lookupTyped[T any](s *Store, key string) (T, error) {
+	var zero T
+	d, ok := s.lookup(key)
 	if !ok {
-		return "ERR data not found"
+		return zero, nil
 	}
+	v, isT := d.value.(T)
+	if !isT {
+		return zero, errWrongType
+	}
+	return v, nil
+}
 
-	value.expiresAt = time.Now().Add(time.Second * time.Duration(seconds))
-	s.data[key] = value
+// set stores value at key, discarding any previous value and TTL. The
+// caller must hold mu for writing.
+func (s *Store) set(key string, value []byte) {
+	s.put(key, StoreData{value: value})
+}
 
+// unlink removes key like del, but hands the value to the background
+// reclaimer instead of dropping it here. The caller must hold mu for
+// writing.
+func (s *Store) unlink(key string) bool {
+	d, ok := s.lookup(key)
+	if _, present := s.data[key]; present {
+		s.remove(key)
+		lazyfree.free(d)
+	}
+	if ok {
+		s.notify(notifyGeneric, "del", key)
+	}
+	return ok
+}
+
+// touch records an access to the live value at key and reports whether
+// there was one. Unlike a write through put, it does not count as a
+// modification for WATCH. The caller must hold mu for writing.
+func (s *Store) touch(key string) bool {
+	d, ok := s.lookup(key)
+	if ok {
+		d.accessedAt = time.Now()
+		s.data[key] = d
+	}
+	return ok
+}
+
+// flush empties the store and returns the old contents, which the caller
+// may release in the background. The caller must hold mu for writing.
+func (s *Store) flush() map[string]StoreData {
+	for key := range s.watchers {
+		if _, ok := s.data[key]; ok {
+			s.signalModified(key)
+		}
+	}
+	if s.tracking != nil {
+		s.tracking.invalidateAll()
+	}
+	s.preserveAll()
+	old := s.data
+	s.dirty += int64(len(old))
+	s.data = make(map[string]StoreData)
+	s.expires = make(map[string]struct{})
+	s.slots = nil
+	s.keys = nil
+	s.keyPos = nil
+	s.fieldExpires = nil
+	return old
+}
+
+// del removes key and reports whether a live value was stored there. The
+// caller must hold mu for writing.
+func (s *Store) del(key string) bool {
+	_, ok := s.lookup(key)
+	s.remove(key)
+	if ok {
+		s.notify(notifyGeneric, "del", key)
+	}
+	return ok
+}
+
+func (s *Store) Set(key string, value string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, []byte(value))
 	return "OK"
 }
 
-func (s *Store) TTL(key string) (string) {
+// Get returns the value stored at key. The boolean is false when the key
+// does not exist or has expired.
+func (s *Store) Get(key string) (string, bool) {
 	s.mu.RLock()
-	
-	value, ok := s.data[key]
+	defer s.mu.RUnlock()
+
+	storeData, ok := s.lookup(key)
+	if !ok {
+		return "", false
+	}
+	b, ok := storeData.value.([]byte)
+	return string(b), ok
+}
+
+// Del removes key and reports whether it existed.
+func (s *Store) Del(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.del(key)
+}
 
-	s.mu.RUnlock()
+func (s *Store) Exists(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.lookup(key)
+	return exists
+}
 
+// setExpire sets the deadline of the live value at key, deleting the key
+// straight away if the deadline has already passed. It reports whether the
+// key existed, and publishes an expire event, or del if the key was
+// deleted. The caller must hold mu for writing.
+func (s *Store) setExpire(key string, at time.Time) bool {
+	d, ok := s.lookup(key)
 	if !ok {
-		return  "-1"
+		return false
+	}
+	if !time.Now().Before(at) && !s.replaying() {
+		s.remove(key)
+		s.notify(notifyGeneric, "del", key)
+		return true
 	}
+	d.expiresAt = at
+	s.put(key, d)
+	s.notify(notifyGeneric, "expire", key)
+	return true
+}
 
-	if value.expiresAt.IsZero() {
-		return "Data never expires"
+// persist removes the TTL of the live value at key and reports whether
+// there was one to remove. The caller must hold mu for writing.
+func (s *Store) persist(key string) bool {
+	d, ok := s.lookup(key)
+	if !ok || d.expiresAt.IsZero() {
+		return false
 	}
+	d.expiresAt = time.Time{}
+	s.put(key, d)
+	s.notify(notifyGeneric, "persist", key)
+	return true
+}
 
-	diff := time.Until(value.expiresAt)
+// Expire makes key expire after the given number of seconds and reports
+// whether the key existed.
+func (s *Store) Expire(key string, seconds int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setExpire(key, time.Now().Add(time.Second*time.Duration(seconds)))
+}
+
+// pttl returns the remaining time to live of key in milliseconds, -1 if
+// the key exists but has no TTL and -2 if it does not exist. The caller
+// must hold mu for reading or writing.
+func (s *Store) pttl(key string) int64 {
+	d, ok := s.lookup(key)
+	if !ok {
+		return -2
+	}
+	if d.expiresAt.IsZero() {
+		return -1
+	}
+	return max(time.Until(d.expiresAt).Milliseconds(), 0)
+}
 
-	if diff <= 0 {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		delete(s.data, key)
-		return "-1"
+// pexpireTime returns the Unix time in milliseconds at which key expires,
+// with the same -1 and -2 sentinels as pttl. The caller must hold mu for
+// reading or writing.
+func (s *Store) pexpireTime(key string) int64 {
+	d, ok := s.lookup(key)
+	if !ok {
+		return -2
 	}
+	if d.expiresAt.IsZero() {
+		return -1
+	}
+	return d.expiresAt.UnixMilli()
+}
+
+// TTL returns the remaining time to live of key in seconds, -1 if the key
+// exists but has no TTL and -2 if it does not exist.
+func (s *Store) TTL(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return msToSeconds(s.pttl(key))
+}
 
-	return strconv.Itoa(int(diff.Seconds()))
+// msToSeconds converts a pttl result to seconds, rounding to the nearest
+// second like Redis and passing the negative sentinels through.
+func msToSeconds(ms int64) int64 {
+	if ms < 0 {
+		return ms
+	}
+	return (ms + 500) / 1000
 }
 
 func (s *Store) StartJanitor(interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
-		
+
 		for range ticker.C {
 			s.cleanup()
 		}
@@ -118,50 +546,22 @@ func (s *Store) cleanup() {
 	now := time.Now()
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.actFor(nil)()
+	if s.latency != nil {
+		defer func() { s.latency.add(latencyExpireCycleEvent, time.Since(now)) }()
+	}
 
-	for k, v := range s.data {
-		if !v.expiresAt.IsZero() && now.After(v.expiresAt) {
-			delete(s.data, k)
+	s.cleanupFields(now)
+	if s.replica {
+		return
+	}
+	for k := range s.expires {
+		if s.data[k].expired(now) {
+			s.remove(k)
+			s.notify(notifyExpired, "expired", k)
+			if s.expired != nil {
+				s.expired(k)
+			}
 		}
 	}
 }
-
-func (s *Store) Execute(command string, args []string) (string) {
-	switch command {
-    case "PING":
-        return "PONG"
-    case "SET":
-		if len(args) != 2 {
-			return "ERR wrong number of arguments for 'set' command"
-		}
-		s.Set(args[0], args[1])
-		return "OK"
-	case "GET":
-		if len(args) != 1 {	
-			return "ERR wrong number of arguments for 'get' command"
-		}
-		variable := s.Get(args[0])
-		if variable == "" {
-			return "ERR property doesn't exist in store"
-		}
-		return variable
-	case "DEL":
-		if len(args) != 1 {
-			return "ERR wrong number of arguments for 'del' command"
-		}
-		s.Del(args[0])
-		return "OK"
-	case "EXISTS":
-		if len(args) != 1 {
-			return "ERR wrong number of arguments for 'exists' command"
-		}
-		exists := s.Exists(args[0])
-		if exists {
-			return "Yes"
-		}
-		return "No"
-    default:
-        return "ERR unknown command"
-    }
-
-}
\ No newline at end of file
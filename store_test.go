@@ -8,26 +8,40 @@ import (
 
 func TestSetAndGet(t *testing.T) {
 	s := &Store{
-		mu: sync.RWMutex{},
+		mu:   sync.RWMutex{},
 		data: make(map[string]StoreData),
 	}
 
 	s.Set("foo", "bar")
-	val := s.Get("foo")
+	val, _ := s.Get("foo")
 
 	if val != "bar" {
 		t.Errorf("expected bar, got %s", val)
 	}
 }
 
+func TestSetAndGetBinary(t *testing.T) {
+	s := &Store{
+		mu:   sync.RWMutex{},
+		data: make(map[string]StoreData),
+	}
+
+	value := "line one\r\nline two\x00\xff"
+	s.Set("bin\x00key", value)
+
+	if got, _ := s.Get("bin\x00key"); got != value {
+		t.Errorf("expected %q, got %q", value, got)
+	}
+}
+
 func TestDel(t *testing.T) {
 	s := &Store{
-		mu: sync.RWMutex{},
+		mu:   sync.RWMutex{},
 		data: make(map[string]StoreData),
 	}
 
 	s.Set("foo", "bar")
-	var val = s.Get("foo")
+	var val, _ = s.Get("foo")
 
 	if val != "bar" {
 		t.Errorf("expected bar, got %s", val)
@@ -35,49 +49,49 @@ func TestDel(t *testing.T) {
 
 	s.Del("foo")
 
-	val = s.Get("foo")
+	val, ok := s.Get("foo")
 
-	if val == "bar" {
+	if ok || val == "bar" {
 		t.Error("Value was not deleted")
 	}
 }
 
-func TestTTL (t *testing.T) {
+func TestTTL(t *testing.T) {
 	s := &Store{
-		mu: sync.RWMutex{},
+		mu:   sync.RWMutex{},
 		data: make(map[string]StoreData),
 	}
 
 	s.mu.Lock()
 	s.data["foo"] = StoreData{
-		value: "bar",
+		value:     []byte("bar"),
 		expiresAt: time.Now().Add(1 * time.Second),
 	}
 	s.mu.Unlock()
 
-	if s.Get("foo") != "bar" {
+	if val, _ := s.Get("foo"); val != "bar" {
 		t.Errorf("Expected bar before expiry")
 	}
 
 	time.Sleep(2 * time.Second)
 
-	if s.Get("foo") == "bar" {
+	if val, _ := s.Get("foo"); val == "bar" {
 		t.Error("Value didn't expire")
 	}
 
-	if s.Get("foo") != "ERR data doesn't exist" {
+	if _, ok := s.Get("foo"); ok {
 		t.Error("Data didn't expire")
 	}
 }
 
 func TestSetAndGetCases(t *testing.T) {
 	s := &Store{
-		mu: sync.RWMutex{},
+		mu:   sync.RWMutex{},
 		data: make(map[string]StoreData),
 	}
 
-	tests := []struct{
-		key string
+	tests := []struct {
+		key   string
 		value string
 	}{
 		{"a", "1"},
@@ -87,7 +101,7 @@ func TestSetAndGetCases(t *testing.T) {
 
 	for _, tc := range tests {
 		s.Set(tc.key, tc.value)
-		got := s.Get(tc.key)
+		got, _ := s.Get(tc.key)
 
 		if got != tc.value {
 			t.Error("Wrong value")
@@ -97,7 +111,7 @@ func TestSetAndGetCases(t *testing.T) {
 
 func TestConcurrency(t *testing.T) {
 	s := &Store{
-		mu: sync.RWMutex{},
+		mu:   sync.RWMutex{},
 		data: make(map[string]StoreData),
 	}
 
@@ -107,7 +121,7 @@ func TestConcurrency(t *testing.T) {
 		go func(i int) {
 			key := "k" + time.Now().String()
 			s.Set(key, "value")
-			_ = s.Get(key)
+			_, _ = s.Get(key)
 			done <- true
 		}(i)
 	}
@@ -115,4 +129,21 @@ func TestConcurrency(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		<-done
 	}
-}
\ No newline at end of file
+}
+
+func TestCleanupOnlyRemovesExpiredKeys(t *testing.T) {
+	s := NewStore()
+
+	s.put("expired", StoreData{value: []byte("a"), expiresAt: time.Now().Add(-time.Second)})
+	s.put("live", StoreData{value: []byte("b"), expiresAt: time.Now().Add(time.Hour)})
+	s.put("forever", StoreData{value: []byte("c")})
+
+	s.cleanup()
+
+	if _, ok := s.data["expired"]; ok {
+		t.Error("expired key was not removed")
+	}
+	if len(s.data) != 2 || len(s.expires) != 1 {
+		t.Errorf("expected 2 keys with 1 TTL left, got %d keys and %d TTLs", len(s.data), len(s.expires))
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID identifies a stream entry: the millisecond time it was added
+// and a sequence number telling apart entries added in the same
+// millisecond. IDs only ever grow within a stream.
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms != other.ms:
+		if id.ms < other.ms {
+			return -1
+		}
+		return 1
+	case id.seq != other.seq:
+		if id.seq < other.seq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// next returns the smallest ID after id, or false if id is the largest.
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the largest ID before id, or false if id is 0-0.
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+var errStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// parseStreamID parses an ID given as "ms-seq", or as "ms" alone, in which
+// case the sequence number is missingSeq.
+func parseStreamID(arg string, missingSeq uint64) (streamID, error) {
+	msArg, seqArg, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msArg, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqArg, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// streamEntry is one entry of a stream: its ID and alternating field names
+// and values, in the order they were given.
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// streamValue is the value of a stream key: entries in ID order. Unlike
+// the other aggregates a stream is not deleted when it becomes empty,
+// since its last ID and consumer groups must survive to keep new IDs
+// growing. groups is nil until the first group is created.
+type streamValue struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+func newStream() *streamValue {
+	return &streamValue{}
+}
+
+func (s *streamValue) clone() *streamValue {
+	c := *s
+	c.entries = slices.Clone(s.entries)
+	if s.groups != nil {
+		c.groups = make(map[string]*streamGroup, len(s.groups))
+		for name, g := range s.groups {
+			c.groups[name] = g.clone()
+		}
+	}
+	return &c
+}
+
+// release drops the entries so the collector can reclaim them.
+func (s *streamValue) release() {
+	s.entries = nil
+	s.groups = nil
+}
+
+// length returns the number of entries, 0 for a nil stream.
+func (s *streamValue) length() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.entries)
+}
+
+// nextID returns the ID XADD assigns with "*": the current time, or the
+// last ID's time if the clock is behind it, with the next free sequence
+// number.
+func (s *streamValue) nextID(now time.Time) (streamID, error) {
+	ms := uint64(now.UnixMilli())
+	if ms > s.lastID.ms {
+		return streamID{ms, 0}, nil
+	}
+	id, ok := s.lastID.next()
+	if !ok {
+		return id, errStreamExhausted
+	}
+	return id, nil
+}
+
+var errStreamExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+
+// add appends an entry, whose ID must be greater than the last one.
+func (s *streamValue) add(id streamID, fields []string) {
+	s.entries = append(s.entries, streamEntry{id: id, fields: fields})
+	s.lastID = id
+}
+
+// search returns the index of the first entry whose ID is at least id.
+func (s *streamValue) search(id streamID) int {
+	i, _ := slices.BinarySearchFunc(s.entries, id, func(e streamEntry, id streamID) int {
+		return e.id.compare(id)
+	})
+	return i
+}
+
+// between returns up to count entries with IDs from start to end
+// inclusive, in descending order if rev is set. A negative count means no
+// limit. The entries are shared with the stream and must not be modified.
+func (s *streamValue) between(start, end streamID, count int, rev bool) []streamEntry {
+	if s == nil || start.compare(end) > 0 {
+		return nil
+	}
+	lo, hi := s.search(start), s.search(end)
+	if hi < len(s.entries) && s.entries[hi].id == end {
+		hi++
+	}
+	if count < 0 || count > hi-lo {
+		count = hi - lo
+	}
+	if !rev {
+		return s.entries[lo : lo+count]
+	}
+	out := make([]streamEntry, count)
+	for i := range out {
+		out[i] = s.entries[hi-1-i]
+	}
+	return out
+}
+
+// lookupStream returns the stream at key, or nil if there is none. The
+// caller must hold mu for reading or writing.
+func (s *Store) lookupStream(key string) (*streamValue, error) {
+	return lookupTyped[*streamValue](s, key)
+}
+
+// streamGroup is a consumer group: the last entry delivered to any of its
+// consumers, and the pending entries list (PEL) of entries delivered but
+// not acknowledged yet.
+type streamGroup struct {
+	lastID    streamID
+	pel       map[streamID]*pendingEntry
+	consumers map[string]*streamConsumer
+}
+
+// streamConsumer is a member of a group, with the subset of the group's
+// PEL that was delivered to it.
+type streamConsumer struct {
+	name    string
+	seenAt  time.Time
+	pending map[streamID]*pendingEntry
+}
+
+// pendingEntry records the delivery of an entry to a consumer. It is
+// shared by the group's PEL and its consumer's.
+type pendingEntry struct {
+	id          streamID
+	consumer    *streamConsumer
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+func newStreamGroup(lastID streamID) *streamGroup {
+	return &streamGroup{
+		lastID:    lastID,
+		pel:       make(map[streamID]*pendingEntry),
+		consumers: make(map[string]*streamConsumer),
+	}
+}
+
+func (g *streamGroup) clone() *streamGroup {
+	c := newStreamGroup(g.lastID)
+	for name, cons := range g.consumers {
+		cc := &streamConsumer{name: name, seenAt: cons.seenAt, pending: make(map[streamID]*pendingEntry, len(cons.pending))}
+		for id, p := range cons.pending {
+			cp := *p
+			cp.consumer = cc
+			cc.pending[id] = &cp
+			c.pel[id] = &cp
+		}
+		c.consumers[name] = cc
+	}
+	return c
+}
+
+// consumer returns the named consumer, creating it if needed. created
+// reports whether it did.
+func (g *streamGroup) consumer(name string, now time.Time) (cons *streamConsumer, created bool) {
+	if cons, ok := g.consumers[name]; ok {
+		return cons, false
+	}
+	cons = &streamConsumer{name: name, seenAt: now, pending: make(map[streamID]*pendingEntry)}
+	g.consumers[name] = cons
+	return cons, true
+}
+
+// deliver records in the PEL that the entry id was delivered to cons.
+func (g *streamGroup) deliver(id streamID, cons *streamConsumer, now time.Time) {
+	p := g.claim(id, cons)
+	p.deliveredAt = now
+	p.deliveries++
+}
+
+// claim makes cons the owner of the entry id in the PEL, moving it from
+// whichever consumer had it before or adding it if it was not pending.
+// The caller updates the delivery time and count.
+func (g *streamGroup) claim(id streamID, cons *streamConsumer) *pendingEntry {
+	p, ok := g.pel[id]
+	if !ok {
+		p = &pendingEntry{id: id}
+		g.pel[id] = p
+	} else if p.consumer != cons {
+		delete(p.consumer.pending, id)
+	}
+	p.consumer = cons
+	cons.pending[id] = p
+	return p
+}
+
+// ack removes the entry id from the PEL, reporting whether it was there.
+func (g *streamGroup) ack(id streamID) bool {
+	p, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(g.pel, id)
+	delete(p.consumer.pending, id)
+	return true
+}
+
+// removeConsumer deletes a consumer along with its pending entries and
+// returns how many it had.
+func (g *streamGroup) removeConsumer(name string) int {
+	cons, ok := g.consumers[name]
+	if !ok {
+		return 0
+	}
+	for id := range cons.pending {
+		delete(g.pel, id)
+	}
+	delete(g.consumers, name)
+	return len(cons.pending)
+}
+
+// sortedPending returns the entries of a PEL in ID order.
+func sortedPending(pel map[streamID]*pendingEntry) []*pendingEntry {
+	out := make([]*pendingEntry, 0, len(pel))
+	for _, p := range pel {
+		out = append(out, p)
+	}
+	slices.SortFunc(out, func(a, b *pendingEntry) int { return a.id.compare(b.id) })
+	return out
+}
+
+// entry returns the entry with the given ID, if the stream still has it.
+func (s *streamValue) entry(id streamID) (streamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].id == id {
+		return s.entries[i], true
+	}
+	return streamEntry{}, false
+}
+
+// streamTrim is a trimming request from XTRIM or XADD: keep at most maxLen
+// entries, or with byMinID drop the entries before minID. An approximate
+// trim only removes whole blocks of entries, which is cheaper in Redis and
+// keeps the semantics here, and removes at most limit entries (0 for no
+// limit).
+type streamTrim struct {
+	byMinID bool
+	maxLen  int64
+	minID   streamID
+	approx  bool
+	limit   int64
+}
+
+// trim removes entries from the start of the stream as t asks, treating
+// blocks of nodeSize entries as the unit of approximate trimming, and
+// returns how many it removed. Pending entries of groups are kept; the
+// claim commands drop them when they find their entry gone.
+func (s *streamValue) trim(t streamTrim, nodeSize int) int {
+	var n int
+	if t.byMinID {
+		n = s.search(t.minID)
+	} else if int64(len(s.entries)) > t.maxLen {
+		n = len(s.entries) - int(t.maxLen)
+	}
+	if t.approx {
+		nodeSize = max(nodeSize, 1)
+		if t.limit > 0 {
+			n = int(min(int64(n), t.limit))
+		}
+		n -= n % nodeSize
+	}
+	if n > 0 {
+		s.entries = slices.Delete(s.entries, 0, n)
+	}
+	return n
+}
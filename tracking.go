@@ -0,0 +1,248 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go-http-practice/resp"
+)
+
+// invalidateChannel is the pub/sub channel RESP2 clients subscribe to when
+// they receive the invalidation messages of another client through
+// CLIENT TRACKING ... REDIRECT.
+const invalidateChannel = "__redis__:invalidate"
+
+// trackingOptions are the CLIENT TRACKING options a client turned tracking
+// on with. They are replaced, never modified, under the tracking lock.
+type trackingOptions struct {
+	// redirect is the ID of the client invalidation messages go to instead,
+	// 0 for the tracking client itself.
+	redirect int64
+	// bcast asks for the invalidation of every key starting with one of
+	// prefixes, read or not, instead of the keys the client read.
+	bcast    bool
+	prefixes []string
+	// optin only tracks the keys of commands following CLIENT CACHING yes,
+	// optout all but those following CLIENT CACHING no.
+	optin, optout bool
+	// noloop skips the keys modified by the client itself.
+	noloop bool
+}
+
+// tracking remembers which keys the clients with CLIENT TRACKING on have
+// read, so they can be told to drop them from their caches when the keys
+// are modified. Like Redis, it forgets a key once it was invalidated,
+// until it is read again, and does not bother to forget the keys of
+// clients that turned tracking off: they are skipped when invalidating.
+// The keys modified under BCAST prefixes are collected and broadcast once
+// the command modifying them is done, in one message per client.
+//
+// The lock order is store lock, tracking lock, pubsub lock, output lock.
+type tracking struct {
+	mu sync.Mutex
+	// keys holds the clients that read each key.
+	keys map[string]map[*Client]struct{}
+	// prefixes holds the BCAST clients of each prefix, and modified the
+	// keys to broadcast, each mapped to the client that modified it, or nil
+	// if that was the server or several clients.
+	prefixes map[string]map[*Client]struct{}
+	modified map[string]*Client
+	// enabled counts the clients with tracking on, so that writes can skip
+	// the lock when there are none.
+	enabled atomic.Int32
+	srv     *Server
+}
+
+func newTracking(srv *Server) *tracking {
+	return &tracking{
+		keys:     make(map[string]map[*Client]struct{}),
+		prefixes: make(map[string]map[*Client]struct{}),
+		modified: make(map[string]*Client),
+		srv:      srv,
+	}
+}
+
+// enable turns tracking on for c with opts, or updates the options if it
+// is on already.
+func (t *tracking) enable(c *Client, opts *trackingOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.tracking == nil {
+		t.enabled.Add(1)
+	}
+	c.tracking = opts
+	for _, prefix := range opts.prefixes {
+		if t.prefixes[prefix] == nil {
+			t.prefixes[prefix] = make(map[*Client]struct{})
+		}
+		t.prefixes[prefix][c] = struct{}{}
+	}
+}
+
+// disable turns tracking off for c.
+func (t *tracking) disable(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.tracking == nil {
+		return
+	}
+	for _, prefix := range c.tracking.prefixes {
+		delete(t.prefixes[prefix], c)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	c.tracking = nil
+	c.caching = 0
+	t.enabled.Add(-1)
+}
+
+// remember records that c read keys.
+func (t *tracking) remember(c *Client, keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = make(map[*Client]struct{})
+		}
+		t.keys[key][c] = struct{}{}
+	}
+}
+
+// invalidate tells the clients tracking key that it was modified, by the
+// client by or, if by is nil, by the server itself, for instance because
+// it expired.
+func (t *tracking) invalidate(key string, by *Client) {
+	if t.enabled.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := resp.BulkStrings([]string{key})
+	for c := range t.keys[key] {
+		if c.tracking != nil && !c.tracking.bcast {
+			t.send(c, keys, by)
+		}
+	}
+	delete(t.keys, key)
+	if len(t.prefixes) > 0 {
+		if other, ok := t.modified[key]; ok && other != by {
+			by = nil
+		}
+		t.modified[key] = by
+	}
+}
+
+// broadcast sends the BCAST clients the keys modified under their prefixes
+// since the last broadcast. The caller must hold the store lock for
+// writing.
+func (t *tracking) broadcast() {
+	if t.enabled.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.modified) == 0 {
+		return
+	}
+	keys := make(map[*Client][]string)
+	for key, by := range t.modified {
+		for prefix, clients := range t.prefixes {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for c := range clients {
+				if !c.tracking.noloop || c != by {
+					keys[c] = append(keys[c], key)
+				}
+			}
+		}
+	}
+	clear(t.modified)
+	for c, mine := range keys {
+		slices.Sort(mine)
+		t.send(c, resp.BulkStrings(mine), nil)
+	}
+}
+
+// invalidateAll tells every tracking client to drop its whole cache, after
+// FLUSHDB or FLUSHALL.
+func (t *tracking) invalidateAll() {
+	if t.enabled.Load() == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.srv.clientList() {
+		if c.tracking != nil {
+			t.send(c, resp.NullArray, nil)
+		}
+	}
+	clear(t.keys)
+	clear(t.modified)
+}
+
+// send sends an invalidation message for keys, an array of keys or nil
+// for all of them, to c or the client it redirects to: a push for RESP3
+// connections and a pub/sub message for RESP2 ones subscribed to
+// __redis__:invalidate. RESP2 connections that are not subscribers cannot
+// take unsolicited messages and get nothing.
+func (t *tracking) send(c *Client, keys resp.Value, by *Client) {
+	opts := c.tracking
+	if opts.noloop && c == by {
+		return
+	}
+	to := c
+	if opts.redirect != 0 {
+		if to = t.srv.clientByID(opts.redirect); to == nil {
+			if c.out.protocol() >= 3 {
+				c.out.push(resp.Push(resp.BulkString("tracking-redir-broken"), resp.Integer(opts.redirect)))
+			}
+			return
+		}
+	}
+	switch {
+	case to.out.protocol() >= 3:
+		to.out.push(resp.Push(resp.BulkString("invalidate"), keys))
+	case opts.redirect != 0 && t.srv.pubsub.isSubscriber(to):
+		to.out.push(resp.Push(resp.BulkString("message"), resp.BulkString(invalidateChannel), keys))
+	}
+}
+
+// actFor records that the commands run until done is called act for c, or
+// for the server itself if c is nil, which NOLOOP needs to know. done then
+// broadcasts the keys modified meanwhile to the BCAST clients. The caller
+// must hold mu for writing.
+func (s *Store) actFor(c *Client) (done func()) {
+	s.current = c
+	return func() {
+		s.current = nil
+		if s.tracking != nil {
+			s.tracking.broadcast()
+		}
+	}
+}
+
+// trackKeys remembers the keys cmd read, if c tracks the keys it reads and
+// cmd is a read-only command, and ends the effect of CLIENT CACHING, which
+// only applies to the command after it. Commands whose keys cannot be told
+// from the command table, such as XREAD, are not tracked. The caller must
+// hold the store lock, so no write can slip in between the read and this.
+func (c *Client) trackKeys(cmd *Command, args []string) {
+	opts := c.tracking
+	if opts == nil {
+		return
+	}
+	caching := c.caching
+	if cmd.Name != "client" || !strings.EqualFold(args[1], "caching") {
+		c.caching = 0
+	}
+	if opts.bcast || cmd.Flags&flagReadonly == 0 || (opts.optin && caching <= 0) || (opts.optout && caching < 0) {
+		return
+	}
+	if keys := cmd.keys(args); len(keys) > 0 {
+		c.srv.tracking.remember(c, keys)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go-http-practice/resp"
+)
+
+// newResp3Client returns a client of srv that switched to RESP3, with the
+// HELLO reply written so pushes are sent in RESP3 too.
+func newResp3Client(t *testing.T, srv *Server) *Client {
+	t.Helper()
+	c := newClient(nil, srv)
+	c.out.write(do(c, "HELLO", "3"), c.proto)
+	written(t, c)
+	return c
+}
+
+func invalidated(keys ...string) resp.Value {
+	if keys == nil {
+		return resp.Push(resp.BulkString("invalidate"), resp.Value{Type: resp.TypeNull})
+	}
+	return resp.Push(resp.BulkString("invalidate"), resp.BulkStrings(keys))
+}
+
+func TestTracking(t *testing.T) {
+	c := newResp3Client(t, NewServer(defaultConfig()))
+	other := newClient(nil, c.srv)
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", "a", "1"}, resp.OK},
+		{[]string{"CLIENT", "TRACKING", "ON"}, resp.OK},
+		{[]string{"GET", "a"}, resp.BulkString("1")},
+		{[]string{"MGET", "b", "c"}, resp.Array(resp.NullBulk, resp.NullBulk)},
+	})
+	expectWritten(t, c)
+
+	do(other, "SET", "a", "2")
+	do(other, "SET", "a", "3")
+	do(other, "LPUSH", "b", "x")
+	do(other, "SET", "unread", "x")
+	// A key is only invalidated once until it is read again.
+	expectWritten(t, c, invalidated("a"), invalidated("b"))
+
+	// The client's own writes are invalidated too, unless NOLOOP is set.
+	do(c, "GET", "a")
+	do(c, "SET", "a", "4")
+	expectWritten(t, c, invalidated("a"))
+	do(c, "CLIENT", "TRACKING", "ON", "NOLOOP")
+	do(c, "GET", "a")
+	do(c, "SET", "a", "5")
+	expectWritten(t, c)
+
+	do(c, "GET", "c")
+	do(other, "FLUSHALL")
+	expectWritten(t, c, invalidated())
+
+	do(c, "CLIENT", "TRACKING", "OFF")
+	do(c, "GET", "a")
+	do(other, "SET", "a", "6")
+	expectWritten(t, c)
+}
+
+func TestTrackingModes(t *testing.T) {
+	c := newResp3Client(t, NewServer(defaultConfig()))
+	other := newClient(nil, c.srv)
+
+	do(c, "CLIENT", "TRACKING", "ON", "OPTIN")
+	do(c, "GET", "skipped")
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"CLIENT", "CACHING", "yes"}, resp.OK},
+		{[]string{"GET", "cached"}, resp.NullBulk},
+		{[]string{"CLIENT", "CACHING", "no"}, resp.Error("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")},
+	})
+	do(other, "SET", "skipped", "x")
+	do(other, "SET", "cached", "x")
+	expectWritten(t, c, invalidated("cached"))
+	do(c, "CLIENT", "TRACKING", "OFF")
+
+	do(c, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "job:")
+	do(other, "SET", "user:1", "x")
+	do(other, "SET", "item:1", "x")
+	do(other, "LPUSH", "job:2", "x")
+	// Each command's keys are broadcast together, once each.
+	do(other, "MSET", "job:3", "x", "user:2", "x", "job:3", "y")
+	expectWritten(t, c, invalidated("user:1"), invalidated("job:2"), invalidated("job:3", "user:2"))
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	c := newTestClient()
+	other := newClient(nil, c.srv)
+	sub := newClient(nil, c.srv)
+	do(sub, "SUBSCRIBE", invalidateChannel)
+	written(t, sub)
+
+	do(c, "CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(sub.id, 10))
+	if got := do(c, "CLIENT", "GETREDIR"); !reflect.DeepEqual(got, resp.Integer(sub.id)) {
+		t.Errorf("CLIENT GETREDIR = %+v, want %d", got, sub.id)
+	}
+	do(c, "GET", "k")
+	do(other, "SET", "k", "v")
+	// RESP2 subscribers get the invalidations as pub/sub messages.
+	expectWritten(t, sub, pushed(resp.BulkString("message"), resp.BulkString(invalidateChannel), resp.BulkStrings([]string{"k"})))
+	expectWritten(t, c)
+
+	// A RESP3 client learns when the client it redirects to is gone.
+	r3 := newResp3Client(t, c.srv)
+	do(r3, "CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(sub.id, 10))
+	do(r3, "GET", "k")
+	c.srv.removeClient(sub)
+	do(other, "SET", "k", "v")
+	expectWritten(t, r3, resp.Push(resp.BulkString("tracking-redir-broken"), resp.Integer(sub.id)))
+}
+
+func TestClientTrackingErrors(t *testing.T) {
+	c := newTestClient()
+	expectReply(t, c, []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"CLIENT", "ID"}, resp.Integer(c.id)},
+		{[]string{"CLIENT", "GETREDIR"}, resp.Integer(-1)},
+		{[]string{"CLIENT", "NOPE"}, resp.Error("ERR unknown subcommand 'NOPE'. Try CLIENT HELP.")},
+		{[]string{"CLIENT", "TRACKING"}, wrongArityReply("client|tracking")},
+		{[]string{"CLIENT", "TRACKING", "MAYBE"}, syntaxErrorReply},
+		{[]string{"CLIENT", "TRACKING", "ON", "PREFIX", "a"}, resp.Error("ERR PREFIX option requires BCAST mode to be enabled")},
+		{[]string{"CLIENT", "TRACKING", "ON", "OPTIN", "OPTOUT"}, resp.Error("ERR You can't use both OPTIN and OPTOUT")},
+		{[]string{"CLIENT", "TRACKING", "ON", "BCAST", "OPTIN"}, resp.Error("ERR OPTIN and OPTOUT are not compatible with BCAST")},
+		{[]string{"CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "ab", "PREFIX", "a"}, resp.Error("ERR Prefix 'a' overlaps with an existing prefix 'ab'. Prefixes for a single client must not overlap.")},
+		{[]string{"CLIENT", "TRACKING", "ON", "REDIRECT", "999999"}, resp.Error("ERR The client ID you want redirect to does not exist")},
+		{[]string{"CLIENT", "CACHING", "yes"}, resp.Error("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")},
+		{[]string{"CLIENT", "TRACKING", "ON", "OPTOUT"}, resp.OK},
+		{[]string{"CLIENT", "TRACKING", "ON", "BCAST"}, resp.Error("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")},
+		{[]string{"CLIENT", "CACHING", "no"}, resp.OK},
+		{[]string{"CLIENT", "TRACKINGINFO"}, resp.Map(
+			resp.BulkString("flags"), resp.Set(resp.BulkString("on"), resp.BulkString("optout"), resp.BulkString("caching-no")),
+			resp.BulkString("redirect"), resp.Integer(0),
+			resp.BulkString("prefixes"), resp.Array(),
+		)},
+		{[]string{"CLIENT", "TRACKING", "OFF"}, resp.OK},
+		{[]string{"CLIENT", "TRACKINGINFO"}, resp.Map(
+			resp.BulkString("flags"), resp.Set(resp.BulkString("off")),
+			resp.BulkString("redirect"), resp.Integer(-1),
+			resp.BulkString("prefixes"), resp.Array(),
+		)},
+	})
+}
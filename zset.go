@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// zsetValue is the value of a sorted set key. The skiplist keeps members in
+// (score, member) order for range queries and the map gives O(1) score
+// lookups by member.
+type zsetValue struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newZset() *zsetValue {
+	return &zsetValue{scores: make(map[string]float64), zsl: newSkiplist()}
+}
+
+func (z *zsetValue) clone() *zsetValue {
+	c := newZset()
+	for x := z.zsl.first(); x != nil; x = x.next() {
+		c.add(x.member, x.score)
+	}
+	return c
+}
+
+// release drops the members so the collector can reclaim them.
+func (z *zsetValue) release() {
+	clear(z.scores)
+	z.zsl = newSkiplist()
+}
+
+// size returns the number of members, 0 for a nil sorted set.
+func (z *zsetValue) size() int {
+	if z == nil {
+		return 0
+	}
+	return len(z.scores)
+}
+
+// score returns the score of member. It is safe to call on a nil sorted
+// set, which has no members.
+func (z *zsetValue) score(member string) (float64, bool) {
+	if z == nil {
+		return 0, false
+	}
+	s, ok := z.scores[member]
+	return s, ok
+}
+
+// add sets the score of member, adding it if needed, and reports whether
+// it was added.
+func (z *zsetValue) add(member string, score float64) bool {
+	cur, ok := z.scores[member]
+	switch {
+	case !ok:
+		z.zsl.insert(score, member)
+	case cur != score:
+		z.zsl.updateScore(cur, member, score)
+	}
+	z.scores[member] = score
+	return !ok
+}
+
+// zaddFlags are the conditions ZADD and ZINCRBY apply to each member.
+type zaddFlags struct {
+	nx, xx, gt, lt, incr bool
+}
+
+// zaddResult reports what upsert did to a member.
+type zaddResult int
+
+const (
+	zaddSkipped zaddResult = iota // a condition prevented the write
+	zaddAdded
+	zaddUpdated
+	zaddUnchanged // the member was present with the same score
+)
+
+var errScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+// upsert adds member with score, or updates it, subject to flags. With
+// incr set, score is added to the current score. It returns the resulting
+// score and what was done; an increment of an infinite score by its
+// opposite is an error that leaves the member alone.
+func (z *zsetValue) upsert(member string, score float64, flags zaddFlags) (float64, zaddResult, error) {
+	cur, ok := z.scores[member]
+	if !ok {
+		if flags.xx {
+			return 0, zaddSkipped, nil
+		}
+		z.add(member, score)
+		return score, zaddAdded, nil
+	}
+	if flags.nx {
+		return cur, zaddSkipped, nil
+	}
+	if flags.incr {
+		score += cur
+		if math.IsNaN(score) {
+			return 0, zaddSkipped, errScoreNaN
+		}
+	}
+	if (flags.gt && score <= cur) || (flags.lt && score >= cur) {
+		return cur, zaddSkipped, nil
+	}
+	if score == cur {
+		return cur, zaddUnchanged, nil
+	}
+	z.add(member, score)
+	return score, zaddUpdated, nil
+}
+
+// remove deletes member and reports whether it was present.
+func (z *zsetValue) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.zsl.remove(score, member)
+	return true
+}
+
+// pop removes up to count members from the low end of the sorted set, or
+// the high end if fromMax is set, returning them in the order popped.
+func (z *zsetValue) pop(count int64, fromMax bool) []*skipNode {
+	var nodes []*skipNode
+	for ; count > 0 && z.size() > 0; count-- {
+		x := z.zsl.first()
+		if fromMax {
+			x = z.zsl.tail
+		}
+		z.remove(x.member)
+		nodes = append(nodes, x)
+	}
+	return nodes
+}
+
+// rank returns the 0-based rank of member in ascending order.
+func (z *zsetValue) rank(member string) (int, bool) {
+	score, ok := z.score(member)
+	if !ok {
+		return 0, false
+	}
+	return z.zsl.rank(score, member), true
+}
+
+// lookupZset returns the sorted set at key, or nil if there is none. The
+// caller must hold mu for reading or writing.
+func (s *Store) lookupZset(key string) (*zsetValue, error) {
+	return lookupTyped[*zsetValue](s, key)
+}
+
+// zsetForWrite returns the sorted set at key, creating an empty one unless
+// mustExist is set, in which case it may return nil. The caller must hold
+// mu for writing and must call doneWithZset afterwards, which deletes the
+// key if the sorted set was left empty.
+func (s *Store) zsetForWrite(key string, mustExist bool) (*zsetValue, error) {
+	z, err := s.lookupZset(key)
+	if err != nil || z != nil || mustExist {
+		return z, err
+	}
+	z = newZset()
+	s.put(key, StoreData{value: z})
+	return z, nil
+}
+
+// doneWithZset finishes a write to the sorted set at key, deleting the key
+// if no members are left. The caller must hold mu for writing.
+func (s *Store) doneWithZset(key string, z *zsetValue) {
+	if z.size() == 0 {
+		s.remove(key)
+	}
+}
+
+// zsetOperand is an input of ZUNIONSTORE and friends, which accept plain
+// sets as well as sorted sets and score every member of a set as 1.
+type zsetOperand struct {
+	z *zsetValue
+	s *setValue
+}
+
+func (o zsetOperand) size() int {
+	if o.s != nil {
+		return o.s.size()
+	}
+	return o.z.size()
+}
+
+func (o zsetOperand) score(member string) (float64, bool) {
+	if o.s != nil {
+		return 1, o.s.has(member)
+	}
+	return o.z.score(member)
+}
+
+func (o zsetOperand) each(fn func(member string, score float64)) {
+	switch {
+	case o.s != nil:
+		for m := range o.s.members {
+			fn(m, 1)
+		}
+	case o.z != nil:
+		for m, score := range o.z.scores {
+			fn(m, score)
+		}
+	}
+}
+
+// lookupZsetOperand returns the sorted set or set at key as an operand,
+// empty if there is no such key. The caller must hold mu for reading or
+// writing.
+func (s *Store) lookupZsetOperand(key string) (zsetOperand, error) {
+	d, ok := s.lookup(key)
+	if !ok {
+		return zsetOperand{}, nil
+	}
+	switch v := d.value.(type) {
+	case *zsetValue:
+		return zsetOperand{z: v}, nil
+	case *setValue:
+		return zsetOperand{s: v}, nil
+	}
+	return zsetOperand{}, errWrongType
+}